@@ -39,6 +39,10 @@ func (s *SpectralWhitening) Do(fftgrain *Cvec) {
 	if s.PeakValues.Length < length {
 		length = s.PeakValues.Length
 	}
+	if Accelerated() {
+		whiten(fftgrain.Norm[:length], s.PeakValues.Data, s.RDecay, s.Floor)
+		return
+	}
 
 	for i := uint(0); i < length; i++ {
 		tmp := math.Max(s.RDecay*s.PeakValues.Data[i], s.Floor)
@@ -0,0 +1,82 @@
+package onset
+
+// backtrackFrameMs is the length in milliseconds of the frames of the energy
+// envelope searched by backtracking
+const backtrackFrameMs = 5.0
+
+// backtrackMaxMs is the longest distance in milliseconds an onset is moved
+// back by backtracking
+const backtrackMaxMs = 200.0
+
+// backtrackOnsets moves every onset back to the preceding local minimum of the
+// energy envelope, like librosa.onset.onset_backtrack, never past the previous
+// onset
+func backtrackOnsets(samples []float64, sampleRate uint, onsets []float64) []float64 {
+	backtracked := make([]float64, len(onsets))
+	for i, onsetTime := range onsets {
+		start, end := backtrackWindowRange(sampleRate, onsets, i)
+		start, end = min(start, len(samples)), min(end, len(samples))
+		backtracked[i] = backtrackInWindow(samples[start:end], start, sampleRate, onsetTime)
+	}
+	return backtracked
+}
+
+// backtrackWindowRange returns the range of samples searched for the local
+// minimum preceding onset i, which starts after the previous onset and ends
+// one frame after the onset
+func backtrackWindowRange(sampleRate uint, onsets []float64, i int) (int, int) {
+	onsetSample := int(onsets[i] * float64(sampleRate))
+	frame := backtrackFrameSamples(sampleRate)
+
+	start := onsetSample - int(backtrackMaxMs*float64(sampleRate)/1000)
+	if i > 0 {
+		start = max(start, int(onsets[i-1]*float64(sampleRate)))
+	}
+	return max(start, 0), onsetSample + frame
+}
+
+// backtrackFrameSamples returns the length of the envelope frames in samples
+func backtrackFrameSamples(sampleRate uint) int {
+	return max(int(backtrackFrameMs*float64(sampleRate)/1000), 1)
+}
+
+// backtrackInWindow searches the window samples, which start at sample index
+// offset of the file, for the nearest local minimum of the energy envelope
+// before the onset and returns the time of the center of its frame. The onset
+// is kept if there is no minimum in the window.
+func backtrackInWindow(window []float64, offset int, sampleRate uint, onsetTime float64) float64 {
+	frame := backtrackFrameSamples(sampleRate)
+	onsetSample := int(onsetTime * float64(sampleRate))
+
+	// energy returns the mean square of the frame starting k frames before
+	// the onset, or -1 if it is not in the window
+	energy := func(k int) float64 {
+		start := onsetSample - k*frame - offset
+		if start < 0 || start+frame > len(window) {
+			return -1
+		}
+		sum := 0.0
+		for _, v := range window[start : start+frame] {
+			sum += v * v
+		}
+		return sum / float64(frame)
+	}
+
+	next := energy(-1)
+	current := energy(0)
+	for k := 0; current >= 0; k++ {
+		previous := energy(k + 1)
+		if previous < 0 {
+			break
+		}
+		if current <= previous && (next < 0 || current < next) {
+			if k == 0 {
+				// The onset is already at a minimum
+				return onsetTime
+			}
+			return float64(onsetSample-k*frame+frame/2) / float64(sampleRate)
+		}
+		next, current = current, previous
+	}
+	return onsetTime
+}
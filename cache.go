@@ -0,0 +1,167 @@
+package onset
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// cacheVersion is part of every cache key; bump it whenever the analysis
+// changes in a way that invalidates cached results
+const cacheVersion = 2
+
+// analyzeFileCached analyzes an audio file, reusing the result cached in
+// options.CacheDir when the file contents and options are unchanged
+func analyzeFileCached(wavFile string, options SliceAnalyzerOptions) (*SliceAnalyzerResult, error) {
+	key, err := cacheKey(wavFile, options)
+	if err != nil {
+		return nil, err
+	}
+	cachePath := filepath.Join(options.CacheDir, key+".json")
+
+	if result, ok := readCachedResult(cachePath); ok {
+		if !options.Streaming {
+			samples, err := readAnalyzedSamples(wavFile, options)
+			if err != nil {
+				return nil, err
+			}
+			result.Samples = samples
+			if options.KeepSlices {
+				result.Slices = result.sliceSamples()
+			}
+		}
+		newProgress(options.Progress).report(1)
+		return result, nil
+	}
+
+	result, err := analyzeFile(wavFile, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCachedResult(cachePath, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// cacheKey hashes the file contents together with the analysis options and,
+// for the consensus, the names of the registered custom methods
+func cacheKey(wavFile string, options SliceAnalyzerOptions) (string, error) {
+	f, err := os.Open(wavFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	fmt.Fprintf(h, "v%d\n", cacheVersion)
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	// Progress and CacheDir are excluded from the JSON encoding
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode options: %w", err)
+	}
+	h.Write(encoded)
+
+	// The consensus also runs the custom methods registered in this process
+	if analysisMethod(options) == "consensus" {
+		methods := customMethods()
+		slices.Sort(methods)
+		fmt.Fprintf(h, "\n%s", strings.Join(methods, ","))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readCachedResult loads a cached result. Missing or corrupt entries are
+// reported as a cache miss.
+func readCachedResult(cachePath string) (*SliceAnalyzerResult, bool) {
+	f, err := os.Open(cachePath)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	result, err := ReadResultJSON(f)
+	if err != nil {
+		return nil, false
+	}
+	return result, true
+}
+
+// writeCachedResult stores a result without its samples. The entry is written
+// to a temporary file first so that concurrent readers never see a partial entry.
+func writeCachedResult(cachePath string, result *SliceAnalyzerResult) error {
+	var data bytes.Buffer
+	if err := result.WriteJSON(&data); err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+
+	dir := filepath.Dir(cachePath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(cachePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if _, err := tmp.Write(data.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return nil
+}
+
+// readAnalyzedSamples decodes the samples that an analysis of the file with
+// the given options returns in result.Samples
+func readAnalyzedSamples(wavFile string, options SliceAnalyzerOptions) ([]float64, error) {
+	s, err := openAudioFile(wavFile, options.DecoderFallback)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	channels, sampleRate, err := readStream(s)
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+
+	resample := options.AnalyzeRate > 0 && options.AnalyzeRate != sampleRate
+	if strings.EqualFold(options.Channel, ChannelPerChannel) {
+		if resample {
+			for i, channel := range channels {
+				channels[i] = Resample(channel, sampleRate, options.AnalyzeRate)
+			}
+		}
+		return mixChannels(channels), nil
+	}
+
+	samples, err := selectChannel(channels, options.Channel)
+	if err != nil {
+		return nil, err
+	}
+	if resample {
+		samples = Resample(samples, sampleRate, options.AnalyzeRate)
+	}
+	return samples, nil
+}
@@ -0,0 +1,137 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+)
+
+// calibrationSteps is the number of thresholds evaluated in every pass of
+// CalibrateThreshold, as sensitivity tiers sharing one detection function
+const calibrationSteps = 16
+
+// calibrationPasses is the maximum number of passes of CalibrateThreshold,
+// each narrowing the threshold range by calibrationSteps-1
+const calibrationPasses = 5
+
+// calibrationMaxThreshold is the highest threshold CalibrateThreshold tries
+const calibrationMaxThreshold = 1000.0
+
+// CalibrateThreshold searches the peak picking threshold at which method
+// detects targetCount onsets in samples, give or take tolerance, with the
+// settings of DefaultSliceAnalyzerOptions, including the minimum spacing but
+// before position optimization. It returns the threshold and the number
+// of onsets detected at it, so that options.Threshold can be set to reach a
+// slice count without trial and error. If no threshold reaches the target
+// within tolerance, the closest count is returned without error; check the
+// returned count. Every pass over the audio evaluates many thresholds at once,
+// so a search analyzes the audio a handful of times.
+func CalibrateThreshold(samples []float64, sampleRate uint, method string, targetCount, tolerance int) (float64, int, error) {
+	if sampleRate == 0 {
+		return 0, 0, fmt.Errorf("invalid sample rate: %d", sampleRate)
+	}
+	if targetCount <= 0 {
+		return 0, 0, fmt.Errorf("invalid target count %d: must be positive", targetCount)
+	}
+	if tolerance < 0 {
+		return 0, 0, fmt.Errorf("invalid tolerance %d: must not be negative", tolerance)
+	}
+	if method == "" {
+		method = "hfc"
+	}
+	if method == "consensus" || !isMethod(method) {
+		return 0, 0, fmt.Errorf("unsupported method %q for threshold calibration", method)
+	}
+
+	bestThreshold, bestCount := 0.0, -1
+	// better reports whether count is closer to the target than the best so
+	// far, preferring the lower threshold on ties
+	better := func(threshold float64, count int) bool {
+		if bestCount < 0 {
+			return true
+		}
+		distance, bestDistance := abs(count-targetCount), abs(bestCount-targetCount)
+		return distance < bestDistance || (distance == bestDistance && threshold < bestThreshold)
+	}
+
+	lo, hi := 0.0, 1.0
+	for pass := 0; pass < calibrationPasses; pass++ {
+		thresholds := make([]float64, calibrationSteps)
+		for i := range thresholds {
+			thresholds[i] = lo + (hi-lo)*float64(i)/float64(calibrationSteps-1)
+		}
+		counts := countOnsetsAt(samples, sampleRate, method, thresholds)
+		for i, count := range counts {
+			if better(thresholds[i], count) {
+				bestThreshold, bestCount = thresholds[i], count
+			}
+		}
+		if abs(bestCount-targetCount) <= tolerance {
+			break
+		}
+
+		// Counts fall as the threshold rises: widen the range while even the
+		// highest threshold detects too many onsets, and otherwise narrow it
+		// to the step where the count crosses the target
+		if last := counts[len(counts)-1]; last > targetCount {
+			if hi >= calibrationMaxThreshold {
+				break
+			}
+			lo, hi = hi, math.Min(hi*8, calibrationMaxThreshold)
+			continue
+		}
+		if counts[0] < targetCount {
+			break
+		}
+		crossing := 0
+		for i, count := range counts {
+			if count > targetCount {
+				crossing = i
+			}
+		}
+		lo, hi = thresholds[crossing], thresholds[min(crossing+1, len(thresholds)-1)]
+	}
+	return bestThreshold, bestCount, nil
+}
+
+// countOnsetsAt returns the number of onsets method detects in samples at each
+// of thresholds with the default analyzer options, in one pass over the audio
+func countOnsetsAt(samples []float64, sampleRate uint, method string, thresholds []float64) []int {
+	onsets := onsetsAt(samples, sampleRate, method, thresholds)
+	counts := make([]int, len(thresholds))
+	for i := range onsets {
+		counts[i] = len(onsets[i])
+	}
+	return counts
+}
+
+// onsetsAt returns the onsets method detects in samples at each of
+// thresholds with the default analyzer options, including the minimum
+// spacing, in one pass over the audio
+func onsetsAt(samples []float64, sampleRate uint, method string, thresholds []float64) [][]float64 {
+	const bufSize, hopSize = 512, 256
+	options := DefaultSliceAnalyzerOptions()
+	tiers := make([]SensitivityTier, len(thresholds))
+	for i, threshold := range thresholds {
+		tiers[i] = SensitivityTier{Threshold: threshold}
+	}
+	d := newHopDetector(method, bufSize, hopSize, sampleRate, options, false)
+	d.addTiers(method, bufSize, tiers, options)
+	d.feed(samples, nil)
+
+	if options.UseMinimumSpacing {
+		for i, onsets := range d.tierOnsets {
+			if len(onsets) > 0 {
+				d.tierOnsets[i] = applyMinimumSpacing(onsets, options.MinimumSpacing)
+			}
+		}
+	}
+	return d.tierOnsets
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,173 @@
+// Command capi builds goaubio-onset as a C shared library for DAW plugins and
+// foreign function interfaces such as Python's ctypes. The API is declared in
+// onsets.h. Build it with:
+//
+//	go build -buildmode=c-shared -o libonsets.so ./capi
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+
+	"github.com/schollz/onsets"
+)
+
+// detector is the state behind an onset_t handle
+type detector struct {
+	o      *onset.Onset
+	input  *onset.Fvec
+	output *onset.Fvec
+}
+
+// handles holds the live handles returned by onset_new, since cgo.Handle
+// panics on a handle that was deleted or never issued
+var handles sync.Map
+
+func main() {}
+
+// detectorOf returns the detector of a handle, or nil for an invalid one
+func detectorOf(o C.uintptr_t) *detector {
+	if _, ok := handles.Load(uintptr(o)); !ok {
+		return nil
+	}
+	d, _ := cgo.Handle(o).Value().(*detector)
+	return d
+}
+
+//export onset_new
+func onset_new(method *C.char, bufSize, hopSize, samplerate C.uint) C.uintptr_t {
+	return newDetector(C.GoString(method), uint(bufSize), uint(hopSize), uint(samplerate))
+}
+
+// newDetector creates a detector and returns its handle, or 0 on error
+func newDetector(method string, bufSize, hopSize, samplerate uint) C.uintptr_t {
+	o, err := onset.NewOnsetErr(method, bufSize, hopSize, samplerate)
+	if err != nil {
+		return 0
+	}
+
+	d := &detector{
+		o:      o,
+		input:  onset.NewFvec(hopSize),
+		output: onset.NewFvec(1),
+	}
+	h := cgo.NewHandle(d)
+	handles.Store(uintptr(h), struct{}{})
+	return C.uintptr_t(h)
+}
+
+//export onset_del
+func onset_del(o C.uintptr_t) {
+	if _, ok := handles.LoadAndDelete(uintptr(o)); ok {
+		cgo.Handle(o).Delete()
+	}
+}
+
+//export onset_do
+func onset_do(o C.uintptr_t, input *C.float) C.int {
+	d := detectorOf(o)
+	if d == nil {
+		return -1
+	}
+
+	samples := unsafe.Slice((*float32)(unsafe.Pointer(input)), len(d.input.Data))
+	for i, v := range samples {
+		d.input.Data[i] = float64(v)
+	}
+	d.o.Do(d.input, d.output)
+	if d.output.Data[0] > 0 {
+		return 1
+	}
+	return 0
+}
+
+//export onset_get_last_s
+func onset_get_last_s(o C.uintptr_t) C.double {
+	if d := detectorOf(o); d != nil {
+		return C.double(d.o.GetLastS())
+	}
+	return 0
+}
+
+//export onset_get_descriptor
+func onset_get_descriptor(o C.uintptr_t) C.double {
+	if d := detectorOf(o); d != nil {
+		return C.double(d.o.GetDescriptor())
+	}
+	return 0
+}
+
+//export onset_set_threshold
+func onset_set_threshold(o C.uintptr_t, threshold C.double) {
+	if d := detectorOf(o); d != nil {
+		d.o.SetThreshold(float64(threshold))
+	}
+}
+
+//export onset_set_minioi_ms
+func onset_set_minioi_ms(o C.uintptr_t, minioi C.double) {
+	if d := detectorOf(o); d != nil {
+		d.o.SetMinioiMs(float64(minioi))
+	}
+}
+
+//export onset_reset
+func onset_reset(o C.uintptr_t) {
+	if d := detectorOf(o); d != nil {
+		d.o.Reset()
+	}
+}
+
+// analyzeResult is the JSON returned by analyze_file
+type analyzeResult struct {
+	Onsets     []float64 `json:"onsets"`
+	SampleRate uint      `json:"sample_rate"`
+	NumSamples int       `json:"num_samples"`
+}
+
+//export analyze_file
+func analyze_file(path *C.char, optionsJSON *C.char) *C.char {
+	data, err := analyzeFile(C.GoString(path), optionsJSON)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	return C.CString(string(data))
+}
+
+// analyzeFile analyzes a file with the options in optionsJSON, which may be NULL
+func analyzeFile(path string, optionsJSON *C.char) ([]byte, error) {
+	options := onset.DefaultSliceAnalyzerOptions()
+	if optionsJSON != nil {
+		if err := json.Unmarshal([]byte(C.GoString(optionsJSON)), &options); err != nil {
+			return nil, fmt.Errorf("invalid options: %w", err)
+		}
+	}
+
+	result, err := onset.AnalyzeSlices(path, options)
+	if err != nil {
+		return nil, err
+	}
+	onsets := result.Onsets
+	if onsets == nil {
+		onsets = []float64{}
+	}
+	return json.Marshal(analyzeResult{
+		Onsets:     onsets,
+		SampleRate: result.SampleRate,
+		NumSamples: max(result.NumSamples, len(result.Samples)),
+	})
+}
+
+//export onset_free_string
+func onset_free_string(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
@@ -0,0 +1,35 @@
+//go:build cgo
+
+package main
+
+import "testing"
+
+func TestStaleHandles(t *testing.T) {
+	h := newDetector("hfc", 512, 256, 44100)
+	if h == 0 {
+		t.Fatal("Failed to create a detector")
+	}
+	onset_set_threshold(h, 0.5)
+	onset_del(h)
+
+	// Using or releasing a released handle must not panic
+	onset_del(h)
+	if r := onset_do(h, nil); r != -1 {
+		t.Errorf("Expected -1 for a released handle, got %d", r)
+	}
+	if s := onset_get_last_s(h); s != 0 {
+		t.Errorf("Expected 0 s for a released handle, got %g", s)
+	}
+	onset_set_threshold(h, 0.5)
+	onset_reset(h)
+
+	// Handles that were never issued are invalid too
+	onset_del(0)
+	onset_del(1 << 40)
+	if r := onset_do(0, nil); r != -1 {
+		t.Errorf("Expected -1 for handle 0, got %d", r)
+	}
+	if r := onset_do(1<<40, nil); r != -1 {
+		t.Errorf("Expected -1 for a handle never issued, got %d", r)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * C interface of the goaubio-onset shared library.
+ *
+ * Build the library with:
+ *
+ *     go build -buildmode=c-shared -o libonsets.so ./capi
+ *
+ * All functions are safe to call from multiple threads as long as a single
+ * detector is not used by two threads at once.
+ */
+#ifndef ONSETS_H
+#define ONSETS_H
+
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+/* Handle of an onset detector; 0 is never a valid handle. */
+typedef uintptr_t onset_t;
+
+/*
+ * Create an onset detector for a method ("hfc", "energy", "complex", "phase",
+ * "wphase", "specdiff", "kl", "mkl" or "specflux"). Returns 0 if the method is
+ * unknown or a size is invalid. Release it with onset_del.
+ */
+onset_t onset_new(const char *method, unsigned int buf_size, unsigned int hop_size, unsigned int samplerate);
+
+/* Release a detector. Releasing an invalid or released handle does nothing. */
+void onset_del(onset_t o);
+
+/*
+ * Process hop_size samples. Returns 1 if an onset was detected in this block,
+ * 0 if not, and -1 for an invalid handle.
+ */
+int onset_do(onset_t o, const float *input);
+
+/* Time of the last detected onset in seconds, 0 for an invalid handle. */
+double onset_get_last_s(onset_t o);
+
+/* Value of the detection function of the last processed block. */
+double onset_get_descriptor(onset_t o);
+
+/* Peak picking threshold and minimum inter-onset interval. */
+void onset_set_threshold(onset_t o, double threshold);
+void onset_set_minioi_ms(onset_t o, double minioi);
+
+/* Reset the detector state. */
+void onset_reset(onset_t o);
+
+/*
+ * Run the slice analyzer on an audio file (WAV, FLAC or MP3). options_json
+ * holds SliceAnalyzerOptions fields overriding the defaults, such as
+ * {"Method": "consensus", "NumSlices": 8}, and may be NULL.
+ * Returns a JSON string {"onsets": [...], "sample_rate": ..., "num_samples": ...}
+ * or {"error": "..."}, which must be released with onset_free_string.
+ */
+char *analyze_file(const char *path, const char *options_json);
+
+/* Release a string returned by analyze_file. */
+void onset_free_string(char *s);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
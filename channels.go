@@ -0,0 +1,86 @@
+package onset
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Channel selection modes for SliceAnalyzerOptions.Channel
+const (
+	ChannelLeft       = "left"
+	ChannelRight      = "right"
+	ChannelMix        = "mix"
+	ChannelMid        = "mid"
+	ChannelSide       = "side"
+	ChannelPerChannel = "per-channel"
+)
+
+// selectChannel returns the mono signal requested by the channel mode.
+// Mono files are returned as-is for "left", "right", "mix" and "mid".
+// The "mid" and "side" modes use the first two channels.
+func selectChannel(channels [][]float64, mode string) ([]float64, error) {
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("no audio channels")
+	}
+
+	switch strings.ToLower(mode) {
+	case "", ChannelLeft:
+		return channels[0], nil
+	case ChannelRight:
+		if len(channels) < 2 {
+			return channels[0], nil
+		}
+		return channels[1], nil
+	case ChannelMix:
+		return mixChannels(channels), nil
+	case ChannelMid:
+		if len(channels) < 2 {
+			return channels[0], nil
+		}
+		return midSide(channels[0], channels[1], 1), nil
+	case ChannelSide:
+		if len(channels) < 2 {
+			return nil, fmt.Errorf("side channel requires a stereo file")
+		}
+		return midSide(channels[0], channels[1], -1), nil
+	}
+
+	index, err := strconv.Atoi(mode)
+	if err != nil {
+		return nil, fmt.Errorf("unknown channel mode %q", mode)
+	}
+	if index < 0 || index >= len(channels) {
+		return nil, fmt.Errorf("channel index %d out of range (file has %d channels)", index, len(channels))
+	}
+	return channels[index], nil
+}
+
+// mixChannels averages all channels into a mono signal
+func mixChannels(channels [][]float64) []float64 {
+	if len(channels) == 1 {
+		return channels[0]
+	}
+
+	mixed := make([]float64, len(channels[0]))
+	for _, channel := range channels {
+		for i, v := range channel {
+			mixed[i] += v
+		}
+	}
+	scale := 1.0 / float64(len(channels))
+	for i := range mixed {
+		mixed[i] *= scale
+	}
+	return mixed
+}
+
+// midSide returns the mid (sign = 1) or side (sign = -1) signal of a stereo pair.
+// Both are scaled by 1/2 so that a centered mono source keeps its level in the mid signal.
+func midSide(left, right []float64, sign float64) []float64 {
+	out := make([]float64, len(left))
+	for i := range out {
+		out[i] = 0.5 * (left[i] + sign*right[i])
+	}
+	return out
+}
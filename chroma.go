@@ -0,0 +1,128 @@
+package onset
+
+import "math"
+
+const (
+	// ChromaClasses is the number of pitch classes of a chroma vector, C
+	// first
+	ChromaClasses = 12
+
+	chromaDefaultMinHz  = 55.0
+	chromaDefaultMaxHz  = 5000.0
+	chromaDefaultTuning = 440.0
+)
+
+// ChromaNames are the names of the pitch classes of a chroma vector
+var ChromaNames = [ChromaClasses]string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// Chroma folds spectra into the energy of the 12 pitch classes, the
+// chromagram used for key estimation and harmonic structure analysis
+type Chroma struct {
+	BufSize    uint
+	Samplerate uint
+	// MinHz and MaxHz bound the bins folded into pitch classes; the lowest
+	// bins cannot resolve semitones and the highest mostly hold harmonics
+	MinHz float64
+	MaxHz float64
+	// Tuning is the frequency of A4 in Hz
+	Tuning float64
+
+	// classes maps every bin to its pitch class, or -1 outside MinHz..MaxHz
+	classes []int
+}
+
+// NewChroma creates a chroma extractor for spectra of bufSize samples
+// between 55 Hz and 5 kHz, tuned to A4 = 440 Hz
+func NewChroma(bufSize, samplerate uint) *Chroma {
+	c := &Chroma{
+		BufSize:    bufSize,
+		Samplerate: samplerate,
+		MinHz:      chromaDefaultMinHz,
+		MaxHz:      chromaDefaultMaxHz,
+		Tuning:     chromaDefaultTuning,
+	}
+	c.update()
+	return c
+}
+
+// SetRange sets the frequencies in Hz bounding the bins folded into pitch
+// classes
+func (c *Chroma) SetRange(minHz, maxHz float64) {
+	c.MinHz, c.MaxHz = minHz, maxHz
+	c.update()
+}
+
+// SetTuning sets the frequency of A4 in Hz
+func (c *Chroma) SetTuning(tuning float64) {
+	c.Tuning = tuning
+	c.update()
+}
+
+// update maps the bins to their pitch class
+func (c *Chroma) update() {
+	c.classes = make([]int, c.BufSize/2+1)
+	binHz := float64(c.Samplerate) / float64(c.BufSize)
+	for bin := range c.classes {
+		c.classes[bin] = -1
+		hz := float64(bin) * binHz
+		if bin == 0 || hz < c.MinHz || hz > c.MaxHz || c.Tuning <= 0 {
+			continue
+		}
+		// A is 9 semitones above C
+		semitones := int(math.Round(12 * math.Log2(hz/c.Tuning)))
+		c.classes[bin] = (semitones%ChromaClasses + ChromaClasses + 9) % ChromaClasses
+	}
+}
+
+// Do writes the energy of every pitch class of the spectrum to the first 12
+// values of out
+func (c *Chroma) Do(fftgrain *Cvec, out *Fvec) {
+	clear(out.Data[:ChromaClasses])
+	for bin, class := range c.classes[:min(len(c.classes), len(fftgrain.Norm))] {
+		if class >= 0 {
+			out.Data[class] += fftgrain.Norm[bin] * fftgrain.Norm[bin]
+		}
+	}
+}
+
+// Chromagram returns the chroma vector of every hop of samples, spectra of
+// bufSize samples every hopSize samples, the last ones zero-padded. Every
+// vector is scaled so that its strongest pitch class is 1, so that loudness
+// does not matter; silent hops are all zeros.
+func Chromagram(samples []float64, sampleRate, bufSize, hopSize uint) [][]float64 {
+	if bufSize == 0 || hopSize == 0 {
+		return nil
+	}
+	p := NewPvoc(bufSize, hopSize)
+	c := NewChroma(bufSize, sampleRate)
+	grain := NewCvec(bufSize)
+	frame := NewFvec(bufSize)
+	out := NewFvec(ChromaClasses)
+
+	var chromagram [][]float64
+	for pos := 0; pos < len(samples); pos += int(hopSize) {
+		frame.Zeros()
+		copy(frame.Data, samples[pos:min(pos+int(bufSize), len(samples))])
+		p.Do(frame, grain)
+		c.Do(grain, out)
+		vector := make([]float64, ChromaClasses)
+		copy(vector, out.Data)
+		normalizeChroma(vector)
+		chromagram = append(chromagram, vector)
+	}
+	return chromagram
+}
+
+// normalizeChroma scales vector so that its largest value is 1, leaving
+// silence all zeros
+func normalizeChroma(vector []float64) {
+	peak := 0.0
+	for _, v := range vector {
+		peak = max(peak, v)
+	}
+	if peak > 0 {
+		for k := range vector {
+			vector[k] /= peak
+		}
+	}
+}
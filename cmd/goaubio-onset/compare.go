@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/schollz/onsets"
+)
+
+// allMethods lists the detection methods compared by default
+const allMethods = "hfc,energy,complex,phase,wphase,specdiff,kl,mkl,specflux"
+
+// runCompare analyzes an audio file with several methods and prints how their
+// onsets agree
+func runCompare(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	analysis := addAnalysisFlags(fs)
+	methodList := fs.String("methods", allMethods, "comma-separated detection methods to compare")
+	toleranceMs := fs.Float64("tolerance", 50, "maximum distance in milliseconds between agreeing onsets")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaubio-onset compare <file> [--methods hfc,complex,specflux] [flags]")
+		fs.PrintDefaults()
+	}
+
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one audio file")
+	}
+	options, err := analysis.options()
+	if err != nil {
+		return err
+	}
+
+	var methods []string
+	for _, m := range strings.Split(*methodList, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			methods = append(methods, m)
+		}
+	}
+	if len(methods) < 2 {
+		return fmt.Errorf("expected at least two methods to compare")
+	}
+	tolerance := *toleranceMs / 1000
+
+	onsets := make([][]float64, len(methods))
+	for i, m := range methods {
+		options.Method = m
+		result, err := onset.AnalyzeSlices(files[0], options)
+		if err != nil {
+			return fmt.Errorf("method %s: %w", m, err)
+		}
+		onsets[i] = result.Onsets
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "method\tonsets\tunique\t")
+	for _, m := range methods {
+		fmt.Fprintf(w, "%s\t", m)
+	}
+	fmt.Fprintln(w)
+	for i, m := range methods {
+		fmt.Fprintf(w, "%s\t%d\t%d\t", m, len(onsets[i]), countUnique(onsets, i, tolerance))
+		for j := range methods {
+			// Share of this method's onsets matched by the other method
+			agreement := 1.0
+			if len(onsets[i]) > 0 {
+				agreement = onset.MatchOnsets(onsets[j], onsets[i], tolerance).Precision
+			}
+			fmt.Fprintf(w, "%.0f%%\t", agreement*100)
+		}
+		fmt.Fprintln(w)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "\nAgreement: share of the row method's onsets found by the column method within %g ms.\n", *toleranceMs)
+	fmt.Fprintln(stdout, "Unique: onsets found by no other method.")
+	return nil
+}
+
+// countUnique counts the onsets of method i that no other method detected
+// within tolerance seconds
+func countUnique(onsets [][]float64, i int, tolerance float64) int {
+	unique := 0
+	for _, t := range onsets[i] {
+		found := false
+		for j, other := range onsets {
+			if j == i {
+				continue
+			}
+			for _, u := range other {
+				if math.Abs(t-u) <= tolerance {
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			unique++
+		}
+	}
+	return unique
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/schollz/onsets"
+)
+
+// detectOutput is the JSON output of the detect command
+type detectOutput struct {
+	File       string    `json:"file"`
+	SampleRate uint      `json:"sample_rate"`
+	Method     string    `json:"method"`
+	Unit       string    `json:"unit"`
+	Onsets     []float64 `json:"onsets"`
+	// Votes lists, for the consensus method, the methods that detected
+	// every onset
+	Votes [][]voteOutput `json:"votes,omitempty"`
+}
+
+// voteOutput is the detection of a consensus onset by one method, in the
+// unit of the onsets
+type voteOutput struct {
+	Method string  `json:"method"`
+	Time   float64 `json:"time"`
+}
+
+// runDetect prints the onset times of an audio file
+func runDetect(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("detect", flag.ContinueOnError)
+	analysis := addAnalysisFlags(fs)
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	inSamples := fs.Bool("samples", false, "print onset positions in samples instead of seconds")
+	rawSpec := fs.String("raw", "", "read headerless PCM from stdin in the format rate:channels:encoding, such as 44100:1:s16le")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaubio-onset detect <file|-> [flags]")
+		fmt.Fprintln(fs.Output(), "With \"-\", WAV (or raw PCM with --raw) is read from stdin and onsets are printed as they are detected.")
+		fs.PrintDefaults()
+	}
+
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one audio file")
+	}
+	options, err := analysis.options()
+	if err != nil {
+		return err
+	}
+
+	if files[0] == "-" {
+		return detectStdin(os.Stdin, *rawSpec, options, *asJSON, *inSamples, stdout)
+	}
+	if *rawSpec != "" {
+		return fmt.Errorf("--raw requires reading from stdin (\"-\")")
+	}
+
+	result, err := onset.AnalyzeSlices(files[0], options)
+	if err != nil {
+		return err
+	}
+
+	unit := "seconds"
+	convert := func(t float64) float64 { return t }
+	if *inSamples {
+		unit = "samples"
+		convert = func(t float64) float64 { return float64(int(t * float64(result.SampleRate))) }
+	}
+	onsets := make([]float64, len(result.Onsets))
+	for i, t := range result.Onsets {
+		onsets[i] = convert(t)
+	}
+
+	if *asJSON {
+		var votes [][]voteOutput
+		for _, onsetVotes := range result.Votes {
+			converted := make([]voteOutput, len(onsetVotes))
+			for i, vote := range onsetVotes {
+				converted[i] = voteOutput{Method: vote.Method, Time: convert(vote.Time)}
+			}
+			votes = append(votes, converted)
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(detectOutput{
+			File:       files[0],
+			SampleRate: result.SampleRate,
+			Method:     options.Method,
+			Unit:       unit,
+			Onsets:     onsets,
+			Votes:      votes,
+		})
+	}
+
+	for _, v := range onsets {
+		if *inSamples {
+			fmt.Fprintf(stdout, "%d\n", int(v))
+		} else {
+			fmt.Fprintf(stdout, "%.6f\n", v)
+		}
+	}
+	return nil
+}
+
+// detectStdin detects onsets in WAV or raw PCM read from r, printing each onset
+// as soon as it is detected. JSON output is written once the stream ends.
+func detectStdin(r io.Reader, rawSpec string, options onset.SliceAnalyzerOptions, asJSON, inSamples bool, stdout io.Writer) error {
+	format, r, err := pcmInput(r, rawSpec)
+	if err != nil {
+		return err
+	}
+
+	unit := "seconds"
+	if inSamples {
+		unit = "samples"
+	}
+	onsets := []float64{}
+	err = onset.DetectStream(r, format, options, func(onsetTime, strength float64) {
+		v := onsetTime
+		if inSamples {
+			v = float64(int(onsetTime * float64(format.SampleRate)))
+		}
+		switch {
+		case asJSON:
+			onsets = append(onsets, v)
+		case inSamples:
+			fmt.Fprintf(stdout, "%d\n", int(v))
+		default:
+			fmt.Fprintf(stdout, "%.6f\n", v)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(detectOutput{
+			File:       "-",
+			SampleRate: format.SampleRate,
+			Method:     options.Method,
+			Unit:       unit,
+			Onsets:     onsets,
+		})
+	}
+	return nil
+}
+
+// pcmInput returns the format and PCM data of r, which holds raw PCM in the
+// format given by rawSpec, or WAV if rawSpec is empty
+func pcmInput(r io.Reader, rawSpec string) (onset.RawFormat, io.Reader, error) {
+	if rawSpec == "" {
+		return onset.ReadWavHeader(r)
+	}
+	format, err := onset.ParseRawFormat(rawSpec)
+	return format, r, err
+}
+
+// streamOnsets calls fn for every onset detected in the WAV or raw PCM read
+// from r. Reading stops at the first error returned by fn.
+func streamOnsets(r io.Reader, rawSpec string, options onset.SliceAnalyzerOptions, fn func(onsetTime, strength float64) error) error {
+	format, r, err := pcmInput(r, rawSpec)
+	if err != nil {
+		return err
+	}
+
+	stop := &stopReader{r: r}
+	return onset.DetectStream(stop, format, options, func(onsetTime, strength float64) {
+		if stop.err == nil {
+			stop.err = fn(onsetTime, strength)
+		}
+	})
+}
+
+// stopReader reads from r until err is set
+type stopReader struct {
+	r   io.Reader
+	err error
+}
+
+// Read reads from the underlying reader, or returns err once it is set
+func (s *stopReader) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.r.Read(p)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/schollz/onsets"
+)
+
+// parseArgs parses fs from args, allowing flags to appear before, between and
+// after the positional arguments, which are returned in order. Arguments after
+// a "--" terminator are always positional.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if len(args) > len(rest) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// analysisFlags holds the analysis options shared by the subcommands
+type analysisFlags struct {
+	method    string
+	threshold float64
+	minioi    float64
+	highpass  float64
+	removeDC  bool
+	gate      float64
+	flatness  float64
+	crest     float64
+	minSlice  float64
+	maxSlice  float64
+	numSlices int
+	selection string
+	optimize  bool
+	windowMs  float64
+	backtrack bool
+	spacing   float64
+	channel   string
+	ffmpeg    bool
+	workers   int
+	params    string
+	preset    string
+	fs        *flag.FlagSet
+}
+
+// addAnalysisFlags registers the analysis flags on fs
+func addAnalysisFlags(fs *flag.FlagSet) *analysisFlags {
+	defaults := onset.DefaultSliceAnalyzerOptions()
+	f := &analysisFlags{fs: fs}
+
+	fs.StringVar(&f.method, "m", defaults.Method, "onset detection method (shorthand for -method)")
+	fs.StringVar(&f.method, "method", defaults.Method, "onset detection method: hfc, energy, complex, phase, wphase, specdiff, kl, mkl, specflux or consensus")
+	fs.Float64Var(&f.threshold, "t", 0, "peak picking threshold (shorthand for -threshold)")
+	fs.Float64Var(&f.threshold, "threshold", 0, "peak picking threshold, 0 for the relaxed default")
+	fs.Float64Var(&f.minioi, "minioi", 0, "minimum inter-onset interval in milliseconds, 0 for the default")
+	fs.Float64Var(&f.highpass, "highpass", 0, "highpass cutoff in Hz applied before detection, 0 to disable")
+	fs.BoolVar(&f.removeDC, "remove-dc", false, "remove the DC offset before detection")
+	fs.Float64Var(&f.gate, "gate", 0, "noise gate threshold in dBFS applied before detection, such as -50; 0 to disable")
+	fs.Float64Var(&f.flatness, "min-flatness", 0, "suppress onsets in frames with a spectral flatness below this, such as 0.02 for tonal material; 0 to disable")
+	fs.Float64Var(&f.crest, "max-crest", 0, "suppress onsets in frames with a spectral crest factor above this; 0 to disable")
+	fs.IntVar(&f.numSlices, "n", 0, "number of onsets to keep, 0 for all")
+	fs.StringVar(&f.selection, "select", onset.SelectionEnergy, "ranking of the onsets kept by -n: energy or strength (detection function value)")
+	fs.BoolVar(&f.optimize, "optimize", defaults.Optimize, "refine onset positions using variance analysis")
+	fs.Float64Var(&f.windowMs, "window", defaults.OptimizeWindowMs, "optimization window in milliseconds")
+	fs.BoolVar(&f.backtrack, "backtrack", false, "move onsets back to the preceding energy minimum")
+	fs.Float64Var(&f.spacing, "spacing", defaults.MinimumSpacing, "minimum spacing between onsets in milliseconds, 0 to disable")
+	fs.Float64Var(&f.minSlice, "min-slice", 0, "merge slices shorter than this many milliseconds into their neighbors, 0 to disable")
+	fs.Float64Var(&f.maxSlice, "max-slice", 0, "subdivide slices longer than this many milliseconds, 0 to disable")
+	fs.StringVar(&f.channel, "channel", defaults.Channel, "channel to analyze: left, right, mix, mid, side or a zero-based index")
+	fs.BoolVar(&f.ffmpeg, "ffmpeg", false, "decode unsupported formats with ffmpeg")
+	fs.IntVar(&f.workers, "workers", runtime.NumCPU(), "number of goroutines analyzing long files in chunks")
+	fs.StringVar(&f.preset, "preset", "", "material preset: "+strings.Join(onset.ListPresets(), ", ")+"; explicit flags take precedence")
+	fs.StringVar(&f.params, "params", "", "detection preset file (.json or .toml) whose method, threshold and minioi apply unless set by flags")
+
+	return f
+}
+
+// options converts the flags to analyzer options
+func (f *analysisFlags) options() (onset.SliceAnalyzerOptions, error) {
+	if f.numSlices < 0 {
+		return onset.SliceAnalyzerOptions{}, fmt.Errorf("number of onsets must be 0 or greater")
+	}
+	if f.workers < 0 {
+		return onset.SliceAnalyzerOptions{}, fmt.Errorf("number of workers must be 0 or greater")
+	}
+
+	options := onset.DefaultSliceAnalyzerOptions()
+	options.Method = f.method
+	options.Threshold = f.threshold
+	options.MinioiMs = f.minioi
+	options.InputHighpassHz = f.highpass
+	options.RemoveDC = f.removeDC
+	options.NoiseGateDB = f.gate
+	options.MinFlatness = f.flatness
+	options.MaxCrest = f.crest
+	options.NumSlices = f.numSlices
+	options.Selection = f.selection
+	options.Optimize = f.optimize
+	options.OptimizeWindowMs = f.windowMs
+	options.Backtrack = f.backtrack
+	options.UseMinimumSpacing = f.spacing > 0
+	options.MinimumSpacing = f.spacing
+	options.MinSliceMs = f.minSlice
+	options.MaxSliceMs = f.maxSlice
+	options.Channel = f.channel
+	options.Workers = f.workers
+	if f.ffmpeg {
+		options.DecoderFallback = onset.DecoderFallbackFFmpeg
+	}
+	if f.params != "" {
+		preset, err := onset.LoadParams(f.params)
+		if err != nil {
+			return onset.SliceAnalyzerOptions{}, err
+		}
+		options.Params = &preset
+		if preset.Method != "" && !f.isSet("m", "method") {
+			options.Method = preset.Method
+		}
+	}
+	if f.preset != "" {
+		preset, ok := onset.PresetInfo(f.preset)
+		if !ok {
+			return onset.SliceAnalyzerOptions{}, fmt.Errorf("unknown preset %q: supported presets are %s", f.preset, strings.Join(onset.ListPresets(), ", "))
+		}
+		options = preset.Apply(options)
+		if f.isSet("m", "method") {
+			options.Method = f.method
+		}
+	}
+	return options, nil
+}
+
+// isSet reports whether any of the named flags was given on the command line
+func (f *analysisFlags) isSet(names ...string) bool {
+	set := false
+	f.fs.Visit(func(fl *flag.Flag) {
+		for _, name := range names {
+			if fl.Name == name {
+				set = true
+			}
+		}
+	})
+	return set
+}
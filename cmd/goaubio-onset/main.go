@@ -0,0 +1,88 @@
+// Command goaubio-onset detects onsets in audio files and slices them.
+//
+// Usage:
+//
+//	goaubio-onset <command> [arguments]
+//
+// Run "goaubio-onset help" for the list of commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// command is a goaubio-onset subcommand
+type command struct {
+	// summary is the one-line description shown in the usage
+	summary string
+	// run executes the command with the arguments following its name
+	run func(args []string, stdout io.Writer) error
+}
+
+// commands maps subcommand names to their implementation
+var commands = map[string]command{
+	"compare":   {summary: "compare the onsets found by several detection methods", run: runCompare},
+	"detect":    {summary: "print the onset times of an audio file", run: runDetect},
+	"midi":      {summary: "send a MIDI note for every onset of a live stream", run: runMidi},
+	"mqtt":      {summary: "publish every onset of a live stream to an MQTT topic", run: runMqtt},
+	"plot":      {summary: "render the waveform and onsets of an audio file to a PNG image", run: runPlot},
+	"rearrange": {summary: "write the slices of an audio file in the order of a pattern to a WAV file", run: runRearrange},
+	"selftest":  {summary: "check every detection method on a synthesized signal", run: runSelfTest},
+	"serve":     {summary: "serve onset analysis as an HTTP JSON API", run: runServe},
+	"slice":     {summary: "write the slices of an audio file to WAV files", run: runSlice},
+	"split":     {summary: "write the transients and the sustain of an audio file to two WAV files", run: runSplit},
+	"tempo":     {summary: "print the tempo and beat times of an audio file", run: runTempo},
+	"watch":     {summary: "analyze or slice audio files as they appear in a directory", run: runWatch},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage(os.Stdout)
+		return
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "goaubio-onset: unknown command %q\n", name)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:], os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "goaubio-onset %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the list of commands
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: goaubio-onset <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run \"goaubio-onset <command> -h\" for the flags of a command.")
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schollz/onsets"
+	"github.com/schollz/onsets/onsethttp"
+)
+
+func TestParseArgs(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	method := fs.String("m", "hfc", "")
+	asJSON := fs.Bool("json", false, "")
+
+	positional, err := parseArgs(fs, []string{"a.wav", "-m", "complex", "b.wav", "--json", "--", "-c.wav"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	if want := []string{"a.wav", "b.wav", "-c.wav"}; !reflect.DeepEqual(positional, want) {
+		t.Errorf("Expected positional arguments %v, got %v", want, positional)
+	}
+	if *method != "complex" || !*asJSON {
+		t.Errorf("Expected flags after positional arguments to be parsed, got m=%q json=%v", *method, *asJSON)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	var out bytes.Buffer
+	if err := runDetect([]string{"../../amen.wav", "-n", "4", "--json"}, &out); err != nil {
+		t.Fatalf("detect failed: %v", err)
+	}
+
+	var result detectOutput
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse JSON output: %v", err)
+	}
+	if result.SampleRate != 44100 || result.Unit != "seconds" || len(result.Onsets) != 4 || result.Votes != nil {
+		t.Errorf("Unexpected output: %+v", result)
+	}
+
+	out.Reset()
+	if err := runDetect([]string{"../../amen.wav", "-m", "consensus", "-n", "4", "--json", "--samples"}, &out); err != nil {
+		t.Fatalf("detect failed: %v", err)
+	}
+	result = detectOutput{}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse JSON output: %v", err)
+	}
+	if len(result.Votes) != len(result.Onsets) || len(result.Votes[0]) < 3 || result.Votes[0][0].Time < 1 {
+		t.Errorf("Expected the votes of every onset in samples, got %+v", result)
+	}
+
+	out.Reset()
+	if err := runDetect([]string{"../../amen.wav", "-n", "4", "--samples"}, &out); err != nil {
+		t.Fatalf("detect failed: %v", err)
+	}
+	lines := strings.Fields(out.String())
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 lines, got %q", out.String())
+	}
+
+	if err := runDetect(nil, io.Discard); err == nil {
+		t.Error("Expected error without a file, got nil")
+	}
+}
+
+func TestDetectParams(t *testing.T) {
+	preset := filepath.Join(t.TempDir(), "drums.toml")
+	if err := os.WriteFile(preset, []byte("method = \"specflux\"\nthreshold = 0.3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		args   []string
+		method string
+	}{
+		{[]string{"-params", preset}, "specflux"},
+		{[]string{"-params", preset, "-m", "energy"}, "energy"},
+		{[]string{"-preset", "vocals"}, "complex"},
+		{[]string{"-preset", "vocals", "-m", "energy"}, "energy"},
+	} {
+		var out bytes.Buffer
+		if err := runDetect(append([]string{"../../amen.wav", "--json"}, tc.args...), &out); err != nil {
+			t.Fatalf("detect %v failed: %v", tc.args, err)
+		}
+		var result detectOutput
+		if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to parse JSON output: %v", err)
+		}
+		if result.Method != tc.method || len(result.Onsets) == 0 {
+			t.Errorf("detect %v: expected onsets of method %s, got %+v", tc.args, tc.method, result)
+		}
+	}
+
+	if err := runDetect([]string{"../../amen.wav", "-params", "missing.toml"}, io.Discard); err == nil {
+		t.Error("Expected error for a missing preset, got nil")
+	}
+	if err := runDetect([]string{"../../amen.wav", "-preset", "polka"}, io.Discard); err == nil {
+		t.Error("Expected error for an unknown material preset, got nil")
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	var out bytes.Buffer
+	if err := runSelfTest(nil, &out); err != nil {
+		t.Fatalf("selftest failed: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "specflux") || !strings.HasSuffix(out.String(), "PASS\n") {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := runSelfTest([]string{"--json"}, &out); err != nil {
+		t.Fatalf("selftest failed: %v", err)
+	}
+	var report onset.SelfTestReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil || !report.Passed {
+		t.Errorf("Expected a passing JSON report, got %q (%v)", out.String(), err)
+	}
+}
+
+func TestDetectStdin(t *testing.T) {
+	wav, err := os.ReadFile("../../amen.wav")
+	if err != nil {
+		t.Fatalf("Failed to read amen.wav: %v", err)
+	}
+	var out bytes.Buffer
+	if err := detectStdin(bytes.NewReader(wav), "", onset.DefaultSliceAnalyzerOptions(), false, false, &out); err != nil {
+		t.Fatalf("detect from stdin failed: %v", err)
+	}
+	if len(strings.Fields(out.String())) == 0 {
+		t.Error("Expected onsets from stdin, got none")
+	}
+
+	if err := detectStdin(bytes.NewReader(wav), "44100:1:s12le", onset.DefaultSliceAnalyzerOptions(), false, false, io.Discard); err == nil {
+		t.Error("Expected error for an invalid raw format, got nil")
+	}
+	if err := runDetect([]string{"../../amen.wav", "--raw", "44100:1:s16le"}, io.Discard); err == nil {
+		t.Error("Expected error for --raw with a file, got nil")
+	}
+}
+
+func TestSlice(t *testing.T) {
+	dir := t.TempDir()
+	var out bytes.Buffer
+	if err := runSlice([]string{"../../amen.wav", "-n", "4", "-o", dir, "--name", "{index}.wav"}, &out); err != nil {
+		t.Fatalf("slice failed: %v", err)
+	}
+
+	paths := strings.Fields(out.String())
+	if len(paths) != 4 || filepath.Base(paths[0]) != "01.wav" {
+		t.Errorf("Unexpected slice files: %v", paths)
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Slice file missing: %v", err)
+		}
+	}
+
+	out.Reset()
+	if err := runSlice([]string{"../../amen.wav", "-n", "2", "-o", t.TempDir(), "-normalize-lufs", "-16", "-normalize", "-1", "-fade-in", "2", "-fade-out", "10"}, &out); err != nil {
+		t.Fatalf("slice with normalization and fades failed: %v", err)
+	}
+	if paths := strings.Fields(out.String()); len(paths) != 2 {
+		t.Errorf("Unexpected slice files: %v", paths)
+	}
+	if err := runSlice([]string{"../../amen.wav", "-o", t.TempDir(), "-fade-in", "-1"}, io.Discard); err == nil {
+		t.Error("Expected error for a negative fade, got nil")
+	}
+}
+
+func TestRearrange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rearranged.wav")
+	var out bytes.Buffer
+	if err := runRearrange([]string{"../../amen.wav", "-p", "1 2 1 3r 4x2", "-o", path, "-optimize=false"}, &out); err != nil {
+		t.Fatalf("rearrange failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "6 steps") {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Rearranged file missing: %v", err)
+	}
+
+	if err := runRearrange([]string{"../../amen.wav", "-p", "1 0"}, io.Discard); err == nil {
+		t.Error("Expected error for an invalid pattern, got nil")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	dir := t.TempDir()
+	var out bytes.Buffer
+	if err := runSplit([]string{"../../amen.wav", "-o", dir, "-attack", "20", "-optimize=false"}, &out); err != nil {
+		t.Fatalf("split failed: %v", err)
+	}
+	for _, name := range []string{"amen_transients.wav", "amen_sustain.wav"} {
+		path := filepath.Join(dir, name)
+		if !strings.Contains(out.String(), path) {
+			t.Errorf("Expected %s in output %q", path, out.String())
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Split file missing: %v", err)
+		}
+	}
+
+	if err := runSplit([]string{"../../amen.wav", "-o", dir, "-attack", "-1"}, io.Discard); err == nil {
+		t.Error("Expected error for a negative attack, got nil")
+	}
+}
+
+func TestPlot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plot.png")
+	if err := runPlot([]string{"../../amen.wav", "-o", path, "-width", "300", "-height", "120", "-novelty", "-grid"}, io.Discard); err != nil {
+		t.Fatalf("plot failed: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Plot file missing: %v", err)
+	}
+	defer f.Close()
+	cfg, err := png.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("Invalid PNG: %v", err)
+	}
+	if cfg.Width != 300 || cfg.Height != 120 {
+		t.Errorf("Expected 300x120 image, got %dx%d", cfg.Width, cfg.Height)
+	}
+
+	spectrogram := filepath.Join(t.TempDir(), "spectrogram.png")
+	if err := runPlot([]string{"../../amen.wav", "-o", spectrogram, "-spectrogram", "-logfreq", "-optimize=false"}, io.Discard); err != nil {
+		t.Fatalf("plot spectrogram failed: %v", err)
+	}
+	if _, err := os.Stat(spectrogram); err != nil {
+		t.Errorf("Spectrogram file missing: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runPlot([]string{"../../amen.wav", "-terminal", "60", "-optimize=false"}, &out); err != nil {
+		t.Fatalf("plot terminal failed: %v", err)
+	}
+	if lines := strings.Split(out.String(), "\n"); len(lines) < 6 || !strings.Contains(lines[4], "^") {
+		t.Errorf("Expected a waveform with onset markers, got:\n%s", out.String())
+	}
+
+	svg := filepath.Join(t.TempDir(), "plot.svg")
+	if err := runPlot([]string{"../../amen.wav", "-o", svg, "-novelty", "-optimize=false"}, io.Discard); err != nil {
+		t.Fatalf("plot SVG failed: %v", err)
+	}
+	if content, err := os.ReadFile(svg); err != nil || !strings.HasPrefix(string(content), "<svg") {
+		t.Errorf("Expected an SVG file, got error %v", err)
+	}
+
+	report := filepath.Join(t.TempDir(), "report.html")
+	if err := runPlot([]string{"../../amen.wav", "-o", report, "-optimize=false"}, io.Discard); err != nil {
+		t.Fatalf("plot report failed: %v", err)
+	}
+	content, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatalf("Report file missing: %v", err)
+	}
+	if !strings.Contains(string(content), "<canvas") {
+		t.Error("Expected a waveform canvas in the report")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	var out bytes.Buffer
+	if err := runCompare([]string{"../../amen.wav", "--methods", "hfc,complex", "-optimize=false"}, &out); err != nil {
+		t.Fatalf("compare failed: %v", err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	if len(lines) < 3 || !strings.Contains(lines[1], "hfc") || !strings.Contains(lines[2], "complex") {
+		t.Errorf("Unexpected compare output:\n%s", out.String())
+	}
+	if !strings.Contains(lines[1], "100%") {
+		t.Errorf("Expected a method to fully agree with itself:\n%s", out.String())
+	}
+
+	if err := runCompare([]string{"../../amen.wav", "--methods", "hfc"}, io.Discard); err == nil {
+		t.Error("Expected error for a single method, got nil")
+	}
+}
+
+func TestServeAnalyze(t *testing.T) {
+	audio, err := os.ReadFile("../../amen.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", "amen.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(audio)
+	mw.WriteField("slices", "8")
+	mw.WriteField("optimize", "false")
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/analyze", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	newServeMux(100<<20).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response onsethttp.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if len(response.Onsets) != 8 || len(response.Slices) != 8 {
+		t.Errorf("Expected 8 onsets and slices, got %d and %d", len(response.Onsets), len(response.Slices))
+	}
+	if response.BPM <= 0 || response.SampleRate != 44100 {
+		t.Errorf("Expected a tempo and the sample rate, got %+v", response)
+	}
+
+	rec = httptest.NewRecorder()
+	newServeMux(100<<20).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/analyze", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", rec.Code)
+	}
+}
+
+func TestTempo(t *testing.T) {
+	var out bytes.Buffer
+	if err := runTempo([]string{"../../amen.wav", "--json", "-optimize=false"}, &out); err != nil {
+		t.Fatalf("tempo failed: %v", err)
+	}
+
+	var result tempoOutput
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse JSON output: %v", err)
+	}
+	if result.BPM < 60 || result.BPM > 200 || len(result.Beats) == 0 || result.Curve != nil {
+		t.Errorf("Unexpected tempo output: %+v", result)
+	}
+
+	out.Reset()
+	if err := runTempo([]string{"../../amen.wav", "--json", "-optimize=false", "-curve", "3"}, &out); err != nil {
+		t.Fatalf("tempo failed: %v", err)
+	}
+	result = tempoOutput{}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse JSON output: %v", err)
+	}
+	if len(result.Curve) < 2 || len(result.Beats) == 0 {
+		t.Errorf("Expected a tempo curve, got %+v", result)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	outDir := filepath.Join(dir, "slices")
+	source, err := onset.AnalyzeSlices("../../amen.wav", onset.SliceAnalyzerOptions{Method: "hfc"})
+	if err != nil {
+		t.Fatalf("Failed to read amen.wav: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &syncBuffer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- watchDir(ctx, dir, watchConfig{
+			action:  "slice",
+			options: onset.SliceAnalyzerOptions{Method: "hfc", NumSlices: 4},
+			outDir:  outDir,
+			settle:  50 * time.Millisecond,
+		}, out)
+	}()
+
+	// Wait for the watcher to be set up before writing the file
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(out.String(), "watching") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := onset.WriteWav(filepath.Join(dir, "loop.wav"), source.Samples, source.SampleRate, 16); err != nil {
+		t.Fatalf("Failed to write WAV: %v", err)
+	}
+	for !strings.Contains(out.String(), "wrote") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("watch failed: %v", err)
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(outDir, "loop", "*.wav"))
+	if len(paths) != 4 {
+		t.Errorf("Expected 4 slices, got %d: %s", len(paths), out.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestMidi(t *testing.T) {
+	port := filepath.Join(t.TempDir(), "midi")
+	if err := os.WriteFile(port, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runMidi([]string{"../../amen.wav", "--port", port, "-t", "0.3", "--note", "38"}, io.Discard); err != nil {
+		t.Fatalf("midi failed: %v", err)
+	}
+
+	messages, err := os.ReadFile(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) == 0 || len(messages)%6 != 0 {
+		t.Fatalf("Expected note-on/note-off pairs, got %d bytes", len(messages))
+	}
+	for i := 0; i < len(messages); i += 6 {
+		if messages[i] != 0x99 || messages[i+1] != 38 || messages[i+2] == 0 || messages[i+3] != 0x89 {
+			t.Fatalf("Unexpected messages % x", messages[i:i+6])
+		}
+	}
+
+	// Ports that are not files are opened through the MIDI driver, which this
+	// build does not link
+	if err := runMidi([]string{"../../amen.wav", "--port", "Synth"}, io.Discard); err == nil {
+		t.Error("Expected error for a MIDI port without a driver, got nil")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/schollz/onsets"
+	"github.com/schollz/onsets/onsetmidi"
+)
+
+// runMidi sends a MIDI note for every onset detected in a stream
+func runMidi(args []string, stdout io.Writer) error {
+	defaults := onsetmidi.DefaultOptions()
+	fs := flag.NewFlagSet("midi", flag.ContinueOnError)
+	analysis := addAnalysisFlags(fs)
+	port := fs.String("port", "", "MIDI output port to send to, by a substring of its name, or a raw MIDI device such as /dev/snd/midiC1D0")
+	listPorts := fs.Bool("list-ports", false, "list the MIDI output ports and exit")
+	rawSpec := fs.String("raw", "", "read headerless PCM in the format rate:channels:encoding, such as 44100:1:s16le")
+	channel := fs.Int("midi-channel", defaults.Channel, "MIDI channel (1-16)")
+	note := fs.Int("note", defaults.Note, "MIDI note number")
+	noteLength := fs.Duration("note-length", defaults.NoteLength, "time between note-on and note-off")
+	verbose := fs.Bool("v", false, "print the time and strength of every onset")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaubio-onset midi <-|file> --port <port|device> [--raw rate:channels:encoding] [flags]")
+		fmt.Fprintln(fs.Output(), "Reads WAV (or raw PCM with --raw) from stdin or a file, such as a FIFO, and sends")
+		fmt.Fprintln(fs.Output(), "a note-on with a velocity mapped from the onset strength for every onset.")
+		fmt.Fprintln(fs.Output(), "MIDI output ports need a build with the rtmidi tag.")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *listPorts {
+		names, err := onsetmidi.Ports()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Fprintln(stdout, name)
+		}
+		return nil
+	}
+	if len(inputs) != 1 || *port == "" {
+		fs.Usage()
+		return fmt.Errorf("expected one input and a MIDI port")
+	}
+	options, err := analysis.options()
+	if err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if inputs[0] != "-" {
+		f, err := os.Open(inputs[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	device, err := openMidiPort(*port)
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+
+	trigger, err := onsetmidi.NewTrigger(device, onsetmidi.Options{
+		Channel:    *channel,
+		Note:       *note,
+		NoteLength: *noteLength,
+	})
+	if err != nil {
+		return err
+	}
+	return sendMidi(input, *rawSpec, options, trigger, *verbose, stdout)
+}
+
+// openMidiPort opens a raw MIDI device if port is a file, and the MIDI output
+// port whose name contains port otherwise
+func openMidiPort(port string) (io.WriteCloser, error) {
+	if _, err := os.Stat(port); err == nil {
+		device, err := os.OpenFile(port, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open MIDI device: %w", err)
+		}
+		return device, nil
+	}
+	return onsetmidi.OpenPort(port)
+}
+
+// sendMidi triggers a note for every onset detected in the WAV or raw PCM read from r
+func sendMidi(r io.Reader, rawSpec string, options onset.SliceAnalyzerOptions, trigger *onsetmidi.Trigger, verbose bool, stdout io.Writer) error {
+	err := streamOnsets(r, rawSpec, options, func(onsetTime, strength float64) error {
+		if verbose {
+			fmt.Fprintf(stdout, "%.6f %g\n", onsetTime, strength)
+		}
+		return trigger.Onset(strength)
+	})
+	if closeErr := trigger.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,7 @@
+//go:build rtmidi
+
+package main
+
+// Link the rtmidi driver, which needs cgo and, on Linux, the ALSA development
+// headers, so that the midi command can open the MIDI output ports
+import _ "gitlab.com/gomidi/midi/v2/drivers/rtmididrv"
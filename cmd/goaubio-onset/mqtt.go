@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/schollz/onsets"
+	"github.com/schollz/onsets/onsetmqtt"
+)
+
+// runMqtt publishes every onset detected in a stream to an MQTT topic
+func runMqtt(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("mqtt", flag.ContinueOnError)
+	analysis := addAnalysisFlags(fs)
+	broker := fs.String("broker", "tcp://localhost:1883", "MQTT broker URL")
+	topic := fs.String("topic", "onsets", "topic to publish onset events to")
+	clientID := fs.String("client-id", "goaubio-onset", "MQTT client identifier")
+	username := fs.String("username", "", "MQTT username")
+	password := fs.String("password", os.Getenv("MQTT_PASSWORD"), "MQTT password (default: $MQTT_PASSWORD)")
+	qos := fs.Uint("qos", 0, "MQTT quality of service level: 0, 1 or 2")
+	rawSpec := fs.String("raw", "", "read headerless PCM in the format rate:channels:encoding, such as 44100:1:s16le")
+	verbose := fs.Bool("v", false, "print the time and strength of every onset")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaubio-onset mqtt <-|file> [--broker tcp://host:1883] [--topic onsets] [--raw rate:channels:encoding] [flags]")
+		fmt.Fprintln(fs.Output(), "Reads WAV (or raw PCM with --raw) from stdin or a file, such as a FIFO, and publishes")
+		fmt.Fprintln(fs.Output(), "a JSON event {\"time\", \"strength\", \"timestamp\"} for every onset.")
+		fs.PrintDefaults()
+	}
+
+	inputs, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(inputs) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one input")
+	}
+	if *qos > 2 {
+		return fmt.Errorf("invalid QoS: %d", *qos)
+	}
+	options, err := analysis.options()
+	if err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if inputs[0] != "-" {
+		f, err := os.Open(inputs[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	publisher, err := onsetmqtt.Connect(onsetmqtt.Options{
+		Broker:   *broker,
+		ClientID: *clientID,
+		Username: *username,
+		Password: *password,
+		Topic:    *topic,
+		QoS:      byte(*qos),
+	})
+	if err != nil {
+		return err
+	}
+	defer publisher.Close()
+
+	return publishOnsets(input, *rawSpec, options, publisher, *verbose, stdout)
+}
+
+// publishOnsets publishes every onset detected in the WAV or raw PCM read from r
+func publishOnsets(r io.Reader, rawSpec string, options onset.SliceAnalyzerOptions, publisher *onsetmqtt.Publisher, verbose bool, stdout io.Writer) error {
+	return streamOnsets(r, rawSpec, options, func(onsetTime, strength float64) error {
+		if verbose {
+			fmt.Fprintf(stdout, "%.6f %g\n", onsetTime, strength)
+		}
+		return publisher.Publish(onsetTime, strength)
+	})
+}
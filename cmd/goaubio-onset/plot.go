@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/schollz/onsets"
+	"github.com/schollz/onsets/plot"
+)
+
+// runPlot renders the waveform and onsets of an audio file to a PNG image, to
+// an SVG image if the output file ends in .svg, or to an interactive HTML
+// report if it ends in .html
+func runPlot(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("plot", flag.ContinueOnError)
+	analysis := addAnalysisFlags(fs)
+	output := fs.String("o", "plot.png", "output PNG file, SVG if it ends in .svg, or HTML report if it ends in .html")
+	width := fs.Int("width", 1200, "image width in pixels")
+	height := fs.Int("height", 400, "image height in pixels")
+	novelty := fs.Bool("novelty", false, "draw the detection function below the waveform")
+	grid := fs.Bool("grid", false, "draw a time and amplitude grid behind the waveform")
+	spectrogram := fs.Bool("spectrogram", false, "draw the spectrogram instead of the waveform")
+	logFrequency := fs.Bool("logfreq", false, "space the frequencies of the spectrogram logarithmically")
+	terminal := fs.Int("terminal", 0, "print the waveform to the terminal, `N` characters wide, instead of writing an image")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaubio-onset plot <file> [-o plot.png] [flags]")
+		fs.PrintDefaults()
+	}
+
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one audio file")
+	}
+	options, err := analysis.options()
+	if err != nil {
+		return err
+	}
+
+	result, err := onset.AnalyzeSlices(files[0], options)
+	if err != nil {
+		return err
+	}
+
+	if *terminal > 0 {
+		return result.WriteTerminal(stdout, *terminal)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(*output)); {
+	case ext == ".html" || ext == ".htm":
+		if err := plot.WriteHTMLReport(result, *output); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s: %d onsets\n", *output, len(result.Onsets))
+		return nil
+	case ext == ".svg" && !*spectrogram:
+		err := plot.WriteSVGFile(result, *output, plot.PlotOptions{
+			Width:       *width,
+			Height:      *height,
+			ShowNovelty: *novelty,
+			ShowGrid:    *grid,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s: %d onsets\n", *output, len(result.Onsets))
+		return nil
+	}
+
+	var img image.Image
+	if *spectrogram {
+		img, err = plot.PlotSpectrogram(result, plot.SpectrogramOptions{
+			Width:        *width,
+			Height:       *height,
+			LogFrequency: *logFrequency,
+		})
+	} else {
+		img, err = plot.PlotResult(result, plot.PlotOptions{
+			Width:       *width,
+			Height:      *height,
+			ShowNovelty: *novelty,
+			ShowGrid:    *grid,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("failed to create image file: %w", err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write image file: %w", err)
+	}
+
+	fmt.Fprintf(stdout, "%s: %d onsets\n", *output, len(result.Onsets))
+	return nil
+}
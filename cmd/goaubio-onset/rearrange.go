@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/schollz/onsets"
+)
+
+// runRearrange analyzes an audio file and writes its slices in the order of a
+// pattern to a WAV file
+func runRearrange(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("rearrange", flag.ContinueOnError)
+	analysis := addAnalysisFlags(fs)
+	pattern := fs.String("p", "", "pattern of 1-based slice numbers, such as \"1 2 1 3r 4x2\" (r reverses, xN repeats)")
+	output := fs.String("o", "rearranged.wav", "output WAV file")
+	bitDepth := fs.Int("bits", 16, "bit depth of the output file: 8, 16, 24 or 32")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaubio-onset rearrange <file> -p pattern [-o rearranged.wav] [flags]")
+		fs.PrintDefaults()
+	}
+
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one audio file")
+	}
+	steps, err := onset.ParsePattern(*pattern)
+	if err != nil {
+		return err
+	}
+	options, err := analysis.options()
+	if err != nil {
+		return err
+	}
+
+	result, err := onset.AnalyzeSlices(files[0], options)
+	if err != nil {
+		return err
+	}
+	if err := onset.WriteRearranged(result, steps, *output, *bitDepth); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "%s: %d steps from %d slices\n", *output, len(steps), len(result.Onsets))
+	return nil
+}
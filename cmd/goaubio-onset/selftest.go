@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/schollz/onsets"
+)
+
+// runSelfTest checks every detection method on a synthesized reference signal
+func runSelfTest(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaubio-onset selftest [flags]")
+		fs.PrintDefaults()
+	}
+
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments %v", rest)
+	}
+
+	report := onset.SelfTest()
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprint(stdout, report)
+	}
+	if !report.Passed {
+		return fmt.Errorf("self-test failed")
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/schollz/onsets/onsethttp"
+)
+
+// runServe serves the analyzer over HTTP
+func runServe(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	maxUploadMB := fs.Int64("max-upload", 100, "maximum upload size in megabytes")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaubio-onset serve [--addr :8080]")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "POST /analyze with a multipart \"file\" field and optional form fields")
+		fmt.Fprintln(fs.Output(), "method, threshold, minioi, slices, optimize, window, spacing and channel.")
+		fmt.Fprintln(fs.Output(), "Connect a WebSocket to /live?rate=44100 and send mono float32 samples to")
+		fmt.Fprintln(fs.Output(), "receive onset events as they are detected.")
+		fs.PrintDefaults()
+	}
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           newServeMux(*maxUploadMB << 20),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	fmt.Fprintf(stdout, "listening on %s\n", *addr)
+	return server.ListenAndServe()
+}
+
+// newServeMux routes the endpoints of the serve command
+func newServeMux(maxUpload int64) *http.ServeMux {
+	opts := onsethttp.DefaultOptions()
+	opts.MaxUploadBytes = maxUpload
+
+	mux := http.NewServeMux()
+	mux.Handle("/analyze", onsethttp.Handler(opts))
+	mux.Handle("/live", onsethttp.LiveHandler(opts))
+	return mux
+}
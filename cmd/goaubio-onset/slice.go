@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/schollz/onsets"
+)
+
+// runSlice analyzes an audio file and writes every slice to a WAV file
+func runSlice(args []string, stdout io.Writer) error {
+	defaults := onset.DefaultExportOptions()
+	fs := flag.NewFlagSet("slice", flag.ContinueOnError)
+	analysis := addAnalysisFlags(fs)
+	outDir := fs.String("o", ".", "output directory")
+	template := fs.String("name", defaults.NameTemplate, "slice file name template with {name}, {index}, {count}, {start} and {time} placeholders")
+	bitDepth := fs.Int("bits", defaults.BitDepth, "bit depth of the slice files: 8, 16, 24 or 32")
+	normalize := fs.Float64("normalize", 0, "normalize every slice to this true peak in dBTP, such as -1; 0 to keep the levels")
+	normalizePeak := fs.Float64("normalize-peak", 0, "normalize every slice to this sample peak in dBFS, such as -0.3; 0 to disable")
+	normalizeLUFS := fs.Float64("normalize-lufs", 0, "normalize every slice to this integrated loudness in LUFS, such as -16, capped by the peak levels; 0 to disable")
+	fadeIn := fs.Float64("fade-in", 0, "fade-in length of every slice in milliseconds")
+	fadeOut := fs.Float64("fade-out", 0, "fade-out length of every slice in milliseconds")
+	crossfade := fs.Float64("crossfade", 0, "extend every slice past its end and crossfade the slices over this many milliseconds, for click-free resequencing")
+	minLoudness := fs.Float64("min-lufs", 0, "skip slices quieter than this integrated loudness in LUFS, such as -40; 0 to keep all")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaubio-onset slice <file> [-n slices] [-o outdir] [flags]")
+		fs.PrintDefaults()
+	}
+
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one audio file")
+	}
+	options, err := analysis.options()
+	if err != nil {
+		return err
+	}
+
+	result, err := onset.AnalyzeSlices(files[0], options)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(files[0]), filepath.Ext(files[0]))
+	paths, err := onset.ExportSlices(result, *outDir, name, onset.ExportOptions{
+		NameTemplate:        *template,
+		BitDepth:            *bitDepth,
+		MinLoudnessLUFS:     *minLoudness,
+		NormalizeTruePeakDB: *normalize,
+		NormalizePeakDB:     *normalizePeak,
+		NormalizeLUFS:       *normalizeLUFS,
+		FadeInMs:            *fadeIn,
+		FadeOutMs:           *fadeOut,
+		CrossfadeMs:         *crossfade,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		fmt.Fprintln(stdout, path)
+	}
+	if *normalize == 0 && *normalizePeak == 0 && *normalizeLUFS == 0 {
+		warnClipping(result, os.Stderr)
+	}
+	return nil
+}
+
+// warnClipping warns about the slices whose waveform clips between samples,
+// which normalization to a negative true peak prevents
+func warnClipping(result *onset.SliceAnalyzerResult, w io.Writer) {
+	for i, sr := range result.SliceRanges() {
+		if peak := onset.TruePeak(result.Samples[sr.Start:sr.End], result.SampleRate); peak > 1 {
+			fmt.Fprintf(w, "warning: slice %d clips between samples with a true peak of %+.1f dBTP; use -normalize to prevent it\n", i+1, 20*math.Log10(peak))
+		}
+	}
+}
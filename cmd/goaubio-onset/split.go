@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/schollz/onsets"
+)
+
+// runSplit analyzes an audio file and writes its transients and sustain to
+// two WAV files
+func runSplit(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("split", flag.ContinueOnError)
+	analysis := addAnalysisFlags(fs)
+	attack := fs.Float64("attack", 30, "length of the transient following every onset in milliseconds")
+	fade := fs.Float64("fade", 5, "length of the crossfades between transients and sustain in milliseconds")
+	outDir := fs.String("o", ".", "output directory of {name}_transients.wav and {name}_sustain.wav")
+	bitDepth := fs.Int("bits", 16, "bit depth of the output files: 8, 16, 24 or 32")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaubio-onset split <file> [-attack ms] [-o outdir] [flags]")
+		fs.PrintDefaults()
+	}
+
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one audio file")
+	}
+	options, err := analysis.options()
+	if err != nil {
+		return err
+	}
+
+	result, err := onset.AnalyzeSlices(files[0], options)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(files[0]), filepath.Ext(files[0]))
+	transientPath := filepath.Join(*outDir, name+"_transients.wav")
+	sustainPath := filepath.Join(*outDir, name+"_sustain.wav")
+	split := onset.TransientOptions{AttackMs: *attack, FadeMs: *fade}
+	if err := onset.WriteTransientSplit(result, split, transientPath, sustainPath, *bitDepth); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(stdout, transientPath)
+	fmt.Fprintln(stdout, sustainPath)
+	return nil
+}
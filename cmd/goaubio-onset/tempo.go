@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/schollz/onsets"
+)
+
+// tempoOutput is the JSON output of the tempo command
+type tempoOutput struct {
+	File       string    `json:"file"`
+	BPM        float64   `json:"bpm"`
+	Confidence float64   `json:"confidence"`
+	Beats      []float64 `json:"beats"`
+	// Curve is the tempo over time, with -curve
+	Curve []tempoPoint `json:"curve,omitempty"`
+}
+
+// tempoPoint is a local tempo in the JSON output of the tempo command
+type tempoPoint struct {
+	Time       float64 `json:"time"`
+	BPM        float64 `json:"bpm"`
+	Confidence float64 `json:"confidence"`
+}
+
+// runTempo prints the estimated tempo and beat times of an audio file
+func runTempo(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("tempo", flag.ContinueOnError)
+	analysis := addAnalysisFlags(fs)
+	minBPM := fs.Float64("min-bpm", 60, "lowest tempo considered")
+	maxBPM := fs.Float64("max-bpm", 200, "highest tempo considered")
+	curveSec := fs.Float64("curve", 0, "estimate the tempo over sliding windows of this many seconds, such as 8, for tempo-varying material; the beats follow the tempo curve")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaubio-onset tempo <file> [flags]")
+		fs.PrintDefaults()
+	}
+
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one audio file")
+	}
+	options, err := analysis.options()
+	if err != nil {
+		return err
+	}
+
+	result, err := onset.AnalyzeSlices(files[0], options)
+	if err != nil {
+		return err
+	}
+	if *curveSec < 0 {
+		return fmt.Errorf("invalid tempo curve window of %g s", *curveSec)
+	}
+	tempoOptions := onset.TempoOptions{MinBPM: *minBPM, MaxBPM: *maxBPM}
+	tempo := onset.EstimateTempo(result.Onsets, tempoOptions)
+	if tempo.BPM == 0 {
+		return fmt.Errorf("not enough onsets to estimate a tempo")
+	}
+	var curve []tempoPoint
+	if *curveSec > 0 {
+		c := onset.EstimateTempoCurve(result.Onsets, onset.TempoCurveOptions{TempoOptions: tempoOptions, WindowSec: *curveSec})
+		for _, p := range c.Points {
+			curve = append(curve, tempoPoint{Time: p.Time, BPM: p.BPM, Confidence: p.Confidence})
+		}
+		tempo.Beats = c.Beats
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tempoOutput{
+			File:       files[0],
+			BPM:        tempo.BPM,
+			Confidence: tempo.Confidence,
+			Beats:      tempo.Beats,
+			Curve:      curve,
+		})
+	}
+
+	fmt.Fprintf(stdout, "bpm: %.2f\n", tempo.BPM)
+	fmt.Fprintf(stdout, "confidence: %.2f\n", tempo.Confidence)
+	if curve != nil {
+		fmt.Fprintln(stdout, "curve:")
+		for _, p := range curve {
+			fmt.Fprintf(stdout, "%.3f %.2f\n", p.Time, p.BPM)
+		}
+	}
+	fmt.Fprintln(stdout, "beats:")
+	for _, beat := range tempo.Beats {
+		fmt.Fprintf(stdout, "%.6f\n", beat)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/schollz/onsets"
+)
+
+// watchConfig configures what the watch command does with new files
+type watchConfig struct {
+	// action is "detect" or "slice"
+	action string
+	// options are the analysis options
+	options onset.SliceAnalyzerOptions
+	// outDir is the root directory of the slices; each file gets a subdirectory
+	outDir string
+	// export configures the written slices
+	export onset.ExportOptions
+	// settle is how long a file must stay unchanged before it is analyzed
+	settle time.Duration
+}
+
+// runWatch watches a directory and analyzes audio files as they appear
+func runWatch(args []string, stdout io.Writer) error {
+	defaults := onset.DefaultExportOptions()
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	analysis := addAnalysisFlags(fs)
+	action := fs.String("on-new", "detect", "action for new files: detect (print onsets) or slice (write slice WAVs)")
+	outDir := fs.String("o", "", "output directory for slices (default: <dir>/slices)")
+	template := fs.String("name", defaults.NameTemplate, "slice file name template")
+	bitDepth := fs.Int("bits", defaults.BitDepth, "bit depth of the slice files")
+	settle := fs.Duration("settle", time.Second, "time a file must stay unchanged before it is analyzed")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaubio-onset watch <dir> [--on-new detect|slice] [flags]")
+		fs.PrintDefaults()
+	}
+
+	dirs, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(dirs) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one directory")
+	}
+	if *action != "detect" && *action != "slice" {
+		return fmt.Errorf("unknown action %q", *action)
+	}
+	options, err := analysis.options()
+	if err != nil {
+		return err
+	}
+	if *outDir == "" {
+		*outDir = filepath.Join(dirs[0], "slices")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	return watchDir(ctx, dirs[0], watchConfig{
+		action:  *action,
+		options: options,
+		outDir:  *outDir,
+		export:  onset.ExportOptions{NameTemplate: *template, BitDepth: *bitDepth},
+		settle:  *settle,
+	}, stdout)
+}
+
+// watchDir processes audio files created or modified in dir until ctx is done.
+// Files are processed once no event was seen for them during cfg.settle, so
+// that files still being written are not read early.
+func watchDir(ctx context.Context, dir string, cfg watchConfig, stdout io.Writer) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	absOut, err := filepath.Abs(cfg.outDir)
+	if err != nil {
+		return err
+	}
+	if cfg.action == "slice" && absOut == absDir {
+		return fmt.Errorf("output directory must differ from the watched directory")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer watcher.Close()
+	if err := watcher.Add(dir); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+	fmt.Fprintf(stdout, "watching %s\n", dir)
+
+	ready := make(chan string)
+	var mu sync.Mutex
+	timers := make(map[string]*time.Timer)
+	defer func() {
+		mu.Lock()
+		for _, timer := range timers {
+			timer.Stop()
+		}
+		mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return fmt.Errorf("watch failed: %w", err)
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			path := event.Name
+			if !onset.IsAudioFile(path, cfg.options.DecoderFallback) {
+				continue
+			}
+			if abs, err := filepath.Abs(path); err == nil && strings.HasPrefix(abs, absOut+string(filepath.Separator)) {
+				continue
+			}
+
+			// Restart the settle timer of the file on every event
+			mu.Lock()
+			if timer, ok := timers[path]; ok {
+				timer.Stop()
+			}
+			timers[path] = time.AfterFunc(cfg.settle, func() {
+				mu.Lock()
+				delete(timers, path)
+				mu.Unlock()
+				select {
+				case ready <- path:
+				case <-ctx.Done():
+				}
+			})
+			mu.Unlock()
+
+		case path := <-ready:
+			if err := processFile(path, cfg, stdout); err != nil {
+				fmt.Fprintf(stdout, "%s: %v\n", path, err)
+			}
+		}
+	}
+}
+
+// processFile runs the watch action on a single file
+func processFile(path string, cfg watchConfig, stdout io.Writer) error {
+	result, err := onset.AnalyzeSlices(path, cfg.options)
+	if err != nil {
+		return err
+	}
+
+	if cfg.action == "detect" {
+		times := make([]string, len(result.Onsets))
+		for i, t := range result.Onsets {
+			times[i] = fmt.Sprintf("%.6f", t)
+		}
+		fmt.Fprintf(stdout, "%s: %s\n", path, strings.Join(times, " "))
+		return nil
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	paths, err := onset.ExportSlices(result, filepath.Join(cfg.outDir, name), name, cfg.export)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s: wrote %d slices to %s\n", path, len(paths), filepath.Join(cfg.outDir, name))
+	return nil
+}
@@ -71,9 +71,14 @@ func (c *Cvec) Copy(source *Cvec) {
 
 // LogMag applies logarithmic compression to magnitudes
 func (c *Cvec) LogMag(lambda float64) {
-	if lambda > 0 {
-		for i := range c.Norm {
-			c.Norm[i] = math.Log(1.0 + lambda*c.Norm[i])
-		}
+	if lambda <= 0 {
+		return
+	}
+	if Accelerated() {
+		logCompress(c.Norm, lambda)
+		return
+	}
+	for i := range c.Norm {
+		c.Norm[i] = math.Log(1.0 + lambda*c.Norm[i])
 	}
 }
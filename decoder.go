@@ -0,0 +1,574 @@
+package onset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/mewkiz/flac"
+)
+
+// audioFormat identifies a supported audio container
+type audioFormat int
+
+const (
+	formatUnknown audioFormat = iota
+	formatWAV
+	formatFLAC
+	formatMP3
+)
+
+// errUnsupportedFormat is returned when the audio format cannot be detected
+var errUnsupportedFormat = errors.New("unsupported audio format")
+
+// audioStream decodes audio incrementally, one block of samples at a time
+type audioStream interface {
+	// SampleRate returns the sample rate in Hz
+	SampleRate() uint
+	// NumChannels returns the number of channels
+	NumChannels() int
+	// Read returns the next block of per-channel samples normalized to [-1.0, 1.0].
+	// It returns io.EOF once the stream is exhausted.
+	Read() ([][]float64, error)
+	// Close releases the resources held by the stream
+	Close() error
+}
+
+// streamBlockSize is the number of frames decoded per block by the PCM streams
+const streamBlockSize = 4096
+
+// readAudioFile reads an audio file, auto-detecting its format, and returns
+// the samples of every channel normalized to [-1.0, 1.0]
+func readAudioFile(filename string) ([][]float64, uint, error) {
+	s, err := openAudioFile(filename, "")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer s.Close()
+
+	return readStream(s)
+}
+
+// openAudioFile opens an audio file as a stream, auto-detecting its format.
+// Unsupported formats are decoded with the given decoder fallback, if any.
+func openAudioFile(filename string, fallback string) (audioStream, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	s, err := newAudioStream(f)
+	if err != nil {
+		f.Close()
+		if errors.Is(err, errUnsupportedFormat) && fallback == DecoderFallbackFFmpeg {
+			return openFFmpegStream(filename)
+		}
+		return nil, err
+	}
+	fs := &fileStream{audioStream: s, file: f}
+	if info, err := f.Stat(); err == nil {
+		fs.size = info.Size()
+	}
+	return fs, nil
+}
+
+// fileStream closes the underlying file along with the stream
+type fileStream struct {
+	audioStream
+	file *os.File
+	// size is the file size in bytes, used to report progress
+	size int64
+}
+
+// Close closes the stream and the file
+func (s *fileStream) Close() error {
+	s.audioStream.Close()
+	return s.file.Close()
+}
+
+// decodeAudio decodes audio from r, auto-detecting its format
+func decodeAudio(r io.ReadSeeker) ([][]float64, uint, error) {
+	s, err := newAudioStream(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer s.Close()
+
+	return readStream(s)
+}
+
+// newAudioStream returns a stream decoding r, auto-detecting its format
+func newAudioStream(r io.ReadSeeker) (audioStream, error) {
+	format, err := detectFormat(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case formatWAV:
+		return newWavStream(r)
+	case formatFLAC:
+		return newFlacStream(r)
+	case formatMP3:
+		return newMp3Stream(r)
+	}
+	return nil, errUnsupportedFormat
+}
+
+// readStream decodes a stream until EOF and returns the samples of every channel
+func readStream(s audioStream) ([][]float64, uint, error) {
+	return readStreamWithProgress(s, nil)
+}
+
+// readStreamWithProgress decodes a stream until EOF, reporting the fraction of
+// the input read to p when the stream can tell
+func readStreamWithProgress(s audioStream, p *progress) ([][]float64, uint, error) {
+	channels := make([][]float64, s.NumChannels())
+	for {
+		block, err := s.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		for ch := range channels {
+			channels[ch] = append(channels[ch], block[ch]...)
+		}
+		if frac, ok := streamPosition(s); ok {
+			p.report(frac)
+		}
+	}
+
+	p.report(1)
+	return channels, s.SampleRate(), nil
+}
+
+// streamPosition returns the fraction of the input consumed by s, if known
+func streamPosition(s audioStream) (float64, bool) {
+	fs, ok := s.(*fileStream)
+	if !ok || fs.size <= 0 {
+		return 0, false
+	}
+	pos, err := fs.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	return float64(pos) / float64(fs.size), true
+}
+
+// detectFormat sniffs the container format from the first bytes of r and
+// rewinds r to the beginning afterwards
+func detectFormat(r io.ReadSeeker) (audioFormat, error) {
+	header := make([]byte, 12)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return formatUnknown, errUnsupportedFormat
+		}
+		return formatUnknown, fmt.Errorf("failed to read header: %w", err)
+	}
+	header = header[:n]
+
+	// Skip a prepended ID3v2 tag and sniff what follows it
+	offset := int64(0)
+	if len(header) >= 10 && bytes.HasPrefix(header, []byte("ID3")) {
+		size := int64(header[6]&0x7f)<<21 | int64(header[7]&0x7f)<<14 |
+			int64(header[8]&0x7f)<<7 | int64(header[9]&0x7f)
+		offset = 10 + size
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return formatUnknown, fmt.Errorf("failed to skip ID3 tag: %w", err)
+		}
+		n, _ = io.ReadFull(r, header[:cap(header)])
+		header = header[:n]
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return formatUnknown, fmt.Errorf("failed to rewind: %w", err)
+	}
+
+	switch {
+	case len(header) >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE")):
+		return formatWAV, nil
+	case len(header) >= 4 && bytes.Equal(header[0:4], []byte("fLaC")):
+		return formatFLAC, nil
+	case len(header) >= 4 && isMp3FrameHeader(header):
+		return formatMP3, nil
+	}
+	return formatUnknown, errUnsupportedFormat
+}
+
+// wavStream decodes a WAV stream block by block
+type wavStream struct {
+	decoder     *wav.Decoder
+	buf         *audio.IntBuffer
+	numChannels int
+	offset      float64
+	scale       float64
+}
+
+// newWavStream returns a stream decoding the WAV data in r
+func newWavStream(r io.ReadSeeker) (*wavStream, error) {
+	decoder := wav.NewDecoder(r)
+	if !decoder.IsValidFile() {
+		return nil, fmt.Errorf("invalid WAV file")
+	}
+
+	numChannels := int(decoder.NumChans)
+	if numChannels <= 0 {
+		return nil, fmt.Errorf("invalid channel count: %d", numChannels)
+	}
+
+	// 8-bit WAV samples are unsigned, all other depths are signed
+	bitDepth := int(decoder.BitDepth)
+	offset := 0.0
+	if bitDepth == 8 {
+		offset = 128
+	}
+
+	return &wavStream{
+		decoder:     decoder,
+		buf:         &audio.IntBuffer{Data: make([]int, streamBlockSize*numChannels)},
+		numChannels: numChannels,
+		offset:      offset,
+		scale:       1.0 / float64(int64(1)<<(bitDepth-1)),
+	}, nil
+}
+
+// SampleRate returns the sample rate in Hz
+func (s *wavStream) SampleRate() uint { return uint(s.decoder.SampleRate) }
+
+// NumChannels returns the number of channels
+func (s *wavStream) NumChannels() int { return s.numChannels }
+
+// Close is a no-op; the underlying reader is owned by the caller
+func (s *wavStream) Close() error { return nil }
+
+// Read decodes the next block of frames
+func (s *wavStream) Read() ([][]float64, error) {
+	n, err := s.decoder.PCMBuffer(s.buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PCM data: %w", err)
+	}
+	numFrames := n / s.numChannels
+	if numFrames == 0 {
+		return nil, io.EOF
+	}
+
+	// Deinterleave the channels and normalize to [-1.0, 1.0]
+	block := make([][]float64, s.numChannels)
+	for ch := range block {
+		block[ch] = make([]float64, numFrames)
+	}
+	for i := 0; i < numFrames; i++ {
+		for ch := 0; ch < s.numChannels; ch++ {
+			block[ch][i] = (float64(s.buf.Data[i*s.numChannels+ch]) - s.offset) * s.scale
+		}
+	}
+
+	return block, nil
+}
+
+// flacStream decodes a FLAC stream frame by frame
+type flacStream struct {
+	stream *flac.Stream
+	scale  float64
+}
+
+// newFlacStream returns a stream decoding the FLAC data in r
+func newFlacStream(r io.Reader) (*flacStream, error) {
+	stream, err := flac.New(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FLAC file: %w", err)
+	}
+
+	return &flacStream{
+		stream: stream,
+		scale:  1.0 / float64(int64(1)<<(stream.Info.BitsPerSample-1)),
+	}, nil
+}
+
+// SampleRate returns the sample rate in Hz
+func (s *flacStream) SampleRate() uint { return uint(s.stream.Info.SampleRate) }
+
+// NumChannels returns the number of channels
+func (s *flacStream) NumChannels() int { return int(s.stream.Info.NChannels) }
+
+// Close releases the FLAC decoder
+func (s *flacStream) Close() error { return s.stream.Close() }
+
+// Read decodes the next FLAC frame
+func (s *flacStream) Read() ([][]float64, error) {
+	frame, err := s.stream.ParseNext()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode FLAC frame: %w", err)
+	}
+
+	block := make([][]float64, len(frame.Subframes))
+	for ch, subframe := range frame.Subframes {
+		block[ch] = make([]float64, subframe.NSamples)
+		for i, v := range subframe.Samples[:subframe.NSamples] {
+			block[ch][i] = float64(v) * s.scale
+		}
+	}
+
+	return block, nil
+}
+
+// mp3DecoderDelay is the delay in samples introduced by the MP3 synthesis filterbank
+const mp3DecoderDelay = 529
+
+// mp3Info holds the stream properties read from the first MP3 frame
+type mp3Info struct {
+	// mono reports whether the stream has a single channel
+	mono bool
+	// samplesPerFrame is the number of samples per channel in each frame
+	samplesPerFrame int
+	// hasInfoFrame reports whether the first frame is a Xing/Info header frame
+	hasInfoFrame bool
+	// frames is the number of audio frames from the Xing header (0 if unknown)
+	frames int
+	// encoderDelay and encoderPadding come from the LAME extension (-1 if absent)
+	encoderDelay   int
+	encoderPadding int
+}
+
+// isMp3FrameHeader reports whether b starts with an MPEG audio layer III frame header
+func isMp3FrameHeader(b []byte) bool {
+	if b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return false
+	}
+	version := (b[1] >> 3) & 0x03
+	layer := (b[1] >> 1) & 0x03
+	bitrate := b[2] >> 4
+	sampleRate := (b[2] >> 2) & 0x03
+	return version != 1 && layer == 1 && bitrate != 0x0f && sampleRate != 0x03
+}
+
+// readMp3Info parses the first frame of an MP3 stream, including the optional
+// Xing/Info header and its LAME extension carrying the encoder delay and padding.
+// r is rewound to the beginning afterwards.
+func readMp3Info(r io.ReadSeeker) (mp3Info, error) {
+	info := mp3Info{encoderDelay: -1, encoderPadding: -1}
+
+	// Skip a prepended ID3v2 tag
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return info, fmt.Errorf("failed to read MP3 header: %w", err)
+	}
+	offset := int64(0)
+	if bytes.HasPrefix(header, []byte("ID3")) {
+		offset = 10 + (int64(header[6]&0x7f)<<21 | int64(header[7]&0x7f)<<14 |
+			int64(header[8]&0x7f)<<7 | int64(header[9]&0x7f))
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return info, fmt.Errorf("failed to seek to first MP3 frame: %w", err)
+	}
+
+	// The frame header, side info, Xing header and LAME extension fit in 192 bytes
+	frame := make([]byte, 192)
+	n, err := io.ReadFull(r, frame)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return info, fmt.Errorf("failed to read first MP3 frame: %w", err)
+	}
+	frame = frame[:n]
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return info, fmt.Errorf("failed to rewind: %w", err)
+	}
+	if len(frame) < 4 || !isMp3FrameHeader(frame) {
+		return info, fmt.Errorf("invalid MP3 frame header")
+	}
+
+	mpeg1 := (frame[1]>>3)&0x03 == 3
+	info.mono = frame[3]>>6 == 3
+	info.samplesPerFrame = 576
+	if mpeg1 {
+		info.samplesPerFrame = 1152
+	}
+
+	// Side info size depends on the MPEG version and channel count
+	sideInfo := 17
+	if mpeg1 && !info.mono {
+		sideInfo = 32
+	} else if !mpeg1 && info.mono {
+		sideInfo = 9
+	}
+
+	p := 4 + sideInfo
+	if len(frame) < p+8 {
+		return info, nil
+	}
+	tag := string(frame[p : p+4])
+	if tag != "Xing" && tag != "Info" {
+		return info, nil
+	}
+	info.hasInfoFrame = true
+
+	flags := binary.BigEndian.Uint32(frame[p+4 : p+8])
+	p += 8
+	if flags&0x1 != 0 && len(frame) >= p+4 {
+		info.frames = int(binary.BigEndian.Uint32(frame[p : p+4]))
+		p += 4
+	}
+	if flags&0x2 != 0 {
+		p += 4 // stream size in bytes
+	}
+	if flags&0x4 != 0 {
+		p += 100 // seek table
+	}
+	if flags&0x8 != 0 {
+		p += 4 // quality indicator
+	}
+
+	// LAME extension: 9-byte encoder version followed by fixed-size fields,
+	// with the 12-bit encoder delay and padding at byte offset 21
+	if len(frame) < p+24 {
+		return info, nil
+	}
+	encoder := string(frame[p : p+4])
+	if encoder != "LAME" && encoder != "Lavf" && encoder != "Lavc" {
+		return info, nil
+	}
+	d := frame[p+21 : p+24]
+	info.encoderDelay = int(d[0])<<4 | int(d[1])>>4
+	info.encoderPadding = int(d[1]&0x0f)<<8 | int(d[2])
+
+	return info, nil
+}
+
+// mp3Stream decodes an MP3 stream block by block. When the stream carries a
+// LAME/Info header, the header frame, encoder delay, decoder delay and padding are
+// removed so that sample 0 lines up with the start of the original audio.
+type mp3Stream struct {
+	decoder     *mp3.Decoder
+	numChannels int
+	buf         []byte
+	// pos is the index of the next decoded frame, before trimming
+	pos int
+	// start and end delimit the frames to keep; end is -1 when unknown
+	start, end int
+	// holdback is the number of trailing frames to drop when end is unknown
+	holdback int
+	// pending holds decoded frames withheld until it is known they are not padding
+	pending [][]float64
+	eof     bool
+}
+
+// newMp3Stream returns a stream decoding the MP3 data in r
+func newMp3Stream(r io.ReadSeeker) (*mp3Stream, error) {
+	info, err := readMp3Info(r)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder, err := mp3.NewDecoder(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MP3 file: %w", err)
+	}
+
+	s := &mp3Stream{
+		decoder:     decoder,
+		numChannels: 2,
+		// The decoder always produces 16-bit little-endian stereo
+		buf: make([]byte, streamBlockSize*4),
+		end: -1,
+	}
+	if info.mono {
+		s.numChannels = 1
+	}
+
+	if info.hasInfoFrame {
+		// The Info frame decodes to one frame of silence
+		s.start = info.samplesPerFrame
+	}
+	if info.encoderDelay >= 0 {
+		s.start += info.encoderDelay + mp3DecoderDelay
+		if info.frames > 0 {
+			s.end = s.start + info.frames*info.samplesPerFrame - info.encoderDelay - info.encoderPadding
+		} else if info.encoderPadding > mp3DecoderDelay {
+			s.holdback = info.encoderPadding - mp3DecoderDelay
+			s.pending = make([][]float64, s.numChannels)
+		}
+	}
+
+	return s, nil
+}
+
+// SampleRate returns the sample rate in Hz
+func (s *mp3Stream) SampleRate() uint { return uint(s.decoder.SampleRate()) }
+
+// NumChannels returns the number of channels
+func (s *mp3Stream) NumChannels() int { return s.numChannels }
+
+// Close is a no-op; the underlying reader is owned by the caller
+func (s *mp3Stream) Close() error { return nil }
+
+// Read decodes the next block of frames within the trimmed range
+func (s *mp3Stream) Read() ([][]float64, error) {
+	for !s.eof {
+		n, err := io.ReadFull(s.decoder, s.buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			s.eof = true
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode MP3: %w", err)
+		}
+
+		numFrames := n / 4
+		block := make([][]float64, s.numChannels)
+		for i := 0; i < numFrames; i++ {
+			index := s.pos + i
+			if index < s.start || (s.end >= 0 && index >= s.end) {
+				continue
+			}
+			for ch := range block {
+				v := int16(binary.LittleEndian.Uint16(s.buf[i*4+ch*2:]))
+				block[ch] = append(block[ch], float64(v)/32768.0)
+			}
+		}
+		s.pos += numFrames
+		if s.end >= 0 && s.pos >= s.end {
+			s.eof = true
+		}
+
+		if s.holdback > 0 {
+			block = s.withholdPadding(block)
+		}
+		if len(block[0]) > 0 {
+			return block, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// withholdPadding appends block to the pending frames and returns the frames
+// that are followed by at least holdback more frames. At the end of the stream
+// the remaining holdback frames are dropped as encoder padding.
+func (s *mp3Stream) withholdPadding(block [][]float64) [][]float64 {
+	for ch := range s.pending {
+		s.pending[ch] = append(s.pending[ch], block[ch]...)
+	}
+
+	ready := len(s.pending[0]) - s.holdback
+	if ready <= 0 {
+		if s.eof {
+			s.pending = make([][]float64, s.numChannels)
+		}
+		return make([][]float64, s.numChannels)
+	}
+
+	out := make([][]float64, s.numChannels)
+	for ch := range s.pending {
+		out[ch] = s.pending[ch][:ready]
+		s.pending[ch] = append([]float64(nil), s.pending[ch][ready:]...)
+	}
+	return out
+}
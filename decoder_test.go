@@ -0,0 +1,454 @@
+package onset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+)
+
+// writeTestFlac encodes 24-bit channels to a FLAC file using verbatim subframes
+func writeTestFlac(t *testing.T, path string, channels [][]float64, sampleRate uint) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create FLAC file: %v", err)
+	}
+	defer f.Close()
+
+	info := &meta.StreamInfo{
+		BlockSizeMin:  4096,
+		BlockSizeMax:  4096,
+		SampleRate:    uint32(sampleRate),
+		NChannels:     uint8(len(channels)),
+		BitsPerSample: 24,
+		NSamples:      uint64(len(channels[0])),
+	}
+	enc, err := flac.NewEncoder(f, info)
+	if err != nil {
+		t.Fatalf("Failed to create FLAC encoder: %v", err)
+	}
+
+	for start := 0; start < len(channels[0]); start += 4096 {
+		end := start + 4096
+		if end > len(channels[0]) {
+			end = len(channels[0])
+		}
+		subframes := make([]*frame.Subframe, len(channels))
+		for ch, channel := range channels {
+			samples := make([]int32, end-start)
+			for i := range samples {
+				samples[i] = int32(channel[start+i] * (1 << 23))
+			}
+			subframes[ch] = &frame.Subframe{
+				SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+				Samples:   samples,
+				NSamples:  len(samples),
+			}
+		}
+		fr := &frame.Frame{
+			Header: frame.Header{
+				HasFixedBlockSize: false,
+				BlockSize:         uint16(end - start),
+				SampleRate:        uint32(sampleRate),
+				Channels:          frame.ChannelsLR,
+				BitsPerSample:     24,
+			},
+			Subframes: subframes,
+		}
+		if len(channels) == 1 {
+			fr.Channels = frame.ChannelsMono
+		}
+		if err := enc.WriteFrame(fr); err != nil {
+			t.Fatalf("Failed to write FLAC frame: %v", err)
+		}
+	}
+
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Failed to close FLAC encoder: %v", err)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	f, err := os.Open("amen.wav")
+	if err != nil {
+		t.Fatalf("Failed to open amen.wav: %v", err)
+	}
+	defer f.Close()
+
+	format, err := detectFormat(f)
+	if err != nil {
+		t.Fatalf("detectFormat failed: %v", err)
+	}
+	if format != formatWAV {
+		t.Errorf("Expected WAV format, got %d", format)
+	}
+
+	path := filepath.Join(t.TempDir(), "unknown.bin")
+	if err := os.WriteFile(path, []byte("not an audio file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readAudioFile(path); err == nil {
+		t.Error("Expected error for unknown format, got nil")
+	}
+}
+
+func TestAnalyzeSlicesFlac(t *testing.T) {
+	channels, sampleRate, err := readAudioFile("amen.wav")
+	if err != nil {
+		t.Fatalf("Failed to read amen.wav: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "amen.flac")
+	writeTestFlac(t, path, channels, sampleRate)
+
+	decoded, decodedRate, err := readAudioFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read FLAC file: %v", err)
+	}
+	if decodedRate != sampleRate {
+		t.Errorf("Expected sample rate %d, got %d", sampleRate, decodedRate)
+	}
+	if len(decoded) != len(channels) || len(decoded[0]) != len(channels[0]) {
+		t.Fatalf("Decoded FLAC shape %dx%d does not match WAV %dx%d",
+			len(decoded), len(decoded[0]), len(channels), len(channels[0]))
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+
+	wavResult, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed for WAV: %v", err)
+	}
+	flacResult, err := AnalyzeSlices(path, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed for FLAC: %v", err)
+	}
+
+	if len(flacResult.Onsets) != len(wavResult.Onsets) {
+		t.Fatalf("Expected %d onsets from FLAC, got %d", len(wavResult.Onsets), len(flacResult.Onsets))
+	}
+	for i := range wavResult.Onsets {
+		if flacResult.Onsets[i] != wavResult.Onsets[i] {
+			t.Errorf("Onset %d differs: WAV %.4f, FLAC %.4f", i, wavResult.Onsets[i], flacResult.Onsets[i])
+		}
+	}
+}
+
+// mp3InfoFrame builds a silent MPEG-2 layer III mono frame carrying an Info
+// header with a LAME extension, matching the frames of testdata/speech.mp3
+func mp3InfoFrame(frames, delay, padding int) []byte {
+	frame := make([]byte, 156)
+	copy(frame, []byte{0xff, 0xf3, 0x60, 0xc4})
+	p := 4 + 9
+	copy(frame[p:], "Info")
+	binary.BigEndian.PutUint32(frame[p+4:], 0x1)
+	binary.BigEndian.PutUint32(frame[p+8:], uint32(frames))
+	p += 12
+	copy(frame[p:], "LAME3.100")
+	frame[p+21] = byte(delay >> 4)
+	frame[p+22] = byte(delay&0x0f)<<4 | byte(padding>>8)
+	frame[p+23] = byte(padding)
+	return frame
+}
+
+func TestDecodeMp3(t *testing.T) {
+	data, err := os.ReadFile("testdata/speech.mp3")
+	if err != nil {
+		t.Fatalf("Failed to read speech.mp3: %v", err)
+	}
+
+	plain, sampleRate, err := decodeAudio(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode MP3: %v", err)
+	}
+	if sampleRate != 22050 {
+		t.Errorf("Expected sample rate 22050, got %d", sampleRate)
+	}
+	if len(plain) != 1 {
+		t.Fatalf("Expected a mono stream, got %d channels", len(plain))
+	}
+
+	// Prepend an Info frame declaring encoder delay and padding, replacing the ID3 tag
+	frames := len(plain[0]) / 576
+	delay, padding := 576, 1000
+	audio := data[45:]
+	gapless := append(mp3InfoFrame(frames, delay, padding), audio...)
+
+	trimmed, _, err := decodeAudio(bytes.NewReader(gapless))
+	if err != nil {
+		t.Fatalf("Failed to decode gapless MP3: %v", err)
+	}
+
+	start := delay + mp3DecoderDelay
+	expectedLength := frames*576 - delay - padding
+	if len(trimmed[0]) != expectedLength {
+		t.Fatalf("Expected %d samples after trimming, got %d", expectedLength, len(trimmed[0]))
+	}
+	for i, v := range trimmed[0] {
+		if v != plain[0][start+i] {
+			t.Fatalf("Sample %d differs: expected %f, got %f", i, plain[0][start+i], v)
+		}
+	}
+}
+
+func TestParseFFprobeOutput(t *testing.T) {
+	sampleRate, numChannels, err := parseFFprobeOutput([]byte("sample_rate=48000\nchannels=2\n"))
+	if err != nil {
+		t.Fatalf("parseFFprobeOutput failed: %v", err)
+	}
+	if sampleRate != 48000 || numChannels != 2 {
+		t.Errorf("Expected 48000 Hz and 2 channels, got %d Hz and %d channels", sampleRate, numChannels)
+	}
+
+	if _, _, err := parseFFprobeOutput([]byte("")); err == nil {
+		t.Error("Expected error for empty ffprobe output, got nil")
+	}
+}
+
+func TestDecoderFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unknown.bin")
+	if err := os.WriteFile(path, []byte("not an audio file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.DecoderFallback = "sox"
+	if _, err := AnalyzeSlices(path, options); err == nil {
+		t.Error("Expected error for unknown decoder fallback, got nil")
+	}
+
+	// Without ffmpeg (or with ffmpeg failing on garbage input) the fallback must report an error
+	options.DecoderFallback = DecoderFallbackFFmpeg
+	if _, err := AnalyzeSlices(path, options); err == nil {
+		t.Error("Expected error when decoding garbage with ffmpeg, got nil")
+	}
+}
+
+func TestRawSampleDecoding(t *testing.T) {
+	testCases := []struct {
+		name     string
+		format   RawFormat
+		data     []byte
+		expected float64
+	}{
+		{"s16le", RawFormat{BitDepth: 16}, []byte{0x00, 0xc0}, -0.5},
+		{"s16be", RawFormat{BitDepth: 16, Endianness: BigEndian}, []byte{0x40, 0x00}, 0.5},
+		{"s24le", RawFormat{BitDepth: 24}, []byte{0x00, 0x00, 0x80}, -1.0},
+		{"s24be", RawFormat{BitDepth: 24, Endianness: BigEndian}, []byte{0x40, 0x00, 0x00}, 0.5},
+		{"u8", RawFormat{BitDepth: 8, Unsigned: true}, []byte{0xc0}, 0.5},
+		{"s32le", RawFormat{BitDepth: 32}, []byte{0x00, 0x00, 0x00, 0xc0}, -0.5},
+		{"f32le", RawFormat{BitDepth: 32, Float: true}, []byte{0x00, 0x00, 0x00, 0x3f}, 0.5},
+	}
+
+	for _, tc := range testCases {
+		decode, err := tc.format.sampleDecoder()
+		if err != nil {
+			t.Fatalf("%s: sampleDecoder failed: %v", tc.name, err)
+		}
+		if got := decode(tc.data); got != tc.expected {
+			t.Errorf("%s: expected %f, got %f", tc.name, tc.expected, got)
+		}
+	}
+
+	if err := (RawFormat{SampleRate: 44100, Channels: 1, BitDepth: 12}).Validate(); err == nil {
+		t.Error("Expected error for 12-bit samples, got nil")
+	}
+}
+
+func TestAnalyzeRaw(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75, 1.25, 1.75}, 2.25)
+
+	// Interleave as 16-bit little-endian stereo with the bursts on the right channel
+	data := make([]byte, len(samples)*4)
+	for i, v := range samples {
+		binary.LittleEndian.PutUint16(data[i*4+2:], uint16(int16(v*32767)))
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Channel = ChannelRight
+	format := RawFormat{SampleRate: sampleRate, Channels: 2, BitDepth: 16}
+	result, err := AnalyzeRaw(bytes.NewReader(data), format, options)
+	if err != nil {
+		t.Fatalf("AnalyzeRaw failed: %v", err)
+	}
+
+	if len(result.Samples) != len(samples) {
+		t.Errorf("Expected %d samples, got %d", len(samples), len(result.Samples))
+	}
+	if len(result.Onsets) != 4 {
+		t.Errorf("Expected 4 onsets, got %d: %v", len(result.Onsets), result.Onsets)
+	}
+}
+
+func TestParseRawFormat(t *testing.T) {
+	format, err := ParseRawFormat("48000:2:f32be")
+	if err != nil {
+		t.Fatalf("ParseRawFormat failed: %v", err)
+	}
+	expected := RawFormat{SampleRate: 48000, Channels: 2, BitDepth: 32, Endianness: BigEndian, Float: true}
+	if format != expected {
+		t.Errorf("Expected %+v, got %+v", expected, format)
+	}
+
+	for _, spec := range []string{"44100:1", "44100:1:s12le", "44100:0:s16le", "44100:1:x16"} {
+		if _, err := ParseRawFormat(spec); err == nil {
+			t.Errorf("Expected error for %q, got nil", spec)
+		}
+	}
+}
+
+func TestReadWavHeader(t *testing.T) {
+	// wav builds a WAV stream with a 16-bit mono format chunk declaring
+	// fmtSize bytes, of which fmtBytes are present, an odd-sized chunk to
+	// skip and a data chunk of 4 bytes
+	wav := func(fmtSize uint32, fmtBytes int) []byte {
+		var b bytes.Buffer
+		b.WriteString("RIFF\x00\x00\x00\x00WAVE")
+		b.WriteString("fmt ")
+		binary.Write(&b, binary.LittleEndian, fmtSize)
+		format := make([]byte, max(fmtBytes, 16))
+		binary.LittleEndian.PutUint16(format[0:2], 1)
+		binary.LittleEndian.PutUint16(format[2:4], 1)
+		binary.LittleEndian.PutUint32(format[4:8], 44100)
+		binary.LittleEndian.PutUint16(format[14:16], 16)
+		b.Write(format[:fmtBytes])
+		if fmtBytes < int(fmtSize) {
+			return b.Bytes()
+		}
+		b.WriteString("LIST\x03\x00\x00\x00abc\x00")
+		b.WriteString("data\x04\x00\x00\x00\x01\x02\x03\x04")
+		return b.Bytes()
+	}
+
+	// Format chunks with extra bytes are read up to the data
+	for _, size := range []uint32{16, 18, 40} {
+		format, pcm, err := ReadWavHeader(bytes.NewReader(wav(size, int(size))))
+		if err != nil {
+			t.Fatalf("Size %d: ReadWavHeader failed: %v", size, err)
+		}
+		if format.SampleRate != 44100 || format.Channels != 1 || format.BitDepth != 16 {
+			t.Errorf("Size %d: unexpected format %+v", size, format)
+		}
+		if data, _ := io.ReadAll(pcm); !bytes.Equal(data, []byte{1, 2, 3, 4}) {
+			t.Errorf("Size %d: expected the data chunk, got %v", size, data)
+		}
+	}
+
+	// Truncated, huge and wrapping format chunk sizes are errors
+	for _, c := range []struct {
+		size  uint32
+		bytes int
+	}{
+		{16, 8},
+		{40, 30},
+		{0xFFFFFFFF, 16},
+		{0xFFFFFFFE, 16},
+		{maxWavFormatSize + 2, 16},
+	} {
+		if _, _, err := ReadWavHeader(bytes.NewReader(wav(c.size, c.bytes))); err == nil {
+			t.Errorf("Expected error for a format chunk of %d bytes declaring %d, got nil", c.bytes, c.size)
+		}
+	}
+}
+
+func TestDetectStream(t *testing.T) {
+	options := DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+	expected, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	// A WAV file read through a plain reader, as from a pipe
+	data, err := os.ReadFile("amen.wav")
+	if err != nil {
+		t.Fatalf("Failed to read amen.wav: %v", err)
+	}
+	wavFormat, pcm, err := ReadWavHeader(bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatalf("ReadWavHeader failed: %v", err)
+	}
+	if wavFormat.SampleRate != expected.SampleRate {
+		t.Errorf("Expected sample rate %d, got %d", expected.SampleRate, wavFormat.SampleRate)
+	}
+	var onsets []float64
+	err = DetectStream(pcm, wavFormat, options, func(onsetTime, strength float64) {
+		onsets = append(onsets, onsetTime)
+	})
+	if err != nil {
+		t.Fatalf("DetectStream failed: %v", err)
+	}
+	if len(onsets) != len(expected.Onsets) {
+		t.Fatalf("Expected %d onsets, got %d", len(expected.Onsets), len(onsets))
+	}
+	for i := range onsets {
+		if onsets[i] != expected.Onsets[i] {
+			t.Errorf("Onset %d: expected %f, got %f", i, expected.Onsets[i], onsets[i])
+		}
+	}
+
+	// The same samples as raw 64-bit floats
+	raw := make([]byte, len(expected.Samples)*8)
+	for i, v := range expected.Samples {
+		binary.LittleEndian.PutUint64(raw[i*8:], math.Float64bits(v))
+	}
+	format := RawFormat{SampleRate: expected.SampleRate, Channels: 1, BitDepth: 64, Float: true}
+	count := 0
+	if err := DetectStream(bytes.NewReader(raw), format, options, func(float64, float64) { count++ }); err != nil {
+		t.Fatalf("DetectStream failed on raw input: %v", err)
+	}
+	if count != len(expected.Onsets) {
+		t.Errorf("Expected %d raw onsets, got %d", len(expected.Onsets), count)
+	}
+
+	options.Method = "consensus"
+	if err := DetectStream(bytes.NewReader(raw), format, options, func(float64, float64) {}); err == nil {
+		t.Error("Expected error for the consensus method, got nil")
+	}
+}
+
+func TestWriteWav(t *testing.T) {
+	sampleRate := uint(44100)
+	left := synthBursts(sampleRate, []float64{0.1, 0.4}, 0.6)
+	right := make([]float64, len(left))
+	for i := range right {
+		right[i] = -0.5 * left[i]
+	}
+
+	for _, bitDepth := range []int{8, 16, 24, 32} {
+		path := filepath.Join(t.TempDir(), "out.wav")
+		if err := WriteWavChannels(path, [][]float64{left, right}, sampleRate, bitDepth); err != nil {
+			t.Fatalf("WriteWavChannels(%d bits) failed: %v", bitDepth, err)
+		}
+
+		channels, readRate, err := readAudioFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %d-bit WAV: %v", bitDepth, err)
+		}
+		if readRate != sampleRate || len(channels) != 2 || len(channels[0]) != len(left) {
+			t.Fatalf("%d-bit WAV round-trip changed the format", bitDepth)
+		}
+
+		tolerance := 2.0 / float64(int64(1)<<(bitDepth-1))
+		for i := range left {
+			if math.Abs(channels[0][i]-left[i]) > tolerance || math.Abs(channels[1][i]-right[i]) > tolerance {
+				t.Fatalf("%d-bit WAV sample %d differs beyond quantization", bitDepth, i)
+			}
+		}
+	}
+
+	if err := WriteWav(filepath.Join(t.TempDir(), "bad.wav"), left, sampleRate, 12); err == nil {
+		t.Error("Expected error for 12-bit WAV, got nil")
+	}
+}
@@ -0,0 +1,66 @@
+package onset
+
+import (
+	"fmt"
+	"sort"
+)
+
+// densityStepsPerWindow is the number of points OnsetDensity computes per
+// window length
+const densityStepsPerWindow = 4
+
+// DensityPoint is the onset density of a window
+type DensityPoint struct {
+	// Time is the center of the window in seconds
+	Time float64
+	// Density is the number of onsets per second in the window
+	Density float64
+}
+
+// OnsetDensity returns the number of onsets per second over time, counted in
+// windows of windowSec seconds centered every quarter window from 0 to the
+// last onset. Windows reaching before 0 count their part after it only. The
+// curve is empty without onsets.
+func OnsetDensity(onsets []float64, windowSec float64) ([]DensityPoint, error) {
+	if windowSec <= 0 {
+		return nil, fmt.Errorf("invalid density window of %g s", windowSec)
+	}
+	if len(onsets) == 0 {
+		return nil, nil
+	}
+
+	sorted := sortedCopy(onsets)
+	last := sorted[len(sorted)-1]
+	hop := windowSec / densityStepsPerWindow
+	var points []DensityPoint
+	for i := 0; float64(i)*hop <= last+1e-9; i++ {
+		center := float64(i) * hop
+		start, end := max(center-windowSec/2, 0), center+windowSec/2
+		count := sort.SearchFloat64s(sorted, end) - sort.SearchFloat64s(sorted, start)
+		points = append(points, DensityPoint{Time: center, Density: float64(count) / (end - start)})
+	}
+	return points, nil
+}
+
+// BusiestWindow returns the start in seconds of the window of windowSec
+// seconds holding the most onsets, and their number, so that the busiest
+// section of a long recording can be found to sample from. The window starts
+// at an onset, the earliest one of the busiest windows. It returns 0 and 0
+// without onsets or for a window that is not positive.
+func BusiestWindow(onsets []float64, windowSec float64) (float64, int) {
+	if len(onsets) == 0 || windowSec <= 0 {
+		return 0, 0
+	}
+	sorted := sortedCopy(onsets)
+	bestStart, bestCount := 0.0, 0
+	end := 0
+	for i, start := range sorted {
+		for end < len(sorted) && sorted[end] < start+windowSec {
+			end++
+		}
+		if end-i > bestCount {
+			bestStart, bestCount = start, end-i
+		}
+	}
+	return bestStart, bestCount
+}
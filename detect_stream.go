@@ -0,0 +1,66 @@
+package onset
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DetectStream runs onset detection on audio read from r until EOF and calls fn
+// with the time in seconds and the strength (the value of the detection
+// function at its peak) of every onset as soon as it is detected, so that
+// onsets of a live or piped stream are reported without waiting for its end.
+//
+// r holds headerless PCM in the given format; use ReadWavHeader first to
+// detect onsets in a WAV stream.
+//
+// Method, Params, Preset, Threshold, MinioiMs, InputHighpassHz, RemoveDC,
+// the noise gate, Channel, the peak picker and the minimum spacing options apply.
+// Energy ranking (NumSlices) and the "consensus" method need the whole stream
+// and are not supported; Optimize, MinSliceMs and MaxSliceMs are ignored.
+func DetectStream(r io.Reader, format RawFormat, options SliceAnalyzerOptions, fn func(onsetTime, strength float64)) error {
+	options, err := applyPreset(options)
+	if err != nil {
+		return err
+	}
+	method := analysisMethod(options)
+	if method == "consensus" {
+		return fmt.Errorf("stream detection does not support the consensus method")
+	}
+	if options.NumSlices > 0 {
+		return fmt.Errorf("stream detection does not support NumSlices")
+	}
+	if strings.EqualFold(options.Channel, ChannelPerChannel) {
+		return fmt.Errorf("stream detection does not support the %q channel mode", ChannelPerChannel)
+	}
+
+	s, err := newRawStream(r, format)
+	if err != nil {
+		return fmt.Errorf("failed to read audio stream: %w", err)
+	}
+	if err := checkDetectionOptions(options, s.SampleRate()); err != nil {
+		return err
+	}
+
+	d := newHopDetector(method, 512, 256, s.SampleRate(), options, false)
+	minimumSpacing := options.MinimumSpacing / 1000.0
+	last := 0.0
+	emitted := 0
+
+	err = streamChannel(s, options.Channel, nil, func(block []float64) {
+		d.write(block)
+		for i, onsetTime := range d.onsets {
+			// Keep the first onset and those far enough from the last reported one
+			if !options.UseMinimumSpacing || emitted == 0 || onsetTime-last >= minimumSpacing {
+				fn(onsetTime, d.strengths[i])
+				last = onsetTime
+				emitted++
+			}
+		}
+		d.onsets, d.strengths = d.onsets[:0], d.strengths[:0]
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read audio stream: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package onset
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// nativeExtensions lists the file extensions decoded natively
+var nativeExtensions = []string{".wav", ".wave", ".flac", ".mp3"}
+
+// ffmpegExtensions lists additional file extensions picked up when the ffmpeg
+// decoder fallback is enabled
+var ffmpegExtensions = []string{".aif", ".aiff", ".ogg", ".opus", ".m4a", ".aac", ".wma"}
+
+// AnalyzeDirectory analyzes every audio file below dir in parallel.
+// Files are recognized by their extension (WAV, FLAC and MP3, plus AIFF, Ogg,
+// Opus, M4A, AAC and WMA when options.DecoderFallback is "ffmpeg").
+// Up to concurrency files are analyzed at once; if concurrency is 0 or less,
+// the number of CPUs is used.
+//
+// It returns the results and the per-file errors, both keyed by file path.
+// The error is only non-nil if the directory cannot be walked. When
+// options.Progress is set, it reports the fraction of files analyzed.
+func AnalyzeDirectory(dir string, options SliceAnalyzerOptions, concurrency int) (map[string]*SliceAnalyzerResult, map[string]error, error) {
+	paths, err := findAudioFiles(dir, options.DecoderFallback)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	// Progress is reported per file; the per-file callbacks would run concurrently
+	p := newProgress(options.Progress)
+	options.Progress = nil
+
+	type fileResult struct {
+		path   string
+		result *SliceAnalyzerResult
+		err    error
+	}
+	jobs := make(chan string)
+	done := make(chan fileResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				result, err := AnalyzeSlices(path, options)
+				done <- fileResult{path: path, result: result, err: err}
+			}
+		}()
+	}
+	go func() {
+		for _, path := range paths {
+			jobs <- path
+		}
+		close(jobs)
+		wg.Wait()
+		close(done)
+	}()
+
+	results := make(map[string]*SliceAnalyzerResult)
+	errs := make(map[string]error)
+	for r := range done {
+		if r.err != nil {
+			errs[r.path] = r.err
+		} else {
+			results[r.path] = r.result
+		}
+		p.report(float64(len(results)+len(errs)) / float64(len(paths)))
+	}
+	p.report(1)
+
+	return results, errs, nil
+}
+
+// IsAudioFile reports whether path has the extension of a format that can be
+// analyzed with the given decoder fallback ("" or "ffmpeg")
+func IsAudioFile(path string, decoderFallback string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range nativeExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	if decoderFallback == DecoderFallbackFFmpeg {
+		for _, e := range ffmpegExtensions {
+			if ext == e {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findAudioFiles returns the sorted paths of the audio files below dir
+func findAudioFiles(dir string, decoderFallback string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if IsAudioFile(path, decoderFallback) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
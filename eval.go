@@ -0,0 +1,67 @@
+package onset
+
+import "sort"
+
+// MatchResult summarizes how well detected onsets agree with reference onsets
+type MatchResult struct {
+	// Matched is the number of detected onsets paired with a reference onset
+	Matched int
+	// FalsePositives is the number of detected onsets without a reference onset
+	FalsePositives int
+	// FalseNegatives is the number of reference onsets that were not detected
+	FalseNegatives int
+	// Precision is the fraction of detected onsets that were matched
+	Precision float64
+	// Recall is the fraction of reference onsets that were matched
+	Recall float64
+	// FMeasure is the harmonic mean of precision and recall
+	FMeasure float64
+}
+
+// MatchOnsets pairs detected onsets with reference onsets lying within
+// tolerance seconds of each other. Each onset is matched at most once,
+// scanning both lists in time order.
+func MatchOnsets(reference, detected []float64, tolerance float64) MatchResult {
+	ref := sortedCopy(reference)
+	det := sortedCopy(detected)
+
+	matched := 0
+	i, j := 0, 0
+	for i < len(ref) && j < len(det) {
+		diff := det[j] - ref[i]
+		switch {
+		case diff < -tolerance:
+			j++
+		case diff > tolerance:
+			i++
+		default:
+			matched++
+			i++
+			j++
+		}
+	}
+
+	result := MatchResult{
+		Matched:        matched,
+		FalsePositives: len(det) - matched,
+		FalseNegatives: len(ref) - matched,
+	}
+	if len(det) > 0 {
+		result.Precision = float64(matched) / float64(len(det))
+	}
+	if len(ref) > 0 {
+		result.Recall = float64(matched) / float64(len(ref))
+	}
+	if result.Precision+result.Recall > 0 {
+		result.FMeasure = 2 * result.Precision * result.Recall / (result.Precision + result.Recall)
+	}
+	return result
+}
+
+// sortedCopy returns a sorted copy of values
+func sortedCopy(values []float64) []float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	return sorted
+}
@@ -1,28 +1,30 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
+	"image/png"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/schollz/onsets"
+	"github.com/schollz/onsets/plot"
 )
 
 func main() {
 	// Parse command-line arguments
 	soundFile := flag.String("file", "", "Path to the sound file (required)")
 	numSlices := flag.Int("slices", 8, "Number of slices to find (default: 8, 0 means all)")
-	outputFile := flag.String("output", "waveform.html", "Output HTML file (default: waveform.html)")
+	outputFile := flag.String("output", "waveform.html", "Output HTML report, or PNG image if it ends in .png (default: waveform.html)")
 	optimizeOnsets := flag.Bool("optimize", true, "Optimize onset positions using RMS differential (default: true)")
 	optimizeWindowMs := flag.Float64("optimize-window", 100.0, "Window size in milliseconds for onset optimization (default: 100.0)")
 	method := flag.String("method", "hfc", "Onset detection method: hfc, energy, complex, phase, wphase, specdiff, kl, mkl, specflux, consensus (default: hfc)")
 	minConsensusClusterSize := flag.Int("min-consensus-cluster", 3, "Minimum cluster size for consensus method (default: 3)")
 	useMinimumSpacing := flag.Bool("use-minimum-spacing", true, "Enable minimum spacing filter between slices (default: true)")
 	minimumSpacing := flag.Float64("minimum-spacing", 80.0, "Minimum spacing in milliseconds between slices (default: 80.0)")
+	channel := flag.String("channel", "left", "Channel to analyze: left, right, mix, mid, side, or a zero-based index (default: left)")
 	flag.Parse()
 
 	if *soundFile == "" {
@@ -45,6 +47,7 @@ func main() {
 		MinConsensusClusterSize: *minConsensusClusterSize,
 		UseMinimumSpacing:       *useMinimumSpacing,
 		MinimumSpacing:          *minimumSpacing,
+		Channel:                 *channel,
 	}
 
 	result, err := onset.AnalyzeSlices(*soundFile, options)
@@ -72,16 +75,14 @@ func main() {
 		fmt.Printf("  %2d: %.4f seconds (sample %d)\n", i+1, onset, int(onset*float64(result.SampleRate)))
 	}
 
-	// Write data to JSON file
-	dataFile := "waveform_data.json"
-	err = writeDataToJSON(result.Samples, result.SampleRate, result.Onsets, dataFile)
-	if err != nil {
-		log.Fatalf("Failed to write data file: %v", err)
-	}
-
-	// Run plotly visualization script
+	// Render the waveform and onsets: an interactive HTML report, or a PNG
+	// image if the output ends in .png
 	fmt.Printf("\nGenerating visualization...\n")
-	err = runPlotlyScript(dataFile, *outputFile)
+	if strings.EqualFold(filepath.Ext(*outputFile), ".png") {
+		err = writePNG(result, *outputFile)
+	} else {
+		err = plot.WriteHTMLReport(result, *outputFile)
+	}
 	if err != nil {
 		log.Fatalf("Failed to generate plot: %v", err)
 	}
@@ -89,40 +90,20 @@ func main() {
 	fmt.Printf("Waveform plot saved to: %s\n", *outputFile)
 }
 
-// WaveformData represents the data structure for JSON export
-type WaveformData struct {
-	Samples    []float64 `json:"samples"`
-	SampleRate uint      `json:"sample_rate"`
-	Onsets     []float64 `json:"onsets"`
-}
-
-// writeDataToJSON writes the waveform and onset data to a JSON file
-func writeDataToJSON(samples []float64, sampleRate uint, onsets []float64, filename string) error {
-	data := WaveformData{
-		Samples:    samples,
-		SampleRate: sampleRate,
-		Onsets:     onsets,
-	}
-
-	jsonData, err := json.MarshalIndent(data, "", "  ")
+// writePNG writes the waveform with onset markers and a time grid to a PNG file
+func writePNG(result *onset.SliceAnalyzerResult, filename string) error {
+	img, err := plot.PlotResult(result, plot.PlotOptions{Width: 1600, Height: 500, ShowGrid: true})
 	if err != nil {
-		return fmt.Errorf("failed to marshal JSON: %w", err)
+		return err
 	}
 
-	err = os.WriteFile(filename, jsonData, 0644)
+	f, err := os.Create(filename)
 	if err != nil {
-		return fmt.Errorf("failed to write JSON file: %w", err)
+		return fmt.Errorf("failed to create PNG file: %w", err)
 	}
-
-	return nil
-}
-
-// runPlotlyScript executes the Python plotly script to generate the visualization
-func runPlotlyScript(dataFile, outputFile string) error {
-	cmd := exec.Command("python3", "plot_waveform.py", dataFile, outputFile)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to run plotly script: %w\nOutput: %s", err, string(output))
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to encode PNG: %w", err)
 	}
-	return nil
+	return f.Close()
 }
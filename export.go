@@ -0,0 +1,257 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ExportOptions contains configuration options for slice export
+type ExportOptions struct {
+	// NameTemplate is the file name of each slice. The placeholders {name}
+	// (base name of the source), {index} (1-based slice number, zero-padded),
+	// {count} (number of slices), {start} (start sample) and {time}
+	// (start time in seconds) are replaced.
+	// Default is "{name}_{index}.wav" if empty.
+	NameTemplate string
+	// BitDepth is the bit depth of the written WAV files: 8, 16, 24 or 32.
+	// Default is 16 if 0.
+	BitDepth int
+	// MinLoudnessLUFS skips the slices whose EBU R128 integrated loudness is
+	// below this level in LUFS, such as -40, when building kits. Skipped
+	// slices keep their number, so {index} still matches the onsets.
+	// Disabled if 0.
+	MinLoudnessLUFS float64
+	// NormalizeTruePeakDB scales every slice so that its true peak, see
+	// TruePeak, reaches this level in dBTP, such as -1, so that normalized
+	// slices do not clip between samples. It must be negative. Disabled if 0.
+	NormalizeTruePeakDB float64
+	// NormalizePeakDB scales every slice so that its largest sample reaches
+	// this level in dBFS, such as -0.3. It must be negative. Disabled if 0.
+	NormalizePeakDB float64
+	// NormalizeLUFS scales every slice so that its EBU R128 integrated
+	// loudness reaches this level in LUFS, such as -16. It must be negative.
+	// Disabled if 0. When several normalizations are set, the smallest gain
+	// applies, so that a peak level caps a loudness target.
+	NormalizeLUFS float64
+	// FadeInMs and FadeOutMs are the lengths in milliseconds of the linear
+	// fades at the start and the end of every slice, which avoid clicks in
+	// one-shots. FadeInSamples and FadeOutSamples give the lengths in
+	// samples instead, taking precedence when set. Fades are shortened to
+	// the length of the slice. Disabled if 0.
+	FadeInMs, FadeOutMs           float64
+	FadeInSamples, FadeOutSamples int
+	// CrossfadeMs extends every slice by this many milliseconds past its end
+	// and crossfades the slices over the overlap, fading every slice in over
+	// its first CrossfadeMs and out over the extension. Slices overlapped by
+	// the crossfade when concatenated, in any order, join without clicks,
+	// and in their order sum back to the samples unless normalized. The last
+	// slice fades out over its end. Disabled if 0.
+	CrossfadeMs float64
+}
+
+// DefaultExportOptions returns default options for slice export
+func DefaultExportOptions() ExportOptions {
+	return ExportOptions{
+		NameTemplate: "{name}_{index}.wav",
+		BitDepth:     16,
+	}
+}
+
+// SliceRange is the sample range of one slice
+type SliceRange struct {
+	// Start is the first sample of the slice
+	Start int
+	// End is the sample following the last sample of the slice
+	End int
+}
+
+// SliceRanges returns the sample range of every slice. Each slice starts at an
+// onset and ends at the next onset, the last one at the end of the samples.
+func (r *SliceAnalyzerResult) SliceRanges() []SliceRange {
+	numSamples := r.NumSamples
+	if numSamples == 0 {
+		numSamples = len(r.Samples)
+	}
+
+	ranges := make([]SliceRange, 0, len(r.Onsets))
+	for i, onsetTime := range r.Onsets {
+		start := int(onsetTime * float64(r.SampleRate))
+		end := numSamples
+		if i+1 < len(r.Onsets) {
+			end = int(r.Onsets[i+1] * float64(r.SampleRate))
+		}
+		start = min(max(start, 0), numSamples)
+		end = min(max(end, start), numSamples)
+		ranges = append(ranges, SliceRange{Start: start, End: end})
+	}
+	return ranges
+}
+
+// sliceSamples returns the samples of every slice, sharing the memory of
+// r.Samples with their capacity capped at their end
+func (r *SliceAnalyzerResult) sliceSamples() [][]float64 {
+	ranges := r.SliceRanges()
+	samples := make([][]float64, len(ranges))
+	for i, sr := range ranges {
+		samples[i] = r.Samples[sr.Start:sr.End:sr.End]
+	}
+	return samples
+}
+
+// ExportSlices writes every slice of the analyzed samples to a WAV file in
+// outDir, named after the template in options with name as the {name}
+// placeholder. It returns the paths of the written files.
+// The result must hold its samples, so results of streaming analysis cannot be exported.
+func ExportSlices(result *SliceAnalyzerResult, outDir string, name string, options ExportOptions) ([]string, error) {
+	if len(result.Samples) == 0 && len(result.Onsets) > 0 {
+		return nil, fmt.Errorf("result has no samples to export")
+	}
+	if err := checkRenderOptions(options); err != nil {
+		return nil, err
+	}
+
+	template := options.NameTemplate
+	if template == "" {
+		template = "{name}_{index}.wav"
+	}
+	bitDepth := options.BitDepth
+	if bitDepth == 0 {
+		bitDepth = 16
+	}
+
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	ranges := result.SliceRanges()
+	var weighted []float64
+	if options.MinLoudnessLUFS != 0 {
+		weighted = kWeighted(result.Samples, result.SampleRate)
+	}
+	crossfade := int(options.CrossfadeMs / 1000 * float64(result.SampleRate))
+	width := max(len(strconv.Itoa(len(ranges))), 2)
+	paths := make([]string, 0, len(ranges))
+	seen := make(map[string]bool, len(ranges))
+	for i, sr := range ranges {
+		if weighted != nil && gatedLoudness(weighted[sr.Start:sr.End], result.SampleRate) < options.MinLoudnessLUFS {
+			continue
+		}
+		filename := strings.NewReplacer(
+			"{name}", name,
+			"{index}", fmt.Sprintf("%0*d", width, i+1),
+			"{count}", strconv.Itoa(len(ranges)),
+			"{start}", strconv.Itoa(sr.Start),
+			"{time}", strconv.FormatFloat(float64(sr.Start)/float64(result.SampleRate), 'f', 3, 64),
+		).Replace(template)
+		if seen[filename] {
+			return paths, fmt.Errorf("name template %q produces duplicate file name %q", template, filename)
+		}
+		seen[filename] = true
+
+		path := filepath.Join(outDir, filename)
+		slice := renderSlice(result.Samples[sr.Start:min(sr.End+crossfade, len(result.Samples))], result.SampleRate, options)
+		if crossfade > 0 {
+			slice = crossfadeSlice(slice, crossfade)
+		}
+		if err := WriteWav(path, slice, result.SampleRate, bitDepth); err != nil {
+			return paths, fmt.Errorf("failed to write slice %d: %w", i+1, err)
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// checkRenderOptions returns an error for normalization levels or fades of
+// options out of range
+func checkRenderOptions(options ExportOptions) error {
+	if options.NormalizeTruePeakDB > 0 {
+		return fmt.Errorf("invalid normalization level %g dBTP: must be negative", options.NormalizeTruePeakDB)
+	}
+	if options.NormalizePeakDB > 0 {
+		return fmt.Errorf("invalid normalization level %g dBFS: must be negative", options.NormalizePeakDB)
+	}
+	if options.NormalizeLUFS > 0 {
+		return fmt.Errorf("invalid normalization level %g LUFS: must be negative", options.NormalizeLUFS)
+	}
+	if options.CrossfadeMs < 0 {
+		return fmt.Errorf("invalid crossfade of %g ms", options.CrossfadeMs)
+	}
+	if options.FadeInMs < 0 || options.FadeOutMs < 0 || options.FadeInSamples < 0 || options.FadeOutSamples < 0 {
+		return fmt.Errorf("invalid fades of %g ms (%d samples) and %g ms (%d samples)", options.FadeInMs, options.FadeInSamples, options.FadeOutMs, options.FadeOutSamples)
+	}
+	return nil
+}
+
+// renderSlice returns the samples of a slice normalized and faded as
+// configured by options, copied if they change
+func renderSlice(samples []float64, sampleRate uint, options ExportOptions) []float64 {
+	// The smallest gain of the normalizations applies
+	gain := math.Inf(1)
+	if options.NormalizeTruePeakDB != 0 {
+		if peak := TruePeak(samples, sampleRate); peak > 0 {
+			gain = min(gain, math.Pow(10, options.NormalizeTruePeakDB/20)/peak)
+		}
+	}
+	if options.NormalizePeakDB != 0 {
+		peak := 0.0
+		for _, v := range samples {
+			peak = max(peak, math.Abs(v))
+		}
+		if peak > 0 {
+			gain = min(gain, math.Pow(10, options.NormalizePeakDB/20)/peak)
+		}
+	}
+	if options.NormalizeLUFS != 0 {
+		if loudness := IntegratedLoudness(samples, sampleRate); loudness > loudnessFloor {
+			gain = min(gain, math.Pow(10, (options.NormalizeLUFS-loudness)/20))
+		}
+	}
+
+	fadeIn, fadeOut := options.FadeInSamples, options.FadeOutSamples
+	if fadeIn == 0 {
+		fadeIn = int(options.FadeInMs / 1000 * float64(sampleRate))
+	}
+	if fadeOut == 0 {
+		fadeOut = int(options.FadeOutMs / 1000 * float64(sampleRate))
+	}
+	fadeIn, fadeOut = min(fadeIn, len(samples)), min(fadeOut, len(samples))
+	if math.IsInf(gain, 1) && fadeIn == 0 && fadeOut == 0 {
+		return samples
+	}
+	if math.IsInf(gain, 1) {
+		gain = 1
+	}
+
+	rendered := make([]float64, len(samples))
+	for i, v := range samples {
+		g := gain
+		if i < fadeIn {
+			g *= float64(i) / float64(fadeIn)
+		}
+		if remaining := len(samples) - 1 - i; remaining < fadeOut {
+			g *= float64(remaining) / float64(fadeOut)
+		}
+		rendered[i] = v * g
+	}
+	return rendered
+}
+
+// crossfadeSlice returns a copy of samples faded in over their first length
+// samples and out over their last length samples, with ramps of which those
+// of two slices overlapping by length sum to one
+func crossfadeSlice(samples []float64, length int) []float64 {
+	faded := slices.Clone(samples)
+	length = min(length, len(faded))
+	for i := range length {
+		g := (float64(i) + 0.5) / float64(length)
+		faded[i] *= g
+		faded[len(faded)-1-i] *= g
+	}
+	return faded
+}
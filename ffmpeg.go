@@ -0,0 +1,130 @@
+package onset
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// DecoderFallbackFFmpeg decodes files that the native decoders cannot handle by
+// running the ffmpeg command-line tool
+const DecoderFallbackFFmpeg = "ffmpeg"
+
+// decodeWithFFmpeg decodes any format supported by ffmpeg
+func decodeWithFFmpeg(filename string) ([][]float64, uint, error) {
+	s, err := openFFmpegStream(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	channels, sampleRate, err := readStream(s)
+	if err != nil {
+		s.Close()
+		return nil, 0, fmt.Errorf("failed to read ffmpeg output: %w", err)
+	}
+	if err := s.Close(); err != nil {
+		return nil, 0, err
+	}
+
+	return channels, sampleRate, nil
+}
+
+// ffmpegStream decodes the raw PCM output of an ffmpeg process
+type ffmpegStream struct {
+	*rawStream
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+// openFFmpegStream starts ffmpeg on the given file. The stream properties are
+// queried with ffprobe and the audio is streamed as raw 64-bit float PCM over a pipe.
+func openFFmpegStream(filename string) (*ffmpegStream, error) {
+	ffprobe, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe not found: %w", err)
+	}
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg not found: %w", err)
+	}
+
+	probe, err := exec.Command(ffprobe, "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=sample_rate,channels",
+		"-of", "default=noprint_wrappers=1", filename).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	sampleRate, numChannels, err := parseFFprobeOutput(probe)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(ffmpeg, "-v", "error", "-i", filename, "-map", "0:a:0",
+		"-f", "f64le", "-acodec", "pcm_f64le", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ffmpeg pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	raw, err := newRawStream(stdout, RawFormat{
+		SampleRate: sampleRate,
+		Channels:   numChannels,
+		BitDepth:   64,
+		Float:      true,
+	})
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+
+	return &ffmpegStream{rawStream: raw, cmd: cmd, stderr: &stderr}, nil
+}
+
+// Close waits for ffmpeg to exit. If the output was not read to the end,
+// the process is killed first.
+func (s *ffmpegStream) Close() error {
+	if _, err := s.reader.Peek(1); err != io.EOF {
+		s.cmd.Process.Kill()
+		s.cmd.Wait()
+		return nil
+	}
+	if err := s.cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(s.stderr.String()))
+	}
+	return nil
+}
+
+// parseFFprobeOutput parses the sample rate and channel count printed by ffprobe
+func parseFFprobeOutput(output []byte) (uint, int, error) {
+	sampleRate, numChannels := 0, 0
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "sample_rate":
+			sampleRate = n
+		case "channels":
+			numChannels = n
+		}
+	}
+
+	if sampleRate <= 0 || numChannels <= 0 {
+		return 0, 0, fmt.Errorf("no audio stream found")
+	}
+	return uint(sampleRate), numChannels, nil
+}
@@ -0,0 +1,277 @@
+package onset
+
+import "math"
+
+// FFT computes the spectrum of the real frames of the phase vocoder, so that
+// faster transforms, such as FFTW bindings or a fixed-size transform, can
+// replace the built-in one
+type FFT interface {
+	// Forward returns the magnitudes and phases of the first len(in)/2+1 bins
+	// of the discrete Fourier transform of in. The returned slices may be
+	// reused by the next call.
+	Forward(in []float64) (norm, phas []float64)
+}
+
+// MagnitudeFFT is implemented by FFTs that can compute the magnitudes alone,
+// which the phase vocoder uses when the detection function does not read the
+// phases and the accelerated kernels are enabled
+type MagnitudeFFT interface {
+	// ForwardNorm returns the magnitudes of the first len(in)/2+1 bins of the
+	// discrete Fourier transform of in. The returned slice may be reused by
+	// the next call.
+	ForwardNorm(in []float64) []float64
+}
+
+// InverseFFT is implemented by FFTs that can compute inverse transforms, which
+// the phase vocoder uses to resynthesize frames in RDo. The built-in FFT is
+// used for FFTs that do not implement it.
+type InverseFFT interface {
+	// Inverse writes to out the len(out) real samples whose spectrum has the
+	// magnitudes norm and phases phas in its first len(out)/2+1 bins
+	Inverse(norm, phas, out []float64)
+}
+
+// realFFT is the built-in FFT. Power-of-two sizes use a radix-2 transform of
+// half the size on the packed even and odd samples; other sizes fall back to
+// a direct DFT. All buffers are allocated when the size changes, so that
+// steady-state transforms do not allocate.
+type realFFT struct {
+	n int
+	// re and im hold the packed half-size transform
+	re, im []float64
+	// cos and sin are the twiddle factors exp(-2*pi*i*k/n) for k < n
+	cos, sin []float64
+	// bitrev is the bit-reversal permutation of the half-size transform
+	bitrev []int
+	// twr and twi hold the twiddles of every stage of the half-size
+	// transform contiguously, those of the stage with butterflies of span m
+	// starting at index m-1, for the accelerated transform
+	twr, twi []float64
+	norm     []float64
+	phas     []float64
+	// fullrev is the bit-reversal permutation of the full-size transform and
+	// xr and xi its buffers, allocated by the first inverse transform
+	fullrev []int
+	xr, xi  []float64
+}
+
+// NewDefaultFFT returns the built-in FFT implementation
+func NewDefaultFFT() FFT {
+	return &realFFT{}
+}
+
+// cloneFFT returns an FFT for another detector: a new built-in FFT for the
+// built-in one, a clone for FFTs with a Clone() FFT method, and f itself
+// otherwise
+func cloneFFT(f FFT) FFT {
+	switch f := f.(type) {
+	case *realFFT:
+		return NewDefaultFFT()
+	case interface{ Clone() FFT }:
+		return f.Clone()
+	}
+	return f
+}
+
+// init allocates the buffers and tables for transforms of n samples
+func (f *realFFT) init(n int) {
+	f.n = n
+	f.norm = make([]float64, n/2+1)
+	f.phas = make([]float64, n/2+1)
+	f.cos = make([]float64, n)
+	f.sin = make([]float64, n)
+	f.fullrev, f.xr, f.xi = nil, nil, nil
+	for k := range n {
+		angle := -2 * math.Pi * float64(k) / float64(n)
+		f.cos[k], f.sin[k] = math.Cos(angle), math.Sin(angle)
+	}
+
+	if !isPowerOfTwo(n) || n < 2 {
+		f.re, f.im, f.bitrev, f.twr, f.twi = nil, nil, nil, nil, nil
+		return
+	}
+	half := n / 2
+	f.re = make([]float64, half)
+	f.im = make([]float64, half)
+	f.bitrev = make([]int, half)
+	bits := 0
+	for 1<<bits < half {
+		bits++
+	}
+	for i := range half {
+		r := 0
+		for b := range bits {
+			r |= (i >> b & 1) << (bits - 1 - b)
+		}
+		f.bitrev[i] = r
+	}
+
+	f.twr = make([]float64, max(half-1, 0))
+	f.twi = make([]float64, max(half-1, 0))
+	for m := 1; m < half; m <<= 1 {
+		step := n / (2 * m)
+		for k := range m {
+			f.twr[m-1+k], f.twi[m-1+k] = f.cos[k*step], f.sin[k*step]
+		}
+	}
+}
+
+// isPowerOfTwo reports whether n is a power of two
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// Forward computes the spectrum of in
+func (f *realFFT) Forward(in []float64) ([]float64, []float64) {
+	f.forward(in, true)
+	return f.norm, f.phas
+}
+
+// ForwardNorm computes the magnitudes of the spectrum of in
+func (f *realFFT) ForwardNorm(in []float64) []float64 {
+	f.forward(in, false)
+	return f.norm
+}
+
+// forward computes the spectrum of in into norm and, if phases is set, phas
+func (f *realFFT) forward(in []float64, phases bool) {
+	if len(in) != f.n || f.norm == nil {
+		f.init(len(in))
+	}
+	if f.bitrev == nil {
+		f.dft(in)
+		return
+	}
+	if Accelerated() {
+		f.forwardFast(in, phases)
+		return
+	}
+
+	// Pack the even and odd samples into a complex sequence of half the size
+	half := f.n / 2
+	for i := range half {
+		j := f.bitrev[i]
+		f.re[j], f.im[j] = in[2*i], in[2*i+1]
+	}
+
+	// Iterative radix-2 transform; the twiddles of size n are used with a
+	// stride of 2 for the half-size transform
+	for size := 2; size <= half; size <<= 1 {
+		step := f.n / size
+		for start := 0; start < half; start += size {
+			for k := range size / 2 {
+				wr, wi := f.cos[k*step], f.sin[k*step]
+				a, b := start+k, start+k+size/2
+				tr := wr*f.re[b] - wi*f.im[b]
+				ti := wr*f.im[b] + wi*f.re[b]
+				f.re[b], f.im[b] = f.re[a]-tr, f.im[a]-ti
+				f.re[a], f.im[a] = f.re[a]+tr, f.im[a]+ti
+			}
+		}
+	}
+
+	// Separate the transforms of the even and odd samples and combine them
+	for k := 0; k <= half; k++ {
+		zr, zi := f.re[k%half], f.im[k%half]
+		cr, ci := f.re[(half-k)%half], -f.im[(half-k)%half]
+		er, ei := (zr+cr)/2, (zi+ci)/2
+		or, oi := (zi-ci)/2, -(zr-cr)/2
+		wr, wi := f.cos[k], f.sin[k]
+		xr := er + wr*or - wi*oi
+		xi := ei + wr*oi + wi*or
+		f.norm[k] = math.Sqrt(xr*xr + xi*xi)
+		f.phas[k] = math.Atan2(xi, xr)
+	}
+}
+
+// dft computes the spectrum of in directly, for sizes that are not a power of
+// two
+func (f *realFFT) dft(in []float64) {
+	for k := range f.norm {
+		xr, xi := 0.0, 0.0
+		for j, v := range in {
+			index := k * j % f.n
+			xr += v * f.cos[index]
+			xi += v * f.sin[index]
+		}
+		f.norm[k] = math.Sqrt(xr*xr + xi*xi)
+		f.phas[k] = math.Atan2(xi, xr)
+	}
+}
+
+// Inverse computes the len(out) real samples whose spectrum has the
+// magnitudes norm and phases phas in its first len(out)/2+1 bins, the others
+// following by symmetry
+func (f *realFFT) Inverse(norm, phas, out []float64) {
+	n := len(out)
+	if n != f.n || f.norm == nil {
+		f.init(n)
+	}
+	if !isPowerOfTwo(n) || n < 2 {
+		f.idft(norm, phas, out)
+		return
+	}
+	if f.fullrev == nil {
+		f.fullrev = make([]int, n)
+		f.xr, f.xi = make([]float64, n), make([]float64, n)
+		bits := 0
+		for 1<<bits < n {
+			bits++
+		}
+		for i := range n {
+			r := 0
+			for b := range bits {
+				r |= (i >> b & 1) << (bits - 1 - b)
+			}
+			f.fullrev[i] = r
+		}
+	}
+
+	// Place the conjugate-symmetric spectrum in bit-reversed order
+	for k := range n {
+		m, sign := k, 1.0
+		if k > n/2 {
+			m, sign = n-k, -1
+		}
+		sin, cos := math.Sincos(phas[m])
+		j := f.fullrev[k]
+		f.xr[j], f.xi[j] = norm[m]*cos, sign*norm[m]*sin
+	}
+
+	// Iterative radix-2 transform with the conjugate twiddles
+	for size := 2; size <= n; size <<= 1 {
+		step := n / size
+		for start := 0; start < n; start += size {
+			for k := range size / 2 {
+				wr, wi := f.cos[k*step], -f.sin[k*step]
+				a, b := start+k, start+k+size/2
+				tr := wr*f.xr[b] - wi*f.xi[b]
+				ti := wr*f.xi[b] + wi*f.xr[b]
+				f.xr[b], f.xi[b] = f.xr[a]-tr, f.xi[a]-ti
+				f.xr[a], f.xi[a] = f.xr[a]+tr, f.xi[a]+ti
+			}
+		}
+	}
+	for i := range out {
+		out[i] = f.xr[i] / float64(n)
+	}
+}
+
+// idft computes the inverse of a spectrum directly, for sizes that are not a
+// power of two
+func (f *realFFT) idft(norm, phas, out []float64) {
+	n := len(out)
+	for j := range out {
+		v := 0.0
+		for k := range n {
+			m, sign := k, 1.0
+			if k > n/2 {
+				m, sign = n-k, -1
+			}
+			index := k * j % n
+			// Real part of X[k] * exp(2*pi*i*k*j/n)
+			v += norm[m] * (math.Cos(phas[m])*f.cos[index] + sign*math.Sin(phas[m])*f.sin[index])
+		}
+		out[j] = v / float64(n)
+	}
+}
@@ -1,5 +1,10 @@
 package onset
 
+import (
+	"fmt"
+	"math"
+)
+
 // Filter represents a digital filter
 type Filter struct {
 	Order uint
@@ -36,6 +41,63 @@ func NewBiquadFilter(b0, b1, b2, a1, a2 float64) *Filter {
 	return f
 }
 
+// biquadParams returns the cosine of the normalized cutoff frequency and the
+// alpha term of the Audio EQ Cookbook designs, or an error for a frequency
+// outside (0, samplerate/2) or a q that is not positive and finite, whose
+// coefficients would be unstable or NaN
+func biquadParams(fc, q float64, samplerate uint) (float64, float64, error) {
+	if !(fc > 0 && fc < float64(samplerate)/2) {
+		return 0, 0, fmt.Errorf("invalid filter frequency %g Hz: must be between 0 and half the sample rate %d", fc, samplerate)
+	}
+	if !(q > 0) || math.IsInf(q, 1) {
+		return 0, 0, fmt.Errorf("invalid filter Q %g: must be positive and finite", q)
+	}
+	w0 := 2 * math.Pi * fc / float64(samplerate)
+	return math.Cos(w0), math.Sin(w0) / (2 * q), nil
+}
+
+// newNormalizedBiquad creates a biquad filter from unnormalized coefficients
+func newNormalizedBiquad(b0, b1, b2, a0, a1, a2 float64) *Filter {
+	return NewBiquadFilter(b0/a0, b1/a0, b2/a0, a1/a0, a2/a0)
+}
+
+// NewLowpassBiquad creates a second-order lowpass filter with cutoff frequency
+// fc in Hz and quality factor q, following the Audio EQ Cookbook. A q of
+// 1/sqrt(2) gives a Butterworth response. The cutoff must be between 0 and
+// half the sample rate, and q positive.
+func NewLowpassBiquad(fc, q float64, samplerate uint) (*Filter, error) {
+	cosw0, alpha, err := biquadParams(fc, q, samplerate)
+	if err != nil {
+		return nil, err
+	}
+	b1 := 1 - cosw0
+	return newNormalizedBiquad(b1/2, b1, b1/2, 1+alpha, -2*cosw0, 1-alpha), nil
+}
+
+// NewHighpassBiquad creates a second-order highpass filter with cutoff
+// frequency fc in Hz and quality factor q, following the Audio EQ Cookbook.
+// The cutoff must be between 0 and half the sample rate, and q positive.
+func NewHighpassBiquad(fc, q float64, samplerate uint) (*Filter, error) {
+	cosw0, alpha, err := biquadParams(fc, q, samplerate)
+	if err != nil {
+		return nil, err
+	}
+	b1 := -(1 + cosw0)
+	return newNormalizedBiquad(-b1/2, b1, -b1/2, 1+alpha, -2*cosw0, 1-alpha), nil
+}
+
+// NewBandpassBiquad creates a second-order bandpass filter with center
+// frequency fc in Hz and quality factor q, with a gain of 0 dB at the center,
+// following the Audio EQ Cookbook. The center frequency must be between 0 and
+// half the sample rate, and q positive.
+func NewBandpassBiquad(fc, q float64, samplerate uint) (*Filter, error) {
+	cosw0, alpha, err := biquadParams(fc, q, samplerate)
+	if err != nil {
+		return nil, err
+	}
+	return newNormalizedBiquad(alpha, 0, -alpha, 1+alpha, -2*cosw0, 1-alpha), nil
+}
+
 // Do applies the filter to the input vector in-place
 func (f *Filter) Do(in *Fvec) {
 	for j := uint(0); j < in.Length; j++ {
@@ -90,3 +152,11 @@ func (f *Filter) Reset() {
 		f.Y[i] = 0
 	}
 }
+
+// Clone returns a filter with the same coefficients and a cleared history
+func (f *Filter) Clone() *Filter {
+	c := NewFilter(f.Order)
+	copy(c.A, f.A)
+	copy(c.B, f.B)
+	return c
+}
@@ -0,0 +1,145 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+)
+
+// Slaney's mel filterbank, as in the Auditory Toolbox and aubio: 13 linearly
+// spaced filters from 133 Hz followed by 27 log-spaced ones
+const (
+	slaneyLowestHz      = 400.0 / 3
+	slaneyLinearSpacing = 200.0 / 3
+	slaneyLogSpacing    = 1.0711703
+	slaneyLinearFilters = 13
+	slaneyLogFilters    = 27
+)
+
+// Filterbank weighs the magnitudes of spectra into the energies of a set of
+// bands, one row of bin coefficients per filter
+type Filterbank struct {
+	NFilters uint
+	WinSize  uint
+	// Coeffs holds the coefficients of every filter, WinSize/2+1 bins each
+	Coeffs [][]float64
+	// Power raises the magnitudes to this power before they are weighed; 1
+	// weighs magnitudes, 2 energies
+	Power float64
+}
+
+// NewFilterbank creates a filterbank of nFilters filters for spectra of
+// winSize samples, with all coefficients zero
+func NewFilterbank(nFilters, winSize uint) *Filterbank {
+	f := &Filterbank{
+		NFilters: nFilters,
+		WinSize:  winSize,
+		Coeffs:   make([][]float64, nFilters),
+		Power:    1,
+	}
+	for i := range f.Coeffs {
+		f.Coeffs[i] = make([]float64, winSize/2+1)
+	}
+	return f
+}
+
+// SetPower sets the power the magnitudes are raised to before they are
+// weighed
+func (f *Filterbank) SetPower(power float64) {
+	f.Power = power
+}
+
+// Do writes the output of every filter of the spectrum to out
+func (f *Filterbank) Do(in *Cvec, out *Fvec) {
+	for i, coeffs := range f.Coeffs[:min(len(f.Coeffs), len(out.Data))] {
+		sum := 0.0
+		for bin, c := range coeffs[:min(len(coeffs), len(in.Norm))] {
+			if c == 0 {
+				continue
+			}
+			v := in.Norm[bin]
+			if f.Power != 1 {
+				v = math.Pow(v, f.Power)
+			}
+			sum += c * v
+		}
+		out.Data[i] = sum
+	}
+}
+
+// SetTriangleBands sets the filters to triangles between consecutive
+// frequencies in Hz, filter i rising from freqs[i] to its peak at freqs[i+1]
+// and falling to freqs[i+2]. Every triangle has an area of 1, so that wider
+// bands do not weigh more. freqs must hold NFilters+2 increasing values.
+func (f *Filterbank) SetTriangleBands(freqs []float64, samplerate uint) error {
+	if len(freqs) != int(f.NFilters)+2 {
+		return fmt.Errorf("expected %d frequencies for %d filters, got %d", f.NFilters+2, f.NFilters, len(freqs))
+	}
+	for i := 1; i < len(freqs); i++ {
+		if freqs[i] <= freqs[i-1] {
+			return fmt.Errorf("filterbank frequencies must increase, got %g Hz after %g Hz", freqs[i], freqs[i-1])
+		}
+	}
+	if samplerate == 0 {
+		return fmt.Errorf("invalid sample rate: %d", samplerate)
+	}
+
+	binHz := float64(samplerate) / float64(f.WinSize)
+	for i, coeffs := range f.Coeffs {
+		lower, center, upper := freqs[i], freqs[i+1], freqs[i+2]
+		height := 2 / (upper - lower)
+		for bin := range coeffs {
+			hz := float64(bin) * binHz
+			switch {
+			case hz >= lower && hz < center:
+				coeffs[bin] = height * (hz - lower) / (center - lower)
+			case hz >= center && hz < upper:
+				coeffs[bin] = height * (upper - hz) / (upper - center)
+			default:
+				coeffs[bin] = 0
+			}
+		}
+	}
+	return nil
+}
+
+// SetMelCoeffsSlaney sets the 40 filters of Slaney's mel filterbank, the
+// default of aubio's MFCC, spanning 133 Hz to 6.4 kHz. The filterbank must
+// have 40 filters.
+func (f *Filterbank) SetMelCoeffsSlaney(samplerate uint) error {
+	if f.NFilters != slaneyLinearFilters+slaneyLogFilters {
+		return fmt.Errorf("Slaney's filterbank has %d filters, got %d", slaneyLinearFilters+slaneyLogFilters, f.NFilters)
+	}
+	freqs := make([]float64, f.NFilters+2)
+	for i := range slaneyLinearFilters {
+		freqs[i] = slaneyLowestHz + float64(i)*slaneyLinearSpacing
+	}
+	lastLinear := freqs[slaneyLinearFilters-1]
+	for i := range slaneyLogFilters + 2 {
+		freqs[slaneyLinearFilters+i] = lastLinear * math.Pow(slaneyLogSpacing, float64(i+1))
+	}
+	return f.SetTriangleBands(freqs, samplerate)
+}
+
+// SetMelCoeffs sets the filters to triangles evenly spaced on the mel scale
+// from minHz to maxHz, using the HTK formula
+func (f *Filterbank) SetMelCoeffs(samplerate uint, minHz, maxHz float64) error {
+	if minHz < 0 || maxHz <= minHz {
+		return fmt.Errorf("invalid mel filterbank range %g to %g Hz", minHz, maxHz)
+	}
+	minMel, maxMel := HzToMel(minHz), HzToMel(maxHz)
+	freqs := make([]float64, f.NFilters+2)
+	for i := range freqs {
+		freqs[i] = MelToHz(minMel + (maxMel-minMel)*float64(i)/float64(f.NFilters+1))
+	}
+	return f.SetTriangleBands(freqs, samplerate)
+}
+
+// HzToMel converts a frequency in Hz to mels with the HTK formula
+func HzToMel(hz float64) float64 {
+	return 2595 * math.Log10(1+hz/700)
+}
+
+// MelToHz converts mels to a frequency in Hz with the HTK formula
+func MelToHz(mel float64) float64 {
+	return 700 * (math.Pow(10, mel/2595) - 1)
+}
@@ -0,0 +1,261 @@
+package onset
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// scalarKernels disables the accelerated kernels when set
+var scalarKernels atomic.Bool
+
+// SetAccelerated enables or disables the accelerated implementations of the
+// hot loops of the analysis: the FFT, whose stages are paired into radix-4
+// passes, the magnitude computation, which skips the phases when the
+// detection function does not use them, log compression, the "energy", "hfc"
+// and "specflux" detection functions and adaptive whitening. They are
+// written in plain Go, unrolled so that the compiler can eliminate bounds
+// checks and overlap the independent accumulations, and are enabled by
+// default. Sums are accumulated in a different order than the scalar
+// implementations, so detection functions can differ in the last bits.
+// Disabling them restores the reference implementations, such as to rule them
+// out when investigating a detection. It affects all detectors and is safe
+// for concurrent use.
+func SetAccelerated(enable bool) {
+	scalarKernels.Store(!enable)
+}
+
+// Accelerated reports whether the accelerated kernels are enabled
+func Accelerated() bool {
+	return !scalarKernels.Load()
+}
+
+// forwardFast implements forward for power-of-two sizes, with the twiddles of
+// every stage stored contiguously and the butterflies written on subslices so
+// that their bounds checks are eliminated
+func (f *realFFT) forwardFast(in []float64, phases bool) {
+	half := f.n / 2
+	re, im := f.re[:half], f.im[:half]
+	in = in[:2*half]
+	m := 1
+	if half >= 4 {
+		// The first two stages as radix-4 butterflies on the bit-reversed
+		// input, whose twiddles 1 and -i need no multiplications
+		bitrev := f.bitrev[:half]
+		for a := 0; a+4 <= half; a += 4 {
+			p := bitrev[a : a+4 : a+4]
+			r0, i0 := in[2*p[0]], in[2*p[0]+1]
+			r1, i1 := in[2*p[1]], in[2*p[1]+1]
+			r2, i2 := in[2*p[2]], in[2*p[2]+1]
+			r3, i3 := in[2*p[3]], in[2*p[3]+1]
+			sr0, si0, dr0, di0 := r0+r1, i0+i1, r0-r1, i0-i1
+			sr1, si1, dr1, di1 := r2+r3, i2+i3, r2-r3, i2-i3
+			x, y := re[a:a+4:a+4], im[a:a+4:a+4]
+			x[0], y[0] = sr0+sr1, si0+si1
+			x[2], y[2] = sr0-sr1, si0-si1
+			x[1], y[1] = dr0+di1, di0-dr1
+			x[3], y[3] = dr0-di1, di0+dr1
+		}
+		m = 4
+	} else {
+		for i, j := range f.bitrev[:half] {
+			re[j], im[j] = in[2*i], in[2*i+1]
+		}
+	}
+	// Pairs of stages as radix-4 passes, which load and store every value
+	// once for two stages, with the same arithmetic as two radix-2 stages
+	for ; 4*m <= half; m <<= 2 {
+		w1r, w1i := f.twr[m-1:2*m-1], f.twi[m-1:2*m-1]
+		w2r, w2i := f.twr[2*m-1:4*m-1], f.twi[2*m-1:4*m-1]
+		for start := 0; start < half; start += 4 * m {
+			ar, ai := re[start:start+m], im[start:start+m]
+			br, bi := re[start+m:start+2*m], im[start+m:start+2*m]
+			cr, ci := re[start+2*m:start+3*m], im[start+2*m:start+3*m]
+			dr, di := re[start+3*m:start+4*m], im[start+3*m:start+4*m]
+			ai, br, bi = ai[:len(ar)], br[:len(ar)], bi[:len(ar)]
+			cr, ci, dr, di = cr[:len(ar)], ci[:len(ar)], dr[:len(ar)], di[:len(ar)]
+			wr, wi := w1r[:len(ar)], w1i[:len(ar)]
+			vr, vi := w2r[:len(ar)], w2i[:len(ar)]
+			ur, ui := w2r[len(ar):2*len(ar)], w2i[len(ar):2*len(ar)]
+			for k, xr := range ar {
+				// First stage: (a, b) and (c, d) with the twiddle w
+				tr := wr[k]*br[k] - wi[k]*bi[k]
+				ti := wr[k]*bi[k] + wi[k]*br[k]
+				xi := ai[k]
+				a0r, a0i, b0r, b0i := xr+tr, xi+ti, xr-tr, xi-ti
+				tr = wr[k]*dr[k] - wi[k]*di[k]
+				ti = wr[k]*di[k] + wi[k]*dr[k]
+				c0r, c0i, d0r, d0i := cr[k]+tr, ci[k]+ti, cr[k]-tr, ci[k]-ti
+				// Second stage: (a, c) with the twiddle v and (b, d) with u
+				tr = vr[k]*c0r - vi[k]*c0i
+				ti = vr[k]*c0i + vi[k]*c0r
+				ar[k], ai[k], cr[k], ci[k] = a0r+tr, a0i+ti, a0r-tr, a0i-ti
+				tr = ur[k]*d0r - ui[k]*d0i
+				ti = ur[k]*d0i + ui[k]*d0r
+				br[k], bi[k], dr[k], di[k] = b0r+tr, b0i+ti, b0r-tr, b0i-ti
+			}
+		}
+	}
+	for ; m < half; m <<= 1 {
+		twr, twi := f.twr[m-1:2*m-1], f.twi[m-1:2*m-1]
+		for start := 0; start < half; start += 2 * m {
+			ar, ai := re[start:start+m], im[start:start+m]
+			br, bi := re[start+m:start+2*m], im[start+m:start+2*m]
+			ai, br, bi = ai[:len(ar)], br[:len(ar)], bi[:len(ar)]
+			wr, wi := twr[:len(ar)], twi[:len(ar)]
+			for k, xr := range ar {
+				tr := wr[k]*br[k] - wi[k]*bi[k]
+				ti := wr[k]*bi[k] + wi[k]*br[k]
+				xi := ai[k]
+				br[k], bi[k] = xr-tr, xi-ti
+				ar[k], ai[k] = xr+tr, xi+ti
+			}
+		}
+	}
+
+	// Separate the transforms of the even and odd samples and combine them.
+	// Bins 0 and n/2 wrap around the half-size transform and are combined
+	// with the same arithmetic as the reference implementation, whose
+	// rounding decides the sign of their phase.
+	norm, phas := f.norm[:half+1], f.phas[:half+1]
+	for _, k := range [2]int{0, half} {
+		zr, zi := re[0], im[0]
+		er, ei := zr, (zi-zi)/2
+		or, oi := zi, -(zr-zr)/2
+		wr, wi := f.cos[k], f.sin[k]
+		xr := er + wr*or - wi*oi
+		xi := ei + wr*oi + wi*or
+		norm[k] = math.Sqrt(xr*xr + xi*xi)
+		if phases {
+			phas[k] = math.Atan2(xi, xr)
+		}
+	}
+	cos, sin := f.cos[:half], f.sin[:half]
+	for k := 1; k < half; k++ {
+		zr, zi := re[k], im[k]
+		cr, ci := re[half-k], -im[half-k]
+		er, ei := (zr+cr)*0.5, (zi+ci)*0.5
+		or, oi := (zi-ci)*0.5, (cr-zr)*0.5
+		wr, wi := cos[k], sin[k]
+		xr := er + wr*or - wi*oi
+		xi := ei + wr*oi + wi*or
+		norm[k] = math.Sqrt(xr*xr + xi*xi)
+		if phases {
+			phas[k] = math.Atan2(xi, xr)
+		}
+	}
+}
+
+// sumSquares returns the sum of the squares of x
+func sumSquares(x []float64) float64 {
+	var s0, s1, s2, s3 float64
+	i := 0
+	for ; i+4 <= len(x); i += 4 {
+		v := x[i : i+4 : i+4]
+		s0 += v[0] * v[0]
+		s1 += v[1] * v[1]
+		s2 += v[2] * v[2]
+		s3 += v[3] * v[3]
+	}
+	for ; i < len(x); i++ {
+		s0 += x[i] * x[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+// weightedSum returns the sum of x[j] weighted by j+1
+func weightedSum(x []float64) float64 {
+	var s0, s1, s2, s3 float64
+	w := 1.0
+	i := 0
+	for ; i+4 <= len(x); i += 4 {
+		v := x[i : i+4 : i+4]
+		s0 += w * v[0]
+		s1 += (w + 1) * v[1]
+		s2 += (w + 2) * v[2]
+		s3 += (w + 3) * v[3]
+		w += 4
+	}
+	for ; i < len(x); i++ {
+		s0 += w * x[i]
+		w++
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+// positiveFlux returns the sum of the increases of norm over old and copies
+// norm into old
+func positiveFlux(norm, old []float64) float64 {
+	old = old[:len(norm)]
+	var s0, s1, s2, s3 float64
+	i := 0
+	for ; i+4 <= len(norm); i += 4 {
+		v, p := norm[i:i+4:i+4], old[i:i+4:i+4]
+		s0 += max(v[0]-p[0], 0)
+		s1 += max(v[1]-p[1], 0)
+		s2 += max(v[2]-p[2], 0)
+		s3 += max(v[3]-p[3], 0)
+	}
+	for ; i < len(norm); i++ {
+		s0 += max(norm[i]-old[i], 0)
+	}
+	copy(old, norm)
+	return (s0 + s1) + (s2 + s3)
+}
+
+// whiten divides norm by the decaying peaks, which it updates like
+// SpectralWhitening.Do
+func whiten(norm, peaks []float64, decay, floor float64) {
+	peaks = peaks[:len(norm)]
+	for i, v := range norm {
+		peak := max(v, decay*peaks[i], floor)
+		peaks[i] = peak
+		if peak > 0 {
+			norm[i] = v / peak
+		}
+	}
+}
+
+// Coefficients of the logarithm of logCompress, those of the math package
+const (
+	logLn2Hi = 6.93147180369123816490e-01
+	logLn2Lo = 1.90821492927058770002e-10
+	logL1    = 6.666666666666735130e-01
+	logL2    = 3.999999999940941908e-01
+	logL3    = 2.857142874366239149e-01
+	logL4    = 2.222219843214978396e-01
+	logL5    = 1.818357216161805012e-01
+	logL6    = 1.531383769920937332e-01
+	logL7    = 1.479819860511658591e-01
+	// sqrtHalfBits are the bits of sqrt(2)/2
+	sqrtHalfBits = 0x3fe6a09e667f3bcd
+)
+
+// logCompress replaces every magnitude v of norm with log(1 + lambda*v),
+// like Cvec.LogMag. The logarithm is that of the math package, inlined
+// without the special cases of arguments below 1, which never occur here,
+// and with the exponent and mantissa read directly from the bits.
+func logCompress(norm []float64, lambda float64) {
+	for i, v := range norm {
+		x := 1 + lambda*v
+		bits := math.Float64bits(x)
+		exp := int(bits>>52) & 0x7ff
+		if x < 1 || exp == 0x7ff {
+			// Negative magnitudes, infinities and NaNs
+			norm[i] = math.Log(x)
+			continue
+		}
+		// x = m * 2^k with m in [sqrt(2)/2, sqrt(2)), without a branch: the
+		// offset carries into the exponent when the mantissa is above sqrt(2)
+		bits += 1023<<52 - sqrtHalfBits
+		k := float64(int(bits>>52) - 1023)
+		m := math.Float64frombits(bits&(1<<52-1) + sqrtHalfBits)
+		f := m - 1
+		s := f / (2 + f)
+		s2 := s * s
+		s4 := s2 * s2
+		t1 := s2 * (logL1 + s4*(logL3+s4*(logL5+s4*logL7)))
+		t2 := s4 * (logL2 + s4*(logL4+s4*logL6))
+		hfsq := 0.5 * f * f
+		norm[i] = k*logLn2Hi - ((hfsq - (s*(hfsq+t1+t2) + k*logLn2Lo)) - f)
+	}
+}
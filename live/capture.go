@@ -0,0 +1,164 @@
+//go:build cgo
+
+package live
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gen2brain/malgo"
+)
+
+// Devices returns the names of the available capture devices
+func Devices() ([]string, error) {
+	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize audio: %w", err)
+	}
+	defer func() {
+		ctx.Uninit()
+		ctx.Free()
+	}()
+
+	infos, err := ctx.Devices(malgo.Capture)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list capture devices: %w", err)
+	}
+	names := make([]string, len(infos))
+	for i := range infos {
+		names[i] = infos[i].Name()
+	}
+	return names, nil
+}
+
+// Stream captures audio from an input device and detects onsets in it
+type Stream struct {
+	ctx     *malgo.AllocatedContext
+	device  *malgo.Device
+	events  chan Event
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// Start opens the capture device and starts detecting onsets. Call Close to
+// stop capturing.
+func Start(options Options) (*Stream, error) {
+	if options.SampleRate == 0 {
+		options.SampleRate = 44100
+	}
+	if options.Channels == 0 {
+		options.Channels = options.Channel + 1
+	}
+	if options.LatencyMs == 0 {
+		options.LatencyMs = 10
+	}
+	if options.EventBuffer == 0 {
+		options.EventBuffer = 64
+	}
+	if options.Channel < 0 || options.Channel >= options.Channels {
+		return nil, fmt.Errorf("channel %d out of range (capturing %d channels)", options.Channel, options.Channels)
+	}
+	d, err := newDetector(options)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize audio: %w", err)
+	}
+	s := &Stream{ctx: ctx, events: make(chan Event, options.EventBuffer)}
+
+	config := malgo.DefaultDeviceConfig(malgo.Capture)
+	config.Capture.Format = malgo.FormatF32
+	config.Capture.Channels = uint32(options.Channels)
+	config.SampleRate = uint32(options.SampleRate)
+	config.PeriodSizeInMilliseconds = uint32(options.LatencyMs)
+	if options.Device != "" {
+		info, err := findDevice(ctx, options.Device)
+		if err != nil {
+			s.freeContext()
+			return nil, err
+		}
+		config.Capture.DeviceID = info.ID.Pointer()
+	}
+
+	stride := 4 * options.Channels
+	samples := make([]float64, 0, 4096)
+	onData := func(_, input []byte, frameCount uint32) {
+		samples = samples[:0]
+		for i := 0; i+stride <= len(input); i += stride {
+			offset := i + 4*options.Channel
+			samples = append(samples, float64(math.Float32frombits(binary.LittleEndian.Uint32(input[offset:]))))
+		}
+		d.write(samples, s.send)
+	}
+
+	s.device, err = malgo.InitDevice(ctx.Context, config, malgo.DeviceCallbacks{Data: onData})
+	if err != nil {
+		s.freeContext()
+		return nil, fmt.Errorf("failed to open capture device: %w", err)
+	}
+	if err := s.device.Start(); err != nil {
+		s.device.Uninit()
+		s.freeContext()
+		return nil, fmt.Errorf("failed to start capture: %w", err)
+	}
+	return s, nil
+}
+
+// findDevice returns the first capture device whose name contains name
+func findDevice(ctx *malgo.AllocatedContext, name string) (malgo.DeviceInfo, error) {
+	infos, err := ctx.Devices(malgo.Capture)
+	if err != nil {
+		return malgo.DeviceInfo{}, fmt.Errorf("failed to list capture devices: %w", err)
+	}
+	for i := range infos {
+		if strings.Contains(strings.ToLower(infos[i].Name()), strings.ToLower(name)) {
+			return infos[i], nil
+		}
+	}
+	return malgo.DeviceInfo{}, fmt.Errorf("no capture device matching %q", name)
+}
+
+// Events returns the channel the onset events are delivered on. It is closed
+// by Close. The audio callback never blocks: when the channel is full because
+// the receiver falls behind, events are dropped and counted by Dropped.
+func (s *Stream) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events dropped because the channel was full
+func (s *Stream) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// send delivers an event without blocking the audio callback
+func (s *Stream) send(ev Event) {
+	select {
+	case s.events <- ev:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Close stops capturing, releases the device and closes the event channel
+func (s *Stream) Close() error {
+	s.once.Do(func() {
+		// Uninit waits for a running callback, so no event is sent after it
+		s.device.Uninit()
+		s.freeContext()
+		close(s.events)
+	})
+	return nil
+}
+
+// freeContext releases the audio context
+func (s *Stream) freeContext() {
+	s.ctx.Uninit()
+	s.ctx.Free()
+}
@@ -0,0 +1,49 @@
+//go:build !cgo
+
+package live
+
+import (
+	"errors"
+	"sync"
+)
+
+// errNoCgo is returned by the capture functions of builds without cgo
+var errNoCgo = errors.New("live capture requires cgo")
+
+// Devices returns the names of the available capture devices. It always
+// fails in builds without cgo.
+func Devices() ([]string, error) {
+	return nil, errNoCgo
+}
+
+// Stream captures audio from an input device and detects onsets in it
+type Stream struct {
+	events chan Event
+	once   sync.Once
+}
+
+// Start opens the capture device and starts detecting onsets. It always
+// fails in builds without cgo.
+func Start(options Options) (*Stream, error) {
+	return nil, errNoCgo
+}
+
+// Events returns the channel the onset events are delivered on
+func (s *Stream) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events dropped because the channel was full
+func (s *Stream) Dropped() int64 {
+	return 0
+}
+
+// Close closes the event channel
+func (s *Stream) Close() error {
+	s.once.Do(func() {
+		if s.events != nil {
+			close(s.events)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,228 @@
+//go:build jack
+
+package live
+
+/*
+#cgo LDFLAGS: -ljack
+#include <stdint.h>
+#include <stdlib.h>
+#include <jack/jack.h>
+#include <jack/transport.h>
+
+extern int goJackProcess(jack_nframes_t nframes, uintptr_t handle);
+
+static int jack_process(jack_nframes_t nframes, void *arg) {
+	return goJackProcess(nframes, (uintptr_t)arg);
+}
+
+static int set_process_callback(jack_client_t *client, uintptr_t handle) {
+	return jack_set_process_callback(client, jack_process, (void *)handle);
+}
+
+static jack_client_t *open_client(const char *name, jack_status_t *status) {
+	return jack_client_open(name, JackNoStartServer, status);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime/cgo"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// JackOptions contains configuration options for a JACK client
+type JackOptions struct {
+	// ClientName is the name of the JACK client. Default is "goaubio-onset" if empty.
+	ClientName string
+	// PortName is the name of the registered input port. Default is "in" if empty.
+	PortName string
+	// Connect is the output port connected to the input port once the client
+	// is active, such as "system:capture_1". The port is left unconnected if empty.
+	Connect string
+	// Method is the onset detection method. Default is "hfc" if empty.
+	// The "consensus" method is not supported.
+	Method string
+	// Threshold is the peak picking threshold. The tuned default of the
+	// method is used if 0.
+	Threshold float64
+	// MinioiMs is the minimum inter-onset interval in milliseconds. The
+	// tuned default of the method is used if 0.
+	MinioiMs float64
+	// EventBuffer is the capacity of the event channel. Default is 64 if 0.
+	EventBuffer int
+}
+
+// JackEvent is an onset detected by a JACK client, with sample-accurate JACK
+// timestamps. Sample and Time count from the activation of the client.
+type JackEvent struct {
+	Event
+	// FrameTime is the JACK frame time of the onset, as used by
+	// jack_last_frame_time. It wraps around like all JACK frame times.
+	FrameTime uint32
+	// TransportFrame is the JACK transport position of the onset in frames
+	TransportFrame uint32
+	// Rolling reports whether the transport was rolling at the onset
+	Rolling bool
+}
+
+// JackStream is a JACK client detecting onsets on its input port
+type JackStream struct {
+	client   *C.jack_client_t
+	port     *C.jack_port_t
+	handle   cgo.Handle
+	detector *detector
+	// processed is the number of samples processed before the current cycle
+	processed uint64
+	samples   []float64
+	events    chan JackEvent
+	dropped   atomic.Int64
+	once      sync.Once
+}
+
+// StartJack connects to the running JACK server, registers an input port and
+// starts detecting onsets on it. The sample rate is the server's. Call Close
+// to deactivate the client.
+func StartJack(options JackOptions) (*JackStream, error) {
+	if options.ClientName == "" {
+		options.ClientName = "goaubio-onset"
+	}
+	if options.PortName == "" {
+		options.PortName = "in"
+	}
+	if options.EventBuffer == 0 {
+		options.EventBuffer = 64
+	}
+
+	name := C.CString(options.ClientName)
+	defer C.free(unsafe.Pointer(name))
+	var status C.jack_status_t
+	client := C.open_client(name, &status)
+	if client == nil {
+		return nil, fmt.Errorf("failed to connect to the JACK server (status 0x%x)", int(status))
+	}
+
+	d, err := newDetector(Options{
+		SampleRate: uint(C.jack_get_sample_rate(client)),
+		Method:     options.Method,
+		Threshold:  options.Threshold,
+		MinioiMs:   options.MinioiMs,
+	})
+	if err != nil {
+		C.jack_client_close(client)
+		return nil, err
+	}
+
+	portName := C.CString(options.PortName)
+	defer C.free(unsafe.Pointer(portName))
+	portType := C.CString(C.JACK_DEFAULT_AUDIO_TYPE)
+	defer C.free(unsafe.Pointer(portType))
+	port := C.jack_port_register(client, portName, portType, C.JackPortIsInput, 0)
+	if port == nil {
+		C.jack_client_close(client)
+		return nil, fmt.Errorf("failed to register port %q", options.PortName)
+	}
+
+	s := &JackStream{
+		client:   client,
+		port:     port,
+		detector: d,
+		samples:  make([]float64, 0, 4096),
+		events:   make(chan JackEvent, options.EventBuffer),
+	}
+	s.handle = cgo.NewHandle(s)
+	if C.set_process_callback(client, C.uintptr_t(s.handle)) != 0 {
+		s.release()
+		return nil, fmt.Errorf("failed to set the process callback")
+	}
+	if C.jack_activate(client) != 0 {
+		s.release()
+		return nil, fmt.Errorf("failed to activate the JACK client")
+	}
+
+	if options.Connect != "" {
+		source := C.CString(options.Connect)
+		defer C.free(unsafe.Pointer(source))
+		if C.jack_connect(client, source, C.jack_port_name(port)) != 0 {
+			s.Close()
+			return nil, fmt.Errorf("failed to connect %s to %s", options.Connect, options.PortName)
+		}
+	}
+	return s, nil
+}
+
+// goJackProcess is the JACK process callback
+//
+//export goJackProcess
+func goJackProcess(nframes C.jack_nframes_t, handle C.uintptr_t) C.int {
+	s := cgo.Handle(handle).Value().(*JackStream)
+	s.process(uint32(nframes))
+	return 0
+}
+
+// process analyzes one JACK cycle of the input port
+func (s *JackStream) process(nframes uint32) {
+	buf := unsafe.Slice((*float32)(C.jack_port_get_buffer(s.port, C.jack_nframes_t(nframes))), nframes)
+	s.samples = s.samples[:0]
+	for _, v := range buf {
+		s.samples = append(s.samples, float64(v))
+	}
+
+	// Timestamps of the first sample of the cycle
+	cycleStart := uint32(C.jack_last_frame_time(s.client))
+	var pos C.jack_position_t
+	rolling := C.jack_transport_query(s.client, &pos) == C.JackTransportRolling
+
+	s.detector.write(s.samples, func(ev Event) {
+		// Onsets may lie before the cycle because of the delay compensation;
+		// the wrapping arithmetic of uint32 handles negative offsets
+		offset := uint32(int64(ev.Sample) - int64(s.processed))
+		s.send(JackEvent{
+			Event:          ev,
+			FrameTime:      cycleStart + offset,
+			TransportFrame: uint32(pos.frame) + offset,
+			Rolling:        rolling,
+		})
+	})
+	s.processed += uint64(nframes)
+}
+
+// send delivers an event without blocking the process callback
+func (s *JackStream) send(ev JackEvent) {
+	select {
+	case s.events <- ev:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Events returns the channel the onset events are delivered on. It is closed
+// by Close. The process callback never blocks: when the channel is full
+// because the receiver falls behind, events are dropped and counted by Dropped.
+func (s *JackStream) Events() <-chan JackEvent {
+	return s.events
+}
+
+// Dropped returns the number of events dropped because the channel was full
+func (s *JackStream) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close deactivates and closes the client and closes the event channel
+func (s *JackStream) Close() error {
+	s.once.Do(func() {
+		// Deactivation waits for a running process callback
+		C.jack_deactivate(s.client)
+		s.release()
+		close(s.events)
+	})
+	return nil
+}
+
+// release closes the client and frees the callback handle
+func (s *JackStream) release() {
+	C.jack_client_close(s.client)
+	s.handle.Delete()
+}
@@ -0,0 +1,99 @@
+// Package live detects onsets in audio captured from an input device, such as
+// a microphone or an audio interface, and delivers them on a channel.
+// Capture uses miniaudio through cgo, which supports ALSA, PulseAudio and JACK
+// on Linux, Core Audio on macOS and WASAPI on Windows. Without cgo the
+// package builds, but Devices and Start return an error.
+package live
+
+import (
+	"fmt"
+
+	"github.com/schollz/onsets"
+)
+
+// Options contains configuration options for a capture Stream
+type Options struct {
+	// Device selects the capture device by a case-insensitive substring of its
+	// name, as listed by Devices. The system default device is used if empty.
+	Device string
+	// SampleRate is the capture sample rate in Hz. Default is 44100 if 0.
+	SampleRate uint
+	// Channel is the zero-based index of the device channel to analyze.
+	Channel int
+	// Channels is the number of channels to capture. It must be greater than
+	// Channel. Default is Channel+1 if 0.
+	Channels int
+	// LatencyMs is the capture period in milliseconds, which bounds the delay
+	// between a sound and its event together with the detector's hop of
+	// 256 samples. Default is 10 ms if 0.
+	LatencyMs uint
+	// Method is the onset detection method. Default is "hfc" if empty.
+	// The "consensus" method is not supported.
+	Method string
+	// Threshold is the peak picking threshold. The tuned default of the
+	// method is used if 0.
+	Threshold float64
+	// MinioiMs is the minimum inter-onset interval in milliseconds. The
+	// tuned default of the method is used if 0.
+	MinioiMs float64
+	// EventBuffer is the capacity of the event channel. Default is 64 if 0.
+	EventBuffer int
+}
+
+// Event is an onset detected in the captured audio
+type Event struct {
+	// Sample is the onset position in samples since the start of the capture
+	Sample uint64
+	// Time is the onset time in seconds since the start of the capture
+	Time float64
+	// Strength is the value of the detection function at the onset
+	Strength float64
+}
+
+// detector runs onset detection on blocks of any size, hop by hop
+type detector struct {
+	o    *onset.Onset
+	hop  *onset.Fvec
+	out  *onset.Fvec
+	fill uint
+}
+
+// newDetector creates the detector configured by options
+func newDetector(options Options) (*detector, error) {
+	method := options.Method
+	if method == "" {
+		method = "hfc"
+	}
+	if method == "consensus" {
+		return nil, fmt.Errorf("live detection does not support the consensus method")
+	}
+
+	o, err := onset.NewOnsetErr(method, 512, 256, options.SampleRate)
+	if err != nil {
+		return nil, err
+	}
+	if options.Threshold > 0 {
+		o.SetThreshold(options.Threshold)
+	}
+	if options.MinioiMs > 0 {
+		o.SetMinioiMs(options.MinioiMs)
+	}
+	return &detector{o: o, hop: onset.NewFvec(o.HopSize), out: onset.NewFvec(1)}, nil
+}
+
+// write feeds samples to the detector and calls emit for every onset
+func (d *detector) write(samples []float64, emit func(Event)) {
+	for _, v := range samples {
+		d.hop.Data[d.fill] = v
+		d.fill++
+		if d.fill < d.o.HopSize {
+			continue
+		}
+		d.fill = 0
+
+		d.o.Do(d.hop, d.out)
+		if d.out.Data[0] > 0 {
+			emit(Event{Sample: d.o.GetLast(), Time: d.o.GetLastS(), Strength: d.o.GetOnsetDescriptor()})
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package live
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDetector(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := make([]float64, 2*sampleRate)
+	for _, start := range []float64{0.25, 0.75, 1.25} {
+		offset := int(start * float64(sampleRate))
+		for i := 0; i < 2000; i++ {
+			samples[offset+i] = math.Sin(float64(i)*0.3) * math.Exp(-float64(i)/500)
+		}
+	}
+
+	d, err := newDetector(Options{SampleRate: sampleRate})
+	if err != nil {
+		t.Fatalf("newDetector failed: %v", err)
+	}
+
+	// Feed blocks of a size unrelated to the hop size, as a device would
+	var events []Event
+	for start := 0; start < len(samples); start += 441 {
+		d.write(samples[start:min(start+441, len(samples))], func(ev Event) {
+			events = append(events, ev)
+		})
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 onsets, got %d: %+v", len(events), events)
+	}
+	for i, expected := range []float64{0.25, 0.75, 1.25} {
+		if math.Abs(events[i].Time-expected) > 0.02 || events[i].Strength <= 0 {
+			t.Errorf("Unexpected event %d: %+v", i, events[i])
+		}
+	}
+
+	if _, err := newDetector(Options{SampleRate: sampleRate, Method: "consensus"}); err == nil {
+		t.Error("Expected error for the consensus method, got nil")
+	}
+	if _, err := Start(Options{Channel: 2, Channels: 2}); err == nil {
+		t.Error("Expected error for an out of range channel, got nil")
+	}
+}
@@ -0,0 +1,172 @@
+package onset
+
+import "math"
+
+// EBU R128 (ITU-R BS.1770) loudness measurement constants
+const (
+	// loudnessBlockSec and loudnessStepSec are the length and step of the
+	// gating blocks of the integrated loudness
+	loudnessBlockSec = 0.4
+	loudnessStepSec  = 0.1
+	// shortTermSec is the window length of the short-term loudness
+	shortTermSec = 3.0
+	// loudnessFloor is the absolute gate in LUFS, reported for signals
+	// below it, such as silence
+	loudnessFloor = -70.0
+	// loudnessRelativeGate is the gate in LU below the loudness of the
+	// blocks above the absolute gate
+	loudnessRelativeGate = -10.0
+)
+
+// newKWeighting returns the K-weighting of BS.1770 at the given sample rate,
+// a high shelf modeling the acoustic effect of the head followed by the RLB
+// highpass, designed from their analog prototypes as in libebur128 so that
+// any sample rate matches the 48 kHz coefficients of the standard
+func newKWeighting(samplerate uint) *Pipeline {
+	// High shelf of +4 dB above about 1.5 kHz
+	k := math.Tan(math.Pi * 1681.974450955533 / float64(samplerate))
+	q := 0.7071752369554196
+	vh := math.Pow(10, 3.999843853973347/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	shelf := newNormalizedBiquad(vh+vb*k/q+k*k, 2*(k*k-vh), vh-vb*k/q+k*k, 1+k/q+k*k, 2*(k*k-1), 1-k/q+k*k)
+
+	// Highpass at 38 Hz, with the unnormalized numerator of the standard
+	k = math.Tan(math.Pi * 38.13547087602444 / float64(samplerate))
+	q = 0.5003270373238773
+	a0 := 1 + k/q + k*k
+	highpass := NewBiquadFilter(1, -2, 1, 2*(k*k-1)/a0, (1-k/q+k*k)/a0)
+	return NewPipeline(shelf, highpass)
+}
+
+// kWeighted returns the samples filtered by the K-weighting
+func kWeighted(samples []float64, sampleRate uint) []float64 {
+	weighted := NewFvec(uint(len(samples)))
+	copy(weighted.Data, samples)
+	newKWeighting(sampleRate).Process(weighted)
+	return weighted.Data
+}
+
+// loudnessOf converts a mean square of K-weighted samples to LUFS, at least
+// loudnessFloor
+func loudnessOf(power float64) float64 {
+	if power <= 0 {
+		return loudnessFloor
+	}
+	return max(-0.691+10*math.Log10(power), loudnessFloor)
+}
+
+// blockPowers returns the mean squares of the K-weighted samples over
+// blocks of blockSec seconds every loudnessStepSec, or over all samples if
+// they are shorter than a block
+func blockPowers(weighted []float64, sampleRate uint, blockSec float64) []float64 {
+	if len(weighted) == 0 {
+		return nil
+	}
+	block := max(int(blockSec*float64(sampleRate)), 1)
+	step := max(int(loudnessStepSec*float64(sampleRate)), 1)
+	if len(weighted) < block {
+		block = len(weighted)
+	}
+	energy := make([]float64, len(weighted)+1)
+	for i, v := range weighted {
+		energy[i+1] = energy[i] + v*v
+	}
+	var powers []float64
+	for start := 0; start+block <= len(weighted); start += step {
+		powers = append(powers, (energy[start+block]-energy[start])/float64(block))
+	}
+	return powers
+}
+
+// gatedLoudness returns the integrated loudness in LUFS of K-weighted
+// samples: the loudness of the blocks above the absolute gate and above the
+// relative gate below their loudness
+func gatedLoudness(weighted []float64, sampleRate uint) float64 {
+	powers := blockPowers(weighted, sampleRate, loudnessBlockSec)
+	mean := func(threshold float64) float64 {
+		sum, n := 0.0, 0
+		for _, p := range powers {
+			if loudnessOf(p) > threshold {
+				sum += p
+				n++
+			}
+		}
+		if n == 0 {
+			return 0
+		}
+		return sum / float64(n)
+	}
+	ungated := mean(loudnessFloor)
+	if ungated == 0 {
+		return loudnessFloor
+	}
+	return loudnessOf(mean(loudnessOf(ungated) + loudnessRelativeGate))
+}
+
+// IntegratedLoudness returns the EBU R128 integrated loudness of mono
+// samples in LUFS, -70 for signals below the absolute gate. Samples shorter
+// than a gating block of 400 ms are measured as one block.
+func IntegratedLoudness(samples []float64, sampleRate uint) float64 {
+	return gatedLoudness(kWeighted(samples, sampleRate), sampleRate)
+}
+
+// ShortTermLoudness returns the EBU R128 short-term loudness of mono samples
+// in LUFS, over windows of 3 s every 100 ms, or one value over all samples
+// if they are shorter than a window
+func ShortTermLoudness(samples []float64, sampleRate uint) []float64 {
+	powers := blockPowers(kWeighted(samples, sampleRate), sampleRate, shortTermSec)
+	loudness := make([]float64, len(powers))
+	for i, p := range powers {
+		loudness[i] = loudnessOf(p)
+	}
+	return loudness
+}
+
+// measureLoudness sets the integrated loudness and the true peak of the
+// samples of r and of every slice. The loudness is measured on the
+// K-weighting of the whole samples, so that the filters are settled at the
+// start of every slice, and the true peaks on the slices alone, as they are
+// exported.
+func (r *SliceAnalyzerResult) measureLoudness() {
+	weighted := kWeighted(r.Samples, r.SampleRate)
+	r.Loudness = gatedLoudness(weighted, r.SampleRate)
+	r.TruePeak = TruePeak(r.Samples, r.SampleRate)
+	ranges := r.SliceRanges()
+	r.SliceLoudness = make([]float64, len(ranges))
+	r.SliceTruePeaks = make([]float64, len(ranges))
+	for i, sr := range ranges {
+		r.SliceLoudness[i] = gatedLoudness(weighted[sr.Start:sr.End], r.SampleRate)
+		r.SliceTruePeaks[i] = TruePeak(r.Samples[sr.Start:sr.End], r.SampleRate)
+	}
+}
+
+// truePeakOversampling returns the oversampling factor of the true-peak
+// measurement at the given sample rate, as recommended by BS.1770: 4 below
+// 96 kHz, 2 below 192 kHz and none above
+func truePeakOversampling(sampleRate uint) uint {
+	switch {
+	case sampleRate < 96000:
+		return 4
+	case sampleRate < 192000:
+		return 2
+	}
+	return 1
+}
+
+// TruePeak returns the true peak of samples, the largest magnitude of the
+// waveform reconstructed between the samples as measured on the samples
+// oversampled with the windowed-sinc interpolator of Resample. It exceeds 1
+// when the waveform clips between samples, even if no sample does, which a
+// converter or a lossy encoder would turn into distortion.
+func TruePeak(samples []float64, sampleRate uint) float64 {
+	peak := 0.0
+	for _, v := range samples {
+		peak = max(peak, math.Abs(v))
+	}
+	if factor := truePeakOversampling(sampleRate); factor > 1 {
+		for _, v := range Resample(samples, sampleRate, sampleRate*factor) {
+			peak = max(peak, math.Abs(v))
+		}
+	}
+	return peak
+}
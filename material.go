@@ -0,0 +1,149 @@
+package onset
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaterialPreset is a starting configuration of the analyzer for a type of
+// material, selected with SliceAnalyzerOptions.Preset
+type MaterialPreset struct {
+	// Name identifies the preset
+	Name string
+	// Description is a one-line description of the material the preset suits
+	Description string
+	// Method is the detection method
+	Method string
+	// Threshold is the peak picking threshold used to detect candidate onsets
+	Threshold float64
+	// MinioiMs is the minimum inter-onset interval in milliseconds
+	MinioiMs float64
+	// InputHighpassHz is the cutoff of the highpass applied before detection,
+	// 0 if disabled
+	InputHighpassHz float64
+	// RemoveDC reports whether the DC offset is removed before detection
+	RemoveDC bool
+}
+
+// materialPresets are the built-in presets, in the order listed by
+// ListPresets
+var materialPresets = []MaterialPreset{
+	{
+		Name:        "drums",
+		Description: "Drum loops and breaks: sharp, closely spaced hits including ghost notes",
+		Method:      "hfc",
+		Threshold:   0.05,
+		MinioiMs:    30,
+	},
+	{
+		Name:            "vocals",
+		Description:     "Sung or spoken vocals: soft, tonal onsets of syllables and phrases",
+		Method:          "complex",
+		Threshold:       0.2,
+		MinioiMs:        100,
+		InputHighpassHz: 80,
+	},
+	{
+		Name:            "guitar",
+		Description:     "Plucked and strummed guitar, bass and other string instruments",
+		Method:          "complex",
+		Threshold:       0.1,
+		MinioiMs:        50,
+		InputHighpassHz: 60,
+	},
+	{
+		Name:            "field-recording",
+		Description:     "Field recordings: sparse events over rumble, wind and background noise",
+		Method:          "specflux",
+		Threshold:       0.3,
+		MinioiMs:        150,
+		InputHighpassHz: 60,
+		RemoveDC:        true,
+	},
+	{
+		Name:            "full-mix",
+		Description:     "Mixed songs: onsets of every instrument over a dense spectrum",
+		Method:          "specflux",
+		Threshold:       0.1,
+		MinioiMs:        50,
+		InputHighpassHz: 30,
+	},
+}
+
+// ListPresets returns the names of the built-in material presets, such as for
+// populating a preset selector
+func ListPresets() []string {
+	names := make([]string, len(materialPresets))
+	for i, preset := range materialPresets {
+		names[i] = preset.Name
+	}
+	return names
+}
+
+// PresetInfo returns the built-in material preset with the given name,
+// ignoring case. It reports false for unknown presets.
+func PresetInfo(name string) (MaterialPreset, bool) {
+	for _, preset := range materialPresets {
+		if strings.EqualFold(preset.Name, name) {
+			return preset, true
+		}
+	}
+	return MaterialPreset{}, false
+}
+
+// Params returns the detection parameters of the preset, with the defaults of
+// its method for the others, for use with NewOnsetParams
+func (m MaterialPreset) Params() Params {
+	return methodParams(m.Method, m.Threshold, m.MinioiMs)
+}
+
+// methodParams returns the parameters of method with the given threshold and
+// minimum inter-onset interval, and the defaults of the method for the others
+func methodParams(method string, threshold, minioiMs float64) Params {
+	spec, _ := lookupMethod(method)
+	relaxTime, floor := whiteningDefaults(method)
+	return Params{
+		Version:            ParamsVersion,
+		Method:             method,
+		Threshold:          threshold,
+		Silence:            -70.0,
+		MinioiMs:           minioiMs,
+		Compression:        spec.Compression,
+		AWhitening:         spec.AWhitening,
+		WhiteningRelaxTime: relaxTime,
+		WhiteningFloor:     floor,
+	}
+}
+
+// Apply returns options with the settings of the preset: it replaces Method
+// and fills Threshold, MinioiMs, InputHighpassHz and RemoveDC where they are
+// left at 0 or false
+func (m MaterialPreset) Apply(options SliceAnalyzerOptions) SliceAnalyzerOptions {
+	options.Method = m.Method
+	if options.Threshold == 0 {
+		options.Threshold = m.Threshold
+	}
+	if options.MinioiMs == 0 {
+		options.MinioiMs = m.MinioiMs
+	}
+	if options.InputHighpassHz == 0 {
+		options.InputHighpassHz = m.InputHighpassHz
+	}
+	options.RemoveDC = options.RemoveDC || m.RemoveDC
+	return options
+}
+
+// applyPreset returns options with the settings of the material preset they
+// select, if any
+func applyPreset(options SliceAnalyzerOptions) (SliceAnalyzerOptions, error) {
+	if options.Preset == "" {
+		return options, nil
+	}
+	preset, ok := PresetInfo(options.Preset)
+	if !ok {
+		return options, fmt.Errorf("unknown preset %q: supported presets are %s", options.Preset, strings.Join(ListPresets(), ", "))
+	}
+	options = preset.Apply(options)
+	options.Preset = ""
+	return options, nil
+}
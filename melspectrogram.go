@@ -0,0 +1,79 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+)
+
+// MelOptions contains configuration options for MelSpectrogram
+type MelOptions struct {
+	// BufSize is the window of the spectra in samples. Default is 2048 if 0.
+	BufSize uint
+	// HopSize is the distance between spectra in samples. Default is 512 if
+	// 0.
+	HopSize uint
+	// Bands is the number of mel bands. Default is 128 if 0.
+	Bands uint
+	// MinHz and MaxHz bound the bands. MaxHz defaults to half the sample
+	// rate if 0.
+	MinHz float64
+	MaxHz float64
+	// Power raises the magnitudes before they are weighed: 1 for magnitudes,
+	// 2 for energies. Default is 2 if 0.
+	Power float64
+	// DB converts the band outputs to decibels, floored at -100 dB
+	DB bool
+}
+
+// MelSpectrogram returns the mel bands of every hop of samples, one row of
+// Bands values per spectrum, the input of most neural onset and beat models
+// and a readable picture of a sound. Spectra are computed by a phase vocoder
+// every HopSize samples from the first sample, the last ones zero-padded,
+// and weighed by triangles evenly spaced on the mel scale with an area of 1.
+func MelSpectrogram(samples []float64, sampleRate uint, options MelOptions) ([][]float64, error) {
+	bufSize, hopSize, bands, power := options.BufSize, options.HopSize, options.Bands, options.Power
+	if bufSize == 0 {
+		bufSize = 2048
+	}
+	if hopSize == 0 {
+		hopSize = 512
+	}
+	if bands == 0 {
+		bands = 128
+	}
+	if power == 0 {
+		power = 2
+	}
+	maxHz := options.MaxHz
+	if maxHz == 0 {
+		maxHz = float64(sampleRate) / 2
+	}
+	if sampleRate == 0 || power < 0 || maxHz > float64(sampleRate)/2 {
+		return nil, fmt.Errorf("invalid mel spectrogram options %+v at %d Hz", options, sampleRate)
+	}
+
+	fb := NewFilterbank(bands, bufSize)
+	if err := fb.SetMelCoeffs(sampleRate, options.MinHz, maxHz); err != nil {
+		return nil, err
+	}
+	fb.SetPower(power)
+
+	p := NewPvoc(bufSize, hopSize)
+	grain := NewCvec(bufSize)
+	frame := NewFvec(bufSize)
+	var spectrogram [][]float64
+	for pos := 0; pos < len(samples); pos += int(hopSize) {
+		frame.Zeros()
+		copy(frame.Data, samples[pos:min(pos+int(bufSize), len(samples))])
+		p.Do(frame, grain)
+		row := NewFvec(bands)
+		fb.Do(grain, row)
+		if options.DB {
+			for k, v := range row.Data {
+				row.Data[k] = 10 * math.Log10(max(v, 1e-10))
+			}
+		}
+		spectrogram = append(spectrogram, row.Data)
+	}
+	return spectrogram, nil
+}
@@ -0,0 +1,284 @@
+package onset
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MethodSpec describes a detection method and its tuned default parameters
+type MethodSpec struct {
+	// Name is the canonical name of the method
+	Name string
+	// Aliases are other names accepted for the method
+	Aliases []string
+	// Description is a one-line description of the method
+	Description string
+	// Threshold is the default peak picking threshold
+	Threshold float64
+	// MinioiMs is the default minimum inter-onset interval in milliseconds
+	MinioiMs float64
+	// DelayHops is the default constant delay subtracted from onset times, in
+	// hops
+	DelayHops float64
+	// Compression is the default log compression lambda, 0 if disabled
+	Compression float64
+	// AWhitening reports whether adaptive whitening is enabled by default
+	AWhitening bool
+	// WhiteningRelaxTime is the whitening relax time in seconds, 0 for the
+	// default of 250
+	WhiteningRelaxTime float64
+	// WhiteningFloor is the whitening floor, 0 for the default of 1e-4
+	WhiteningFloor float64
+	// Legacy marks methods kept for compatibility with aubio, which are not
+	// listed by ListMethods
+	Legacy bool
+
+	// Custom marks detection functions registered with RegisterSpecdesc
+	Custom bool
+
+	// descriptor is the spectral descriptor computed by the method
+	descriptor SpecdescType
+	// custom is the registration of a custom descriptor
+	custom *customSpecdesc
+}
+
+// SpecdescFunc computes a custom detection function from the spectrum of a
+// frame and stores its value in out.Data[0]. state is the per-detector value
+// created at registration, or nil.
+type SpecdescFunc func(grain *Cvec, state any, out *Fvec)
+
+// customSpecdesc is a registered custom detection function
+type customSpecdesc struct {
+	fn       SpecdescFunc
+	newState func(bufSize uint) any
+}
+
+// genericDefaults are the parameters of methods without tuned defaults, and of
+// unknown methods
+var genericDefaults = MethodSpec{Threshold: 0.3, MinioiMs: 50, DelayHops: 4.3}
+
+// registryMu guards methodRegistry against concurrent registrations
+var registryMu sync.RWMutex
+
+// methodRegistry describes the detection methods, in the order listed by
+// ListMethods. The parameters are those tuned by aubio.
+var methodRegistry = []MethodSpec{
+	{
+		Name:        "hfc",
+		Aliases:     []string{"default"},
+		Description: "High Frequency Content: energy weighted towards high frequencies, best for percussive sounds",
+		Threshold:   0.058,
+		MinioiMs:    50,
+		DelayHops:   4.3,
+		Compression: 1,
+		descriptor:  OnsetHFC,
+	},
+	{
+		Name:        "energy",
+		Description: "Local energy of the spectrum, for loud percussive onsets",
+		Threshold:   0.3,
+		MinioiMs:    50,
+		DelayHops:   4.3,
+		descriptor:  OnsetEnergy,
+	},
+	{
+		Name:        "complex",
+		Aliases:     []string{"complexdomain"},
+		Description: "Complex Domain: deviation of magnitude and phase from a prediction, for tonal and percussive onsets",
+		Threshold:   0.15,
+		MinioiMs:    50,
+		DelayHops:   4.6,
+		Compression: 1,
+		AWhitening:  true,
+		descriptor:  OnsetComplex,
+	},
+	{
+		Name:        "phase",
+		Description: "Phase deviation: instability of the phase, for soft tonal onsets",
+		Threshold:   0.3,
+		MinioiMs:    50,
+		DelayHops:   4.3,
+		descriptor:  OnsetPhase,
+	},
+	{
+		Name:        "wphase",
+		Description: "Weighted Phase Deviation: phase deviation weighted by magnitude, less sensitive to noise",
+		Threshold:   0.3,
+		MinioiMs:    50,
+		DelayHops:   4.3,
+		descriptor:  OnsetWPhase,
+	},
+	{
+		Name:        "specdiff",
+		Description: "Spectral Difference: increase of the magnitudes over the previous frame",
+		Threshold:   0.3,
+		MinioiMs:    50,
+		DelayHops:   4.3,
+		descriptor:  OnsetSpecdiff,
+	},
+	{
+		Name:        "kl",
+		Description: "Kullback-Liebler divergence of successive magnitude spectra",
+		Threshold:   0.35,
+		MinioiMs:    50,
+		DelayHops:   4.3,
+		Compression: 0.02,
+		AWhitening:  true,
+		descriptor:  OnsetKL,
+	},
+	{
+		Name:        "mkl",
+		Description: "Modified Kullback-Liebler divergence, emphasizing sudden increases of energy",
+		Threshold:   0.05,
+		MinioiMs:    50,
+		DelayHops:   4.3,
+		Compression: 0.02,
+		AWhitening:  true,
+		descriptor:  OnsetMKL,
+	},
+	{
+		Name:               "specflux",
+		Description:        "Spectral Flux: sum of the magnitude increases, a good general purpose method",
+		Threshold:          0.18,
+		MinioiMs:           50,
+		DelayHops:          4.3,
+		Compression:        10,
+		AWhitening:         true,
+		WhiteningRelaxTime: 100,
+		WhiteningFloor:     1,
+		descriptor:         OnsetSpecflux,
+	},
+	{
+		Name:        "old_default",
+		Description: "High Frequency Content with the defaults of aubio 0.3",
+		Threshold:   0.3,
+		MinioiMs:    20,
+		DelayHops:   4.3,
+		Legacy:      true,
+		descriptor:  OnsetHFC,
+	},
+}
+
+// ListMethods returns the names of the detection methods supported by NewOnset,
+// including registered custom ones, such as for populating a method selector.
+// The analyzer additionally supports the "consensus" method, which combines
+// all of them.
+func ListMethods() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var names []string
+	for _, spec := range methodRegistry {
+		if !spec.Legacy {
+			names = append(names, spec.Name)
+		}
+	}
+	return names
+}
+
+// customMethods returns the names of the registered custom detection functions
+func customMethods() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var names []string
+	for _, spec := range methodRegistry {
+		if spec.Custom {
+			names = append(names, spec.Name)
+		}
+	}
+	return names
+}
+
+// MethodInfo returns the description and default parameters of a detection
+// method by name or alias, so that front-ends can show them without
+// duplicating them. It reports false for unknown methods.
+func MethodInfo(name string) (MethodSpec, bool) {
+	spec, ok := lookupMethod(name)
+	if ok {
+		spec.Aliases = append([]string(nil), spec.Aliases...)
+	}
+	return spec, ok
+}
+
+// RegisterSpecdesc registers a custom detection function under name, so that
+// experimental novelty functions can run through the phase vocoder and peak
+// picker like the built-in methods: NewOnset, the analyzer and the CLI accept
+// the name, and the "consensus" method includes it. The function is called
+// for every frame with the spectrum after whitening and compression; state is
+// always nil, see RegisterSpecdescState. Custom methods use the generic
+// defaults (a threshold of 0.3 and no whitening or compression).
+//
+// It returns an error if the name is empty or already taken, including by
+// the spectral shape modes of NewSpecdesc.
+func RegisterSpecdesc(name string, fn SpecdescFunc) error {
+	return RegisterSpecdescState(name, nil, fn)
+}
+
+// RegisterSpecdescState registers a custom detection function like
+// RegisterSpecdesc, with newState creating the state passed to fn for every
+// detector, such as the magnitudes of the previous frame, given the FFT size
+func RegisterSpecdescState(name string, newState func(bufSize uint) any, fn SpecdescFunc) error {
+	name = strings.ToLower(name)
+	if _, shape := shapeDescriptors[name]; name == "" || name == "consensus" || shape {
+		return fmt.Errorf("invalid custom method name %q", name)
+	}
+	if fn == nil {
+		return fmt.Errorf("custom method %q has no detection function", name)
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := lookupMethodLocked(name); ok {
+		return fmt.Errorf("onset method %q is already registered", name)
+	}
+	spec := genericDefaults
+	spec.Name = name
+	spec.Description = "Custom detection function"
+	spec.Custom = true
+	spec.descriptor = OnsetCustom
+	spec.custom = &customSpecdesc{fn: fn, newState: newState}
+	methodRegistry = append(methodRegistry, spec)
+	return nil
+}
+
+// unregisterSpecdesc removes a custom detection function
+func unregisterSpecdesc(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for i, spec := range methodRegistry {
+		if spec.Custom && spec.Name == name {
+			methodRegistry = append(methodRegistry[:i:i], methodRegistry[i+1:]...)
+			return
+		}
+	}
+}
+
+// lookupMethod returns the registry entry of a method name or alias, ignoring
+// case
+func lookupMethod(name string) (MethodSpec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return lookupMethodLocked(name)
+}
+
+// lookupMethodLocked implements lookupMethod; registryMu must be held
+func lookupMethodLocked(name string) (MethodSpec, bool) {
+	name = strings.ToLower(name)
+	for _, spec := range methodRegistry {
+		if spec.Name == name {
+			return spec, true
+		}
+		for _, alias := range spec.Aliases {
+			if alias == name {
+				return spec, true
+			}
+		}
+	}
+	return MethodSpec{}, false
+}
+
+// isMethod reports whether name is a detection method or an alias of one
+func isMethod(name string) bool {
+	_, ok := lookupMethod(name)
+	return ok
+}
@@ -0,0 +1,106 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+)
+
+// mfccFloor bounds the filter outputs before their logarithm
+const mfccFloor = 1e-10
+
+// Mfcc computes the mel-frequency cepstral coefficients of spectra, the
+// compact description of timbre used to classify sounds such as drum hits:
+// the log10 of the outputs of a mel filterbank, decorrelated by an
+// orthonormal DCT-II, as in aubio
+type Mfcc struct {
+	BufSize    uint
+	NFilters   uint
+	NCoefs     uint
+	Samplerate uint
+	// Scale multiplies the log filter outputs before the DCT
+	Scale float64
+
+	fb *Filterbank
+	// dct holds the NCoefs rows of the DCT-II matrix
+	dct   [][]float64
+	bands *Fvec
+}
+
+// NewMfcc creates an MFCC extractor of nCoefs coefficients for spectra of
+// bufSize samples, using Slaney's filterbank when nFilters is 40 and a mel
+// filterbank up to half the sample rate otherwise
+func NewMfcc(bufSize, nFilters, nCoefs, samplerate uint) (*Mfcc, error) {
+	if bufSize == 0 || samplerate == 0 || nFilters == 0 || nCoefs == 0 || nCoefs > nFilters {
+		return nil, fmt.Errorf("invalid MFCC parameters: buffer %d, %d filters, %d coefficients at %d Hz", bufSize, nFilters, nCoefs, samplerate)
+	}
+	m := &Mfcc{
+		BufSize:    bufSize,
+		NFilters:   nFilters,
+		NCoefs:     nCoefs,
+		Samplerate: samplerate,
+		Scale:      1,
+		fb:         NewFilterbank(nFilters, bufSize),
+		bands:      NewFvec(nFilters),
+	}
+	var err error
+	if nFilters == slaneyLinearFilters+slaneyLogFilters {
+		err = m.fb.SetMelCoeffsSlaney(samplerate)
+	} else {
+		err = m.fb.SetMelCoeffs(samplerate, 0, float64(samplerate)/2)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.dct = make([][]float64, nCoefs)
+	for k := range m.dct {
+		scale := math.Sqrt(2 / float64(nFilters))
+		if k == 0 {
+			scale = math.Sqrt(1 / float64(nFilters))
+		}
+		m.dct[k] = make([]float64, nFilters)
+		for n := range m.dct[k] {
+			m.dct[k][n] = scale * math.Cos(math.Pi*float64(k)*(float64(n)+0.5)/float64(nFilters))
+		}
+	}
+	return m, nil
+}
+
+// Do writes the coefficients of the spectrum to the first NCoefs values of
+// out. The first coefficient follows the overall level, the next ones the
+// shape of the spectral envelope.
+func (m *Mfcc) Do(in *Cvec, out *Fvec) {
+	m.fb.Do(in, m.bands)
+	for i, v := range m.bands.Data {
+		m.bands.Data[i] = m.Scale * math.Log10(max(v, mfccFloor))
+	}
+	for k, row := range m.dct[:min(len(m.dct), len(out.Data))] {
+		sum := 0.0
+		for n, c := range row {
+			sum += c * m.bands.Data[n]
+		}
+		out.Data[k] = sum
+	}
+}
+
+// SetPower sets the power the magnitudes are raised to before the
+// filterbank, 1 by default
+func (m *Mfcc) SetPower(power float64) {
+	m.fb.SetPower(power)
+}
+
+// SetScale sets the factor of the log filter outputs, 1 by default
+func (m *Mfcc) SetScale(scale float64) {
+	m.Scale = scale
+}
+
+// SetMelCoeffs replaces the filterbank with triangles evenly spaced on the
+// mel scale from minHz to maxHz
+func (m *Mfcc) SetMelCoeffs(minHz, maxHz float64) error {
+	return m.fb.SetMelCoeffs(m.Samplerate, minHz, maxHz)
+}
+
+// Filterbank returns the mel filterbank of the extractor
+func (m *Mfcc) Filterbank() *Filterbank {
+	return m.fb
+}
@@ -1,10 +1,30 @@
 package onset
 
 import (
+	"fmt"
+	"math"
+	"sort"
 	"strings"
 )
 
-// Onset represents an onset detection object
+// OnsetEvent is an onset detected by an Onset
+type OnsetEvent struct {
+	// Sample is the onset position in samples since the start of the stream
+	Sample uint64
+	// TimeSec is the onset time in seconds since the start of the stream
+	TimeSec float64
+	// Strength is the value of the detection function at the onset
+	Strength float64
+	// Thresholded is the value of the detection function minus the adaptive
+	// peak picking threshold
+	Thresholded float64
+	// Method is the detection method that fired
+	Method string
+}
+
+// Onset represents an onset detection object. It is not safe for concurrent
+// use; wrap it in a SafeOnset to change parameters while another goroutine
+// calls Do.
 type Onset struct {
 	Pv                *Pvoc
 	Od                *Specdesc
@@ -12,19 +32,117 @@ type Onset struct {
 	Fftgrain          *Cvec
 	Desc              *Fvec
 	Silence           float64
-	Minioi            uint
-	Delay             uint
+	Minioi            uint64
+	Delay             uint64
 	Samplerate        uint
 	HopSize           uint
-	TotalFrames       uint
-	LastOnset         uint
+	TotalFrames       uint64
+	LastOnset         uint64
 	ApplyCompression  bool
 	LambdaCompression float64
 	ApplyAWhitening   bool
 	SpectralWhitening *SpectralWhitening
+
+	// method is the detection method the onset was created with
+	method string
+	// listeners are the subscribed onset callbacks by subscription id
+	listeners map[int]func(OnsetEvent)
+	// nextListener is the id of the next subscription
+	nextListener int
+	// pre is the preprocessor applied to the input before detection
+	pre Processor
+	// highpass is the input highpass filter, nil if disabled
+	highpass *Filter
+	// highpassHz is the cutoff frequency of highpass
+	highpassHz float64
+	// preBuf holds the preprocessed input, leaving the caller's buffer intact
+	preBuf *Fvec
+	// eventOut is the onset output of DoEvent
+	eventOut *Fvec
+	// picker replaces Pp as the peak picker if set
+	picker PeakPickerInterface
+	// pickerShift is the shift of Delay applied for the lookahead of picker,
+	// undone when the picker is replaced
+	pickerShift int64
+	// settle is the number of frames in which onsets are ignored while the
+	// detection function adapts to a change of compression or whitening
+	settle uint
+	// minFlatness and maxCrest gate the onsets of tonal frames, 0 if
+	// disabled
+	minFlatness float64
+	maxCrest    float64
+	// flatness and crest measure the spectrum of the current frame before
+	// whitening and compression, when a tonality gate is enabled
+	flatness float64
+	crest    float64
+	// recent holds the detection function and tonality of the last frames,
+	// oldest first, back to the frame of the peaks the peak picker reports
+	// now
+	recent []peakFrame
+	// strength is the detection function at the peak of the last onset
+	strength float64
+}
+
+// peakFrame is the detection function of a frame and the tonality of its
+// spectrum, kept until the peak picker can report a peak in the frame
+type peakFrame struct {
+	desc     float64
+	flatness float64
+	crest    float64
+}
+
+// Params holds the detection parameters that can be changed between Do calls,
+// and the method they were tuned for, so that tuned configurations can be
+// saved as presets with SaveParams and shared with LoadParams
+type Params struct {
+	// Version is the version of the preset format, ParamsVersion for the
+	// parameters returned by Onset.Params
+	Version int `json:"version"`
+	// Method is the detection method. ApplyParams does not change the method
+	// of a detector; see NewOnsetParams.
+	Method string `json:"method"`
+	// Threshold is the peak picking threshold
+	Threshold float64 `json:"threshold"`
+	// Silence is the silence threshold in dB. ApplyParams uses the default of
+	// -70 dB if 0, since a threshold of 0 dB silences any audio.
+	Silence float64 `json:"silence"`
+	// MinioiMs is the minimum inter-onset interval in milliseconds
+	MinioiMs float64 `json:"minioi_ms"`
+	// Compression is the log compression lambda, 0 to disable compression
+	Compression float64 `json:"compression"`
+	// AWhitening enables adaptive spectral whitening
+	AWhitening bool `json:"awhitening"`
+	// WhiteningRelaxTime is the whitening relax time in seconds, 0 for the
+	// default of the method
+	WhiteningRelaxTime float64 `json:"whitening_relax_time,omitempty"`
+	// WhiteningFloor is the whitening floor, 0 for the default of the method
+	WhiteningFloor float64 `json:"whitening_floor,omitempty"`
+}
+
+// NewOnsetErr creates a new onset detection object like NewOnset, but returns
+// an error for an unknown method or invalid sizes instead of falling back to
+// the "hfc" method
+func NewOnsetErr(onsetMode string, bufSize, hopSize, samplerate uint) (*Onset, error) {
+	if !isMethod(onsetMode) {
+		return nil, fmt.Errorf("unknown onset method %q (supported: %s)", onsetMode, strings.Join(ListMethods(), ", "))
+	}
+	if hopSize < 1 {
+		return nil, fmt.Errorf("invalid hop size: %d", hopSize)
+	}
+	if bufSize < 2 {
+		return nil, fmt.Errorf("invalid buffer size: %d", bufSize)
+	}
+	if bufSize < hopSize {
+		return nil, fmt.Errorf("hop size %d is larger than buffer size %d", hopSize, bufSize)
+	}
+	if samplerate < 1 {
+		return nil, fmt.Errorf("invalid sample rate: %d", samplerate)
+	}
+	return NewOnset(onsetMode, bufSize, hopSize, samplerate), nil
 }
 
-// NewOnset creates a new onset detection object
+// NewOnset creates a new onset detection object. Unknown methods fall back to
+// "hfc"; use NewOnsetErr to validate the method and sizes.
 func NewOnset(onsetMode string, bufSize, hopSize, samplerate uint) *Onset {
 	o := &Onset{
 		Samplerate:        samplerate,
@@ -35,7 +153,9 @@ func NewOnset(onsetMode string, bufSize, hopSize, samplerate uint) *Onset {
 		Fftgrain:          NewCvec(bufSize),
 		Desc:              NewFvec(1),
 		SpectralWhitening: NewSpectralWhitening(bufSize, hopSize, samplerate),
+		method:            onsetMode,
 	}
+	o.Pv.magnitudeOnly = !o.Od.usesPhase()
 
 	o.SetDefaultParameters(onsetMode)
 	o.Reset()
@@ -45,11 +165,37 @@ func NewOnset(onsetMode string, bufSize, hopSize, samplerate uint) *Onset {
 
 // Do processes input and detects onsets
 func (o *Onset) Do(input *Fvec, onset *Fvec) {
-	isonset := 0.0
+	input = o.analyze(input)
+	o.pick(input, onset)
+}
+
+// analyze computes the detection function of input into o.Desc and returns
+// the input after preprocessing
+func (o *Onset) analyze(input *Fvec) *Fvec {
+	// Preprocess a copy of the input
+	if o.preprocesses() {
+		if o.preBuf == nil || o.preBuf.Length != input.Length {
+			o.preBuf = NewFvec(input.Length)
+		}
+		o.preBuf.Copy(input)
+		if o.highpass != nil {
+			o.highpass.Do(o.preBuf)
+		}
+		if o.pre != nil {
+			o.pre.Process(o.preBuf)
+		}
+		input = o.preBuf
+	}
 
 	// Phase vocoder
 	o.Pv.Do(input, o.Fftgrain)
 
+	// Measure the tonality of the spectrum before it is reshaped
+	if o.tonalityGated() {
+		norm := o.Fftgrain.Norm[:o.Fftgrain.Length]
+		o.flatness, o.crest = spectralFlatness(norm), spectralCrest(norm)
+	}
+
 	// Apply adaptive whitening if enabled
 	if o.ApplyAWhitening {
 		o.SpectralWhitening.Do(o.Fftgrain)
@@ -63,17 +209,44 @@ func (o *Onset) Do(input *Fvec, onset *Fvec) {
 	// Compute spectral descriptor
 	o.Od.Do(o.Fftgrain, o.Desc)
 
-	// Peak picking
-	o.Pp.Do(o.Desc, onset)
-	isonset = onset.Data[0]
+	return input
+}
+
+// follow runs peak picking and the onset logic on the detection function of
+// leader, which has just processed input, so that several thresholds can share
+// one analysis
+func (o *Onset) follow(leader *Onset, input *Fvec, onset *Fvec) {
+	if leader.preprocesses() {
+		input = leader.preBuf
+	}
+	o.Desc.Data[0] = leader.Desc.Data[0]
+	o.flatness, o.crest = leader.flatness, leader.crest
+	o.pick(input, onset)
+}
 
-	if isonset > 0 {
+// pick runs peak picking on o.Desc and the onset logic, with input the
+// preprocessed frame used for silence detection
+func (o *Onset) pick(input *Fvec, onset *Fvec) {
+	o.remember()
+
+	// Peak picking
+	o.peakPicker().Do(o.Desc, onset)
+	isonset := onset.Data[0]
+
+	if o.settle > 0 {
+		// The detection function is adapting to new parameters
+		o.settle--
+		isonset = 0
+	} else if isonset > 0 {
 		if SilenceDetection(input, o.Silence) {
 			// Silent onset, not marking
 			isonset = 0
+		} else if o.tonal() {
+			// Onset in a sustained tone, not marking
+			isonset = 0
 		} else {
 			// We have an onset
-			newOnset := o.TotalFrames + uint(Round(isonset*float64(o.HopSize)))
+			newOnset := o.TotalFrames + uint64(Round(isonset*float64(o.HopSize)))
 
 			// Check if last onset time was more than minioi ago
 			if o.LastOnset+o.Minioi < newOnset {
@@ -81,7 +254,8 @@ func (o *Onset) Do(input *Fvec, onset *Fvec) {
 				if o.LastOnset > 0 && o.Delay > newOnset {
 					isonset = 0
 				} else {
-					o.LastOnset = Max(o.Delay, newOnset)
+					o.LastOnset = max(o.Delay, newOnset)
+					o.strength = o.recent[0].desc
 				}
 			} else {
 				// Doubled onset, not marking
@@ -97,17 +271,225 @@ func (o *Onset) Do(input *Fvec, onset *Fvec) {
 				if o.TotalFrames == 0 || o.LastOnset+o.Minioi < newOnset {
 					isonset = float64(o.Delay) / float64(o.HopSize)
 					o.LastOnset = o.TotalFrames + o.Delay
+					o.strength = o.Desc.Data[0]
 				}
 			}
 		}
 	}
 
 	onset.Data[0] = isonset
-	o.TotalFrames += o.HopSize
+	o.TotalFrames += uint64(o.HopSize)
+
+	if isonset > 0 && len(o.listeners) > 0 {
+		o.notify()
+	}
+}
+
+// remember appends the detection function and tonality of the current frame
+// to recent
+func (o *Onset) remember() {
+	n := int(o.peakLag()) + 1
+	if len(o.recent) != n {
+		o.recent = make([]peakFrame, n)
+	}
+	copy(o.recent, o.recent[1:])
+	o.recent[n-1] = peakFrame{desc: o.Desc.Data[0], flatness: o.flatness, crest: o.crest}
+}
+
+// peakLag returns the number of frames by which the peak picker reports the
+// peaks of the detection function, 0 for a custom picker without a
+// Lookahead method
+func (o *Onset) peakLag() uint {
+	if p, ok := o.peakPicker().(interface{ Lookahead() uint }); ok {
+		return p.Lookahead()
+	}
+	return 0
+}
+
+// OnOnset subscribes fn to the onsets detected by Do, so that several
+// listeners can react to detections without polling after every Do call.
+// Listeners are called synchronously from Do, in the order they subscribed.
+// It returns a function that cancels the subscription.
+func (o *Onset) OnOnset(fn func(ev OnsetEvent)) (cancel func()) {
+	if o.listeners == nil {
+		o.listeners = make(map[int]func(OnsetEvent))
+	}
+	id := o.nextListener
+	o.nextListener++
+	o.listeners[id] = fn
+
+	return func() {
+		delete(o.listeners, id)
+	}
+}
+
+// DoEvent processes input like Do and returns the detected onset, if any.
+// It is an alternative to checking Do's output for a nonzero value.
+func (o *Onset) DoEvent(input *Fvec) (*OnsetEvent, bool) {
+	if o.eventOut == nil {
+		o.eventOut = NewFvec(1)
+	}
+	o.Do(input, o.eventOut)
+	if o.eventOut.Data[0] == 0 {
+		return nil, false
+	}
+	ev := o.lastEvent()
+	return &ev, true
+}
+
+// lastEvent returns the event of the last detected onset
+func (o *Onset) lastEvent() OnsetEvent {
+	return OnsetEvent{
+		Sample:      o.GetLast(),
+		TimeSec:     o.GetLastS(),
+		Strength:    o.GetOnsetDescriptor(),
+		Thresholded: o.GetThresholdedDescriptor(),
+		Method:      o.method,
+	}
+}
+
+// notify calls the listeners with the last detected onset
+func (o *Onset) notify() {
+	ev := o.lastEvent()
+	ids := make([]int, 0, len(o.listeners))
+	for id := range o.listeners {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if fn, ok := o.listeners[id]; ok {
+			fn(ev)
+		}
+	}
+}
+
+// SetPreprocessor sets a processor, such as a Pipeline of filters, applied to
+// every input frame before detection, including silence detection. Do
+// processes a copy, so the caller's buffer is left unchanged. A nil processor
+// disables preprocessing.
+func (o *Onset) SetPreprocessor(p Processor) {
+	o.pre = p
+}
+
+// GetPreprocessor returns the processor applied to the input, or nil
+func (o *Onset) GetPreprocessor() Processor {
+	return o.pre
+}
+
+// SetFFTSize zero-pads the analysis frames to size samples before the FFT,
+// which refines the frequency resolution of the detection functions without
+// increasing the window size or the latency. A size up to the window size
+// disables padding. It resets the detector and should be called before
+// processing.
+func (o *Onset) SetFFTSize(size uint) {
+	size = max(size, o.Pv.WinSize)
+	if size == o.Pv.FftSize {
+		return
+	}
+	transform := o.Pv.GetFFT()
+	o.Pv = NewPvocPadded(o.Pv.WinSize, o.HopSize, size)
+	o.Pv.SetFFT(transform)
+	o.Fftgrain = NewCvec(size)
+
+	threshold := o.Od.Threshold
+	o.Od = NewSpecdesc(o.method, size)
+	o.Od.Threshold = threshold
+	o.Pv.magnitudeOnly = !o.Od.usesPhase()
+
+	whitening := NewSpectralWhitening(size, o.HopSize, o.Samplerate)
+	whitening.SetRelaxTime(o.SpectralWhitening.GetRelaxTime())
+	whitening.SetFloor(o.SpectralWhitening.GetFloor())
+	o.SpectralWhitening = whitening
+
+	o.Reset()
+}
+
+// SetFFT replaces the FFT implementation of the phase vocoder, such as with
+// FFTW bindings. A nil FFT restores the built-in one.
+func (o *Onset) SetFFT(f FFT) {
+	o.Pv.SetFFT(f)
+}
+
+// GetFFTSize returns the FFT size in samples
+func (o *Onset) GetFFTSize() uint {
+	return o.Pv.FftSize
+}
+
+// preprocesses reports whether the input is filtered or preprocessed before
+// detection
+func (o *Onset) preprocesses() bool {
+	return o.pre != nil || o.highpass != nil
+}
+
+// SetInputHighpassHz filters the input with a second-order Butterworth
+// highpass at hz before the preprocessor and the phase vocoder, so that rumble
+// and handling noise do not dominate the "energy" and "hfc" detection
+// functions on field recordings. 0 disables the filter. Cutoffs that are not
+// below half the sample rate are ignored, leaving the filter unchanged.
+func (o *Onset) SetInputHighpassHz(hz float64) {
+	if hz <= 0 {
+		o.highpassHz, o.highpass = 0, nil
+		return
+	}
+	highpass, err := NewHighpassBiquad(hz, 1/math.Sqrt2, o.Samplerate)
+	if err != nil {
+		return
+	}
+	o.highpassHz, o.highpass = hz, highpass
+}
+
+// GetInputHighpassHz returns the cutoff frequency of the input highpass filter,
+// 0 if disabled
+func (o *Onset) GetInputHighpassHz() float64 {
+	return o.highpassHz
+}
+
+// SetFlatnessGate suppresses the onsets whose peak frame has a spectral
+// flatness, measured before whitening and compression, below minFlatness,
+// so that vibrato and beating in sustained tones do not fire onsets, while
+// the noisy attack of a pitched note still does. The flatness is near 0 for
+// pure tones and about 0.56 for white noise; 0.01 to 0.05 gates most tonal
+// frames. 0 disables the gate.
+func (o *Onset) SetFlatnessGate(minFlatness float64) {
+	o.minFlatness = max(minFlatness, 0)
+}
+
+// GetFlatnessGate returns the minimum spectral flatness of onset frames, 0
+// if the gate is disabled
+func (o *Onset) GetFlatnessGate() float64 {
+	return o.minFlatness
+}
+
+// SetCrestGate suppresses the onsets whose peak frame has a spectral crest
+// factor, the largest magnitude divided by their mean, above maxCrest, like
+// SetFlatnessGate. The crest factor is about 3 for white noise and in
+// the tens for pure tones in 512-sample frames; 10 to 20 gates most tonal
+// frames. 0 disables the gate.
+func (o *Onset) SetCrestGate(maxCrest float64) {
+	o.maxCrest = max(maxCrest, 0)
+}
+
+// GetCrestGate returns the maximum spectral crest factor of onset frames, 0
+// if the gate is disabled
+func (o *Onset) GetCrestGate() float64 {
+	return o.maxCrest
+}
+
+// tonalityGated reports whether a tonality gate is enabled
+func (o *Onset) tonalityGated() bool {
+	return o.minFlatness > 0 || o.maxCrest > 0
+}
+
+// tonal reports whether the tonality gates suppress the onset reported in
+// the current frame, judging the frame of its peak rather than the decay
+// that follows it
+func (o *Onset) tonal() bool {
+	peak := o.recent[0]
+	return (o.minFlatness > 0 && peak.flatness < o.minFlatness) || (o.maxCrest > 0 && peak.crest > o.maxCrest)
 }
 
 // GetLast returns the time of the latest onset detected, in samples
-func (o *Onset) GetLast() uint {
+func (o *Onset) GetLast() uint64 {
 	if o.Delay > o.LastOnset {
 		return 0
 	}
@@ -124,9 +506,17 @@ func (o *Onset) GetLastMs() float64 {
 	return o.GetLastS() * 1000.0
 }
 
-// SetAWhitening enables or disables adaptive whitening
+// SetAWhitening enables or disables adaptive whitening. Enabling it restarts
+// the adaptation of the whitening.
 func (o *Onset) SetAWhitening(enable bool) {
+	if enable == o.ApplyAWhitening {
+		return
+	}
+	if enable {
+		o.SpectralWhitening.Reset()
+	}
 	o.ApplyAWhitening = enable
+	o.startSettling()
 }
 
 // GetAWhitening returns whether adaptive whitening is enabled
@@ -139,8 +529,63 @@ func (o *Onset) SetCompression(lambda float64) {
 	if lambda < 0 {
 		return
 	}
+	changed := lambda != o.GetCompression()
 	o.LambdaCompression = lambda
 	o.ApplyCompression = lambda > 0
+	if changed {
+		o.startSettling()
+	}
+}
+
+// startSettling ignores onsets for the frames it takes the detection function
+// and the peak picker history to adapt to a change of scale, which would
+// otherwise be reported as an onset. It has no effect before the first frame.
+func (o *Onset) startSettling() {
+	if o.TotalFrames > 0 {
+		o.settle = o.Pp.WinPost + o.Pp.WinPre + 2
+	}
+}
+
+// Params returns the current detection parameters
+func (o *Onset) Params() Params {
+	return Params{
+		Version:            ParamsVersion,
+		Method:             o.method,
+		Threshold:          o.GetThreshold(),
+		Silence:            o.GetSilence(),
+		MinioiMs:           o.GetMinioiMs(),
+		Compression:        o.GetCompression(),
+		AWhitening:         o.GetAWhitening(),
+		WhiteningRelaxTime: o.SpectralWhitening.GetRelaxTime(),
+		WhiteningFloor:     o.SpectralWhitening.GetFloor(),
+	}
+}
+
+// ApplyParams sets all detection parameters at once, such as from a UI while
+// streaming. Parameters can be changed between any two Do calls: threshold,
+// silence and minimum inter-onset interval apply from the next frame, while a
+// change of compression or whitening ignores onsets for a few frames (the
+// peak picker window) until the detection function has adapted. Use it through
+// SafeOnset when another goroutine calls Do. Version and Method are ignored.
+func (o *Onset) ApplyParams(p Params) {
+	o.SetThreshold(p.Threshold)
+	silence := p.Silence
+	if silence == 0 {
+		silence = -70.0
+	}
+	o.SetSilence(silence)
+	o.SetMinioiMs(p.MinioiMs)
+	o.SetCompression(p.Compression)
+	o.SetAWhitening(p.AWhitening)
+	relaxTime, floor := whiteningDefaults(o.method)
+	if p.WhiteningRelaxTime > 0 {
+		relaxTime = p.WhiteningRelaxTime
+	}
+	if p.WhiteningFloor > 0 {
+		floor = p.WhiteningFloor
+	}
+	o.SpectralWhitening.SetRelaxTime(relaxTime)
+	o.SpectralWhitening.SetFloor(floor)
 }
 
 // GetCompression returns the compression lambda value
@@ -161,29 +606,93 @@ func (o *Onset) GetSilence() float64 {
 	return o.Silence
 }
 
-// SetThreshold sets the peak picking threshold
+// SetDescriptorThreshold sets the magnitude below which spectral bins are
+// ignored by the "phase", "wphase" and "specdiff" detection functions, so that
+// noise in quiet bins does not mask onsets. The default is 0.1; other methods
+// are not affected.
+func (o *Onset) SetDescriptorThreshold(threshold float64) {
+	o.Od.Threshold = threshold
+}
+
+// GetDescriptorThreshold returns the magnitude threshold of the spectral bins
+func (o *Onset) GetDescriptorThreshold() float64 {
+	return o.Od.Threshold
+}
+
+// SetPeakPicker replaces the built-in peak picker Pp, such as with a fixed
+// threshold or a learned picker. A nil picker restores the built-in one. The
+// delay adjustment of SetLibrosaPeakPicking is undone.
+func (o *Onset) SetPeakPicker(p PeakPickerInterface) {
+	if o.pickerShift != 0 {
+		o.SetDelay(uint64(max(int64(o.Delay)-o.pickerShift, 0)))
+		o.pickerShift = 0
+	}
+	o.picker = p
+}
+
+// GetPeakPicker returns the peak picker in use
+func (o *Onset) GetPeakPicker() PeakPickerInterface {
+	return o.peakPicker()
+}
+
+// peakPicker returns the peak picker in use
+func (o *Onset) peakPicker() PeakPickerInterface {
+	if o.picker != nil {
+		return o.picker
+	}
+	return o.Pp
+}
+
+// SetPeakWindow sets the number of future (winPre) and past (winPost) frames
+// of the detection function used by the built-in peak picker, and shifts the
+// delay so that onset times stay in place: each frame of lookahead delays
+// detections by one hop. The default window of 1 and 5 frames trades about
+// three hops of latency for stability; a smaller winPre suits live use.
+func (o *Onset) SetPeakWindow(winPre, winPost uint) {
+	delay := int64(o.Delay) + (int64(winPre)-int64(o.Pp.WinPre))*int64(o.HopSize)
+	o.SetDelay(uint64(max(delay, 0)))
+	o.Pp.SetWinPre(winPre)
+	o.Pp.SetWinPost(winPost)
+}
+
+// SetAdaptiveThreshold makes onsets also exceed the given percentile (0 to
+// 100) of the detection function over the last seconds, as described for
+// PeakPicker.SetAdaptiveThreshold. A window of 0 disables it.
+func (o *Onset) SetAdaptiveThreshold(seconds, percentile float64) {
+	frames := uint(Round(seconds * float64(o.Samplerate) / float64(o.HopSize)))
+	o.Pp.SetAdaptiveThreshold(frames, percentile)
+}
+
+// SetThreshold sets the peak picking threshold. It is passed on to a custom
+// peak picker that has a SetThreshold method.
 func (o *Onset) SetThreshold(threshold float64) {
 	o.Pp.SetThreshold(threshold)
+	if p, ok := o.picker.(interface{ SetThreshold(float64) }); ok {
+		p.SetThreshold(threshold)
+	}
 }
 
 // GetThreshold returns the peak picking threshold
 func (o *Onset) GetThreshold() float64 {
+	if p, ok := o.picker.(interface{ GetThreshold() float64 }); ok {
+		return p.GetThreshold()
+	}
 	return o.Pp.GetThreshold()
 }
 
 // SetMinioi sets the minimum inter-onset interval in samples
-func (o *Onset) SetMinioi(minioi uint) {
+func (o *Onset) SetMinioi(minioi uint64) {
 	o.Minioi = minioi
 }
 
 // GetMinioi returns the minimum inter-onset interval in samples
-func (o *Onset) GetMinioi() uint {
+func (o *Onset) GetMinioi() uint64 {
 	return o.Minioi
 }
 
 // SetMinioiS sets the minimum inter-onset interval in seconds
 func (o *Onset) SetMinioiS(minioi float64) {
-	o.SetMinioi(uint(Round(minioi * float64(o.Samplerate))))
+	o.SetMinioi(uint64(Round(minioi * float64(o.Samplerate))))
 }
 
 // GetMinioiS returns the minimum inter-onset interval in seconds
@@ -202,18 +711,18 @@ func (o *Onset) GetMinioiMs() float64 {
 }
 
 // SetDelay sets the constant delay in samples
-func (o *Onset) SetDelay(delay uint) {
+func (o *Onset) SetDelay(delay uint64) {
 	o.Delay = delay
 }
 
 // GetDelay returns the constant delay in samples
-func (o *Onset) GetDelay() uint {
+func (o *Onset) GetDelay() uint64 {
 	return o.Delay
 }
 
 // SetDelayS sets the constant delay in seconds
 func (o *Onset) SetDelayS(delay float64) {
-	o.SetDelay(uint(delay * float64(o.Samplerate)))
+	o.SetDelay(uint64(delay * float64(o.Samplerate)))
 }
 
 // GetDelayS returns the constant delay in seconds
@@ -236,65 +745,115 @@ func (o *Onset) GetDescriptor() float64 {
 	return o.Desc.Data[0]
 }
 
-// GetThresholdedDescriptor returns the thresholded value of the onset detection function
+// GetOnsetDescriptor returns the value of the onset detection function at
+// the peak of the last detected onset, the strength of the onset. The peak
+// picker reports a peak a few frames after it, by which time GetDescriptor
+// follows the decay of the sound.
+func (o *Onset) GetOnsetDescriptor() float64 {
+	return o.strength
+}
+
+// GetThresholdedDescriptor returns the thresholded value of the onset detection
+// function, or 0 for a custom peak picker without a GetThresholdedInput method
 func (o *Onset) GetThresholdedDescriptor() float64 {
-	thresholded := o.Pp.GetThresholdedInput()
+	p, ok := o.peakPicker().(interface{ GetThresholdedInput() *Fvec })
+	if !ok {
+		return 0
+	}
+	thresholded := p.GetThresholdedInput()
 	return thresholded.Data[0]
 }
 
-// Reset resets the onset detection state
+// Reset clears the whole detection state, from the counters to the history of
+// the phase vocoder, the spectral descriptor, the whitening, the peak picker
+// and the input filters, so that the detector can be reused for another file
+// as if it were new. The parameters are kept.
 func (o *Onset) Reset() {
 	o.LastOnset = 0
 	o.TotalFrames = 0
+	o.settle = 0
+	o.flatness, o.crest = 0, 0
+	clear(o.recent)
+	o.strength = 0
+	o.Pv.Reset()
+	o.Fftgrain.Zeros()
+	o.Od.Reset()
+	o.Desc.Zeros()
+	o.SpectralWhitening.Reset()
+	o.Pp.Reset()
+	o.peakPicker().Reset()
+	if o.highpass != nil {
+		o.highpass.Reset()
+	}
+	if r, ok := o.pre.(interface{ Reset() }); ok {
+		r.Reset()
+	}
+}
+
+// Clone returns an independent detector with the parameters of o and a fresh
+// state, so that a pool of workers can be created from one tuned template.
+// The input filters, the preprocessor, the peak picker and the FFT are cloned
+// when they are built-in or have a Clone method returning their interface
+// type, such as Clone() Processor; other custom ones are shared and must then
+// be stateless or safe for concurrent use. OnOnset subscriptions are not
+// copied.
+func (o *Onset) Clone() *Onset {
+	c := *o
+	c.Pv = o.Pv.Clone()
+	c.Od = NewSpecdesc(o.method, o.Pv.FftSize)
+	c.Od.Threshold = o.Od.Threshold
+	c.Pp = o.Pp.Clone()
+	c.Fftgrain = NewCvec(o.Pv.FftSize)
+	c.Desc = NewFvec(1)
+	c.SpectralWhitening = NewSpectralWhitening(o.Pv.FftSize, o.HopSize, o.Samplerate)
+	c.SpectralWhitening.SetRelaxTime(o.SpectralWhitening.GetRelaxTime())
+	c.SpectralWhitening.SetFloor(o.SpectralWhitening.GetFloor())
+
+	c.listeners, c.nextListener = nil, 0
+	if o.pre != nil {
+		c.pre = cloneProcessor(o.pre)
+	}
+	if o.highpass != nil {
+		c.highpass = o.highpass.Clone()
+	}
+	c.preBuf, c.eventOut, c.recent = nil, nil, nil
+	if o.picker != nil {
+		c.picker = clonePeakPicker(o.picker)
+	}
+
+	c.Reset()
+	return &c
 }
 
-// SetDefaultParameters sets default parameters based on onset mode
+// SetDefaultParameters sets the tuned default parameters of a detection
+// method, as described by MethodInfo. Unknown methods get generic defaults.
 func (o *Onset) SetDefaultParameters(onsetMode string) {
-	// Set some default parameters
-	o.SetThreshold(0.3)
-	o.SetDelay(uint(4.3 * float64(o.HopSize)))
-	o.SetMinioiMs(50.0)
+	spec, ok := lookupMethod(onsetMode)
+	if !ok {
+		spec = genericDefaults
+	}
+
+	o.SetThreshold(spec.Threshold)
+	o.SetDelay(uint64(spec.DelayHops * float64(o.HopSize)))
+	o.SetMinioiMs(spec.MinioiMs)
 	o.SetSilence(-70.0)
-	o.SetAWhitening(false)
-	o.SetCompression(0.0)
-
-	// Method specific optimizations
-	mode := strings.ToLower(onsetMode)
-	switch mode {
-	case "energy":
-		// Use defaults
-	case "hfc", "default":
-		o.SetThreshold(0.058)
-		o.SetCompression(1.0)
-	case "complexdomain", "complex":
-		o.SetDelay(uint(4.6 * float64(o.HopSize)))
-		o.SetThreshold(0.15)
-		o.SetAWhitening(true)
-		o.SetCompression(1.0)
-	case "phase":
-		o.SetAWhitening(false)
-		o.SetCompression(0.0)
-	case "wphase":
-		// Use defaults
-	case "mkl":
-		o.SetThreshold(0.05)
-		o.SetAWhitening(true)
-		o.SetCompression(0.02)
-	case "kl":
-		o.SetThreshold(0.35)
-		o.SetAWhitening(true)
-		o.SetCompression(0.02)
-	case "specflux":
-		o.SetThreshold(0.18)
-		o.SetAWhitening(true)
-		o.SpectralWhitening.SetRelaxTime(100)
-		o.SpectralWhitening.SetFloor(1.0)
-		o.SetCompression(10.0)
-	case "specdiff":
-		// Use defaults
-	case "old_default":
-		o.SetThreshold(0.3)
-		o.SetMinioiMs(20.0)
-		o.SetCompression(0.0)
+	o.SetAWhitening(spec.AWhitening)
+	relaxTime, floor := whiteningDefaults(onsetMode)
+	o.SpectralWhitening.SetRelaxTime(relaxTime)
+	o.SpectralWhitening.SetFloor(floor)
+	o.SetCompression(spec.Compression)
+}
+
+// whiteningDefaults returns the default whitening relax time and floor of a
+// detection method
+func whiteningDefaults(method string) (float64, float64) {
+	spec, _ := lookupMethod(method)
+	relaxTime, floor := spectralWhiteningDefaultRelaxTime, spectralWhiteningDefaultFloor
+	if spec.WhiteningRelaxTime > 0 {
+		relaxTime = spec.WhiteningRelaxTime
+	}
+	if spec.WhiteningFloor > 0 {
+		floor = spec.WhiteningFloor
 	}
+	return relaxTime, floor
 }
@@ -0,0 +1,247 @@
+package onset
+
+import "fmt"
+
+// onsetConfig collects the settings of NewOnsetWithOptions
+type onsetConfig struct {
+	method     string
+	bufSize    uint
+	fftSize    uint
+	hopSize    uint
+	samplerate uint
+	// highpassHz is the cutoff of the input highpass filter, 0 if disabled
+	highpassHz float64
+	// params are applied to the detector after the method defaults
+	params []func(o *Onset)
+}
+
+// Option configures a detector created by NewOnsetWithOptions. Options return
+// an error for out-of-range values.
+type Option func(c *onsetConfig) error
+
+// WithMethod sets the detection method. Default is "hfc".
+func WithMethod(method string) Option {
+	return func(c *onsetConfig) error {
+		if !isMethod(method) {
+			return fmt.Errorf("unknown onset method %q", method)
+		}
+		c.method = method
+		return nil
+	}
+}
+
+// WithBufSize sets the FFT window size in samples, which must be a power of
+// two. Default is 512.
+func WithBufSize(bufSize uint) Option {
+	return func(c *onsetConfig) error {
+		if bufSize < 2 || bufSize&(bufSize-1) != 0 {
+			return fmt.Errorf("invalid buffer size %d: must be a power of two", bufSize)
+		}
+		c.bufSize = bufSize
+		return nil
+	}
+}
+
+// WithFFTSize zero-pads the analysis frames to an FFT of fftSize samples, which
+// must be a power of two at least the buffer size, as with SetFFTSize. Default
+// is the buffer size.
+func WithFFTSize(fftSize uint) Option {
+	return func(c *onsetConfig) error {
+		if fftSize < 2 || fftSize&(fftSize-1) != 0 {
+			return fmt.Errorf("invalid FFT size %d: must be a power of two", fftSize)
+		}
+		c.fftSize = fftSize
+		return nil
+	}
+}
+
+// WithHopSize sets the number of samples between analysis frames, which is the
+// size of the input of Do. Default is 256.
+func WithHopSize(hopSize uint) Option {
+	return func(c *onsetConfig) error {
+		if hopSize < 1 {
+			return fmt.Errorf("invalid hop size %d: must be positive", hopSize)
+		}
+		c.hopSize = hopSize
+		return nil
+	}
+}
+
+// WithSampleRate sets the sample rate in Hz. Default is 44100.
+func WithSampleRate(samplerate uint) Option {
+	return func(c *onsetConfig) error {
+		if samplerate < 1 {
+			return fmt.Errorf("invalid sample rate %d: must be positive", samplerate)
+		}
+		c.samplerate = samplerate
+		return nil
+	}
+}
+
+// WithThreshold sets the peak picking threshold, overriding the method default
+func WithThreshold(threshold float64) Option {
+	return func(c *onsetConfig) error {
+		if threshold < 0 {
+			return fmt.Errorf("invalid threshold %g: must not be negative", threshold)
+		}
+		c.params = append(c.params, func(o *Onset) { o.SetThreshold(threshold) })
+		return nil
+	}
+}
+
+// WithMinioiMs sets the minimum inter-onset interval in milliseconds,
+// overriding the method default
+func WithMinioiMs(minioi float64) Option {
+	return func(c *onsetConfig) error {
+		if minioi < 0 {
+			return fmt.Errorf("invalid minimum inter-onset interval %g ms: must not be negative", minioi)
+		}
+		c.params = append(c.params, func(o *Onset) { o.SetMinioiMs(minioi) })
+		return nil
+	}
+}
+
+// WithSilence sets the silence threshold in dB, overriding the default of -70
+func WithSilence(silence float64) Option {
+	return func(c *onsetConfig) error {
+		if silence > 0 {
+			return fmt.Errorf("invalid silence threshold %g dB: must not be positive", silence)
+		}
+		c.params = append(c.params, func(o *Onset) { o.SetSilence(silence) })
+		return nil
+	}
+}
+
+// WithDescriptorThreshold sets the magnitude threshold of the spectral bins
+// used by the phase and spectral difference methods, as with
+// SetDescriptorThreshold
+func WithDescriptorThreshold(threshold float64) Option {
+	return func(c *onsetConfig) error {
+		if threshold < 0 {
+			return fmt.Errorf("invalid descriptor threshold %g: must not be negative", threshold)
+		}
+		c.params = append(c.params, func(o *Onset) { o.SetDescriptorThreshold(threshold) })
+		return nil
+	}
+}
+
+// WithFlatnessGate suppresses the onsets of frames whose spectral flatness is
+// below minFlatness, as with SetFlatnessGate
+func WithFlatnessGate(minFlatness float64) Option {
+	return func(c *onsetConfig) error {
+		if minFlatness < 0 || minFlatness > 1 {
+			return fmt.Errorf("invalid flatness gate %g: must be between 0 and 1", minFlatness)
+		}
+		c.params = append(c.params, func(o *Onset) { o.SetFlatnessGate(minFlatness) })
+		return nil
+	}
+}
+
+// WithCrestGate suppresses the onsets of frames whose spectral crest factor
+// is above maxCrest, as with SetCrestGate
+func WithCrestGate(maxCrest float64) Option {
+	return func(c *onsetConfig) error {
+		if maxCrest < 0 || (maxCrest > 0 && maxCrest < 1) {
+			return fmt.Errorf("invalid crest gate %g: must be 0 or at least 1", maxCrest)
+		}
+		c.params = append(c.params, func(o *Onset) { o.SetCrestGate(maxCrest) })
+		return nil
+	}
+}
+
+// WithDelayMs sets the constant delay subtracted from onset times in
+// milliseconds, overriding the method default
+func WithDelayMs(delay float64) Option {
+	return func(c *onsetConfig) error {
+		if delay < 0 {
+			return fmt.Errorf("invalid delay %g ms: must not be negative", delay)
+		}
+		c.params = append(c.params, func(o *Onset) { o.SetDelayMs(delay) })
+		return nil
+	}
+}
+
+// WithWhitening enables or disables adaptive spectral whitening, overriding
+// the method default
+func WithWhitening(enable bool) Option {
+	return func(c *onsetConfig) error {
+		c.params = append(c.params, func(o *Onset) { o.SetAWhitening(enable) })
+		return nil
+	}
+}
+
+// WithCompression sets the log compression lambda, 0 to disable compression,
+// overriding the method default
+func WithCompression(lambda float64) Option {
+	return func(c *onsetConfig) error {
+		if lambda < 0 {
+			return fmt.Errorf("invalid compression %g: must not be negative", lambda)
+		}
+		c.params = append(c.params, func(o *Onset) { o.SetCompression(lambda) })
+		return nil
+	}
+}
+
+// WithInputHighpassHz filters the input with a highpass at hz before
+// detection, as with SetInputHighpassHz. The cutoff must be below half the
+// sample rate.
+func WithInputHighpassHz(hz float64) Option {
+	return func(c *onsetConfig) error {
+		if hz < 0 {
+			return fmt.Errorf("invalid highpass cutoff %g Hz: must not be negative", hz)
+		}
+		c.highpassHz = hz
+		return nil
+	}
+}
+
+// WithFFT sets the FFT implementation of the phase vocoder, as with SetFFT
+func WithFFT(f FFT) Option {
+	return func(c *onsetConfig) error {
+		c.params = append(c.params, func(o *Onset) { o.SetFFT(f) })
+		return nil
+	}
+}
+
+// WithPreprocessor sets a processor applied to every input frame before
+// detection, as with SetPreprocessor
+func WithPreprocessor(p Processor) Option {
+	return func(c *onsetConfig) error {
+		c.params = append(c.params, func(o *Onset) { o.SetPreprocessor(p) })
+		return nil
+	}
+}
+
+// NewOnsetWithOptions creates a new onset detection object configured by
+// options, returning a descriptive error for invalid settings instead of a
+// silently misconfigured detector. Without options it creates an "hfc"
+// detector with a 512-sample window and 256-sample hop at 44100 Hz. Parameter
+// options override the tuned defaults of the method regardless of their order.
+func NewOnsetWithOptions(opts ...Option) (*Onset, error) {
+	c := &onsetConfig{method: "hfc", bufSize: 512, hopSize: 256, samplerate: 44100}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.hopSize > c.bufSize {
+		return nil, fmt.Errorf("hop size %d is larger than buffer size %d", c.hopSize, c.bufSize)
+	}
+	if c.fftSize > 0 && c.fftSize < c.bufSize {
+		return nil, fmt.Errorf("FFT size %d is smaller than buffer size %d", c.fftSize, c.bufSize)
+	}
+	if c.highpassHz >= float64(c.samplerate)/2 {
+		return nil, fmt.Errorf("highpass cutoff %g Hz is not below half the sample rate %d", c.highpassHz, c.samplerate)
+	}
+
+	o, err := NewOnsetErr(c.method, c.bufSize, c.hopSize, c.samplerate)
+	if err != nil {
+		return nil, err
+	}
+	o.SetFFTSize(c.fftSize)
+	o.SetInputHighpassHz(c.highpassHz)
+	for _, apply := range c.params {
+		apply(o)
+	}
+	return o, nil
+}
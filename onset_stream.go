@@ -0,0 +1,90 @@
+package onset
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrStreamClosed is returned when writing to a closed OnsetStream
+var ErrStreamClosed = errors.New("onset stream closed")
+
+// OnsetStream runs onset detection on samples written to it in blocks of any
+// size and delivers the detected onsets on a channel, for use in concurrent
+// programs where polling the detector after every Do call is awkward.
+//
+// Backpressure: Write sends every event before it returns and blocks while
+// the channel is full, so a consumer that falls behind slows the producer
+// down instead of losing events. Size the buffer for the burstiness of the
+// consumer, and always drain the channel until it is closed by Close.
+// Producers that must never block, such as audio callbacks, should run the
+// stream from a goroutine fed through their own buffer.
+type OnsetStream struct {
+	o      *Onset
+	input  *Fvec
+	output *Fvec
+	fill   uint
+	events chan OnsetEvent
+	// mu serializes Write and Close
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewOnsetStream creates a stream detecting onsets with the given method and
+// parameters. The events channel holds up to buffer events.
+func NewOnsetStream(method string, bufSize, hopSize, samplerate uint, buffer int) *OnsetStream {
+	s := &OnsetStream{
+		o:      NewOnset(method, bufSize, hopSize, samplerate),
+		input:  NewFvec(hopSize),
+		output: NewFvec(1),
+		events: make(chan OnsetEvent, buffer),
+	}
+	s.o.OnOnset(func(ev OnsetEvent) {
+		s.events <- ev
+	})
+	return s
+}
+
+// Onset returns the underlying detector, for setting its parameters before
+// writing to the stream
+func (s *OnsetStream) Onset() *Onset {
+	return s.o
+}
+
+// Events returns the channel the detected onsets are delivered on. It is
+// closed by Close.
+func (s *OnsetStream) Events() <-chan OnsetEvent {
+	return s.events
+}
+
+// Write feeds samples to the detector, processing every complete hop, and
+// sends the onsets detected in them. It returns ErrStreamClosed after Close.
+func (s *OnsetStream) Write(samples []float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+
+	for _, v := range samples {
+		s.input.Data[s.fill] = v
+		s.fill++
+		if s.fill < s.o.HopSize {
+			continue
+		}
+		s.fill = 0
+
+		s.o.Do(s.input, s.output)
+	}
+	return nil
+}
+
+// Close closes the events channel. Samples of an incomplete hop are discarded.
+func (s *OnsetStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+	return nil
+}
@@ -1,9 +1,15 @@
 package onset
 
 import (
+	"cmp"
+	"encoding/json"
 	"fmt"
 	"math"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/go-audio/wav"
@@ -224,6 +230,68 @@ func TestFilter(t *testing.T) {
 	// Just check it doesn't crash
 }
 
+func TestBiquadDesigners(t *testing.T) {
+	samplerate := uint(44100)
+
+	// gain returns the steady-state gain of f for a sine at freq Hz
+	gain := func(f *Filter, freq float64) float64 {
+		input := NewFvec(samplerate)
+		for i := range input.Data {
+			input.Data[i] = math.Sin(2 * math.Pi * freq * float64(i) / float64(samplerate))
+		}
+		f.Do(input)
+		peak := 0.0
+		for _, v := range input.Data[samplerate/2:] {
+			peak = math.Max(peak, math.Abs(v))
+		}
+		return peak
+	}
+
+	// must returns the filter designed by design, failing the test on error
+	must := func(design func(fc, q float64, samplerate uint) (*Filter, error), fc, q float64) func() *Filter {
+		return func() *Filter {
+			f, err := design(fc, q, samplerate)
+			if err != nil {
+				t.Fatalf("Failed to design a filter at %g Hz: %v", fc, err)
+			}
+			return f
+		}
+	}
+	lowpass := must(NewLowpassBiquad, 1000, 1/math.Sqrt2)
+	highpass := must(NewHighpassBiquad, 1000, 1/math.Sqrt2)
+	bandpass := must(NewBandpassBiquad, 1000, 2)
+	testCases := []struct {
+		name     string
+		filter   func() *Filter
+		freq     float64
+		min, max float64
+	}{
+		{"lowpass passband", lowpass, 50, 0.98, 1.02},
+		{"lowpass cutoff", lowpass, 1000, 0.69, 0.72},
+		{"lowpass stopband", lowpass, 10000, 0, 0.02},
+		{"highpass passband", highpass, 10000, 0.98, 1.02},
+		{"highpass cutoff", highpass, 1000, 0.69, 0.72},
+		{"highpass stopband", highpass, 50, 0, 0.01},
+		{"bandpass center", bandpass, 1000, 0.98, 1.02},
+		{"bandpass below", bandpass, 100, 0, 0.06},
+	}
+	for _, tc := range testCases {
+		if g := gain(tc.filter(), tc.freq); g < tc.min || g > tc.max {
+			t.Errorf("%s: expected a gain between %.3f and %.3f at %.0f Hz, got %.3f", tc.name, tc.min, tc.max, tc.freq, g)
+		}
+	}
+
+	// Frequencies outside (0, Nyquist) and a q that is not positive would
+	// give unstable or NaN coefficients
+	for _, design := range []func(fc, q float64, samplerate uint) (*Filter, error){NewLowpassBiquad, NewHighpassBiquad, NewBandpassBiquad} {
+		for _, c := range [][2]float64{{0, 1}, {-100, 1}, {22050, 1}, {30000, 1}, {math.NaN(), 1}, {1000, 0}, {1000, -1}, {1000, math.NaN()}, {1000, math.Inf(1)}} {
+			if _, err := design(c[0], c[1], samplerate); err == nil {
+				t.Errorf("Expected error for a filter at %g Hz with q %g, got nil", c[0], c[1])
+			}
+		}
+	}
+}
+
 func BenchmarkOnsetDetection(b *testing.B) {
 	bufSize := uint(512)
 	hopSize := uint(256)
@@ -238,12 +306,55 @@ func BenchmarkOnsetDetection(b *testing.B) {
 		input.Data[i] = math.Sin(2 * math.Pi * 440 * float64(i) / float64(samplerate))
 	}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		o.Do(input, output)
 	}
 }
 
+func TestDoAllocations(t *testing.T) {
+	samples := synthBursts(44100, []float64{0.1, 0.3}, 0.5)
+
+	configs := map[string]func(o *Onset){
+		"adaptive": func(o *Onset) { o.SetAdaptiveThreshold(1, 90) },
+		"librosa":  func(o *Onset) { o.SetLibrosaPeakPicking(DefaultLibrosaPeakParams(44100, 256)) },
+		"filtered": func(o *Onset) {
+			o.SetInputHighpassHz(60)
+			o.SetPreprocessor(NewPipeline(NewDCBlocker(44100), Gain(2)))
+		},
+	}
+	for _, method := range ListMethods() {
+		configs[method] = nil
+	}
+
+	for name, configure := range configs {
+		method := name
+		if configure != nil {
+			method = "hfc"
+		}
+		o := NewOnset(method, 512, 256, 44100)
+		if configure != nil {
+			configure(o)
+		}
+		input, output := NewFvec(256), NewFvec(1)
+		pos := 0
+		step := func() {
+			copy(input.Data, samples[pos:pos+256])
+			pos = (pos + 256) % (len(samples) - 256)
+			o.Do(input, output)
+		}
+
+		// Fill the histories before measuring the steady state
+		for range 100 {
+			step()
+		}
+		if allocs := testing.AllocsPerRun(200, step); allocs != 0 {
+			t.Errorf("%s: expected no allocations per frame, got %f", name, allocs)
+		}
+	}
+}
+
 // readWavFile reads a WAV file and returns the audio samples
 func readWavFile(filename string) ([]float64, uint, error) {
 	f, err := os.Open(filename)
@@ -465,3 +576,1623 @@ func TestOptimalOnsetParameters(t *testing.T) {
 		})
 	}
 }
+
+func TestResample(t *testing.T) {
+	fromRate, toRate := uint(96000), uint(44100)
+
+	sine := func(freq float64, rate uint, n int) []float64 {
+		out := make([]float64, n)
+		for i := range out {
+			out[i] = math.Sin(2 * math.Pi * freq * float64(i) / float64(rate))
+		}
+		return out
+	}
+
+	// A 1 kHz tone must survive with the same frequency and amplitude
+	out := Resample(sine(1000, fromRate, 96000), fromRate, toRate)
+	if len(out) != 44100 {
+		t.Fatalf("Expected 44100 samples, got %d", len(out))
+	}
+	expected := sine(1000, toRate, len(out))
+	maxErr := 0.0
+	for i := 100; i < len(out)-100; i++ {
+		maxErr = math.Max(maxErr, math.Abs(out[i]-expected[i]))
+	}
+	if maxErr > 1e-3 {
+		t.Errorf("Resampled 1 kHz tone deviates by %f", maxErr)
+	}
+
+	// A 30 kHz tone is above the new Nyquist frequency and must be filtered out
+	aliased := Resample(sine(30000, fromRate, 96000), fromRate, toRate)
+	peak := 0.0
+	for i := 100; i < len(aliased)-100; i++ {
+		peak = math.Max(peak, math.Abs(aliased[i]))
+	}
+	if peak > 0.01 {
+		t.Errorf("Expected 30 kHz tone to be attenuated, got peak %f", peak)
+	}
+}
+
+func TestEstimateTempo(t *testing.T) {
+	// Quarter notes at 128 BPM with an eighth note every other beat
+	period := 60.0 / 128
+	var onsets []float64
+	for i := 0; i < 32; i++ {
+		onsets = append(onsets, 0.3+float64(i)*period)
+		if i%2 == 1 {
+			onsets = append(onsets, 0.3+(float64(i)+0.5)*period)
+		}
+	}
+
+	tempo := EstimateTempo(onsets, TempoOptions{})
+	if math.Abs(tempo.BPM-128) > 1 {
+		t.Errorf("Expected 128 BPM, got %.2f", tempo.BPM)
+	}
+	if tempo.Confidence < 0.5 {
+		t.Errorf("Expected a confident estimate, got %.2f", tempo.Confidence)
+	}
+	if len(tempo.Beats) < 31 || math.Abs(tempo.Beats[0]-0.3) > 0.02 {
+		t.Errorf("Expected beats aligned to the quarter notes, got %d beats starting at %v", len(tempo.Beats), tempo.Beats)
+	}
+
+	if empty := EstimateTempo([]float64{1.0}, TempoOptions{}); empty.BPM != 0 {
+		t.Errorf("Expected no tempo from a single onset, got %.2f", empty.BPM)
+	}
+}
+
+func TestEstimateTempoCurve(t *testing.T) {
+	// An accelerando from 100 to 140 BPM over about 40 s
+	var onsets []float64
+	for beat := 0.5; beat < 40; {
+		onsets = append(onsets, beat)
+		beat += 60 / (100 + beat)
+	}
+
+	curve := EstimateTempoCurve(onsets, TempoCurveOptions{})
+	if len(curve.Points) < 30 {
+		t.Fatalf("Expected a point every second, got %d", len(curve.Points))
+	}
+	for _, at := range []float64{5, 20, 35} {
+		if bpm := curve.BPMAt(at); math.Abs(bpm-(100+at)) > 4 {
+			t.Errorf("Expected about %.0f BPM at %.0f s, got %.2f", 100+at, at, bpm)
+		}
+	}
+	if len(curve.Beats) != len(onsets) {
+		t.Fatalf("Expected %d beats, got %d", len(onsets), len(curve.Beats))
+	}
+	for i, beat := range curve.Beats {
+		if beat != onsets[i] {
+			t.Fatalf("Expected beat %d on the onset at %.3f s, got %.3f s", i, onsets[i], beat)
+		}
+	}
+
+	if empty := EstimateTempoCurve([]float64{1}, TempoCurveOptions{}); len(empty.Points) != 0 || empty.BPMAt(1) != 0 {
+		t.Errorf("Expected an empty curve from a single onset, got %+v", empty)
+	}
+}
+
+func TestIOIHistogram(t *testing.T) {
+	// Eighth notes at 120 BPM with a double trigger 5 ms after every fourth
+	var onsets []float64
+	for i := range 16 {
+		onsets = append(onsets, 0.1+float64(i)*0.25)
+		if i%4 == 0 {
+			onsets = append(onsets, 0.105+float64(i)*0.25)
+		}
+	}
+
+	histogram, err := IOIHistogram(onsets, 10)
+	if err != nil {
+		t.Fatalf("IOIHistogram failed: %v", err)
+	}
+	if len(histogram.Counts) != 26 || histogram.Counts[0] != 4 || histogram.Counts[24] != 4 || histogram.Counts[25] != 11 {
+		t.Errorf("Unexpected counts %v", histogram.Counts)
+	}
+	if len(histogram.Dominant) != 1 || math.Abs(histogram.Dominant[0]-250) > 1e-6 {
+		t.Errorf("Expected a dominant interval of 250 ms, got %v", histogram.Dominant)
+	}
+
+	if empty, err := IOIHistogram([]float64{1}, 10); err != nil || empty.Counts != nil {
+		t.Errorf("Expected an empty histogram for a single onset, got %+v, %v", empty, err)
+	}
+	if _, err := IOIHistogram(onsets, 0); err == nil {
+		t.Error("Expected error for a bin width of 0, got nil")
+	}
+}
+
+func TestOnsetDensity(t *testing.T) {
+	// One onset per second, with a busy section of 8 per second from 10 to 14 s
+	var onsets []float64
+	for i := range 20 {
+		onsets = append(onsets, float64(i)+0.5)
+	}
+	for i := range 32 {
+		onsets = append(onsets, 10.05+float64(i)/8)
+	}
+
+	density, err := OnsetDensity(onsets, 2)
+	if err != nil {
+		t.Fatalf("OnsetDensity failed: %v", err)
+	}
+	if len(density) != 40 || density[len(density)-1].Time != 19.5 {
+		t.Fatalf("Expected points every 0.5 s up to 19.5 s, got %d", len(density))
+	}
+	for _, tc := range []struct{ time, density float64 }{{5, 1}, {12, 9}, {17, 1}} {
+		if point := density[int(tc.time*2)]; point.Time != tc.time || point.Density != tc.density {
+			t.Errorf("Expected %g onsets per second at %g s, got %+v", tc.density, tc.time, point)
+		}
+	}
+
+	start, count := BusiestWindow(onsets, 4)
+	if start != 10.05 || count != 36 {
+		t.Errorf("Expected the busiest 4 s to start at 10.05 s with 36 onsets, got %g s with %d", start, count)
+	}
+
+	if _, err := OnsetDensity(onsets, 0); err == nil {
+		t.Error("Expected error for a window of 0, got nil")
+	}
+	if density, err := OnsetDensity(nil, 2); err != nil || density != nil {
+		t.Errorf("Expected no density without onsets, got %v, %v", density, err)
+	}
+}
+
+func TestBeatGrid(t *testing.T) {
+	// 16 beats at 120 BPM in 3/4, accented on the second beat
+	sampleRate := uint(44100)
+	times := make([]float64, 16)
+	for i := range times {
+		times[i] = 0.25 + float64(i)*0.5
+	}
+	samples := synthBursts(sampleRate, times, 8.5)
+	for i, start := range times {
+		if i%3 != 1 {
+			offset := int(start * float64(sampleRate))
+			for j := offset; j < offset+int(sampleRate)/10; j++ {
+				samples[j] *= 0.3
+			}
+		}
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.MeasureTempo = true
+	options.BeatsPerBar = 3
+	result, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if math.Abs(result.Tempo.BPM-120) > 1 || len(result.BeatGrid) != len(result.Tempo.Beats) {
+		t.Fatalf("Expected a grid at 120 BPM, got %.2f BPM and %d beats", result.Tempo.BPM, len(result.BeatGrid))
+	}
+	for i, beat := range result.BeatGrid {
+		n := i + 2
+		if beat.Time != result.Tempo.Beats[i] || beat.Bar != n/3 || beat.Beat != n%3+1 {
+			t.Errorf("Expected beat %d in bar %d at %.3f s, got %+v", n%3+1, n/3, result.Tempo.Beats[i], beat)
+		}
+	}
+
+	options.BeatsPerBar = -1
+	if _, err := AnalyzeSamples(samples, sampleRate, options); err == nil {
+		t.Error("Expected error for a negative meter, got nil")
+	}
+}
+
+func TestOnsetStream(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75, 1.25, 1.75}, 2.25)
+
+	// An unbuffered channel exercises the blocking delivery
+	s := NewOnsetStream("hfc", 512, 256, sampleRate, 0)
+	done := make(chan []OnsetEvent)
+	go func() {
+		var events []OnsetEvent
+		for ev := range s.Events() {
+			events = append(events, ev)
+		}
+		done <- events
+	}()
+
+	for start := 0; start < len(samples); start += 1000 {
+		if err := s.Write(samples[start:min(start+1000, len(samples))]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	s.Close()
+	events := <-done
+
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 onsets, got %d: %+v", len(events), events)
+	}
+	for i, ev := range events {
+		expected := 0.25 + float64(i)*0.5
+		if math.Abs(ev.TimeSec-expected) > 0.02 || ev.Strength <= 0 || ev.Method != "hfc" {
+			t.Errorf("Unexpected event %d: %+v", i, ev)
+		}
+	}
+
+	if err := s.Write(samples[:10]); err != ErrStreamClosed {
+		t.Errorf("Expected ErrStreamClosed after Close, got %v", err)
+	}
+}
+
+func TestOnOnset(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75}, 1.0)
+
+	o := NewOnset("energy", 512, 256, sampleRate)
+	var first, second []OnsetEvent
+	o.OnOnset(func(ev OnsetEvent) { first = append(first, ev) })
+	cancel := o.OnOnset(func(ev OnsetEvent) { second = append(second, ev) })
+
+	input := NewFvec(256)
+	output := NewFvec(1)
+	polled := 0
+	for start := 0; start+256 <= len(samples); start += 256 {
+		copy(input.Data, samples[start:start+256])
+		o.Do(input, output)
+		if output.Data[0] > 0 {
+			polled++
+			if polled == 1 {
+				cancel()
+			}
+		}
+	}
+
+	if polled == 0 || len(first) != polled {
+		t.Fatalf("Expected %d events for the first listener, got %d", polled, len(first))
+	}
+	if len(second) != 1 {
+		t.Errorf("Expected 1 event before cancelling, got %d", len(second))
+	}
+	if first[0].Method != "energy" || first[0].TimeSec != second[0].TimeSec {
+		t.Errorf("Unexpected event: %+v", first[0])
+	}
+}
+
+func TestDCBlocker(t *testing.T) {
+	d := NewDCBlocker(44100)
+	in := NewFvec(44100)
+	for i := range in.Data {
+		in.Data[i] = 0.5 + 0.25*math.Sin(2*math.Pi*1000*float64(i)/44100)
+	}
+	d.Process(in)
+
+	// The offset is removed from the start and the tone is kept
+	if math.Abs(in.Data[0]) > 1e-9 {
+		t.Errorf("Expected no step at the start, got %f", in.Data[0])
+	}
+	mean, peak := 0.0, 0.0
+	for _, v := range in.Data[22050:] {
+		mean += v / 22050
+		peak = math.Max(peak, math.Abs(v))
+	}
+	if math.Abs(mean) > 1e-3 || math.Abs(peak-0.25) > 0.01 {
+		t.Errorf("Expected a centered tone of amplitude 0.25, got mean %f and peak %f", mean, peak)
+	}
+}
+
+func TestNoiseGate(t *testing.T) {
+	g := NewNoiseGate(-40, 1, 10, 44100)
+	in := NewFvec(22050)
+	for i := range in.Data {
+		// Hiss at -60 dB, then a tone at -6 dB from 0.25 s
+		in.Data[i] = 0.001 * math.Sin(float64(i)*1.7)
+		if i >= 11025 {
+			in.Data[i] = 0.5 * math.Sin(2*math.Pi*1000*float64(i)/44100)
+		}
+	}
+	g.Process(in)
+
+	for i, v := range in.Data[:11025] {
+		if v != 0 {
+			t.Fatalf("Sample %d: expected the hiss to be gated, got %g", i, v)
+		}
+	}
+	if peak := slices.Max(in.Data[11025+441:]); math.Abs(peak-0.5) > 0.01 {
+		t.Errorf("Expected the tone to pass at amplitude 0.5, got %f", peak)
+	}
+
+	// A clone starts closed
+	c := cloneProcessor(g).(*NoiseGate)
+	if c.Threshold != g.Threshold || c.gain != 0 {
+		t.Errorf("Expected a closed gate with the same threshold, got %+v", c)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	var order []string
+	p := NewPipeline(
+		ProcessorFunc(func(in *Fvec) { order = append(order, "first") }),
+		Gain(2),
+	).Add(ProcessorFunc(func(in *Fvec) { order = append(order, "last") }))
+
+	in := NewFvec(4)
+	for i := range in.Data {
+		in.Data[i] = float64(i)
+	}
+	p.Process(in)
+	if len(order) != 2 || order[0] != "first" || order[1] != "last" {
+		t.Errorf("Unexpected stage order: %v", order)
+	}
+	if in.Data[3] != 6 {
+		t.Errorf("Expected gain to double the samples, got %v", in.Data)
+	}
+
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75}, 1.0)
+	detect := func(pre Processor) (int, bool) {
+		o := NewOnset("hfc", 512, 256, sampleRate)
+		o.SetPreprocessor(pre)
+		input := NewFvec(256)
+		output := NewFvec(1)
+		count := 0
+		unchanged := true
+		for start := 0; start+256 <= len(samples); start += 256 {
+			copy(input.Data, samples[start:start+256])
+			o.Do(input, output)
+			if output.Data[0] > 0 {
+				count++
+			}
+			unchanged = unchanged && input.Data[0] == samples[start]
+		}
+		return count, unchanged
+	}
+
+	plain, _ := detect(nil)
+	if plain == 0 {
+		t.Fatal("Expected onsets without preprocessing")
+	}
+	if n, _ := detect(NewPipeline(Gain(1))); n != plain {
+		t.Errorf("Expected unity gain to keep %d onsets, got %d", plain, n)
+	}
+	n, unchanged := detect(NewPipeline(Gain(0)))
+	if n != 0 {
+		t.Errorf("Expected no onsets in muted input, got %d", n)
+	}
+	if !unchanged {
+		t.Error("Expected the preprocessor to leave the input buffer unchanged")
+	}
+}
+
+func TestDoEvent(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75}, 1.0)
+
+	o := NewOnset("hfc", 512, 256, sampleRate)
+	input := NewFvec(256)
+	var events []*OnsetEvent
+	for start := 0; start+256 <= len(samples); start += 256 {
+		copy(input.Data, samples[start:start+256])
+		if ev, ok := o.DoEvent(input); ok {
+			events = append(events, ev)
+		}
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 onsets, got %d: %+v", len(events), events)
+	}
+	for i, ev := range events {
+		expected := 0.25 + float64(i)*0.5
+		if math.Abs(ev.TimeSec-expected) > 0.02 {
+			t.Errorf("Expected onset %d near %.2fs, got %.3fs", i, expected, ev.TimeSec)
+		}
+		if ev.Sample != uint64(Round(ev.TimeSec*float64(sampleRate))) {
+			t.Errorf("Sample %d does not match time %.4fs", ev.Sample, ev.TimeSec)
+		}
+		if ev.Strength <= 0 || ev.Thresholded <= 0 || ev.Method != "hfc" {
+			t.Errorf("Unexpected event %d: %+v", i, ev)
+		}
+	}
+}
+
+func TestSafeOnsetConcurrent(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75}, 1.0)
+	s := NewSafeOnset("hfc", 512, 256, sampleRate)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		input := NewFvec(256)
+		for start := 0; start+256 <= len(samples); start += 256 {
+			copy(input.Data, samples[start:start+256])
+			s.DoEvent(input)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		s.SetThreshold(0.3 + float64(i%10)*0.01)
+		s.SetMinioiMs(20)
+		_ = s.GetLastMs()
+	}
+	<-done
+
+	var minioi float64
+	s.With(func(o *Onset) { minioi = o.GetMinioiMs() })
+	if math.Abs(minioi-20) > 0.1 || math.Abs(s.GetThreshold()-0.39) > 1e-9 {
+		t.Errorf("Unexpected parameters: minioi %.2f, threshold %.2f", minioi, s.GetThreshold())
+	}
+}
+
+func TestApplyParamsWhileStreaming(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := make([]float64, 2*int(sampleRate))
+	seed := uint32(1)
+	for i := range samples {
+		seed = seed*1664525 + 1013904223
+		samples[i] = (float64(seed)/float64(1<<32)*2 - 1) * 0.3
+	}
+
+	// Steady noise has a single onset at its start, whatever the parameters
+	for _, method := range []string{"hfc", "specflux", "kl", "complex"} {
+		o := NewOnset(method, 512, 256, sampleRate)
+		input := NewFvec(256)
+		count := 0
+		for start, frame := 0, 0; start+256 <= len(samples); start, frame = start+256, frame+1 {
+			if frame%40 == 20 {
+				p := o.Params()
+				p.Threshold *= 1.1
+				p.AWhitening = !p.AWhitening
+				if p.Compression > 0 {
+					p.Compression = 0
+				} else {
+					p.Compression = 5
+				}
+				o.ApplyParams(p)
+				if o.Params() != p {
+					t.Fatalf("%s: expected parameters %+v, got %+v", method, p, o.Params())
+				}
+			}
+			copy(input.Data, samples[start:start+256])
+			if _, ok := o.DoEvent(input); ok {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Errorf("%s: expected 1 onset with changing parameters, got %d", method, count)
+		}
+	}
+}
+
+func TestParamsPresets(t *testing.T) {
+	o := NewOnset("specflux", 512, 256, 44100)
+	o.SetThreshold(0.42)
+	o.SetMinioiMs(35)
+	o.SetCompression(3)
+	want := o.Params()
+	if want.Version != ParamsVersion || want.Method != "specflux" {
+		t.Fatalf("Expected version %d of method specflux, got %+v", ParamsVersion, want)
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"preset.json", "preset.toml"} {
+		path := filepath.Join(dir, name)
+		if err := SaveParams(path, want); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := LoadParams(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("%s: expected %+v, got %+v", name, want, got)
+		}
+
+		loaded, err := NewOnsetParams(got, 512, 256, 44100)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if loaded.method != "specflux" || loaded.Params() != want {
+			t.Errorf("%s: expected a specflux detector with %+v, got %s with %+v", name, want, loaded.method, loaded.Params())
+		}
+	}
+
+	var p Params
+	toml := "# tuned on drums\nversion = 1\nmethod = \"hfc\" # fast\nthreshold = 0.3\nminioi_ms = 20\nawhitening = true\nfuture = \"ignored\"\n"
+	if err := p.UnmarshalTOML([]byte(toml)); err != nil {
+		t.Fatal(err)
+	}
+	if p.Method != "hfc" || p.Threshold != 0.3 || p.MinioiMs != 20 || !p.AWhitening {
+		t.Errorf("Unexpected parameters from TOML: %+v", p)
+	}
+
+	// Infinities and NaN use the TOML spellings
+	special := Params{Version: ParamsVersion, Method: "hfc", Threshold: math.Inf(1), Silence: math.Inf(-1), MinioiMs: math.NaN()}
+	data, err := special.MarshalTOML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"threshold = inf\n", "silence = -inf\n", "minioi_ms = nan\n"} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("Expected %q in TOML, got:\n%s", line, data)
+		}
+	}
+	if err := p.UnmarshalTOML(data); err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsInf(p.Threshold, 1) || !math.IsInf(p.Silence, -1) || !math.IsNaN(p.MinioiMs) {
+		t.Errorf("Expected infinities and NaN from TOML, got %+v", p)
+	}
+
+	for _, invalid := range []string{"threshold = high", "awhitening = 1", "method = hfc", "threshold"} {
+		if err := p.UnmarshalTOML([]byte(invalid)); err == nil {
+			t.Errorf("Expected an error for TOML %q", invalid)
+		}
+	}
+	newer := fmt.Sprintf(`{"version": %d, "threshold": 0.3}`, ParamsVersion+1)
+	if err := json.Unmarshal([]byte(newer), &p); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("Expected a version error for a newer JSON preset, got %v", err)
+	}
+	if err := p.UnmarshalTOML([]byte(fmt.Sprintf("version = %d", ParamsVersion+1))); err == nil {
+		t.Error("Expected a version error for a newer TOML preset")
+	}
+	if _, err := NewOnsetParams(Params{Method: "hfcc"}, 512, 256, 44100); err == nil {
+		t.Error("Expected an error for an unknown preset method")
+	}
+}
+
+func TestNewOnsetErr(t *testing.T) {
+	for _, method := range append(ListMethods(), "default", "COMPLEXDOMAIN") {
+		if _, err := NewOnsetErr(method, 512, 256, 44100); err != nil {
+			t.Errorf("Expected method %q to be accepted, got %v", method, err)
+		}
+	}
+
+	testCases := []struct {
+		method                       string
+		bufSize, hopSize, samplerate uint
+	}{
+		{"hfcc", 512, 256, 44100},
+		{"consensus", 512, 256, 44100},
+		{"hfc", 512, 0, 44100},
+		{"hfc", 1, 1, 44100},
+		{"hfc", 256, 512, 44100},
+		{"hfc", 512, 256, 0},
+	}
+	for _, tc := range testCases {
+		if _, err := NewOnsetErr(tc.method, tc.bufSize, tc.hopSize, tc.samplerate); err == nil {
+			t.Errorf("Expected an error for %+v", tc)
+		}
+	}
+
+	methods := ListMethods()
+	methods[0] = "changed"
+	if ListMethods()[0] != "hfc" {
+		t.Error("Expected ListMethods to return a copy")
+	}
+}
+
+func TestNewOnsetWithOptions(t *testing.T) {
+	o, err := NewOnsetWithOptions()
+	if err != nil {
+		t.Fatalf("NewOnsetWithOptions failed: %v", err)
+	}
+	if o.HopSize != 256 || o.Samplerate != 44100 || math.Abs(o.GetThreshold()-0.058) > 1e-9 {
+		t.Errorf("Unexpected defaults: hop %d, rate %d, threshold %f", o.HopSize, o.Samplerate, o.GetThreshold())
+	}
+
+	// Parameters override the method defaults whatever their order
+	o, err = NewOnsetWithOptions(
+		WithThreshold(0.4),
+		WithMethod("specflux"),
+		WithBufSize(1024),
+		WithHopSize(512),
+		WithSampleRate(48000),
+		WithMinioiMs(30),
+		WithWhitening(false),
+		WithCompression(0),
+		WithDescriptorThreshold(0.5),
+		WithInputHighpassHz(40),
+	)
+	if err != nil {
+		t.Fatalf("NewOnsetWithOptions failed: %v", err)
+	}
+	p := o.Params()
+	if o.HopSize != 512 || o.Samplerate != 48000 || p.Threshold != 0.4 || math.Abs(p.MinioiMs-30) > 0.1 || p.AWhitening || p.Compression != 0 {
+		t.Errorf("Unexpected detector: hop %d, rate %d, %+v", o.HopSize, o.Samplerate, p)
+	}
+	if o.GetInputHighpassHz() != 40 {
+		t.Errorf("Expected a highpass cutoff of 40 Hz, got %f", o.GetInputHighpassHz())
+	}
+	if o.GetDescriptorThreshold() != 0.5 {
+		t.Errorf("Expected a descriptor threshold of 0.5, got %f", o.GetDescriptorThreshold())
+	}
+
+	invalid := [][]Option{
+		{WithMethod("nope")},
+		{WithBufSize(1000)},
+		{WithHopSize(0)},
+		{WithBufSize(256), WithHopSize(512)},
+		{WithSampleRate(0)},
+		{WithThreshold(-1)},
+		{WithMinioiMs(-5)},
+		{WithSilence(10)},
+		{WithDelayMs(-1)},
+		{WithCompression(-1)},
+		{WithDescriptorThreshold(-1)},
+		{WithInputHighpassHz(-1)},
+		{WithSampleRate(16000), WithInputHighpassHz(8000)},
+	}
+	for i, opts := range invalid {
+		if _, err := NewOnsetWithOptions(opts...); err == nil {
+			t.Errorf("Expected an error for invalid options %d", i)
+		}
+	}
+}
+
+func TestMethodInfo(t *testing.T) {
+	for _, name := range ListMethods() {
+		spec, ok := MethodInfo(name)
+		if !ok || spec.Name != name || spec.Description == "" {
+			t.Errorf("Unexpected info for %q: %+v", name, spec)
+		}
+		o := NewOnset(name, 512, 256, 44100)
+		if o.GetThreshold() != spec.Threshold || o.GetCompression() != spec.Compression || o.GetAWhitening() != spec.AWhitening {
+			t.Errorf("%s: detector defaults %+v do not match %+v", name, o.Params(), spec)
+		}
+	}
+
+	spec, ok := MethodInfo("ComplexDomain")
+	if !ok || spec.Name != "complex" {
+		t.Errorf("Expected the alias to resolve to complex, got %+v", spec)
+	}
+	spec.Aliases[0] = "changed"
+	if again, _ := MethodInfo("complex"); again.Aliases[0] != "complexdomain" {
+		t.Error("Expected MethodInfo to return a copy of the aliases")
+	}
+	if _, ok := MethodInfo("consensus"); ok {
+		t.Error("Expected no info for an unknown method")
+	}
+}
+
+func TestRegisterSpecdesc(t *testing.T) {
+	// A rectified energy difference, keeping the previous energy as state
+	frames := 0
+	err := RegisterSpecdescState("test-energy-rise", func(bufSize uint) any {
+		return new(float64)
+	}, func(grain *Cvec, state any, out *Fvec) {
+		frames++
+		last := state.(*float64)
+		energy := 0.0
+		for j := uint(0); j < grain.Length; j++ {
+			energy += grain.Norm[j] * grain.Norm[j]
+		}
+		out.Data[0] = math.Max(0, energy-*last)
+		*last = energy
+	})
+	if err != nil {
+		t.Fatalf("RegisterSpecdescState failed: %v", err)
+	}
+	t.Cleanup(func() { unregisterSpecdesc("test-energy-rise") })
+
+	if err := RegisterSpecdesc("hfc", func(*Cvec, any, *Fvec) {}); err == nil {
+		t.Error("Expected an error registering a built-in name")
+	}
+	if err := RegisterSpecdesc("Test-Energy-Rise", func(*Cvec, any, *Fvec) {}); err == nil {
+		t.Error("Expected an error registering a name twice")
+	}
+	if spec, ok := MethodInfo("test-energy-rise"); !ok || !spec.Custom {
+		t.Errorf("Expected custom method info, got %+v", spec)
+	}
+	listed := false
+	for _, name := range ListMethods() {
+		listed = listed || name == "test-energy-rise"
+	}
+	if !listed {
+		t.Error("Expected ListMethods to include the custom method")
+	}
+
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75}, 1.0)
+	o, err := NewOnsetErr("test-energy-rise", 512, 256, sampleRate)
+	if err != nil {
+		t.Fatalf("NewOnsetErr failed: %v", err)
+	}
+	input := NewFvec(256)
+	var times []float64
+	for start := 0; start+256 <= len(samples); start += 256 {
+		copy(input.Data, samples[start:start+256])
+		if ev, ok := o.DoEvent(input); ok {
+			times = append(times, ev.TimeSec)
+		}
+	}
+	if frames == 0 || len(times) != 2 || math.Abs(times[0]-0.25) > 0.02 || math.Abs(times[1]-0.75) > 0.02 {
+		t.Errorf("Expected onsets near 0.25s and 0.75s after %d frames, got %v", frames, times)
+	}
+
+	// The consensus method runs the custom method too
+	frames = 0
+	if _, err := AnalyzeSamples(samples, sampleRate, SliceAnalyzerOptions{Method: "consensus"}); err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if frames == 0 {
+		t.Error("Expected the consensus method to run the custom method")
+	}
+}
+
+// fixedPicker reports an onset when the detection function rises above a
+// fixed threshold
+type fixedPicker struct {
+	threshold float64
+	above     bool
+	resets    int
+}
+
+func (p *fixedPicker) Do(desc *Fvec, out *Fvec) {
+	above := desc.Data[0] > p.threshold
+	out.Data[0] = 0
+	if above && !p.above {
+		out.Data[0] = 1
+	}
+	p.above = above
+}
+
+func (p *fixedPicker) Reset() {
+	p.above = false
+	p.resets++
+}
+
+func (p *fixedPicker) SetThreshold(threshold float64) {
+	p.threshold = threshold
+}
+
+func TestCustomPeakPicker(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75}, 1.0)
+
+	o := NewOnset("energy", 512, 256, sampleRate)
+	picker := &fixedPicker{}
+	o.SetPeakPicker(picker)
+	o.SetThreshold(1)
+	if picker.threshold != 1 || o.GetPeakPicker() != picker {
+		t.Fatalf("Expected the custom picker to be configured, got threshold %f", picker.threshold)
+	}
+
+	input := NewFvec(256)
+	var times []float64
+	for start := 0; start+256 <= len(samples); start += 256 {
+		copy(input.Data, samples[start:start+256])
+		if ev, ok := o.DoEvent(input); ok {
+			times = append(times, ev.TimeSec)
+		}
+	}
+	// Without the latency of the built-in picker, the default delay makes the
+	// onsets a little early
+	if len(times) != 2 || math.Abs(times[0]-0.25) > 0.03 || math.Abs(times[1]-0.75) > 0.03 {
+		t.Errorf("Expected onsets near 0.25s and 0.75s, got %v", times)
+	}
+	if o.GetThresholdedDescriptor() != 0 {
+		t.Error("Expected no thresholded value from the custom picker")
+	}
+
+	o.Reset()
+	if picker.resets != 1 {
+		t.Errorf("Expected Reset to reset the picker once, got %d", picker.resets)
+	}
+	o.SetPeakPicker(nil)
+	if o.GetPeakPicker() != o.Pp {
+		t.Error("Expected nil to restore the built-in picker")
+	}
+}
+
+func TestSetPeakWindow(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75}, 1.0)
+
+	// detect returns the onset times and the frames they were detected in
+	detect := func(o *Onset) ([]float64, []uint64) {
+		input := NewFvec(256)
+		var times []float64
+		var frames []uint64
+		for start := 0; start+256 <= len(samples); start += 256 {
+			copy(input.Data, samples[start:start+256])
+			if ev, ok := o.DoEvent(input); ok {
+				times = append(times, ev.TimeSec)
+				frames = append(frames, o.TotalFrames)
+			}
+		}
+		return times, frames
+	}
+
+	times, frames := detect(NewOnset("hfc", 512, 256, sampleRate))
+	fast := NewOnset("hfc", 512, 256, sampleRate)
+	fast.SetPeakWindow(0, 3)
+	fast.Pp.SetSmoothingFilter(nil)
+	if fast.Pp.GetWinPre() != 0 || fast.Pp.GetWinPost() != 3 {
+		t.Fatalf("Unexpected window: %d/%d", fast.Pp.GetWinPre(), fast.Pp.GetWinPost())
+	}
+	fastTimes, fastFrames := detect(fast)
+
+	if len(times) != 2 || len(fastTimes) != 2 {
+		t.Fatalf("Expected 2 onsets, got %v and %v", times, fastTimes)
+	}
+	for i := range times {
+		if fastFrames[i] >= frames[i] {
+			t.Errorf("Expected onset %d to be detected earlier without lookahead, got frame %d vs %d", i, fastFrames[i], frames[i])
+		}
+		if math.Abs(fastTimes[i]-times[i]) > 0.005 {
+			t.Errorf("Expected onset %d near %.4fs, got %.4fs", i, times[i], fastTimes[i])
+		}
+	}
+}
+
+func TestLibrosaPeakPicker(t *testing.T) {
+	p := NewLibrosaPeakPicker(LibrosaPeakParams{PreMax: 1, PostMax: 2, PreAvg: 1, PostAvg: 2, Delta: 0.1, Wait: 2})
+	if p.Lookahead() != 1 {
+		t.Fatalf("Expected a lookahead of 1 frame, got %d", p.Lookahead())
+	}
+
+	// Frames 1 and 6 are peaks; 3 is below the mean plus delta and 5 is not a
+	// local maximum. Peaks are reported one frame late.
+	values := []float64{0, 1, 0, 0.05, 0, 0.8, 0.9, 0, 0}
+	desc := NewFvec(1)
+	out := NewFvec(1)
+	var reported []int
+	for i, v := range values {
+		desc.Data[0] = v
+		p.Do(desc, out)
+		if out.Data[0] > 0 {
+			reported = append(reported, i)
+		}
+	}
+	if len(reported) != 2 || reported[0] != 2 || reported[1] != 7 {
+		t.Errorf("Expected peaks reported at frames 2 and 7, got %v", reported)
+	}
+
+	// Wait suppresses a peak right after another
+	p = NewLibrosaPeakPicker(LibrosaPeakParams{PostMax: 1, PreAvg: 1, PostAvg: 1, Delta: 0.3, Wait: 2})
+	reported = reported[:0]
+	for i, v := range []float64{0, 1, 0, 1, 0, 0, 1} {
+		desc.Data[0] = v
+		p.Do(desc, out)
+		if out.Data[0] > 0 {
+			reported = append(reported, i)
+		}
+	}
+	if len(reported) != 2 || reported[0] != 1 || reported[1] != 6 {
+		t.Errorf("Expected peaks at frames 1 and 6, got %v", reported)
+	}
+
+	// In a detector, onset times match those of the built-in picker
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75}, 1.0)
+	o := NewOnset("specflux", 512, 256, sampleRate)
+	o.SetLibrosaPeakPicking(DefaultLibrosaPeakParams(sampleRate, 256))
+	o.SetThreshold(0.1)
+	if o.GetThreshold() != 0.1 {
+		t.Errorf("Expected SetThreshold to set delta, got %f", o.GetThreshold())
+	}
+	input := NewFvec(256)
+	var times []float64
+	for start := 0; start+256 <= len(samples); start += 256 {
+		copy(input.Data, samples[start:start+256])
+		if ev, ok := o.DoEvent(input); ok {
+			times = append(times, ev.TimeSec)
+		}
+	}
+	if len(times) != 2 || math.Abs(times[0]-0.25) > 0.02 || math.Abs(times[1]-0.75) > 0.02 {
+		t.Errorf("Expected onsets near 0.25s and 0.75s, got %v", times)
+	}
+
+	// Changing the parameters does not shift the delay again, and restoring
+	// the built-in picker restores its delay
+	builtin := NewOnset("specflux", 512, 256, sampleRate).GetDelay()
+	librosa := o.GetDelay()
+	params := DefaultLibrosaPeakParams(sampleRate, 256)
+	params.Delta = 0.2
+	o.SetLibrosaPeakPicking(params)
+	o.SetLibrosaPeakPicking(params)
+	if o.GetDelay() != librosa {
+		t.Errorf("Expected a delay of %d after changing the parameters, got %d", librosa, o.GetDelay())
+	}
+	if c := o.Clone(); c.GetDelay() != librosa {
+		t.Errorf("Expected a clone delay of %d, got %d", librosa, c.GetDelay())
+	}
+	o.SetPeakPicker(nil)
+	if o.GetDelay() != builtin {
+		t.Errorf("Expected the built-in delay of %d, got %d", builtin, o.GetDelay())
+	}
+	o.SetPeakPicker(nil)
+	if o.GetDelay() != builtin {
+		t.Errorf("Expected the built-in delay of %d after restoring twice, got %d", builtin, o.GetDelay())
+	}
+}
+
+func TestDescriptorThreshold(t *testing.T) {
+	samples := synthBursts(44100, []float64{0.1, 0.3}, 0.5)
+
+	// descriptorSum returns the sum of the specdiff detection function
+	descriptorSum := func(threshold float64) float64 {
+		o := NewOnset("specdiff", 512, 256, 44100)
+		if threshold > 0 {
+			o.SetDescriptorThreshold(threshold)
+		}
+		input, output := NewFvec(256), NewFvec(1)
+		sum := 0.0
+		for start := 0; start+256 <= len(samples); start += 256 {
+			copy(input.Data, samples[start:start+256])
+			o.Do(input, output)
+			sum += o.GetDescriptor()
+		}
+		return sum
+	}
+
+	if descriptorSum(0) <= 0 {
+		t.Fatal("Expected a detection function with the default descriptor threshold")
+	}
+	if sum := descriptorSum(1e9); sum != 0 {
+		t.Errorf("Expected every bin to be gated by a huge descriptor threshold, got %f", sum)
+	}
+}
+
+func TestFFTSize(t *testing.T) {
+	sampleRate := uint(44100)
+	times := []float64{0.25, 0.75}
+	samples := synthBursts(sampleRate, times, 1.0)
+
+	for _, method := range ListMethods() {
+		o := NewOnset(method, 512, 256, sampleRate)
+		o.SetFFTSize(2048)
+		if o.GetFFTSize() != 2048 || o.Fftgrain.Length != 1025 {
+			t.Fatalf("%s: expected 1025 bins of a 2048-point FFT, got %d of %d", method, o.Fftgrain.Length, o.GetFFTSize())
+		}
+
+		input, output := NewFvec(256), NewFvec(1)
+		var onsets []float64
+		for start := 0; start+256 <= len(samples); start += 256 {
+			copy(input.Data, samples[start:start+256])
+			o.Do(input, output)
+			if output.Data[0] > 0 {
+				onsets = append(onsets, o.GetLastS())
+			}
+		}
+		for _, hit := range times {
+			found := false
+			for _, onsetTime := range onsets {
+				found = found || math.Abs(onsetTime-hit) <= 0.03
+			}
+			if !found {
+				t.Errorf("%s: expected an onset near %.2fs, got %v", method, hit, onsets)
+			}
+		}
+	}
+
+	// Sizes up to the window size disable padding
+	o := NewOnset("hfc", 512, 256, sampleRate)
+	o.SetFFTSize(256)
+	if o.GetFFTSize() != 512 {
+		t.Errorf("Expected the FFT size to be raised to the window size, got %d", o.GetFFTSize())
+	}
+	if _, err := NewOnsetWithOptions(WithFFTSize(256)); err == nil {
+		t.Error("Expected an error for an FFT size smaller than the buffer size")
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.FFTSize = 2048
+	result, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) != len(times) {
+		t.Errorf("Expected %d onsets, got %v", len(times), result.Onsets)
+	}
+	options.FFTSize = 1000
+	if _, err := AnalyzeSamples(samples, sampleRate, options); err == nil {
+		t.Error("Expected an error for an FFT size that is not a power of two")
+	}
+}
+
+// countingFFT wraps the built-in FFT and counts the transforms
+type countingFFT struct {
+	FFT
+	calls int
+}
+
+func (f *countingFFT) Forward(in []float64) ([]float64, []float64) {
+	f.calls++
+	return f.FFT.Forward(in)
+}
+
+func TestCustomFFT(t *testing.T) {
+	samples := synthBursts(44100, []float64{0.25, 0.75}, 1.0)
+	detect := func(f FFT) []float64 {
+		o, err := NewOnsetWithOptions(WithFFT(f), WithFFTSize(1024))
+		if err != nil {
+			t.Fatalf("NewOnsetWithOptions failed: %v", err)
+		}
+		input, output := NewFvec(256), NewFvec(1)
+		var curve []float64
+		for start := 0; start+256 <= len(samples); start += 256 {
+			copy(input.Data, samples[start:start+256])
+			o.Do(input, output)
+			curve = append(curve, o.GetDescriptor())
+		}
+		return curve
+	}
+
+	f := &countingFFT{FFT: NewDefaultFFT()}
+	custom, builtin := detect(f), detect(nil)
+	if f.calls != len(custom) {
+		t.Errorf("Expected %d transforms, got %d", len(custom), f.calls)
+	}
+	for i := range builtin {
+		if custom[i] != builtin[i] {
+			t.Fatalf("Frame %d: expected %f with the custom FFT, got %f", i, builtin[i], custom[i])
+		}
+	}
+}
+
+func TestDefaultFFT(t *testing.T) {
+	defer SetAccelerated(true)
+	for _, accelerated := range []bool{true, false} {
+		SetAccelerated(accelerated)
+		for _, n := range []int{2, 4, 8, 512, 12} {
+			in := make([]float64, n)
+			for i := range in {
+				in[i] = math.Sin(0.37*float64(i)) + 0.1*float64(i%5)
+			}
+			norm, phas := NewDefaultFFT().Forward(in)
+			if len(norm) != n/2+1 || len(phas) != n/2+1 {
+				t.Fatalf("n=%d: expected %d bins, got %d", n, n/2+1, len(norm))
+			}
+
+			// Compare with a direct DFT
+			for k := range norm {
+				re, im := 0.0, 0.0
+				for j, v := range in {
+					re += v * math.Cos(-2*math.Pi*float64(k*j)/float64(n))
+					im += v * math.Sin(-2*math.Pi*float64(k*j)/float64(n))
+				}
+				if math.Abs(norm[k]-math.Hypot(re, im)) > 1e-9 {
+					t.Errorf("accelerated=%v, n=%d, bin %d: expected magnitude %f, got %f", accelerated, n, k, math.Hypot(re, im), norm[k])
+				}
+				if norm[k] > 1e-6 && math.Abs(math.Remainder(phas[k]-math.Atan2(im, re), 2*math.Pi)) > 1e-9 {
+					t.Errorf("accelerated=%v, n=%d, bin %d: expected phase %f, got %f", accelerated, n, k, math.Atan2(im, re), phas[k])
+				}
+			}
+		}
+	}
+
+	// Steady-state transforms do not allocate
+	p := NewPvoc(512, 256)
+	input, grain := NewFvec(256), NewCvec(512)
+	p.Do(input, grain)
+	if allocs := testing.AllocsPerRun(10, func() { p.Do(input, grain) }); allocs != 0 {
+		t.Errorf("Expected no allocations per frame, got %f", allocs)
+	}
+}
+
+func TestInverseFFT(t *testing.T) {
+	for _, n := range []int{512, 12} {
+		// The built-in inverse transform undoes the forward one
+		in := make([]float64, n)
+		for i := range in {
+			in[i] = math.Sin(0.37*float64(i)) + 0.1*float64(i%5)
+		}
+		norm, phas := NewDefaultFFT().Forward(in)
+		out := make([]float64, n)
+		NewDefaultFFT().(InverseFFT).Inverse(norm, phas, out)
+		for i := range in {
+			if math.Abs(out[i]-in[i]) > 1e-9 {
+				t.Fatalf("n=%d, sample %d: expected %f, got %f", n, i, in[i], out[i])
+			}
+		}
+	}
+}
+
+func TestPvocRDo(t *testing.T) {
+	samples := synthBursts(44100, []float64{0.01, 0.05}, 0.1)
+	for _, c := range []struct {
+		win, hop, fftSize uint
+		fft               FFT
+	}{
+		{1024, 256, 1024, nil},
+		{1024, 512, 1024, nil},
+		{1000, 300, 1000, nil},
+		{1024, 256, 2048, nil},
+		{512, 128, 512, &countingFFT{FFT: NewDefaultFFT()}},
+	} {
+		p := NewPvocPadded(c.win, c.hop, c.fftSize)
+		p.SetFFT(c.fft)
+		frame, grain, hop := NewFvec(c.win), NewCvec(c.fftSize), NewFvec(c.hop)
+
+		// resynthesize overlap-adds the frames of the samples, the output
+		// of every frame starting where the frame starts
+		resynthesize := func() []float64 {
+			var out []float64
+			for start := 0; start+int(c.win) <= len(samples); start += int(c.hop) {
+				copy(frame.Data, samples[start:start+int(c.win)])
+				p.Do(frame, grain)
+				p.RDo(grain, hop)
+				out = append(out, hop.Data...)
+			}
+			return out
+		}
+
+		// Samples overlapped by full windows are reconstructed
+		out := resynthesize()
+		for i := int(c.win); i < len(out); i++ {
+			if math.Abs(out[i]-samples[i]) > 1e-9 {
+				t.Fatalf("%+v, sample %d: expected %f, got %f", c, i, samples[i], out[i])
+			}
+		}
+
+		// Reset clears the overlap-add buffer
+		p.Reset()
+		if again := resynthesize(); !slices.Equal(again, out) {
+			t.Errorf("%+v: expected the same resynthesis after Reset", c)
+		}
+	}
+}
+
+func TestAccelerated(t *testing.T) {
+	defer SetAccelerated(true)
+	if !Accelerated() {
+		t.Fatal("Expected the accelerated kernels to be enabled by default")
+	}
+	samples := synthBursts(44100, []float64{0.1, 0.35, 0.6}, 0.8)
+
+	// detect returns the detection function and the onsets of every frame
+	detect := func(method string) ([]float64, []float64) {
+		o := NewOnset(method, 1024, 256, 44100)
+		input, output := NewFvec(256), NewFvec(1)
+		var desc, onsets []float64
+		for start := 0; start+256 <= len(samples); start += 256 {
+			copy(input.Data, samples[start:start+256])
+			o.Do(input, output)
+			desc = append(desc, o.GetDescriptor())
+			if output.Data[0] > 0 {
+				onsets = append(onsets, o.GetLastS())
+			}
+		}
+		return desc, onsets
+	}
+
+	for _, method := range ListMethods() {
+		SetAccelerated(true)
+		fastDesc, fastOnsets := detect(method)
+		SetAccelerated(false)
+		desc, onsets := detect(method)
+
+		for i := range desc {
+			if math.Abs(fastDesc[i]-desc[i]) > 1e-9*math.Max(math.Abs(desc[i]), 1) {
+				t.Errorf("%s, frame %d: expected descriptor %g, got %g", method, i, desc[i], fastDesc[i])
+				break
+			}
+		}
+		if len(fastOnsets) != len(onsets) {
+			t.Errorf("%s: expected onsets %v, got %v", method, onsets, fastOnsets)
+		}
+	}
+
+	// The transform matches the reference for every size, whatever the
+	// number of radix-4 passes
+	for n := 2; n <= 4096; n *= 2 {
+		f := &realFFT{}
+		SetAccelerated(true)
+		fastNorm, fastPhas := f.Forward(samples[4000 : 4000+n])
+		fastNorm, fastPhas = slices.Clone(fastNorm), slices.Clone(fastPhas)
+		SetAccelerated(false)
+		norm, phas := f.Forward(samples[4000 : 4000+n])
+		for k := range norm {
+			if math.Abs(fastNorm[k]-norm[k]) > 1e-9*math.Max(norm[k], 1) || (norm[k] > 1e-6 && math.Abs(fastPhas[k]-phas[k]) > 1e-6) {
+				t.Errorf("Size %d, bin %d: expected %g at %g rad, got %g at %g rad", n, k, norm[k], phas[k], fastNorm[k], fastPhas[k])
+				break
+			}
+		}
+	}
+
+	// Log compression matches math.Log, including at its special cases
+	magnitudes := []float64{0, 1e-300, 1e-12, 0.5, 1, math.Sqrt2 - 1, 3, 1e6, 1e300, math.Inf(1), -0.5}
+	compressed := slices.Clone(magnitudes)
+	logCompress(compressed, 1)
+	for i, v := range magnitudes {
+		if want := math.Log(1 + v); math.Abs(compressed[i]-want) > 1e-15*math.Abs(want) && !(math.IsNaN(want) && math.IsNaN(compressed[i])) {
+			t.Errorf("log(1 + %g): expected %g, got %g", v, want, compressed[i])
+		}
+	}
+}
+
+func BenchmarkAccelerated(b *testing.B) {
+	defer SetAccelerated(true)
+	samples := synthBursts(44100, []float64{0.5, 1.5, 2.5}, 3)
+	for _, accelerated := range []bool{true, false} {
+		b.Run(fmt.Sprintf("accelerated=%v", accelerated), func(b *testing.B) {
+			SetAccelerated(accelerated)
+			o := NewOnset("hfc", 1024, 256, 44100)
+			input, output := NewFvec(256), NewFvec(1)
+			for b.Loop() {
+				for start := 0; start+256 <= len(samples); start += 256 {
+					copy(input.Data, samples[start:start+256])
+					o.Do(input, output)
+				}
+			}
+		})
+	}
+}
+
+func TestLongStreamTimes(t *testing.T) {
+	// More than 2^32 samples, over six hours at 192 kHz
+	sampleRate := uint(192000)
+	start := uint64(1) << 33
+	samples := synthBursts(sampleRate, []float64{0.5}, 1.0)
+
+	o := NewOnset("hfc", 2048, 1024, sampleRate)
+	o.TotalFrames = start
+	input := NewFvec(1024)
+	var events []OnsetEvent
+	for i := 0; i+1024 <= len(samples); i += 1024 {
+		copy(input.Data, samples[i:i+1024])
+		if ev, ok := o.DoEvent(input); ok {
+			events = append(events, *ev)
+		}
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 onset, got %d", len(events))
+	}
+	expected := float64(start)/float64(sampleRate) + 0.5
+	if math.Abs(events[0].TimeSec-expected) > 0.02 {
+		t.Errorf("Expected onset at %.3fs, got %.3fs", expected, events[0].TimeSec)
+	}
+	if events[0].Sample < start {
+		t.Errorf("Expected onset sample after %d, got %d", start, events[0].Sample)
+	}
+}
+
+func TestResetClearsState(t *testing.T) {
+	first := synthBursts(44100, []float64{0.05, 0.2, 0.45}, 0.5)
+	second := synthBursts(44100, []float64{0.1, 0.3}, 0.5)
+	for i := range second {
+		second[i] *= 0.5
+	}
+
+	// run returns the detection function and onsets of samples
+	run := func(o *Onset, samples []float64) ([]float64, []float64) {
+		input, output := NewFvec(256), NewFvec(1)
+		var desc, onsets []float64
+		for start := 0; start+256 <= len(samples); start += 256 {
+			copy(input.Data, samples[start:start+256])
+			o.Do(input, output)
+			desc = append(desc, o.GetDescriptor())
+			if output.Data[0] > 0 {
+				onsets = append(onsets, o.GetLastS())
+			}
+		}
+		return desc, onsets
+	}
+
+	configure := func(o *Onset) {
+		o.SetAdaptiveThreshold(0.1, 90)
+		o.SetInputHighpassHz(60)
+		o.SetPreprocessor(NewDCBlocker(44100))
+	}
+	for _, method := range ListMethods() {
+		reused := NewOnset(method, 512, 256, 44100)
+		configure(reused)
+		run(reused, first)
+		reused.Reset()
+		desc, onsets := run(reused, second)
+
+		fresh := NewOnset(method, 512, 256, 44100)
+		configure(fresh)
+		expectedDesc, expectedOnsets := run(fresh, second)
+
+		for i := range expectedDesc {
+			if desc[i] != expectedDesc[i] {
+				t.Errorf("%s, frame %d: expected descriptor %g after Reset, got %g", method, i, expectedDesc[i], desc[i])
+				break
+			}
+		}
+		if fmt.Sprint(onsets) != fmt.Sprint(expectedOnsets) {
+			t.Errorf("%s: expected onsets %v after Reset, got %v", method, expectedOnsets, onsets)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	samples := synthBursts(44100, []float64{0.05, 0.2, 0.45}, 0.5)
+
+	// run returns the detection function and onsets of samples
+	run := func(o *Onset) ([]float64, []float64) {
+		input, output := NewFvec(256), NewFvec(1)
+		var desc, onsets []float64
+		for start := 0; start+256 <= len(samples); start += 256 {
+			copy(input.Data, samples[start:start+256])
+			o.Do(input, output)
+			desc = append(desc, o.GetDescriptor())
+			if output.Data[0] > 0 {
+				onsets = append(onsets, o.GetLastS())
+			}
+		}
+		return desc, onsets
+	}
+
+	configure := func(o *Onset) *Onset {
+		o.SetThreshold(0.2)
+		o.SetMinioiMs(30)
+		o.SetAWhitening(true)
+		o.SetFFTSize(1024)
+		o.SetPeakWindow(2, 4)
+		o.SetAdaptiveThreshold(0.1, 80)
+		o.SetInputHighpassHz(60)
+		o.SetPreprocessor(NewPipeline(NewDCBlocker(44100), Gain(2)))
+		return o
+	}
+	expectedDesc, expectedOnsets := run(configure(NewOnset("specflux", 512, 256, 44100)))
+
+	template := configure(NewOnset("specflux", 512, 256, 44100))
+	calls := 0
+	template.OnOnset(func(OnsetEvent) { calls++ })
+	run(template)
+	calls = 0
+
+	clones := make([]*Onset, 4)
+	for i := range clones {
+		clones[i] = template.Clone()
+	}
+	results := make([][]float64, len(clones))
+	onsets := make([][]float64, len(clones))
+	var wg sync.WaitGroup
+	for i, c := range clones {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], onsets[i] = run(c)
+		}()
+	}
+	wg.Wait()
+
+	for i := range clones {
+		if fmt.Sprint(results[i]) != fmt.Sprint(expectedDesc) || fmt.Sprint(onsets[i]) != fmt.Sprint(expectedOnsets) {
+			t.Errorf("Clone %d: expected onsets %v, got %v", i, expectedOnsets, onsets[i])
+		}
+	}
+	if calls != 0 {
+		t.Errorf("Expected listeners not to be cloned, got %d calls", calls)
+	}
+	if clones[0].GetThreshold() != 0.2 || clones[0].GetFFTSize() != 1024 || clones[0].GetInputHighpassHz() != 60 {
+		t.Errorf("Expected the parameters to be cloned, got threshold %g, FFT size %d, highpass %g", clones[0].GetThreshold(), clones[0].GetFFTSize(), clones[0].GetInputHighpassHz())
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	defer SetAccelerated(true)
+	for _, accelerated := range []bool{true, false} {
+		SetAccelerated(accelerated)
+		report := SelfTest()
+		if !report.Passed || report.Accelerated != accelerated {
+			t.Errorf("Expected the self-test to pass (accelerated %t), got:\n%s", accelerated, report)
+		}
+		if len(report.Results) != len(builtinMethods()) {
+			t.Errorf("Expected a result for every built-in method, got %d", len(report.Results))
+		}
+		if !strings.HasSuffix(report.String(), "PASS\n") {
+			t.Errorf("Expected the report to end with PASS, got:\n%s", report)
+		}
+	}
+}
+
+func TestChromagram(t *testing.T) {
+	sampleRate := uint(44100)
+	tone := func(hz ...float64) []float64 {
+		samples := make([]float64, sampleRate)
+		for i := range samples {
+			for _, f := range hz {
+				samples[i] += 0.2 * math.Sin(2*math.Pi*f*float64(i)/float64(sampleRate))
+			}
+		}
+		return samples
+	}
+	strongest := func(vector []float64, n int) []string {
+		order := make([]int, len(vector))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(vector[b], vector[a]) })
+		var names []string
+		for _, k := range order[:n] {
+			names = append(names, ChromaNames[k])
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	// A 440 Hz tone is an A, and a C major chord holds C, E and G
+	chromagram := Chromagram(tone(440), sampleRate, 4096, 2048)
+	if len(chromagram) != 22 {
+		t.Fatalf("Expected 22 hops, got %d", len(chromagram))
+	}
+	if got := strongest(chromagram[10], 1); got[0] != "A" || chromagram[10][9] != 1 {
+		t.Errorf("Expected an A, got %v", chromagram[10])
+	}
+	chord := Chromagram(tone(261.63, 329.63, 392), sampleRate, 4096, 2048)
+	if got := strongest(chord[10], 3); fmt.Sprint(got) != "[C E G]" {
+		t.Errorf("Expected C, E and G, got %v", got)
+	}
+
+	// Spectra of an A tuned to 415 Hz fold into A once retuned
+	c := NewChroma(4096, sampleRate)
+	p := NewPvoc(4096, 2048)
+	grain := NewCvec(4096)
+	frame := NewFvec(4096)
+	copy(frame.Data, tone(415))
+	p.Do(frame, grain)
+	out := NewFvec(ChromaClasses)
+	c.SetTuning(415)
+	c.Do(grain, out)
+	if got := strongest(out.Data, 1); got[0] != "A" {
+		t.Errorf("Expected an A at 415 Hz tuning, got %v", out.Data)
+	}
+
+	for _, v := range Chromagram(make([]float64, 8192), sampleRate, 4096, 2048)[0] {
+		if v != 0 {
+			t.Errorf("Expected silence to be all zeros, got %g", v)
+		}
+	}
+}
+
+func TestMfcc(t *testing.T) {
+	// Every triangle of Slaney's filterbank has an area of 1
+	sampleRate := uint(44100)
+	fb := NewFilterbank(40, 16384)
+	if err := fb.SetMelCoeffsSlaney(sampleRate); err != nil {
+		t.Fatalf("SetMelCoeffsSlaney failed: %v", err)
+	}
+	binHz := float64(sampleRate) / 16384
+	for i, coeffs := range fb.Coeffs {
+		area := 0.0
+		for _, c := range coeffs {
+			area += c * binHz
+		}
+		if math.Abs(area-1) > 0.05 {
+			t.Errorf("Expected filter %d to have an area of 1, got %.3f", i, area)
+		}
+	}
+	if err := NewFilterbank(24, 1024).SetMelCoeffsSlaney(sampleRate); err == nil {
+		t.Error("Expected error for Slaney's filterbank with 24 filters, got nil")
+	}
+
+	// A flat spectrum has a flat envelope: only the first coefficient is set
+	m, err := NewMfcc(16384, 40, 13, sampleRate)
+	if err != nil {
+		t.Fatalf("NewMfcc failed: %v", err)
+	}
+	grain := NewCvec(16384)
+	for i := range grain.Norm {
+		grain.Norm[i] = 1
+	}
+	flat := NewFvec(13)
+	m.Do(grain, flat)
+	if flat.Data[0] >= 0 {
+		t.Errorf("Expected a negative level coefficient, got %g", flat.Data[0])
+	}
+	for k, v := range flat.Data[1:] {
+		if math.Abs(v) > 0.05 {
+			t.Errorf("Expected coefficient %d of a flat spectrum to be 0, got %g", k+1, v)
+		}
+	}
+
+	// Spectra of different tones have different envelopes
+	coefficients := func(hz float64) []float64 {
+		p := NewPvoc(2048, 1024)
+		frame := NewFvec(2048)
+		for i := range frame.Data {
+			frame.Data[i] = math.Sin(2 * math.Pi * hz * float64(i) / float64(sampleRate))
+		}
+		spectrum := NewCvec(2048)
+		p.Do(frame, spectrum)
+		m, err := NewMfcc(2048, 24, 13, sampleRate)
+		if err != nil {
+			t.Fatalf("NewMfcc failed: %v", err)
+		}
+		out := NewFvec(13)
+		m.Do(spectrum, out)
+		return out.Data
+	}
+	low, high := coefficients(200), coefficients(5000)
+	distance := 0.0
+	for k := 1; k < 13; k++ {
+		distance += (low[k] - high[k]) * (low[k] - high[k])
+	}
+	if distance < 1 {
+		t.Errorf("Expected different coefficients for a low and a high tone, got %v and %v", low, high)
+	}
+
+	if _, err := NewMfcc(2048, 10, 13, sampleRate); err == nil {
+		t.Error("Expected error for more coefficients than filters, got nil")
+	}
+}
+
+func TestMelSpectrogram(t *testing.T) {
+	sampleRate := uint(22050)
+	samples := make([]float64, sampleRate)
+	for i := range samples {
+		samples[i] = 0.5 * math.Sin(2*math.Pi*1000*float64(i)/float64(sampleRate))
+	}
+	spectrogram, err := MelSpectrogram(samples, sampleRate, MelOptions{Bands: 40, DB: true})
+	if err != nil {
+		t.Fatalf("MelSpectrogram failed: %v", err)
+	}
+	if len(spectrogram) != 44 || len(spectrogram[0]) != 40 {
+		t.Fatalf("Expected 44 spectra of 40 bands, got %d of %d", len(spectrogram), len(spectrogram[0]))
+	}
+
+	// The tone peaks in the band centered on 1 kHz
+	row := spectrogram[20]
+	peak := 0
+	for k, v := range row {
+		if v > row[peak] {
+			peak = k
+		}
+	}
+	maxMel := HzToMel(float64(sampleRate) / 2)
+	center := MelToHz(maxMel * float64(peak+1) / 41)
+	if math.Abs(center-1000) > 100 || row[peak]-row[39] < 60 {
+		t.Errorf("Expected a peak at 1 kHz well above the top band, got band %d at %.0f Hz, %.1f dB over %.1f dB", peak, center, row[peak], row[39])
+	}
+
+	if _, err := MelSpectrogram(samples, sampleRate, MelOptions{MaxHz: 20000}); err == nil {
+		t.Error("Expected error for a band above half the sample rate, got nil")
+	}
+	if _, err := MelSpectrogram(samples, sampleRate, MelOptions{MinHz: 8000, MaxHz: 4000}); err == nil {
+		t.Error("Expected error for an empty range, got nil")
+	}
+}
+
+func TestSpectralShape(t *testing.T) {
+	sampleRate := uint(44100)
+	tone := func(hz float64) []float64 {
+		samples := make([]float64, 4096)
+		for i := range samples {
+			samples[i] = 0.5 * math.Sin(2*math.Pi*hz*float64(i)/float64(sampleRate))
+		}
+		return samples
+	}
+
+	// Modes of NewSpecdesc measure frames in bins: a tone at bin 100 has its
+	// centroid and rolloff there
+	p := NewPvoc(2048, 512)
+	grain := NewCvec(2048)
+	frame := NewFvec(2048)
+	copy(frame.Data, tone(100*float64(sampleRate)/2048))
+	p.Do(frame, grain)
+	out := NewFvec(1)
+	for mode, expected := range map[string]float64{"Centroid": 100, "rolloff": 100} {
+		NewSpecdesc(mode, 2048).Do(grain, out)
+		if math.Abs(out.Data[0]-expected) > 1 {
+			t.Errorf("Expected %s at bin %g, got %g", mode, expected, out.Data[0])
+		}
+	}
+	NewSpecdesc("spread", 2048).Do(grain, out)
+	if out.Data[0] > 2 {
+		t.Errorf("Expected a narrow spectrum, got a spread of %g bins²", out.Data[0])
+	}
+
+	// White noise has a flat spectrum
+	seed := uint32(1)
+	noise := make([]float64, 44100)
+	for i := range noise {
+		seed = seed*1664525 + 1013904223
+		noise[i] = float64(seed)/float64(1<<32) - 0.5
+	}
+	shapes := SpectralShapes(noise, sampleRate, 2048, 512)
+	if len(shapes) != 87 {
+		t.Fatalf("Expected 87 frames, got %d", len(shapes))
+	}
+	flat := shapes[40]
+	if math.Abs(flat.Centroid-11025) > 500 || math.Abs(flat.Kurtosis-1.8) > 0.2 || math.Abs(flat.Slope) > 1e-4 || flat.Rolloff < 20000 ||
+		math.Abs(flat.Flatness-0.56) > 0.1 || flat.Crest > 6 {
+		t.Errorf("Expected the shape of a flat spectrum, got %+v", flat)
+	}
+	low, high := SpectralShapes(tone(300), sampleRate, 2048, 512)[2], SpectralShapes(tone(3000), sampleRate, 2048, 512)[2]
+	if math.Abs(low.Centroid-300) > 50 || math.Abs(high.Centroid-3000) > 50 || low.Slope >= high.Slope || low.Flatness > 0.01 || low.Crest < 50 {
+		t.Errorf("Expected centroids at 300 and 3000 Hz, got %+v and %+v", low, high)
+	}
+	if silent := SpectralShapes(make([]float64, 2048), sampleRate, 2048, 512)[0]; silent != (SpectralShape{}) {
+		t.Errorf("Expected silence to be all zeros, got %+v", silent)
+	}
+
+	if err := RegisterSpecdesc("rolloff", func(*Cvec, any, *Fvec) {}); err == nil {
+		t.Error("Expected error for a custom method named after a shape mode, got nil")
+	}
+}
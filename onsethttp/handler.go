@@ -0,0 +1,232 @@
+// Package onsethttp serves onset analysis over HTTP, so that it can be mounted
+// into an existing web application.
+package onsethttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/schollz/onsets"
+)
+
+// Options contains configuration options for Handler
+type Options struct {
+	// Analyzer holds the analysis options used for every request. Form fields
+	// of a request override the corresponding options.
+	Analyzer onset.SliceAnalyzerOptions
+	// MaxUploadBytes is the largest accepted request body in bytes.
+	// Default is 100 MB if 0.
+	MaxUploadBytes int64
+	// TempDir is the directory uploads are spooled to before analysis.
+	// Default is the system temporary directory if empty.
+	TempDir string
+	// CheckOrigin reports whether LiveHandler accepts a WebSocket connection
+	// from the origin of the request. If nil, only same-origin requests are accepted.
+	CheckOrigin func(r *http.Request) bool
+}
+
+// DefaultOptions returns default options for Handler
+func DefaultOptions() Options {
+	return Options{
+		Analyzer:       onset.DefaultSliceAnalyzerOptions(),
+		MaxUploadBytes: 100 << 20,
+	}
+}
+
+// Response is the JSON body of a successful analysis
+type Response struct {
+	SampleRate      uint      `json:"sample_rate"`
+	Duration        float64   `json:"duration"`
+	Method          string    `json:"method"`
+	Onsets          []float64 `json:"onsets"`
+	BPM             float64   `json:"bpm"`
+	TempoConfidence float64   `json:"tempo_confidence"`
+	Slices          []Slice   `json:"slices"`
+}
+
+// Slice describes one slice in Response
+type Slice struct {
+	Index       int     `json:"index"`
+	Start       float64 `json:"start"`
+	End         float64 `json:"end"`
+	Duration    float64 `json:"duration"`
+	StartSample int     `json:"start_sample"`
+	EndSample   int     `json:"end_sample"`
+	Peak        float64 `json:"peak"`
+	RMS         float64 `json:"rms"`
+}
+
+// ErrorResponse is the JSON body of a failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler returns an http.Handler that analyzes audio files uploaded with POST
+// as the multipart form field "file" and responds with a JSON Response.
+// The optional form fields method, threshold, minioi, slices, optimize, window,
+// spacing and channel override the analysis options. Errors are reported as a
+// JSON ErrorResponse.
+func Handler(opts Options) http.Handler {
+	maxUpload := opts.MaxUploadBytes
+	if maxUpload == 0 {
+		maxUpload = 100 << 20
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid upload: %w", err))
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		options, err := formOptions(r, opts.Analyzer)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("missing file: %w", err))
+			return
+		}
+		defer file.Close()
+
+		// The analyzer reads from a path, so spool the upload to disk
+		tmp, err := os.CreateTemp(opts.TempDir, "onsethttp-*"+filepath.Ext(header.Filename))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer os.Remove(tmp.Name())
+		_, err = io.Copy(tmp, file)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		result, err := onset.AnalyzeSlices(tmp.Name(), options)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(NewResponse(result, options)); err != nil {
+			log.Printf("onsethttp: failed to write response: %v", err)
+		}
+	})
+}
+
+// formOptions overrides options with the form fields of r
+func formOptions(r *http.Request, options onset.SliceAnalyzerOptions) (onset.SliceAnalyzerOptions, error) {
+	if v := r.FormValue("method"); v != "" {
+		options.Method = v
+	}
+	if v := r.FormValue("channel"); v != "" {
+		options.Channel = v
+	}
+
+	floats := map[string]*float64{
+		"threshold": &options.Threshold,
+		"minioi":    &options.MinioiMs,
+		"window":    &options.OptimizeWindowMs,
+		"spacing":   &options.MinimumSpacing,
+	}
+	for name, dst := range floats {
+		if v := r.FormValue(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return options, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*dst = f
+		}
+	}
+	if r.FormValue("spacing") != "" {
+		options.UseMinimumSpacing = options.MinimumSpacing > 0
+	}
+
+	if v := r.FormValue("slices"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return options, fmt.Errorf("invalid slices: %q", v)
+		}
+		options.NumSlices = n
+	}
+	if v := r.FormValue("optimize"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return options, fmt.Errorf("invalid optimize: %w", err)
+		}
+		options.Optimize = b
+	}
+	return options, nil
+}
+
+// NewResponse summarizes an analysis result made with the given options.
+// Slice peak and RMS levels are only computed when the result holds its samples.
+func NewResponse(result *onset.SliceAnalyzerResult, options onset.SliceAnalyzerOptions) Response {
+	rate := float64(result.SampleRate)
+	tempo := onset.EstimateTempo(result.Onsets, onset.TempoOptions{})
+
+	response := Response{
+		SampleRate:      result.SampleRate,
+		Duration:        float64(result.NumSamples) / rate,
+		Method:          options.Method,
+		Onsets:          result.Onsets,
+		BPM:             tempo.BPM,
+		TempoConfidence: tempo.Confidence,
+		Slices:          []Slice{},
+	}
+	if response.Onsets == nil {
+		response.Onsets = []float64{}
+	}
+
+	for i, sr := range result.SliceRanges() {
+		peak, rms := 0.0, 0.0
+		if sr.End <= len(result.Samples) && sr.End > sr.Start {
+			sumSquares := 0.0
+			for _, v := range result.Samples[sr.Start:sr.End] {
+				peak = math.Max(peak, math.Abs(v))
+				sumSquares += v * v
+			}
+			rms = math.Sqrt(sumSquares / float64(sr.End-sr.Start))
+		}
+		response.Slices = append(response.Slices, Slice{
+			Index:       i + 1,
+			Start:       float64(sr.Start) / rate,
+			End:         float64(sr.End) / rate,
+			Duration:    float64(sr.End-sr.Start) / rate,
+			StartSample: sr.Start,
+			EndSample:   sr.End,
+			Peak:        peak,
+			RMS:         rms,
+		})
+	}
+	return response
+}
+
+// writeError writes err as a JSON ErrorResponse
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+}
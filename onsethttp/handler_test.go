@@ -0,0 +1,83 @@
+package onsethttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// uploadRequest builds a multipart request uploading amen.wav with the given form fields
+func uploadRequest(t *testing.T, fields map[string]string) *http.Request {
+	t.Helper()
+	audio, err := os.ReadFile("../amen.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", "amen.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(audio)
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestHandler(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Analyzer.NumSlices = 4
+	opts.Analyzer.Optimize = false
+	handler := Handler(opts)
+
+	// The configured options apply when the request sets no fields
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, uploadRequest(t, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if len(response.Onsets) != 4 || len(response.Slices) != 4 || response.SampleRate != 44100 {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	// Form fields override them
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, uploadRequest(t, map[string]string{"slices": "6"}))
+	response = Response{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if len(response.Onsets) != 6 {
+		t.Errorf("Expected 6 onsets, got %d", len(response.Onsets))
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, uploadRequest(t, map[string]string{"threshold": "high"}))
+	var errResponse ErrorResponse
+	if rec.Code != http.StatusBadRequest || json.Unmarshal(rec.Body.Bytes(), &errResponse) != nil || errResponse.Error == "" {
+		t.Errorf("Expected a JSON error with status 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Uploads over the size limit are rejected
+	small := Handler(Options{Analyzer: opts.Analyzer, MaxUploadBytes: 1024})
+	rec = httptest.NewRecorder()
+	small.ServeHTTP(rec, uploadRequest(t, nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an oversized upload, got %d", rec.Code)
+	}
+}
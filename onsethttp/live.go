@@ -0,0 +1,135 @@
+package onsethttp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/schollz/onsets"
+)
+
+// liveReadLimit is the largest accepted WebSocket message in bytes
+const liveReadLimit = 1 << 20
+
+// LiveEvent is the JSON message sent for every onset detected by LiveHandler
+type LiveEvent struct {
+	// Time is the onset time in seconds since the start of the stream
+	Time float64 `json:"time"`
+	// Strength is the value of the detection function at the onset
+	Strength float64 `json:"strength"`
+}
+
+// LiveHandler returns an http.Handler that upgrades requests to WebSocket
+// connections for live onset detection, such as from a WebAudio visualizer.
+//
+// The client sends binary messages of mono 32-bit little-endian float samples
+// (the contents of a Float32Array) and receives a JSON LiveEvent text message
+// for every onset as soon as it is detected. The query parameter rate sets the
+// sample rate and is required; method, threshold and minioi override the
+// analysis options. Unlike Handler, an unset Analyzer.Threshold or
+// Analyzer.MinioiMs keeps the tuned live defaults of the method.
+func LiveHandler(opts Options) http.Handler {
+	upgrader := websocket.Upgrader{CheckOrigin: opts.CheckOrigin}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o, err := liveOnset(r, opts.Analyzer)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// The upgrader writes the error response itself
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(liveReadLimit)
+
+		hop := onset.NewFvec(o.HopSize)
+		out := onset.NewFvec(1)
+		fill := uint(0)
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					log.Printf("onsethttp: live connection failed: %v", err)
+				}
+				return
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			if len(data)%4 != 0 {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseUnsupportedData, "samples must be 32-bit floats"), time.Time{})
+				return
+			}
+
+			for i := 0; i < len(data); i += 4 {
+				hop.Data[fill] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i:])))
+				fill++
+				if fill < o.HopSize {
+					continue
+				}
+				fill = 0
+
+				o.Do(hop, out)
+				if out.Data[0] == 0 {
+					continue
+				}
+				event := LiveEvent{Time: o.GetLastS(), Strength: o.GetOnsetDescriptor()}
+				if err := conn.WriteJSON(event); err != nil {
+					log.Printf("onsethttp: failed to send onset: %v", err)
+					return
+				}
+			}
+		}
+	})
+}
+
+// liveOnset creates the detector for a live request, overriding options with
+// the query parameters of r
+func liveOnset(r *http.Request, options onset.SliceAnalyzerOptions) (*onset.Onset, error) {
+	query := r.URL.Query()
+	rate, err := strconv.ParseUint(query.Get("rate"), 10, 32)
+	if err != nil || rate == 0 {
+		return nil, errors.New("missing or invalid rate")
+	}
+	if v := query.Get("method"); v != "" {
+		options.Method = v
+	}
+	if options.Method == "" {
+		options.Method = "hfc"
+	}
+	if options.Method == "consensus" {
+		return nil, errors.New("live detection does not support the consensus method")
+	}
+	for name, dst := range map[string]*float64{"threshold": &options.Threshold, "minioi": &options.MinioiMs} {
+		if v := query.Get(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*dst = f
+		}
+	}
+
+	o, err := onset.NewOnsetErr(options.Method, 512, 256, uint(rate))
+	if err != nil {
+		return nil, err
+	}
+	if options.Threshold > 0 {
+		o.SetThreshold(options.Threshold)
+	}
+	if options.MinioiMs > 0 {
+		o.SetMinioiMs(options.MinioiMs)
+	}
+	return o, nil
+}
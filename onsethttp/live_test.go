@@ -0,0 +1,71 @@
+package onsethttp
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/schollz/onsets"
+)
+
+func TestLiveHandler(t *testing.T) {
+	source, err := onset.AnalyzeSlices("../amen.wav", onset.SliceAnalyzerOptions{Method: "hfc"})
+	if err != nil {
+		t.Fatalf("Failed to read amen.wav: %v", err)
+	}
+
+	server := httptest.NewServer(LiveHandler(DefaultOptions()))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a rate, got %d", resp.StatusCode)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?rate=44100", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Send the samples in WebAudio-sized blocks
+	const blockSize = 1024
+	for start := 0; start < len(source.Samples); start += blockSize {
+		block := source.Samples[start:min(start+blockSize, len(source.Samples))]
+		data := make([]byte, len(block)*4)
+		for i, v := range block {
+			binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(float32(v)))
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatalf("WriteMessage failed: %v", err)
+		}
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+
+	var events []LiveEvent
+	for {
+		var event LiveEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			break
+		}
+		events = append(events, event)
+	}
+
+	if len(events) < 4 {
+		t.Fatalf("Expected several onsets, got %d", len(events))
+	}
+	for i, event := range events {
+		if event.Strength <= 0 || (i > 0 && event.Time <= events[i-1].Time) {
+			t.Errorf("Unexpected event %d: %+v", i, event)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package onsetmidi
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// errNoDriver is returned when no gomidi driver is linked into the program
+var errNoDriver = errors.New("no MIDI driver registered: import a gomidi driver such as gitlab.com/gomidi/midi/v2/drivers/rtmididrv")
+
+// Port is a MIDI output port of the system, such as a USB interface, a
+// hardware synthesizer or a virtual port of a DAW, opened through gomidi.
+// Ports are provided by the gomidi driver linked into the program, such as
+// rtmididrv or portmididrv. Port implements io.WriteCloser so that a Trigger
+// can send its notes to it; every Write must hold one whole MIDI message, as
+// those of a Trigger do.
+type Port struct {
+	out drivers.Out
+}
+
+// Ports returns the names of the MIDI output ports
+func Ports() ([]string, error) {
+	if drivers.Get() == nil {
+		return nil, errNoDriver
+	}
+	outs, err := drivers.Outs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list MIDI ports: %w", err)
+	}
+	names := make([]string, len(outs))
+	for i, out := range outs {
+		names[i] = out.String()
+	}
+	return names, nil
+}
+
+// OpenPort opens the first MIDI output port whose name contains name, as
+// listed by Ports
+func OpenPort(name string) (*Port, error) {
+	if drivers.Get() == nil {
+		return nil, errNoDriver
+	}
+	out, err := drivers.OutByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MIDI port %q: %w", name, err)
+	}
+	return &Port{out: out}, nil
+}
+
+// Name returns the name of the port
+func (p *Port) Name() string {
+	return p.out.String()
+}
+
+// Write sends the MIDI message msg
+func (p *Port) Write(msg []byte) (int, error) {
+	if err := p.out.Send(msg); err != nil {
+		return 0, err
+	}
+	return len(msg), nil
+}
+
+// Close closes the port
+func (p *Port) Close() error {
+	return p.out.Close()
+}
@@ -0,0 +1,88 @@
+package onsetmidi
+
+import (
+	"bytes"
+	"testing"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+)
+
+// fakeDriver is a gomidi driver with one output port recording its messages
+type fakeDriver struct {
+	out *fakeOut
+}
+
+func (d *fakeDriver) Ins() ([]drivers.In, error)   { return nil, nil }
+func (d *fakeDriver) Outs() ([]drivers.Out, error) { return []drivers.Out{d.out}, nil }
+func (d *fakeDriver) String() string               { return "fake" }
+func (d *fakeDriver) Close() error                 { return nil }
+
+type fakeOut struct {
+	open     bool
+	messages [][]byte
+}
+
+func (o *fakeOut) Open() error             { o.open = true; return nil }
+func (o *fakeOut) Close() error            { o.open = false; return nil }
+func (o *fakeOut) IsOpen() bool            { return o.open }
+func (o *fakeOut) Number() int             { return 0 }
+func (o *fakeOut) String() string          { return "Fake Synth:0" }
+func (o *fakeOut) Underlying() interface{} { return nil }
+
+func (o *fakeOut) Send(msg []byte) error {
+	if !o.open {
+		return drivers.ErrPortClosed
+	}
+	o.messages = append(o.messages, append([]byte(nil), msg...))
+	return nil
+}
+
+func TestPort(t *testing.T) {
+	if _, err := OpenPort("Fake"); err == nil {
+		t.Fatal("Expected error without a MIDI driver, got nil")
+	}
+
+	driver := &fakeDriver{out: &fakeOut{}}
+	drivers.Register(driver)
+	t.Cleanup(func() { delete(drivers.REGISTRY, driver.String()) })
+
+	names, err := Ports()
+	if err != nil || len(names) != 1 || names[0] != "Fake Synth:0" {
+		t.Fatalf("Expected the fake port, got %v (%v)", names, err)
+	}
+	if _, err := OpenPort("Missing"); err == nil {
+		t.Error("Expected error for a missing port, got nil")
+	}
+
+	port, err := OpenPort("Fake")
+	if err != nil {
+		t.Fatalf("OpenPort failed: %v", err)
+	}
+	trigger, err := NewTrigger(port, Options{Channel: 10, Note: 38})
+	if err != nil {
+		t.Fatalf("NewTrigger failed: %v", err)
+	}
+	if err := trigger.Onset(1); err != nil {
+		t.Fatalf("Onset failed: %v", err)
+	}
+	if err := trigger.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := port.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Every message is sent on its own
+	want := [][]byte{{0x99, 38, 127}, {0x89, 38, 0}}
+	if len(driver.out.messages) != len(want) {
+		t.Fatalf("Expected %d messages, got % x", len(want), driver.out.messages)
+	}
+	for i, msg := range driver.out.messages {
+		if !bytes.Equal(msg, want[i]) {
+			t.Errorf("Message %d: expected % x, got % x", i+1, want[i], msg)
+		}
+	}
+	if err := trigger.Onset(1); err == nil {
+		t.Error("Expected error sending to a closed port, got nil")
+	}
+}
@@ -0,0 +1,186 @@
+// Package onsetmidi turns detected onsets into MIDI notes, so that any audio
+// input can act as a drum trigger. Messages are written as raw MIDI bytes to
+// an io.Writer: a MIDI output port of the system opened with OpenPort, a raw
+// MIDI device (/dev/snd/midiC1D0 on Linux) or a serial MIDI interface.
+package onsetmidi
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// MIDI status bytes
+const (
+	noteOff = 0x80
+	noteOn  = 0x90
+)
+
+// Options contains configuration options for a Trigger
+type Options struct {
+	// Channel is the MIDI channel of the notes, from 1 to 16.
+	// Default is 10 (General MIDI percussion) if 0.
+	Channel int
+	// Note is the MIDI note number sent for every onset.
+	// Default is 36 (General MIDI bass drum) if 0.
+	Note int
+	// MinVelocity is the velocity of the weakest onsets. Default is 1 if 0.
+	MinVelocity int
+	// MaxVelocity is the velocity of the strongest onsets. Default is 127 if 0.
+	MaxVelocity int
+	// NoteLength is the time between the note-on and note-off messages.
+	// Default is 50 ms if 0.
+	NoteLength time.Duration
+	// Decay is the fraction by which the reference strength decays with every
+	// onset, so that velocities adapt when the input gets quieter.
+	// Default is 0.01 if 0.
+	Decay float64
+}
+
+// DefaultOptions returns default options for a Trigger
+func DefaultOptions() Options {
+	return Options{
+		Channel:     10,
+		Note:        36,
+		MinVelocity: 1,
+		MaxVelocity: 127,
+		NoteLength:  50 * time.Millisecond,
+		Decay:       0.01,
+	}
+}
+
+// Trigger sends a MIDI note for every onset, with a velocity mapped from the
+// onset strength relative to the strongest recent onset. It is safe for
+// concurrent use.
+type Trigger struct {
+	w       io.Writer
+	options Options
+	mu      sync.Mutex
+	peak    float64
+	pending *time.Timer
+	// note counts the notes sent, so that a late note-off timer can tell
+	// whether its note was already ended
+	note int
+	err  error
+}
+
+// NewTrigger returns a Trigger writing MIDI messages to w
+func NewTrigger(w io.Writer, options Options) (*Trigger, error) {
+	defaults := DefaultOptions()
+	if options.Channel == 0 {
+		options.Channel = defaults.Channel
+	}
+	if options.Note == 0 {
+		options.Note = defaults.Note
+	}
+	if options.MinVelocity == 0 {
+		options.MinVelocity = defaults.MinVelocity
+	}
+	if options.MaxVelocity == 0 {
+		options.MaxVelocity = defaults.MaxVelocity
+	}
+	if options.NoteLength == 0 {
+		options.NoteLength = defaults.NoteLength
+	}
+	if options.Decay == 0 {
+		options.Decay = defaults.Decay
+	}
+
+	if options.Channel < 1 || options.Channel > 16 {
+		return nil, fmt.Errorf("invalid MIDI channel: %d", options.Channel)
+	}
+	if options.Note < 0 || options.Note > 127 {
+		return nil, fmt.Errorf("invalid MIDI note: %d", options.Note)
+	}
+	if options.MinVelocity < 1 || options.MaxVelocity > 127 || options.MinVelocity > options.MaxVelocity {
+		return nil, fmt.Errorf("invalid velocity range: %d-%d", options.MinVelocity, options.MaxVelocity)
+	}
+	if options.Decay < 0 || options.Decay >= 1 {
+		return nil, fmt.Errorf("invalid decay: %f", options.Decay)
+	}
+
+	return &Trigger{w: w, options: options}, nil
+}
+
+// Velocity maps an onset strength to a MIDI velocity and updates the reference
+// strength. Velocities scale with the square root of the strength relative to
+// the reference, which is the largest strength seen, decaying with every onset.
+func (t *Trigger) Velocity(strength float64) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.velocity(strength)
+}
+
+// velocity implements Velocity; t.mu must be held
+func (t *Trigger) velocity(strength float64) int {
+	t.peak = math.Max(t.peak*(1-t.options.Decay), strength)
+	if t.peak <= 0 || strength <= 0 {
+		return t.options.MinVelocity
+	}
+
+	span := float64(t.options.MaxVelocity - t.options.MinVelocity)
+	return t.options.MinVelocity + int(math.Round(span*math.Sqrt(strength/t.peak)))
+}
+
+// Onset sends a note-on message for an onset of the given strength, followed
+// by a note-off message after the note length. A note still sounding is ended
+// first. Errors of delayed note-off messages are returned by the next call.
+func (t *Trigger) Onset(strength float64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.endNote(); err != nil {
+		return err
+	}
+	velocity := t.velocity(strength)
+	if err := t.send(noteOn, velocity); err != nil {
+		return err
+	}
+
+	t.note++
+	note := t.note
+	t.pending = time.AfterFunc(t.options.NoteLength, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.pending != nil && t.note == note {
+			t.pending = nil
+			if err := t.send(noteOff, 0); err != nil && t.err == nil {
+				t.err = err
+			}
+		}
+	})
+	return nil
+}
+
+// Close ends a sounding note. It does not close the underlying writer.
+func (t *Trigger) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.endNote()
+}
+
+// endNote sends the pending note-off message early and returns any error of a
+// delayed one; t.mu must be held
+func (t *Trigger) endNote() error {
+	if t.pending != nil {
+		t.pending.Stop()
+		t.pending = nil
+		if err := t.send(noteOff, 0); err != nil {
+			return err
+		}
+	}
+	err := t.err
+	t.err = nil
+	return err
+}
+
+// send writes a note message for the configured channel and note
+func (t *Trigger) send(status byte, velocity int) error {
+	msg := []byte{status | byte(t.options.Channel-1), byte(t.options.Note), byte(velocity)}
+	if _, err := t.w.Write(msg); err != nil {
+		return fmt.Errorf("failed to send MIDI message: %w", err)
+	}
+	return nil
+}
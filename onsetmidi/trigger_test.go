@@ -0,0 +1,63 @@
+package onsetmidi
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func TestTrigger(t *testing.T) {
+	var out syncBuffer
+	trigger, err := NewTrigger(&out, Options{Channel: 1, Note: 38, NoteLength: time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewTrigger failed: %v", err)
+	}
+
+	// The first onset sets the reference strength and gets the full velocity;
+	// a second one overlapping it ends the first note before sounding
+	if err := trigger.Onset(4); err != nil {
+		t.Fatalf("Onset failed: %v", err)
+	}
+	if err := trigger.Onset(1); err != nil {
+		t.Fatalf("Onset failed: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := trigger.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	expected := []byte{
+		0x90, 38, 127,
+		0x80, 38, 0,
+		0x90, 38, 64,
+		0x80, 38, 0,
+	}
+	if got := out.Bytes(); !bytes.Equal(got, expected) {
+		t.Errorf("Expected messages % x, got % x", expected, got)
+	}
+
+	for _, options := range []Options{{Channel: 17}, {Note: 128}, {MinVelocity: 100, MaxVelocity: 50}, {Decay: 1}} {
+		if _, err := NewTrigger(&out, options); err == nil {
+			t.Errorf("Expected error for %+v, got nil", options)
+		}
+	}
+}
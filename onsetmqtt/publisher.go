@@ -0,0 +1,129 @@
+// Package onsetmqtt publishes detected onsets to an MQTT broker, for
+// installations where a small device listens to a microphone and other
+// devices react to transients.
+package onsetmqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Options contains configuration options for a Publisher
+type Options struct {
+	// Broker is the URL of the MQTT broker, such as "tcp://localhost:1883".
+	// Only used by Connect.
+	Broker string
+	// ClientID identifies the client to the broker. Only used by Connect.
+	// Default is "goaubio-onset" if empty.
+	ClientID string
+	// Username and Password authenticate with the broker if set.
+	// Only used by Connect.
+	Username string
+	Password string
+	// Topic is the topic onset events are published to.
+	// Default is "onsets" if empty.
+	Topic string
+	// QoS is the MQTT quality of service level: 0, 1 or 2. Default is 0.
+	QoS byte
+	// Retain asks the broker to keep the last event for new subscribers
+	Retain bool
+	// Timeout bounds connecting and publishing. Default is 5 seconds if 0.
+	Timeout time.Duration
+}
+
+// Event is the JSON payload published for every onset
+type Event struct {
+	// Time is the onset time in seconds since the start of the stream
+	Time float64 `json:"time"`
+	// Strength is the value of the detection function at the onset
+	Strength float64 `json:"strength"`
+	// Timestamp is the wall clock time the onset was published at
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Publisher publishes onset events as JSON to an MQTT topic
+type Publisher struct {
+	client  mqtt.Client
+	options Options
+	// owned reports whether the client was connected by Connect
+	owned bool
+}
+
+// Connect connects to the broker in options and returns a Publisher using the
+// new connection. Close disconnects it.
+func Connect(options Options) (*Publisher, error) {
+	if options.Broker == "" {
+		return nil, fmt.Errorf("missing MQTT broker")
+	}
+	options = withDefaults(options)
+
+	clientOptions := mqtt.NewClientOptions().
+		AddBroker(options.Broker).
+		SetClientID(options.ClientID).
+		SetUsername(options.Username).
+		SetPassword(options.Password).
+		SetConnectTimeout(options.Timeout).
+		SetAutoReconnect(true)
+	client := mqtt.NewClient(clientOptions)
+	token := client.Connect()
+	if !token.WaitTimeout(options.Timeout) {
+		return nil, fmt.Errorf("failed to connect to %s: timed out", options.Broker)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", options.Broker, err)
+	}
+
+	return &Publisher{client: client, options: options, owned: true}, nil
+}
+
+// NewPublisher returns a Publisher using an already connected client, which
+// is left connected by Close. The connection fields of options are ignored.
+func NewPublisher(client mqtt.Client, options Options) *Publisher {
+	return &Publisher{client: client, options: withDefaults(options)}
+}
+
+// withDefaults fills in the defaults of unset options
+func withDefaults(options Options) Options {
+	if options.ClientID == "" {
+		options.ClientID = "goaubio-onset"
+	}
+	if options.Topic == "" {
+		options.Topic = "onsets"
+	}
+	if options.Timeout == 0 {
+		options.Timeout = 5 * time.Second
+	}
+	return options
+}
+
+// Publish publishes an onset event and waits until it is sent, or
+// acknowledged for a QoS above 0
+func (p *Publisher) Publish(onsetTime, strength float64) error {
+	if p.options.QoS > 2 {
+		return fmt.Errorf("invalid QoS: %d", p.options.QoS)
+	}
+	payload, err := json.Marshal(Event{Time: onsetTime, Strength: strength, Timestamp: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	token := p.client.Publish(p.options.Topic, p.options.QoS, p.options.Retain, payload)
+	if !token.WaitTimeout(p.options.Timeout) {
+		return fmt.Errorf("failed to publish to %s: timed out", p.options.Topic)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", p.options.Topic, err)
+	}
+	return nil
+}
+
+// Close disconnects from the broker if the Publisher was created by Connect
+func (p *Publisher) Close() error {
+	if p.owned {
+		p.client.Disconnect(uint(p.options.Timeout / time.Millisecond))
+	}
+	return nil
+}
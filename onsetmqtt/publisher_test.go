@@ -0,0 +1,65 @@
+package onsetmqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// doneToken is a completed token
+type doneToken struct {
+	err error
+}
+
+func (t doneToken) Wait() bool                     { return true }
+func (t doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t doneToken) Error() error                   { return t.err }
+func (t doneToken) Done() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// fakeClient records published messages
+type fakeClient struct {
+	mqtt.Client
+	topics   []string
+	payloads [][]byte
+	err      error
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.topics = append(c.topics, topic)
+	c.payloads = append(c.payloads, payload.([]byte))
+	return doneToken{err: c.err}
+}
+
+func TestPublisher(t *testing.T) {
+	client := &fakeClient{}
+	p := NewPublisher(client, Options{Topic: "room/onsets"})
+	if err := p.Publish(1.5, 0.25); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	if len(client.topics) != 1 || client.topics[0] != "room/onsets" {
+		t.Fatalf("Unexpected topics: %v", client.topics)
+	}
+	var event Event
+	if err := json.Unmarshal(client.payloads[0], &event); err != nil {
+		t.Fatalf("Invalid JSON payload: %v", err)
+	}
+	if event.Time != 1.5 || event.Strength != 0.25 || event.Timestamp.IsZero() {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+
+	client.err = errors.New("connection lost")
+	if err := p.Publish(2, 0.5); err == nil {
+		t.Error("Expected error from a failed publish, got nil")
+	}
+	if _, err := Connect(Options{}); err == nil {
+		t.Error("Expected error without a broker, got nil")
+	}
+}
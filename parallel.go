@@ -0,0 +1,138 @@
+package onset
+
+import "sync"
+
+// parallelChunkSec is the shortest duration in seconds of the chunks analyzed
+// in parallel; shorter audio is analyzed on one goroutine
+const parallelChunkSec = 30.0
+
+// parallelWarmupSec is the duration in seconds of the audio preceding a chunk
+// that is analyzed, without keeping the results, to bring the state of the
+// detector in line with a sequential analysis
+const parallelWarmupSec = 5.0
+
+// chunksPerWorker is the number of chunks queued per worker, so that workers
+// finishing early pick up the remaining work
+const chunksPerWorker = 4
+
+// analysisChunk is a range of samples analyzed by one detector
+type analysisChunk struct {
+	// warmup is the first sample fed to the detector, start and end delimit
+	// the samples whose hops are kept
+	warmup, start, end int
+}
+
+// detectChunked runs the detection of method, and of the sensitivity tiers,
+// on samples. With options.Workers above 1, long audio is split into chunks
+// analyzed concurrently, each preceded by a warm-up on the audio before it,
+// and the results are stitched in order. The returned detector holds the
+// onsets, strengths, tier onsets and, if recordCurve is set, the detection
+// curve.
+func detectChunked(samples []float64, sampleRate uint, method string, tiers []SensitivityTier, options SliceAnalyzerOptions, recordCurve bool, p *progress) *hopDetector {
+	const bufSize, hopSize = 512, 256
+	newDetector := func() *hopDetector {
+		d := newHopDetector(method, bufSize, hopSize, sampleRate, options, recordCurve)
+		d.addTiers(method, bufSize, tiers, options)
+		return d
+	}
+
+	chunks := splitChunks(len(samples), sampleRate, hopSize, options)
+	if len(chunks) < 2 {
+		d := newDetector()
+		d.feed(samples, p)
+		return d
+	}
+
+	// Workers report the number of samples analyzed, which are forwarded to
+	// p from this goroutine
+	detectors := make([]*hopDetector, len(chunks))
+	jobs := make(chan int, len(chunks))
+	for i := range chunks {
+		jobs <- i
+	}
+	close(jobs)
+	done := make(chan int, 64)
+	var wg sync.WaitGroup
+	for range min(options.Workers, len(chunks)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				detectors[i] = newDetector()
+				detectors[i].feedChunk(samples, chunks[i], done)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	analyzed := 0
+	for n := range done {
+		analyzed += n
+		p.report(float64(analyzed) / float64(len(samples)))
+	}
+
+	// Every hop belongs to exactly one chunk, so the results are concatenated
+	merged := detectors[0]
+	for _, d := range detectors[1:] {
+		merged.onsets = append(merged.onsets, d.onsets...)
+		merged.strengths = append(merged.strengths, d.strengths...)
+		merged.curve = append(merged.curve, d.curve...)
+		for i := range merged.tierOnsets {
+			merged.tierOnsets[i] = append(merged.tierOnsets[i], d.tierOnsets[i]...)
+		}
+	}
+	return merged
+}
+
+// splitChunks divides numSamples samples into hop-aligned chunks for
+// options.Workers workers, or returns nil if the audio is too short to be
+// worth splitting
+func splitChunks(numSamples int, sampleRate, hopSize uint, options SliceAnalyzerOptions) []analysisChunk {
+	if options.Workers < 2 {
+		return nil
+	}
+	hop := int(hopSize)
+	minHops := int(parallelChunkSec * float64(sampleRate) / float64(hop))
+	totalHops := numSamples / hop
+	count := min(options.Workers*chunksPerWorker, totalHops/max(minHops, 1))
+	if count < 2 {
+		return nil
+	}
+
+	// The adaptive threshold needs its whole window of history
+	warmupHops := int((parallelWarmupSec + options.AdaptiveWindowSec) * float64(sampleRate) / float64(hop))
+	chunks := make([]analysisChunk, count)
+	for i := range chunks {
+		start := i * totalHops / count * hop
+		end := (i + 1) * totalHops / count * hop
+		if i == count-1 {
+			end = numSamples
+		}
+		chunks[i] = analysisChunk{warmup: max(start-warmupHops*hop, 0), start: start, end: end}
+	}
+	return chunks
+}
+
+// feedChunk runs detection on the samples of chunk, sending the number of
+// samples of the chunk analyzed to done every 64 hops. The hop ending at the
+// end of the chunk is processed, since the sample following it is fed.
+func (d *hopDetector) feedChunk(samples []float64, chunk analysisChunk, done chan<- int) {
+	d.pos = uint64(chunk.warmup)
+	d.offset = float64(chunk.warmup) / float64(d.sampleRate)
+	d.recordFrom = uint64(chunk.start)
+	if d.recordCurve {
+		d.curve = make([]DetectionFrame, 0, uint(chunk.end-chunk.start)/d.hopSize)
+	}
+
+	end := min(chunk.end+1, len(samples))
+	block := int(d.hopSize) * 64
+	for start := chunk.warmup; start < end; start += block {
+		blockEnd := min(start+block, end)
+		d.write(samples[start:blockEnd])
+		if analyzed := min(blockEnd, chunk.end) - max(start, chunk.start); analyzed > 0 {
+			done <- analyzed
+		}
+	}
+}
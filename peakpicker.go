@@ -1,5 +1,41 @@
 package onset
 
+import (
+	"math"
+	"sort"
+)
+
+// PeakPickerInterface is implemented by peak pickers, which turn the
+// detection function into onsets. Do receives the detection function value of
+// a frame in desc.Data[0] and stores in out.Data[0] either 0 or the position
+// of an onset relative to the frame, in hops (as the built-in PeakPicker,
+// which reports a peak one frame late, giving values around 1). Pickers can
+// also implement SetThreshold(float64), GetThreshold() float64 and
+// GetThresholdedInput() *Fvec to support the threshold methods of Onset, and
+// Lookahead() uint, the number of frames by which peaks are reported late, so
+// that onset strengths are read at the peak.
+// The delay of Onset is tuned for the latency of the built-in picker; adjust
+// it with SetDelay for pickers with a different latency.
+type PeakPickerInterface interface {
+	Do(desc *Fvec, out *Fvec)
+	Reset()
+}
+
+// clonePeakPicker returns a copy of p with a cleared history. The built-in
+// pickers and pickers with a Clone() PeakPickerInterface method are cloned;
+// other pickers are returned as is.
+func clonePeakPicker(p PeakPickerInterface) PeakPickerInterface {
+	switch p := p.(type) {
+	case *PeakPicker:
+		return p.Clone()
+	case *LibrosaPeakPicker:
+		return p.Clone()
+	case interface{ Clone() PeakPickerInterface }:
+		return p.Clone()
+	}
+	return p
+}
+
 // PeakPicker represents a peak picking object for onset detection
 type PeakPicker struct {
 	Threshold   float64
@@ -11,6 +47,19 @@ type PeakPicker struct {
 	OnsetPeek   *Fvec
 	Thresholded *Fvec
 	Scratch     *Fvec
+	// AdaptiveFrames is the length of the long adaptive threshold window in
+	// frames, 0 if disabled
+	AdaptiveFrames uint
+	// AdaptivePercentile is the percentile of the detection function over
+	// the long window that onsets must exceed
+	AdaptivePercentile float64
+
+	// history holds the detection function over the long window
+	history []float64
+	// historyPos is the next position to write in history
+	historyPos int
+	// sorted is scratch space for computing the percentile
+	sorted []float64
 }
 
 // NewPeakPicker creates a new peak picker
@@ -21,10 +70,7 @@ func NewPeakPicker() *PeakPicker {
 		WinPre:    1,
 	}
 
-	bufSize := p.WinPost + p.WinPre + 1
-	p.Scratch = NewFvec(bufSize)
-	p.OnsetKeep = NewFvec(bufSize)
-	p.OnsetProc = NewFvec(bufSize)
+	p.resize()
 	p.OnsetPeek = NewFvec(3)
 	p.Thresholded = NewFvec(1)
 
@@ -44,14 +90,16 @@ func (p *PeakPicker) Do(onset *Fvec, out *Fvec) {
 	p.OnsetProc.Copy(p.OnsetKeep)
 
 	// Filter this copy
-	p.Biquad.DoFiltFilt(p.OnsetProc, p.Scratch)
+	if p.Biquad != nil {
+		p.Biquad.DoFiltFilt(p.OnsetProc, p.Scratch)
+	}
 
 	// Calculate mean
 	mean := FvecMean(p.OnsetProc)
 
-	// Calculate median
+	// Calculate median, partially sorting the scratch copy
 	p.Scratch.Copy(p.OnsetProc)
-	median := FvecMedian(p.Scratch)
+	median := medianInPlace(p.Scratch.Data)
 
 	// Shift peek array
 	for j := uint(0); j < 2; j++ {
@@ -60,6 +108,13 @@ func (p *PeakPicker) Do(onset *Fvec, out *Fvec) {
 
 	// Calculate new thresholded value
 	p.Thresholded.Data[0] = p.OnsetProc.Data[p.WinPost] - median - mean*p.Threshold
+	// Onsets must also exceed a percentile of the smoothed detection function
+	// over the long window
+	if p.AdaptiveFrames > 0 {
+		p.pushHistory(p.OnsetProc.Data[p.WinPost])
+		floor := p.OnsetProc.Data[p.WinPost] - p.historyPercentile()
+		p.Thresholded.Data[0] = math.Min(p.Thresholded.Data[0], floor)
+	}
 	p.OnsetPeek.Data[2] = p.Thresholded.Data[0]
 
 	// Check for peak
@@ -70,6 +125,126 @@ func (p *PeakPicker) Do(onset *Fvec, out *Fvec) {
 	}
 }
 
+// Lookahead returns the number of frames by which peaks are reported late:
+// the peak of the smoothed detection function is found WinPre+1 frames after
+// the frame it peaks in
+func (p *PeakPicker) Lookahead() uint {
+	return p.WinPre + 1
+}
+
+// Reset clears the history of the detection function
+func (p *PeakPicker) Reset() {
+	p.OnsetKeep.Zeros()
+	p.OnsetProc.Zeros()
+	p.OnsetPeek.Zeros()
+	p.Thresholded.Zeros()
+	p.Scratch.Zeros()
+	if p.Biquad != nil {
+		p.Biquad.Reset()
+	}
+	p.history = p.history[:0]
+	p.historyPos = 0
+}
+
+// SetAdaptiveThreshold makes onsets also exceed the given percentile (0 to
+// 100) of the smoothed detection function over the last frames, in addition to the
+// short-window threshold. Over a window of several seconds, this adapts the
+// sensitivity to the dynamics of the music: weak hits are still detected in
+// quiet passages, while busy loud passages need stronger onsets. A window of 0
+// frames disables it. The history is cleared.
+func (p *PeakPicker) SetAdaptiveThreshold(frames uint, percentile float64) {
+	p.AdaptiveFrames = frames
+	p.AdaptivePercentile = math.Max(0, math.Min(100, percentile))
+	p.history = make([]float64, 0, frames)
+	p.historyPos = 0
+	p.sorted = make([]float64, 0, frames)
+}
+
+// pushHistory adds a value of the detection function to the long window
+func (p *PeakPicker) pushHistory(v float64) {
+	if uint(len(p.history)) < p.AdaptiveFrames {
+		p.history = append(p.history, v)
+		return
+	}
+	p.history[p.historyPos] = v
+	p.historyPos = (p.historyPos + 1) % len(p.history)
+}
+
+// historyPercentile returns the adaptive percentile of the long window,
+// interpolating between ranks
+func (p *PeakPicker) historyPercentile() float64 {
+	if len(p.history) == 0 {
+		return 0
+	}
+	p.sorted = append(p.sorted[:0], p.history...)
+	sort.Float64s(p.sorted)
+	rank := p.AdaptivePercentile / 100 * float64(len(p.sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(p.sorted) {
+		return p.sorted[len(p.sorted)-1]
+	}
+	frac := rank - float64(lower)
+	return p.sorted[lower]*(1-frac) + p.sorted[lower+1]*frac
+}
+
+// resize allocates the buffers of the detection function window
+func (p *PeakPicker) resize() {
+	bufSize := p.WinPost + p.WinPre + 1
+	p.Scratch = NewFvec(bufSize)
+	p.OnsetKeep = NewFvec(bufSize)
+	p.OnsetProc = NewFvec(bufSize)
+}
+
+// SetWinPost sets the number of past frames of the detection function used to
+// compute the adaptive threshold, and clears the history. Default is 5.
+func (p *PeakPicker) SetWinPost(winPost uint) {
+	p.WinPost = winPost
+	p.resize()
+}
+
+// GetWinPost returns the number of past frames of the threshold window
+func (p *PeakPicker) GetWinPost() uint {
+	return p.WinPost
+}
+
+// SetWinPre sets the number of future frames of the detection function used to
+// compute the adaptive threshold, and clears the history. Each frame of
+// lookahead delays detections by one hop; 0 gives the lowest latency at the
+// cost of stability. Default is 1.
+func (p *PeakPicker) SetWinPre(winPre uint) {
+	p.WinPre = winPre
+	p.resize()
+}
+
+// GetWinPre returns the number of future frames of the threshold window
+func (p *PeakPicker) GetWinPre() uint {
+	return p.WinPre
+}
+
+// SetSmoothingFilter sets the filter applied forward and backward to the
+// detection function window before thresholding, by default a second-order
+// Butterworth lowpass. A nil filter disables smoothing.
+func (p *PeakPicker) SetSmoothingFilter(f *Filter) {
+	p.Biquad = f
+}
+
+// Clone returns a peak picker with the same parameters and a cleared history
+func (p *PeakPicker) Clone() *PeakPicker {
+	c := &PeakPicker{
+		Threshold:   p.Threshold,
+		WinPost:     p.WinPost,
+		WinPre:      p.WinPre,
+		OnsetPeek:   NewFvec(3),
+		Thresholded: NewFvec(1),
+	}
+	c.resize()
+	if p.Biquad != nil {
+		c.Biquad = p.Biquad.Clone()
+	}
+	c.SetAdaptiveThreshold(p.AdaptiveFrames, p.AdaptivePercentile)
+	return c
+}
+
 // SetThreshold sets the peak picking threshold
 func (p *PeakPicker) SetThreshold(threshold float64) {
 	p.Threshold = threshold
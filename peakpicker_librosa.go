@@ -0,0 +1,174 @@
+package onset
+
+import "math"
+
+// LibrosaPeakParams holds the parameters of librosa-style peak picking, with
+// the semantics of librosa.util.peak_pick. Windows are in frames (hops).
+type LibrosaPeakParams struct {
+	// PreMax and PostMax delimit the window [n-PreMax, n+PostMax) in which a
+	// peak must be the maximum
+	PreMax  uint
+	PostMax uint
+	// PreAvg and PostAvg delimit the window [n-PreAvg, n+PostAvg) whose mean
+	// a peak must exceed by Delta
+	PreAvg  uint
+	PostAvg uint
+	// Delta is the offset above the local mean. Onset.SetThreshold sets it.
+	Delta float64
+	// Wait is the number of frames to skip after a peak
+	Wait uint
+	// Normalize divides the detection function by its running maximum, so
+	// that Delta is relative as in librosa.onset.onset_detect, which
+	// normalizes the whole envelope to [0, 1]
+	Normalize bool
+}
+
+// DefaultLibrosaPeakParams returns the defaults of librosa.onset.onset_detect
+// converted to frames of the given hop size
+func DefaultLibrosaPeakParams(samplerate, hopSize uint) LibrosaPeakParams {
+	frames := func(seconds float64) uint {
+		return uint(seconds * float64(samplerate) / float64(hopSize))
+	}
+	return LibrosaPeakParams{
+		PreMax:    frames(0.03),
+		PostMax:   frames(0.0) + 1,
+		PreAvg:    frames(0.10),
+		PostAvg:   frames(0.10) + 1,
+		Delta:     0.07,
+		Wait:      frames(0.03),
+		Normalize: true,
+	}
+}
+
+// LibrosaPeakPicker picks peaks of the detection function like
+// librosa.util.peak_pick, for parity with Python pipelines. Frames are
+// evaluated once the end of their windows has been received, so detections
+// are delayed by max(PostMax, PostAvg)-1 frames.
+type LibrosaPeakPicker struct {
+	params LibrosaPeakParams
+	// history holds the latest values of the detection function
+	history []float64
+	// frames is the number of frames received
+	frames int
+	// lastPeak is the frame of the last peak, or -1
+	lastPeak int
+	// peak is the running maximum used for normalization
+	peak        float64
+	thresholded *Fvec
+}
+
+// NewLibrosaPeakPicker creates a librosa-style peak picker
+func NewLibrosaPeakPicker(params LibrosaPeakParams) *LibrosaPeakPicker {
+	p := &LibrosaPeakPicker{params: params, thresholded: NewFvec(1)}
+	p.Reset()
+	return p
+}
+
+// Lookahead returns the number of frames by which detections are delayed
+func (p *LibrosaPeakPicker) Lookahead() uint {
+	return max(p.params.PostMax, p.params.PostAvg, 1) - 1
+}
+
+// Do pushes the detection function value of a frame and sets out.Data[0] to 1
+// if the frame Lookahead frames back is a peak, or to 0 otherwise
+func (p *LibrosaPeakPicker) Do(desc *Fvec, out *Fvec) {
+	v := desc.Data[0]
+	p.peak = math.Max(p.peak, v)
+	size := int(max(p.params.PreMax, p.params.PreAvg) + p.Lookahead() + 1)
+	if len(p.history) < size {
+		p.history = append(p.history, v)
+	} else {
+		// Shift in place so that the history does not grow
+		copy(p.history, p.history[len(p.history)-size+1:])
+		p.history = p.history[:size]
+		p.history[size-1] = v
+	}
+	p.frames++
+	out.Data[0] = 0
+	p.thresholded.Data[0] = 0
+
+	n := p.frames - 1 - int(p.Lookahead())
+	if n < 0 {
+		return
+	}
+	// at returns the value of frame k
+	at := func(k int) float64 {
+		return p.history[len(p.history)-(p.frames-k)]
+	}
+	scale := 1.0
+	if p.params.Normalize && p.peak > 0 {
+		scale = 1 / p.peak
+	}
+	x := at(n)
+
+	maxStart, maxEnd := max(n-int(p.params.PreMax), 0), min(n+int(p.params.PostMax), p.frames)
+	for k := maxStart; k < maxEnd; k++ {
+		if at(k) > x {
+			return
+		}
+	}
+
+	avgStart, avgEnd := max(n-int(p.params.PreAvg), 0), min(n+int(p.params.PostAvg), p.frames)
+	mean := 0.0
+	for k := avgStart; k < avgEnd; k++ {
+		mean += at(k)
+	}
+	if avgEnd > avgStart {
+		mean /= float64(avgEnd - avgStart)
+	}
+	p.thresholded.Data[0] = (x-mean)*scale - p.params.Delta
+	if p.thresholded.Data[0] < 0 {
+		return
+	}
+
+	if p.lastPeak >= 0 && n <= p.lastPeak+int(p.params.Wait) {
+		return
+	}
+	p.lastPeak = n
+	out.Data[0] = 1
+}
+
+// Reset clears the history of the detection function
+func (p *LibrosaPeakPicker) Reset() {
+	p.history = p.history[:0]
+	p.frames = 0
+	p.lastPeak = -1
+	p.peak = 0
+	p.thresholded.Zeros()
+}
+
+// Clone returns a picker with the same parameters and a cleared history
+func (p *LibrosaPeakPicker) Clone() *LibrosaPeakPicker {
+	return NewLibrosaPeakPicker(p.params)
+}
+
+// SetThreshold sets Delta
+func (p *LibrosaPeakPicker) SetThreshold(threshold float64) {
+	p.params.Delta = threshold
+}
+
+// GetThreshold returns Delta
+func (p *LibrosaPeakPicker) GetThreshold() float64 {
+	return p.params.Delta
+}
+
+// GetThresholdedInput returns the amount by which the last evaluated frame
+// exceeds the local mean plus Delta
+func (p *LibrosaPeakPicker) GetThresholdedInput() *Fvec {
+	return p.thresholded
+}
+
+// SetLibrosaPeakPicking switches the detector to librosa-style peak picking
+// with the given parameters, adjusting the delay to the lookahead of the
+// picker so that onset times stay in place. It can be called again to change
+// the parameters. Use SetPeakPicker(nil) to restore the built-in picker and
+// its delay.
+func (o *Onset) SetLibrosaPeakPicking(params LibrosaPeakParams) {
+	p := NewLibrosaPeakPicker(params)
+	// Replacing the picker restores the delay of the built-in one, which
+	// reports peaks 2+WinPre frames late at position ~1
+	o.SetPeakPicker(p)
+	delay := max(int64(o.Delay)+(int64(p.Lookahead())-int64(o.Pp.WinPre)-1)*int64(o.HopSize), 0)
+	o.pickerShift = delay - int64(o.Delay)
+	o.SetDelay(uint64(delay))
+}
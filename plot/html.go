@@ -0,0 +1,340 @@
+package plot
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"reflect"
+
+	"github.com/schollz/onsets"
+)
+
+// reportColumns is the number of min/max pairs of the waveform embedded in
+// HTML reports, which bounds the size of the file for long recordings
+const reportColumns = 4000
+
+// reportData is the content of an HTML report, embedded as JSON for the
+// script drawing the waveform
+type reportData struct {
+	SampleRate uint          `json:"sampleRate"`
+	Duration   float64       `json:"duration"`
+	WaveMin    []float64     `json:"waveMin"`
+	WaveMax    []float64     `json:"waveMax"`
+	Onsets     []float64     `json:"onsets"`
+	Novelty    []float64     `json:"novelty"`
+	NoveltyHop float64       `json:"noveltyHop"`
+	Slices     []reportSlice `json:"slices"`
+}
+
+// reportSlice holds the statistics of one slice of an HTML report
+type reportSlice struct {
+	Index    int     `json:"index"`
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Duration float64 `json:"duration"`
+	Peak     float64 `json:"peak"`
+	RMS      float64 `json:"rms"`
+}
+
+// reportOption is one row of the options table of an HTML report
+type reportOption struct {
+	Name  string
+	Value string
+}
+
+// WriteHTMLReport writes a standalone HTML page presenting an analysis
+// result, for sharing it with people who do not use the library: an
+// interactive waveform with the onsets (scroll to zoom, drag to pan, click a
+// slice to zoom to it), the detection function, statistics of every slice and
+// the options of the analysis. The page needs no network access. Results of
+// streaming analysis are drawn from their Preview, without slice levels.
+func WriteHTMLReport(result *onset.SliceAnalyzerResult, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create report: %w", err)
+	}
+	if err := renderHTMLReport(f, result); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// renderHTMLReport writes the HTML report of result to w
+func renderHTMLReport(w io.Writer, result *onset.SliceAnalyzerResult) error {
+	samples, decimation := result.Samples, 1
+	numSamples := len(samples)
+	if numSamples == 0 && len(result.Preview) > 0 {
+		samples, decimation = result.Preview, result.PreviewDecimation
+		numSamples = result.NumSamples
+	}
+	if numSamples == 0 || result.SampleRate == 0 {
+		return fmt.Errorf("result has no samples to report")
+	}
+
+	data := reportData{
+		SampleRate: result.SampleRate,
+		Duration:   float64(numSamples) / float64(result.SampleRate),
+		Onsets:     result.Onsets,
+	}
+	if data.Onsets == nil {
+		data.Onsets = []float64{}
+	}
+
+	// Keep the extremes of the samples falling in every column
+	columns := min(reportColumns, len(samples))
+	data.WaveMin = make([]float64, columns)
+	data.WaveMax = make([]float64, columns)
+	for c := range columns {
+		start := c * len(samples) / columns
+		end := max((c+1)*len(samples)/columns, start+1)
+		lo, hi := samples[start], samples[start]
+		for _, v := range samples[start:end] {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+		data.WaveMin[c], data.WaveMax[c] = roundReport(lo), roundReport(hi)
+	}
+
+	// The detection function is normalized to its maximum
+	peak := 0.0
+	for _, frame := range result.Detection {
+		peak = math.Max(peak, frame.Descriptor)
+	}
+	data.Novelty = make([]float64, len(result.Detection))
+	for i, frame := range result.Detection {
+		if peak > 0 {
+			data.Novelty[i] = roundReport(frame.Descriptor / peak)
+		}
+	}
+	if len(result.Detection) > 1 {
+		data.NoveltyHop = result.Detection[1].Time - result.Detection[0].Time
+	}
+
+	for i, r := range result.SliceRanges() {
+		slice := reportSlice{
+			Index:    i + 1,
+			Start:    float64(r.Start) / float64(result.SampleRate),
+			End:      float64(r.End) / float64(result.SampleRate),
+			Duration: float64(r.End-r.Start) / float64(result.SampleRate),
+		}
+		if len(result.Samples) > 0 {
+			sum := 0.0
+			for _, v := range result.Samples[r.Start:r.End] {
+				slice.Peak = math.Max(slice.Peak, math.Abs(v))
+				sum += v * v
+			}
+			if r.End > r.Start {
+				slice.RMS = math.Sqrt(sum / float64(r.End-r.Start))
+			}
+		} else {
+			lo := min(r.Start/decimation, len(samples))
+			hi := max(min(r.End/decimation+1, len(samples)), lo)
+			for _, v := range samples[lo:hi] {
+				slice.Peak = math.Max(slice.Peak, math.Abs(v))
+			}
+		}
+		data.Slices = append(data.Slices, slice)
+	}
+
+	return reportTemplate.Execute(w, struct {
+		Data       reportData
+		Options    []reportOption
+		HasRMS     bool
+		NumSamples int
+	}{data, reportOptions(result.Options), len(result.Samples) > 0, numSamples})
+}
+
+// roundReport rounds v to 4 decimals, which is below the resolution of the
+// drawing and keeps the embedded data compact
+func roundReport(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
+
+// reportOptions lists the options of an analysis for the options table,
+// skipping callbacks and fields not serialized to JSON
+func reportOptions(options onset.SliceAnalyzerOptions) []reportOption {
+	var rows []reportOption
+	v := reflect.ValueOf(options)
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+		if field.Tag.Get("json") == "-" || value.Kind() == reflect.Func {
+			continue
+		}
+		text := fmt.Sprint(value.Interface())
+		switch {
+		case value.Kind() == reflect.Pointer && value.IsNil():
+			text = "none"
+		case value.Kind() == reflect.Pointer:
+			text = fmt.Sprintf("%+v", value.Elem().Interface())
+		case value.Kind() == reflect.Slice && value.Len() == 0:
+			text = "none"
+		case value.Kind() == reflect.String && text == "":
+			text = "none"
+		}
+		rows = append(rows, reportOption{Name: field.Name, Value: text})
+	}
+	return rows
+}
+
+// reportTemplate is the page of HTML reports. The script draws the waveform,
+// onsets and detection function on a canvas from the embedded data.
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Onset analysis report</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 2em; }
+canvas { width: 100%; height: 320px; border: 1px solid #c8c8c8; cursor: grab; }
+#cursor { color: #666; font-size: 0.9em; height: 1.2em; }
+table { border-collapse: collapse; font-size: 0.9em; }
+th, td { padding: 0.2em 0.8em; text-align: right; border-bottom: 1px solid #eee; }
+th:first-child, td:first-child { text-align: left; }
+#slices tbody tr { cursor: pointer; }
+#slices tbody tr:hover, #slices tbody tr.selected { background: #fde9e9; }
+</style>
+</head>
+<body>
+<h1>Onset analysis report</h1>
+<p>{{len .Data.Onsets}} onsets in {{printf "%.2f" .Data.Duration}} s ({{.NumSamples}} samples at {{.Data.SampleRate}} Hz).
+Scroll to zoom, drag to pan, double-click to show everything.</p>
+<canvas id="wave"></canvas>
+<div id="cursor"></div>
+
+<h2>Slices</h2>
+<table id="slices">
+<thead><tr><th>#</th><th>Start (s)</th><th>End (s)</th><th>Duration (s)</th><th>Peak</th>{{if .HasRMS}}<th>RMS</th>{{end}}</tr></thead>
+<tbody>
+{{range .Data.Slices}}<tr data-index="{{.Index}}"><td>{{.Index}}</td><td>{{printf "%.3f" .Start}}</td><td>{{printf "%.3f" .End}}</td><td>{{printf "%.3f" .Duration}}</td><td>{{printf "%.3f" .Peak}}</td>{{if $.HasRMS}}<td>{{printf "%.3f" .RMS}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+
+<h2>Options</h2>
+<table>
+{{range .Options}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+
+<script>
+const data = {{.Data}};
+const canvas = document.getElementById("wave");
+const ctx = canvas.getContext("2d");
+let view = {start: 0, end: data.duration};
+let selected = null;
+
+function draw() {
+  const ratio = window.devicePixelRatio || 1;
+  canvas.width = canvas.clientWidth * ratio;
+  canvas.height = canvas.clientHeight * ratio;
+  const w = canvas.width, h = canvas.height;
+  const waveH = data.novelty.length ? h * 2 / 3 : h;
+  const span = view.end - view.start;
+  const x = t => (t - view.start) / span * w;
+  ctx.fillStyle = "#fff";
+  ctx.fillRect(0, 0, w, h);
+
+  if (selected) {
+    ctx.fillStyle = "#fde9e9";
+    ctx.fillRect(x(selected.start), 0, x(selected.end) - x(selected.start), h);
+  }
+
+  // Waveform: extremes of the columns falling in every pixel
+  const mid = waveH / 2, cols = data.waveMin.length;
+  ctx.fillStyle = "#c8c8c8";
+  ctx.fillRect(0, mid, w, ratio);
+  ctx.fillStyle = "#1f3a5f";
+  for (let px = 0; px < w; px++) {
+    const c0 = Math.floor((view.start + span * px / w) / data.duration * cols);
+    const c1 = Math.max(Math.floor((view.start + span * (px + 1) / w) / data.duration * cols), c0 + 1);
+    let lo = Infinity, hi = -Infinity;
+    for (let c = Math.max(c0, 0); c < Math.min(c1, cols); c++) {
+      lo = Math.min(lo, data.waveMin[c]);
+      hi = Math.max(hi, data.waveMax[c]);
+    }
+    if (hi >= lo) {
+      const top = mid - Math.min(hi, 1) * mid, bottom = mid - Math.max(lo, -1) * mid;
+      ctx.fillRect(px, top, 1, Math.max(bottom - top, 1));
+    }
+  }
+
+  // Detection function, normalized to its maximum
+  if (data.novelty.length) {
+    ctx.fillStyle = "#c8c8c8";
+    ctx.fillRect(0, waveH, w, ratio);
+    ctx.fillStyle = "#ff7f0e";
+    const panel = h - waveH;
+    for (let px = 0; px < w; px++) {
+      const i0 = Math.floor((view.start + span * px / w) / data.noveltyHop);
+      const i1 = Math.max(Math.floor((view.start + span * (px + 1) / w) / data.noveltyHop), i0 + 1);
+      let v = 0;
+      for (let i = Math.max(i0, 0); i < Math.min(i1, data.novelty.length); i++) {
+        v = Math.max(v, data.novelty[i]);
+      }
+      ctx.fillRect(px, h - v * panel, 1, v * panel);
+    }
+  }
+
+  ctx.fillStyle = "#d62728";
+  for (const t of data.onsets) {
+    const px = x(t);
+    if (px >= 0 && px < w) {
+      ctx.fillRect(px, 0, ratio, h);
+    }
+  }
+}
+
+function timeAt(event) {
+  const rect = canvas.getBoundingClientRect();
+  return view.start + (event.clientX - rect.left) / rect.width * (view.end - view.start);
+}
+
+function setView(start, end) {
+  const span = Math.min(Math.max(end - start, 0.01), data.duration);
+  start = Math.min(Math.max(start, 0), data.duration - span);
+  view = {start: start, end: start + span};
+  draw();
+}
+
+canvas.addEventListener("wheel", event => {
+  event.preventDefault();
+  const t = timeAt(event), factor = event.deltaY < 0 ? 0.8 : 1.25;
+  setView(t - (t - view.start) * factor, t + (view.end - t) * factor);
+});
+
+let drag = null;
+canvas.addEventListener("mousedown", event => { drag = {x: event.clientX, view: view}; });
+window.addEventListener("mouseup", () => { drag = null; });
+canvas.addEventListener("mousemove", event => {
+  document.getElementById("cursor").textContent = timeAt(event).toFixed(3) + " s";
+  if (drag) {
+    const shift = (drag.x - event.clientX) / canvas.clientWidth * (drag.view.end - drag.view.start);
+    setView(drag.view.start + shift, drag.view.end + shift);
+  }
+});
+canvas.addEventListener("dblclick", () => { selected = null; setView(0, data.duration); });
+
+for (const row of document.querySelectorAll("#slices tbody tr")) {
+  row.addEventListener("click", () => {
+    for (const other of document.querySelectorAll("#slices tbody tr.selected")) {
+      other.classList.remove("selected");
+    }
+    row.classList.add("selected");
+    selected = data.slices[row.dataset.index - 1];
+    const margin = (selected.end - selected.start) * 0.25;
+    setView(selected.start - margin, selected.end + margin);
+  });
+}
+
+window.addEventListener("resize", draw);
+draw();
+</script>
+</body>
+</html>
+`))
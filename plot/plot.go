@@ -0,0 +1,192 @@
+// Package plot renders onset analysis results as images.
+package plot
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/schollz/onsets"
+)
+
+// Colors used by PlotResult
+var (
+	backgroundColor = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	axisColor       = color.RGBA{R: 0xc8, G: 0xc8, B: 0xc8, A: 0xff}
+	waveformColor   = color.RGBA{R: 0x1f, G: 0x3a, B: 0x5f, A: 0xff}
+	onsetColor      = color.RGBA{R: 0xd6, G: 0x27, B: 0x28, A: 0xff}
+	noveltyColor    = color.RGBA{R: 0xff, G: 0x7f, B: 0x0e, A: 0xff}
+	gridColor       = color.RGBA{R: 0xeb, G: 0xeb, B: 0xeb, A: 0xff}
+)
+
+// gridSpacing is the approximate distance in pixels between the time lines of
+// the grid
+const gridSpacing = 100
+
+// PlotOptions contains configuration options for PlotResult
+type PlotOptions struct {
+	// Width is the image width in pixels. Default is 1200 if 0.
+	Width int
+	// Height is the image height in pixels. Default is 400 if 0.
+	Height int
+	// ShowNovelty draws the detection function (result.Detection) in a panel
+	// below the waveform, taking a third of the height
+	ShowNovelty bool
+	// ShowGrid draws a grid behind the waveform: vertical lines at round
+	// times, about every 100 pixels, and horizontal lines at half amplitude
+	ShowGrid bool
+}
+
+// PlotResult renders the waveform of an analysis result with a vertical marker
+// at every onset. Results of streaming analysis are drawn from their Preview.
+func PlotResult(result *onset.SliceAnalyzerResult, options PlotOptions) (image.Image, error) {
+	width, height := options.Width, options.Height
+	if width == 0 {
+		width = 1200
+	}
+	if height == 0 {
+		height = 400
+	}
+	if width < 0 || height < 0 {
+		return nil, fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+
+	samples, decimation := result.Samples, 1
+	numSamples := len(samples)
+	if numSamples == 0 && len(result.Preview) > 0 {
+		samples, decimation = result.Preview, result.PreviewDecimation
+		numSamples = result.NumSamples
+	}
+	if numSamples == 0 || result.SampleRate == 0 {
+		return nil, fmt.Errorf("result has no samples to plot")
+	}
+	if options.ShowNovelty && len(result.Detection) == 0 {
+		return nil, fmt.Errorf("result has no detection curve to plot")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(img, img.Bounds(), backgroundColor)
+
+	waveHeight := height
+	if options.ShowNovelty {
+		waveHeight = height * 2 / 3
+	}
+	duration := float64(numSamples) / float64(result.SampleRate)
+	if options.ShowGrid {
+		for _, x := range gridTimes(duration, width) {
+			fillRect(img, image.Rect(x, 0, x+1, height), gridColor)
+		}
+		for _, y := range []int{waveHeight / 4, waveHeight * 3 / 4} {
+			fillRect(img, image.Rect(0, y, width, y+1), gridColor)
+		}
+	}
+	drawWaveform(img, image.Rect(0, 0, width, waveHeight), samples, decimation, numSamples)
+	if options.ShowNovelty {
+		panel := image.Rect(0, waveHeight, width, height)
+		fillRect(img, image.Rect(0, waveHeight, width, waveHeight+1), axisColor)
+		drawNovelty(img, panel, result.Detection, duration)
+	}
+
+	// Onset markers span every panel
+	for _, onsetTime := range result.Onsets {
+		x := int(onsetTime / duration * float64(width))
+		if x >= 0 && x < width {
+			fillRect(img, image.Rect(x, 0, x+1, height), onsetColor)
+		}
+	}
+
+	return img, nil
+}
+
+// drawWaveform draws the minimum and maximum of the samples falling in every
+// column of r. Each sample stands for decimation samples of the original signal.
+func drawWaveform(img *image.RGBA, r image.Rectangle, samples []float64, decimation int, numSamples int) {
+	mid := r.Min.Y + r.Dy()/2
+	fillRect(img, image.Rect(r.Min.X, mid, r.Max.X, mid+1), axisColor)
+
+	scale := float64(r.Dy()) / 2
+	for x := 0; x < r.Dx(); x++ {
+		start := x * numSamples / r.Dx() / decimation
+		end := (x + 1) * numSamples / r.Dx() / decimation
+		end = min(max(end, start+1), len(samples))
+		if start >= end {
+			continue
+		}
+
+		lo, hi := samples[start], samples[start]
+		for _, v := range samples[start:end] {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+		top := mid - int(math.Min(hi, 1)*scale)
+		bottom := mid - int(math.Max(lo, -1)*scale)
+		fillRect(img, image.Rect(r.Min.X+x, top, r.Min.X+x+1, bottom+1).Intersect(r), waveformColor)
+	}
+}
+
+// drawNovelty draws the detection function as a filled area scaled to its maximum
+func drawNovelty(img *image.RGBA, r image.Rectangle, detection []onset.DetectionFrame, duration float64) {
+	peak := 0.0
+	for _, frame := range detection {
+		peak = math.Max(peak, frame.Descriptor)
+	}
+	if peak <= 0 {
+		return
+	}
+
+	// Keep the maximum descriptor value of the frames in every column
+	columns := make([]float64, r.Dx())
+	filled := make([]bool, r.Dx())
+	for _, frame := range detection {
+		x := int(frame.Time / duration * float64(r.Dx()))
+		if x >= 0 && x < len(columns) {
+			columns[x] = math.Max(columns[x], frame.Descriptor/peak)
+			filled[x] = true
+		}
+	}
+
+	// Columns narrower than a hop repeat the previous frame
+	for x := 1; x < len(columns); x++ {
+		if !filled[x] {
+			columns[x] = columns[x-1]
+		}
+	}
+
+	for x, v := range columns {
+		top := r.Max.Y - int(v*float64(r.Dy()-1))
+		fillRect(img, image.Rect(r.Min.X+x, top, r.Min.X+x+1, r.Max.Y), noveltyColor)
+	}
+}
+
+// gridTimes returns the columns of the time lines of the grid of an image
+// width pixels wide showing duration seconds, at multiples of a round step of
+// 1, 2 or 5 times a power of ten
+func gridTimes(duration float64, width int) []int {
+	if duration <= 0 || width <= 0 {
+		return nil
+	}
+	target := duration * gridSpacing / float64(width)
+	step := math.Pow(10, math.Floor(math.Log10(target)))
+	for _, factor := range []float64{1, 2, 5, 10} {
+		if step*factor >= target {
+			step *= factor
+			break
+		}
+	}
+
+	var columns []int
+	for i := 1; float64(i)*step < duration; i++ {
+		columns = append(columns, int(float64(i)*step/duration*float64(width)))
+	}
+	return columns
+}
+
+// fillRect fills r with c
+func fillRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package plot
+
+import (
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/schollz/onsets"
+)
+
+func TestPlotResult(t *testing.T) {
+	sampleRate := uint(1000)
+	samples := make([]float64, 2000)
+	for i := 1000; i < len(samples); i++ {
+		samples[i] = 0.8 * math.Sin(float64(i)*0.3)
+	}
+	result := &onset.SliceAnalyzerResult{
+		Onsets:     []float64{1.0},
+		Samples:    samples,
+		SampleRate: sampleRate,
+		NumSamples: len(samples),
+		Detection: []onset.DetectionFrame{
+			{Time: 0.5, Descriptor: 0.1},
+			{Time: 1.0, Descriptor: 1.0, Onset: true},
+		},
+	}
+
+	img, err := PlotResult(result, PlotOptions{Width: 200, Height: 90, ShowNovelty: true})
+	if err != nil {
+		t.Fatalf("PlotResult failed: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 200 || b.Dy() != 90 {
+		t.Fatalf("Expected 200x90 image, got %v", b)
+	}
+
+	if c := color.RGBAModel.Convert(img.At(100, 5)); c != onsetColor {
+		t.Errorf("Expected onset marker at x=100, got %v", c)
+	}
+	if c := color.RGBAModel.Convert(img.At(150, 30)); c != waveformColor {
+		t.Errorf("Expected waveform at the center of the loud half, got %v", c)
+	}
+	if c := color.RGBAModel.Convert(img.At(50, 10)); c != backgroundColor {
+		t.Errorf("Expected background above the silent half, got %v", c)
+	}
+	if c := color.RGBAModel.Convert(img.At(50, 88)); c != noveltyColor {
+		t.Errorf("Expected novelty curve at the bottom, got %v", c)
+	}
+
+	result.Onsets = nil
+	img, err = PlotResult(result, PlotOptions{Width: 200, Height: 90, ShowGrid: true})
+	if err != nil {
+		t.Fatalf("PlotResult with grid failed: %v", err)
+	}
+	if c := color.RGBAModel.Convert(img.At(100, 5)); c != gridColor {
+		t.Errorf("Expected grid line at 1 s, got %v", c)
+	}
+	if c := color.RGBAModel.Convert(img.At(20, 22)); c != gridColor {
+		t.Errorf("Expected grid line at half amplitude, got %v", c)
+	}
+	if got := gridTimes(10, 1000); !slices.Equal(got, []int{100, 200, 300, 400, 500, 600, 700, 800, 900}) {
+		t.Errorf("Expected a line every second, got %v", got)
+	}
+	if got := gridTimes(3, 1200); !slices.Equal(got, []int{200, 400, 600, 800, 1000}) {
+		t.Errorf("Expected a line every 0.5 s, got %v", got)
+	}
+
+	if _, err := PlotResult(&onset.SliceAnalyzerResult{SampleRate: sampleRate}, PlotOptions{}); err == nil {
+		t.Error("Expected error for a result without samples, got nil")
+	}
+	result.Detection = nil
+	if _, err := PlotResult(result, PlotOptions{ShowNovelty: true}); err == nil {
+		t.Error("Expected error for a result without detection curve, got nil")
+	}
+}
+
+func TestWriteHTMLReport(t *testing.T) {
+	sampleRate := uint(1000)
+	samples := make([]float64, 2000)
+	for i := 1000; i < len(samples); i++ {
+		samples[i] = 0.8 * math.Sin(float64(i)*0.3)
+	}
+	options := onset.DefaultSliceAnalyzerOptions()
+	result := &onset.SliceAnalyzerResult{
+		Onsets:     []float64{0, 1.0},
+		Samples:    samples,
+		SampleRate: sampleRate,
+		NumSamples: len(samples),
+		Detection: []onset.DetectionFrame{
+			{Time: 0.5, Descriptor: 0.1},
+			{Time: 1.0, Descriptor: 1.0, Onset: true},
+		},
+		Options: options,
+	}
+
+	path := filepath.Join(t.TempDir(), "report.html")
+	if err := WriteHTMLReport(result, path); err != nil {
+		t.Fatalf("WriteHTMLReport failed: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Report missing: %v", err)
+	}
+	page := string(content)
+	for _, want := range []string{
+		"2 onsets in 2.00 s",
+		`<tr data-index="2"><td>2</td><td>1.000</td><td>2.000</td><td>1.000</td><td>0.800</td><td>0.566</td></tr>`,
+		"<td>Method</td><td>hfc</td>",
+		`"noveltyHop":0.5`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected report to contain %q", want)
+		}
+	}
+
+	if err := WriteHTMLReport(&onset.SliceAnalyzerResult{SampleRate: sampleRate}, path); err == nil {
+		t.Error("Expected error for a result without samples, got nil")
+	}
+}
+
+func TestPlotSpectrogram(t *testing.T) {
+	sampleRate := uint(8000)
+	samples := make([]float64, 16000)
+	for i := 8000; i < len(samples); i++ {
+		samples[i] = 0.8 * math.Sin(2*math.Pi*1000*float64(i)/float64(sampleRate))
+	}
+	result := &onset.SliceAnalyzerResult{
+		Onsets:     []float64{1.0},
+		Samples:    samples,
+		SampleRate: sampleRate,
+		NumSamples: len(samples),
+	}
+
+	img, err := PlotSpectrogram(result, SpectrogramOptions{Width: 200, Height: 100, WindowSize: 512})
+	if err != nil {
+		t.Fatalf("PlotSpectrogram failed: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 200 || b.Dy() != 100 {
+		t.Fatalf("Expected 200x100 image, got %v", b)
+	}
+
+	// 1000 Hz is at a quarter of the height from the bottom
+	if c := color.RGBAModel.Convert(img.At(100, 5)); c != spectrogramMarkerColor {
+		t.Errorf("Expected onset marker at x=100, got %v", c)
+	}
+	if c := color.RGBAModel.Convert(img.At(150, 75)); c != spectrogramColor(1) {
+		t.Errorf("Expected the loudest color at the tone, got %v", c)
+	}
+	if c := color.RGBAModel.Convert(img.At(50, 75)); c != spectrogramColor(0) {
+		t.Errorf("Expected the silent color before the tone, got %v", c)
+	}
+
+	if _, err := PlotSpectrogram(result, SpectrogramOptions{MinFrequency: 5000, MaxFrequency: 100}); err == nil {
+		t.Error("Expected error for an invalid frequency range, got nil")
+	}
+	if _, err := PlotSpectrogram(&onset.SliceAnalyzerResult{SampleRate: sampleRate, Preview: []float64{1}, NumSamples: 10}, SpectrogramOptions{}); err == nil {
+		t.Error("Expected error for a result without samples, got nil")
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	sampleRate := uint(1000)
+	samples := make([]float64, 2000)
+	for i := 1000; i < len(samples); i++ {
+		samples[i] = 0.8 * math.Sin(float64(i)*0.3)
+	}
+	result := &onset.SliceAnalyzerResult{
+		Onsets:     []float64{1.0},
+		Samples:    samples,
+		SampleRate: sampleRate,
+		NumSamples: len(samples),
+		Detection: []onset.DetectionFrame{
+			{Time: 0.5, Descriptor: 0.1},
+			{Time: 1.0, Descriptor: 1.0, Onset: true},
+		},
+	}
+
+	var b strings.Builder
+	if err := WriteSVG(&b, result, PlotOptions{Width: 200, Height: 90, ShowNovelty: true}); err != nil {
+		t.Fatalf("WriteSVG failed: %v", err)
+	}
+	svg := b.String()
+	for _, want := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" width="200" height="90" viewBox="0 0 200 90">`,
+		`<line x1="100.00" y1="0" x2="100.00" y2="90"/>`,
+		`<path fill="#1f3a5f"`,
+		`<path fill="#ff7f0e" d="M0 90 L50.00 87.10 L100.00 61.00 L200 90 Z"/>`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("Expected SVG to contain %q", want)
+		}
+	}
+	if !strings.HasSuffix(svg, "</svg>\n") {
+		t.Error("Expected SVG to end with </svg>")
+	}
+
+	if err := WriteSVG(&b, &onset.SliceAnalyzerResult{SampleRate: sampleRate}, PlotOptions{}); err == nil {
+		t.Error("Expected error for a result without samples, got nil")
+	}
+}
@@ -0,0 +1,178 @@
+package plot
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/schollz/onsets"
+)
+
+// spectrogramMarkerColor draws onsets over spectrograms, where the onset color
+// of waveforms would blend with the loud bins
+var spectrogramMarkerColor = color.RGBA{R: 0x00, G: 0xe5, B: 0xff, A: 0xff}
+
+// spectrogramPalette maps levels from silent to loudest, evenly spaced
+var spectrogramPalette = []color.RGBA{
+	{R: 0x00, G: 0x00, B: 0x04, A: 0xff},
+	{R: 0x42, G: 0x0a, B: 0x68, A: 0xff},
+	{R: 0x93, G: 0x26, B: 0x67, A: 0xff},
+	{R: 0xdd, G: 0x51, B: 0x3a, A: 0xff},
+	{R: 0xfc, G: 0xa5, B: 0x0a, A: 0xff},
+	{R: 0xfc, G: 0xff, B: 0xa4, A: 0xff},
+}
+
+// SpectrogramOptions contains configuration options for PlotSpectrogram
+type SpectrogramOptions struct {
+	// Width is the image width in pixels. Default is 1200 if 0.
+	Width int
+	// Height is the image height in pixels. Default is 400 if 0.
+	Height int
+	// WindowSize is the size of the analysis window in samples. Default is
+	// 2048 if 0.
+	WindowSize uint
+	// HopSize is the distance between frames in samples. Default is a
+	// quarter of WindowSize if 0.
+	HopSize uint
+	// RangeDb is the dynamic range shown, in decibels below the loudest bin.
+	// Default is 80 if 0.
+	RangeDb float64
+	// MinFrequency and MaxFrequency delimit the frequencies shown, in Hz.
+	// Default is 0 Hz, or 20 Hz with LogFrequency, to the Nyquist frequency if 0.
+	MinFrequency float64
+	MaxFrequency float64
+	// LogFrequency spaces the frequencies logarithmically, giving each octave
+	// the same height
+	LogFrequency bool
+}
+
+// PlotSpectrogram renders the log-magnitude spectrogram of an analysis result,
+// computed with the phase vocoder of the detection, with a vertical marker at
+// every onset. It needs the samples of the result, so results of streaming
+// analysis cannot be rendered.
+func PlotSpectrogram(result *onset.SliceAnalyzerResult, options SpectrogramOptions) (image.Image, error) {
+	width, height := options.Width, options.Height
+	if width == 0 {
+		width = 1200
+	}
+	if height == 0 {
+		height = 400
+	}
+	if width < 0 || height < 0 {
+		return nil, fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+	winSize := options.WindowSize
+	if winSize == 0 {
+		winSize = 2048
+	}
+	hopSize := options.HopSize
+	if hopSize == 0 {
+		hopSize = max(winSize/4, 1)
+	}
+	rangeDb := options.RangeDb
+	if rangeDb == 0 {
+		rangeDb = 80
+	}
+	if rangeDb < 0 {
+		return nil, fmt.Errorf("invalid range %g dB", rangeDb)
+	}
+	if len(result.Samples) == 0 || result.SampleRate == 0 {
+		return nil, fmt.Errorf("result has no samples to plot")
+	}
+
+	nyquist := float64(result.SampleRate) / 2
+	minFreq, maxFreq := options.MinFrequency, options.MaxFrequency
+	if minFreq == 0 && options.LogFrequency {
+		minFreq = 20
+	}
+	if maxFreq == 0 {
+		maxFreq = nyquist
+	}
+	if minFreq < 0 || maxFreq <= minFreq || (options.LogFrequency && minFreq <= 0) {
+		return nil, fmt.Errorf("invalid frequency range %g to %g Hz", minFreq, maxFreq)
+	}
+
+	// Keep the loudest frame falling in every column, in decibels
+	duration := float64(len(result.Samples)) / float64(result.SampleRate)
+	bins := int(winSize/2 + 1)
+	columns := make([][]float64, width)
+	pv := onset.NewPvoc(winSize, hopSize)
+	frame, grain := onset.NewFvec(winSize), onset.NewCvec(winSize)
+	peak := math.Inf(-1)
+	for start := 0; start < len(result.Samples); start += int(hopSize) {
+		frame.Zeros()
+		copy(frame.Data, result.Samples[start:])
+		pv.Do(frame, grain)
+
+		center := (float64(start) + float64(winSize)/2) / float64(result.SampleRate)
+		x := min(int(center/duration*float64(width)), width-1)
+		if columns[x] == nil {
+			columns[x] = make([]float64, bins)
+			for i := range columns[x] {
+				columns[x][i] = math.Inf(-1)
+			}
+		}
+		for i, norm := range grain.Norm {
+			db := 20 * math.Log10(norm+1e-12)
+			columns[x][i] = math.Max(columns[x][i], db)
+			peak = math.Max(peak, db)
+		}
+	}
+
+	// Columns narrower than a hop repeat the previous frame
+	for x := 1; x < width; x++ {
+		if columns[x] == nil {
+			columns[x] = columns[x-1]
+		}
+	}
+
+	// Every row shows the loudest bin of its frequency band
+	binHz := float64(result.SampleRate) / float64(winSize)
+	frequency := func(y int) float64 {
+		position := float64(height-y) / float64(height)
+		if options.LogFrequency {
+			return minFreq * math.Pow(maxFreq/minFreq, position)
+		}
+		return minFreq + (maxFreq-minFreq)*position
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(img, img.Bounds(), spectrogramPalette[0])
+	for y := range height {
+		lo := min(int(math.Round(frequency(y+1)/binHz)), bins-1)
+		hi := max(min(int(math.Round(frequency(y)/binHz)), bins-1), lo)
+		for x, column := range columns {
+			if column == nil {
+				continue
+			}
+			db := math.Inf(-1)
+			for _, v := range column[lo : hi+1] {
+				db = math.Max(db, v)
+			}
+			img.SetRGBA(x, y, spectrogramColor(1+(db-peak)/rangeDb))
+		}
+	}
+
+	for _, onsetTime := range result.Onsets {
+		x := int(onsetTime / duration * float64(width))
+		if x >= 0 && x < width {
+			fillRect(img, image.Rect(x, 0, x+1, height), spectrogramMarkerColor)
+		}
+	}
+
+	return img, nil
+}
+
+// spectrogramColor returns the color of level v, from 0 (silent) to 1
+// (loudest), interpolated in spectrogramPalette
+func spectrogramColor(v float64) color.RGBA {
+	v = math.Max(0, math.Min(v, 1)) * float64(len(spectrogramPalette)-1)
+	i := min(int(v), len(spectrogramPalette)-2)
+	f := v - float64(i)
+	a, b := spectrogramPalette[i], spectrogramPalette[i+1]
+	mix := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*f))
+	}
+	return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: 0xff}
+}
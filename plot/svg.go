@@ -0,0 +1,164 @@
+package plot
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+
+	"github.com/schollz/onsets"
+)
+
+// WriteSVG renders the waveform of an analysis result with a vertical marker
+// at every onset as a resolution-independent SVG image, for documentation and
+// print. The options are those of PlotResult; the size is in user units.
+// Results of streaming analysis are drawn from their Preview.
+func WriteSVG(w io.Writer, result *onset.SliceAnalyzerResult, options PlotOptions) error {
+	width, height := options.Width, options.Height
+	if width == 0 {
+		width = 1200
+	}
+	if height == 0 {
+		height = 400
+	}
+	if width < 0 || height < 0 {
+		return fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+
+	samples, decimation := result.Samples, 1
+	numSamples := len(samples)
+	if numSamples == 0 && len(result.Preview) > 0 {
+		samples, decimation = result.Preview, result.PreviewDecimation
+		numSamples = result.NumSamples
+	}
+	if numSamples == 0 || result.SampleRate == 0 {
+		return fmt.Errorf("result has no samples to plot")
+	}
+	if options.ShowNovelty && len(result.Detection) == 0 {
+		return fmt.Errorf("result has no detection curve to plot")
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", width, height, width, height)
+	fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="%s"/>`+"\n", width, height, svgColor(backgroundColor))
+
+	waveHeight := height
+	if options.ShowNovelty {
+		waveHeight = height * 2 / 3
+	}
+	duration := float64(numSamples) / float64(result.SampleRate)
+	if options.ShowGrid {
+		fmt.Fprintf(bw, `<g stroke="%s">`+"\n", svgColor(gridColor))
+		for _, x := range gridTimes(duration, width) {
+			fmt.Fprintf(bw, `<line x1="%d" y1="0" x2="%d" y2="%d"/>`+"\n", x, x, height)
+		}
+		for _, y := range []int{waveHeight / 4, waveHeight * 3 / 4} {
+			fmt.Fprintf(bw, `<line x1="0" y1="%d" x2="%d" y2="%d"/>`+"\n", y, width, y)
+		}
+		fmt.Fprintln(bw, "</g>")
+	}
+	writeSVGWaveform(bw, width, waveHeight, samples, decimation, numSamples)
+	if options.ShowNovelty {
+		fmt.Fprintf(bw, `<line x1="0" y1="%d" x2="%d" y2="%d" stroke="%s"/>`+"\n", waveHeight, width, waveHeight, svgColor(axisColor))
+		writeSVGNovelty(bw, width, waveHeight, height, result.Detection, duration)
+	}
+
+	// Onset markers span every panel
+	fmt.Fprintf(bw, `<g stroke="%s">`+"\n", svgColor(onsetColor))
+	for _, onsetTime := range result.Onsets {
+		x := onsetTime / duration * float64(width)
+		if x >= 0 && x <= float64(width) {
+			fmt.Fprintf(bw, `<line x1="%.2f" y1="0" x2="%.2f" y2="%d"/>`+"\n", x, x, height)
+		}
+	}
+	fmt.Fprintln(bw, "</g>")
+	fmt.Fprintln(bw, "</svg>")
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write SVG: %w", err)
+	}
+	return nil
+}
+
+// WriteSVGFile renders the waveform and onsets of an analysis result to an SVG
+// file, see WriteSVG
+func WriteSVGFile(result *onset.SliceAnalyzerResult, path string, options PlotOptions) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create SVG file: %w", err)
+	}
+	if err := WriteSVG(f, result, options); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write SVG file: %w", err)
+	}
+	return nil
+}
+
+// writeSVGWaveform writes the minimum and maximum of the samples falling in
+// every column as one closed path, the maxima from left to right and the
+// minima back
+func writeSVGWaveform(w io.Writer, width, height int, samples []float64, decimation int, numSamples int) {
+	mid := float64(height) / 2
+	fmt.Fprintf(w, `<line x1="0" y1="%.2f" x2="%d" y2="%.2f" stroke="%s"/>`+"\n", mid, width, mid, svgColor(axisColor))
+
+	var xs, tops, bottoms []float64
+	for x := range width {
+		start := x * numSamples / width / decimation
+		end := (x + 1) * numSamples / width / decimation
+		end = min(max(end, start+1), len(samples))
+		if start >= end {
+			continue
+		}
+		lo, hi := samples[start], samples[start]
+		for _, v := range samples[start:end] {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+		xs = append(xs, float64(x))
+		tops = append(tops, mid-math.Min(hi, 1)*mid)
+		bottoms = append(bottoms, mid-math.Max(lo, -1)*mid)
+	}
+	if len(xs) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, `<path fill="%s" stroke="%s" stroke-width="0.5" d="M`, svgColor(waveformColor), svgColor(waveformColor))
+	for i, x := range xs {
+		fmt.Fprintf(w, "%.0f %.2f %.0f %.2f ", x, tops[i], x+1, tops[i])
+	}
+	for i := len(xs) - 1; i >= 0; i-- {
+		fmt.Fprintf(w, "%.0f %.2f %.0f %.2f ", xs[i]+1, bottoms[i], xs[i], bottoms[i])
+	}
+	fmt.Fprintln(w, `Z"/>`)
+}
+
+// writeSVGNovelty writes the detection function, scaled to its maximum, as a
+// filled area between top and bottom
+func writeSVGNovelty(w io.Writer, width, top, bottom int, detection []onset.DetectionFrame, duration float64) {
+	peak := 0.0
+	for _, frame := range detection {
+		peak = math.Max(peak, frame.Descriptor)
+	}
+	if peak <= 0 {
+		return
+	}
+
+	scale := float64(bottom - top - 1)
+	fmt.Fprintf(w, `<path fill="%s" d="M0 %d `, svgColor(noveltyColor), bottom)
+	for _, frame := range detection {
+		x := frame.Time / duration * float64(width)
+		if x >= 0 && x <= float64(width) {
+			fmt.Fprintf(w, "L%.2f %.2f ", x, float64(bottom)-frame.Descriptor/peak*scale)
+		}
+	}
+	fmt.Fprintf(w, "L%d %d Z\"/>\n", width, bottom)
+}
+
+// svgColor formats c as a hexadecimal SVG color
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,220 @@
+package onset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ParamsVersion is the version of the preset format. Presets of a newer
+// version are rejected when loaded.
+const ParamsVersion = 1
+
+// NewOnsetParams creates a detector for the method of a preset, "hfc" if
+// empty, and applies its parameters. It returns an error for unknown methods
+// and invalid sizes, like NewOnsetErr.
+func NewOnsetParams(p Params, bufSize, hopSize, samplerate uint) (*Onset, error) {
+	method := p.Method
+	if method == "" {
+		method = "hfc"
+	}
+	o, err := NewOnsetErr(method, bufSize, hopSize, samplerate)
+	if err != nil {
+		return nil, err
+	}
+	o.ApplyParams(p)
+	return o, nil
+}
+
+// checkParamsVersion returns an error for presets of a newer format
+func checkParamsVersion(version int) error {
+	if version > ParamsVersion {
+		return fmt.Errorf("unsupported preset version %d: newest supported is %d", version, ParamsVersion)
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes a preset, rejecting presets of a newer version
+func (p *Params) UnmarshalJSON(data []byte) error {
+	// plain has the fields of Params without its methods
+	type plain Params
+	var decoded plain
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	if err := checkParamsVersion(decoded.Version); err != nil {
+		return err
+	}
+	*p = Params(decoded)
+	return nil
+}
+
+// MarshalTOML encodes the preset as a TOML document of key/value pairs with
+// the keys of the JSON encoding
+func (p Params) MarshalTOML() ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "version = %d\n", p.Version)
+	fmt.Fprintf(&b, "method = %s\n", strconv.Quote(p.Method))
+	fmt.Fprintf(&b, "threshold = %s\n", tomlFloat(p.Threshold))
+	fmt.Fprintf(&b, "silence = %s\n", tomlFloat(p.Silence))
+	fmt.Fprintf(&b, "minioi_ms = %s\n", tomlFloat(p.MinioiMs))
+	fmt.Fprintf(&b, "compression = %s\n", tomlFloat(p.Compression))
+	fmt.Fprintf(&b, "awhitening = %t\n", p.AWhitening)
+	if p.WhiteningRelaxTime != 0 {
+		fmt.Fprintf(&b, "whitening_relax_time = %s\n", tomlFloat(p.WhiteningRelaxTime))
+	}
+	if p.WhiteningFloor != 0 {
+		fmt.Fprintf(&b, "whitening_floor = %s\n", tomlFloat(p.WhiteningFloor))
+	}
+	return b.Bytes(), nil
+}
+
+// tomlFloat formats v as a TOML float, which needs a fraction or an exponent
+// and spells infinities and NaN in lower case
+func tomlFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "nan"
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	}
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// UnmarshalTOML decodes a preset written by MarshalTOML: key/value pairs
+// with comments and blank lines, but no tables. Unknown keys are ignored,
+// like in the JSON encoding, and presets of a newer version are rejected.
+func (p *Params) UnmarshalTOML(data []byte) error {
+	var decoded Params
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("line %d: expected key = value", line)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		if err := decoded.setTOML(key, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := checkParamsVersion(decoded.Version); err != nil {
+		return err
+	}
+	*p = decoded
+	return nil
+}
+
+// setTOML sets the field of key from a TOML value, which may be followed by a
+// comment
+func (p *Params) setTOML(key, value string) error {
+	if key == "method" {
+		quoted, err := strconv.QuotedPrefix(value)
+		if err != nil {
+			return fmt.Errorf("invalid string for %s: %s", key, value)
+		}
+		if rest := strings.TrimSpace(value[len(quoted):]); rest != "" && rest[0] != '#' {
+			return fmt.Errorf("unexpected %q after %s", rest, key)
+		}
+		p.Method, _ = strconv.Unquote(quoted)
+		return nil
+	}
+
+	if i := strings.IndexByte(value, '#'); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	switch key {
+	case "version":
+		version, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer for %s: %s", key, value)
+		}
+		p.Version = version
+	case "awhitening":
+		enable, err := strconv.ParseBool(value)
+		if err != nil || (value != "true" && value != "false") {
+			return fmt.Errorf("invalid boolean for %s: %s", key, value)
+		}
+		p.AWhitening = enable
+	default:
+		fields := map[string]*float64{
+			"threshold":            &p.Threshold,
+			"silence":              &p.Silence,
+			"minioi_ms":            &p.MinioiMs,
+			"compression":          &p.Compression,
+			"whitening_relax_time": &p.WhiteningRelaxTime,
+			"whitening_floor":      &p.WhiteningFloor,
+		}
+		field, ok := fields[key]
+		if !ok {
+			return nil
+		}
+		v, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number for %s: %s", key, value)
+		}
+		*field = v
+	}
+	return nil
+}
+
+// LoadParams reads a preset saved by SaveParams, in TOML for files with the
+// ".toml" extension and in JSON otherwise
+func LoadParams(path string) (Params, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Params{}, fmt.Errorf("failed to read preset: %w", err)
+	}
+	var p Params
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = p.UnmarshalTOML(data)
+	} else {
+		err = json.Unmarshal(data, &p)
+	}
+	if err != nil {
+		return Params{}, fmt.Errorf("failed to parse preset %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// SaveParams writes a preset, such as the parameters returned by
+// Onset.Params, in TOML for files with the ".toml" extension and in indented
+// JSON otherwise. A zero Version is saved as ParamsVersion.
+func SaveParams(path string, p Params) error {
+	if p.Version == 0 {
+		p.Version = ParamsVersion
+	}
+	var data []byte
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		data, err = p.MarshalTOML()
+	} else {
+		data, err = json.MarshalIndent(p, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode preset: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write preset: %w", err)
+	}
+	return nil
+}
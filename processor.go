@@ -0,0 +1,213 @@
+package onset
+
+import "math"
+
+// Processor is a preprocessing stage that transforms a block of samples in
+// place, such as a filter or a gain
+type Processor interface {
+	Process(in *Fvec)
+}
+
+// ProcessorFunc adapts an ordinary function to the Processor interface
+type ProcessorFunc func(in *Fvec)
+
+// Process calls f(in)
+func (f ProcessorFunc) Process(in *Fvec) {
+	f(in)
+}
+
+// Process applies the filter to the input vector in-place, so that a Filter
+// can be used as a pipeline stage
+func (f *Filter) Process(in *Fvec) {
+	f.Do(in)
+}
+
+// Gain is a stage multiplying the samples by a constant factor
+type Gain float64
+
+// Process scales the input vector in-place
+func (g Gain) Process(in *Fvec) {
+	for i := range in.Data {
+		in.Data[i] *= float64(g)
+	}
+}
+
+// dcBlockerCutoffHz is the corner frequency of the DC blocker
+const dcBlockerCutoffHz = 10.0
+
+// DCBlocker is a one-pole highpass stage removing the DC offset of the input,
+// which otherwise skews the silence detection and the "energy" method
+type DCBlocker struct {
+	// Pole is the pole of the filter, just below 1
+	Pole float64
+	// x1 and y1 are the previous input and output samples
+	x1, y1 float64
+	// primed reports whether a sample has been processed since the last
+	// reset. The first sample initializes x1 so that an offset present from
+	// the start does not produce a step.
+	primed bool
+}
+
+// NewDCBlocker creates a DC blocker with a corner frequency of 10 Hz at the
+// given sample rate
+func NewDCBlocker(samplerate uint) *DCBlocker {
+	return &DCBlocker{Pole: math.Exp(-2 * math.Pi * dcBlockerCutoffHz / float64(samplerate))}
+}
+
+// Process removes the DC offset of the input vector in-place
+func (d *DCBlocker) Process(in *Fvec) {
+	if !d.primed && in.Length > 0 {
+		d.x1 = in.Data[0]
+		d.primed = true
+	}
+	for i, x := range in.Data {
+		d.y1 = x - d.x1 + d.Pole*d.y1
+		d.x1 = x
+		in.Data[i] = d.y1
+	}
+}
+
+// Reset clears the filter history
+func (d *DCBlocker) Reset() {
+	d.x1, d.y1 = 0, 0
+	d.primed = false
+}
+
+// Clone returns a DC blocker with the same pole and a cleared history
+func (d *DCBlocker) Clone() *DCBlocker {
+	return &DCBlocker{Pole: d.Pole}
+}
+
+// noiseGateDetectorMs is the decay time in milliseconds of the level detector
+// of the noise gate, which keeps the gate from chattering between the peaks
+// of a waveform
+const noiseGateDetectorMs = 10.0
+
+// NoiseGate is a stage silencing the input while its level stays below a
+// threshold, so that constant background hiss, such as that of a live
+// microphone, does not feed the detection function between hits
+type NoiseGate struct {
+	// Threshold is the peak amplitude above which the gate opens
+	Threshold float64
+	// Attack and Release are the one-pole coefficients of the gain when the
+	// gate opens and closes, 0 for instant changes
+	Attack, Release float64
+	// decay is the one-pole coefficient of the level detector
+	decay float64
+	// level is the detected level and gain the current gain
+	level, gain float64
+}
+
+// NewNoiseGate creates a noise gate opening above thresholdDB dBFS, its gain
+// rising over about attackMs milliseconds and falling over about releaseMs
+// once the level drops below the threshold
+func NewNoiseGate(thresholdDB, attackMs, releaseMs float64, samplerate uint) *NoiseGate {
+	return &NoiseGate{
+		Threshold: math.Pow(10, thresholdDB/20),
+		Attack:    onePoleCoefficient(attackMs, samplerate),
+		Release:   onePoleCoefficient(releaseMs, samplerate),
+		decay:     onePoleCoefficient(noiseGateDetectorMs, samplerate),
+	}
+}
+
+// onePoleCoefficient returns the coefficient of a one-pole smoother with a
+// time constant of ms milliseconds, 0 for instant changes if ms is not
+// positive
+func onePoleCoefficient(ms float64, samplerate uint) float64 {
+	if ms <= 0 || samplerate == 0 {
+		return 0
+	}
+	return math.Exp(-1000 / (ms * float64(samplerate)))
+}
+
+// Process gates the input vector in-place
+func (g *NoiseGate) Process(in *Fvec) {
+	for i, x := range in.Data {
+		g.level = max(math.Abs(x), g.level*g.decay)
+		if g.level >= g.Threshold {
+			g.gain = 1 - g.Attack*(1-g.gain)
+		} else {
+			g.gain *= g.Release
+		}
+		in.Data[i] = x * g.gain
+	}
+}
+
+// Reset closes the gate and clears the detected level
+func (g *NoiseGate) Reset() {
+	g.level, g.gain = 0, 0
+}
+
+// Clone returns a noise gate with the same settings and a closed gate
+func (g *NoiseGate) Clone() *NoiseGate {
+	return &NoiseGate{Threshold: g.Threshold, Attack: g.Attack, Release: g.Release, decay: g.decay}
+}
+
+// Pipeline chains processors, applying them in order. A Pipeline is itself a
+// Processor, so it can be set as the preprocessor of an Onset with
+// SetPreprocessor or nested in another Pipeline.
+type Pipeline struct {
+	stages []Processor
+}
+
+// NewPipeline creates a pipeline applying the given stages in order
+func NewPipeline(stages ...Processor) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Add appends stages to the end of the pipeline and returns the pipeline
+func (p *Pipeline) Add(stages ...Processor) *Pipeline {
+	p.stages = append(p.stages, stages...)
+	return p
+}
+
+// Stages returns the stages of the pipeline in order
+func (p *Pipeline) Stages() []Processor {
+	return p.stages
+}
+
+// Process applies every stage to the input vector in-place
+func (p *Pipeline) Process(in *Fvec) {
+	for _, stage := range p.stages {
+		stage.Process(in)
+	}
+}
+
+// Reset clears the state of the stages that have a Reset method, such as
+// filters
+func (p *Pipeline) Reset() {
+	for _, stage := range p.stages {
+		if r, ok := stage.(interface{ Reset() }); ok {
+			r.Reset()
+		}
+	}
+}
+
+// Clone returns a pipeline of clones of the stages, see cloneProcessor
+func (p *Pipeline) Clone() *Pipeline {
+	stages := make([]Processor, len(p.stages))
+	for i, stage := range p.stages {
+		stages[i] = cloneProcessor(stage)
+	}
+	return &Pipeline{stages: stages}
+}
+
+// cloneProcessor returns a copy of p with a cleared state. Filters, DC
+// blockers, noise gates, pipelines and processors with a Clone() Processor
+// method are cloned; other processors, such as Gain and ProcessorFunc, are
+// returned as is.
+func cloneProcessor(p Processor) Processor {
+	switch p := p.(type) {
+	case *Filter:
+		return p.Clone()
+	case *DCBlocker:
+		return p.Clone()
+	case *NoiseGate:
+		return p.Clone()
+	case *Pipeline:
+		return p.Clone()
+	case interface{ Clone() Processor }:
+		return p.Clone()
+	}
+	return p
+}
@@ -0,0 +1,56 @@
+package onset
+
+// progressStep is the minimum progress increase between two callback invocations
+const progressStep = 0.001
+
+// progress forwards the fraction of completed work to a Progress callback.
+// Each progress covers a sub-range of the overall work; a nil *progress
+// ignores all reports.
+type progress struct {
+	fn    func(float64)
+	last  *float64
+	start float64
+	end   float64
+}
+
+// newProgress returns a progress covering the whole analysis, or nil if fn is nil
+func newProgress(fn func(float64)) *progress {
+	if fn == nil {
+		return nil
+	}
+	return &progress{fn: fn, last: new(float64), start: 0, end: 1}
+}
+
+// sub returns a progress covering the fraction range [from, to] of p
+func (p *progress) sub(from, to float64) *progress {
+	if p == nil {
+		return nil
+	}
+	span := p.end - p.start
+	return &progress{fn: p.fn, last: p.last, start: p.start + from*span, end: p.start + to*span}
+}
+
+// report records that frac of the work covered by p is done. The callback is
+// only invoked when progress advanced noticeably, and always on completion.
+func (p *progress) report(frac float64) {
+	if p == nil {
+		return
+	}
+	if frac < 0 {
+		frac = 0
+	} else if frac > 1 {
+		frac = 1
+	}
+
+	v := p.start + frac*(p.end-p.start)
+	if v >= 1 {
+		if *p.last >= 1 {
+			return
+		}
+		v = 1
+	} else if v < *p.last+progressStep {
+		return
+	}
+	*p.last = v
+	p.fn(v)
+}
@@ -1,36 +1,58 @@
 package onset
 
-import (
-	"math"
-
-	"github.com/mjibson/go-dsp/fft"
-)
+import "math"
 
 // Pvoc represents a phase vocoder
 type Pvoc struct {
 	WinSize  uint      // window size
 	HopSize  uint      // hop size
+	FftSize  uint      // FFT size, at least WinSize
 	Fft      *Fvec     // FFT object
 	Window   *Fvec     // analysis window
-	Synth    *Fvec     // synthesis window
+	Synth    *Fvec     // synthesis window, see initSynth
 	In       *Fvec     // input buffer
-	Out      *Fvec     // output buffer
+	Out      *Fvec     // overlap-add buffer of RDo
 	Grain    *Cvec     // current grain (FFT output)
 	OldGrain *Cvec     // previous grain
 	PrevPhas []float64 // previous phase values
+
+	// transform computes the spectrum of the frames
+	transform FFT
+	// magnitudeOnly skips the phases, leaving the phases of the grains
+	// unchanged, when the transform implements MagnitudeFFT and the
+	// accelerated kernels are enabled
+	magnitudeOnly bool
+	// inverse resynthesizes the frames of RDo when transform does not
+	// implement InverseFFT, allocated by the first call
+	inverse InverseFFT
+	// frame holds the inverse transform of a grain
+	frame []float64
 }
 
 // NewPvoc creates a new phase vocoder
 func NewPvoc(winSize, hopSize uint) *Pvoc {
+	return NewPvocPadded(winSize, hopSize, winSize)
+}
+
+// NewPvocPadded creates a new phase vocoder whose windowed frames are
+// zero-padded to fftSize samples before the FFT, for a finer frequency
+// resolution without a longer window. fftSize is raised to winSize if
+// smaller.
+func NewPvocPadded(winSize, hopSize, fftSize uint) *Pvoc {
+	fftSize = max(fftSize, winSize)
 	p := &Pvoc{
-		WinSize:  winSize,
-		HopSize:  hopSize,
-		Fft:      NewFvec(winSize),
-		Window:   NewFvec(winSize),
-		In:       NewFvec(hopSize),
-		Grain:    NewCvec(winSize),
-		OldGrain: NewCvec(winSize),
-		PrevPhas: make([]float64, winSize/2+1),
+		WinSize:   winSize,
+		HopSize:   hopSize,
+		FftSize:   fftSize,
+		Fft:       NewFvec(fftSize),
+		Window:    NewFvec(winSize),
+		Synth:     NewFvec(winSize),
+		In:        NewFvec(hopSize),
+		Out:       NewFvec(winSize),
+		Grain:     NewCvec(fftSize),
+		OldGrain:  NewCvec(fftSize),
+		PrevPhas:  make([]float64, fftSize/2+1),
+		transform: NewDefaultFFT(),
 	}
 
 	// Create Hann window
@@ -38,33 +60,117 @@ func NewPvoc(winSize, hopSize uint) *Pvoc {
 		p.Window.Data[i] = 0.5 - 0.5*math.Cos(2.0*math.Pi*float64(i)/float64(winSize))
 	}
 
+	p.initSynth()
+
 	return p
 }
 
+// initSynth derives the synthesis window from the analysis window, scaled
+// so that the products of the two windows overlapping every HopSize samples
+// sum to one at every sample, for a perfect reconstruction by RDo with any
+// hop size shorter than the window
+func (p *Pvoc) initSynth() {
+	hop := int(p.HopSize)
+	window := p.Window.Data
+	p.Synth.Zeros()
+	if hop == 0 {
+		return
+	}
+	for i, w := range window {
+		sum := 0.0
+		for j := i % hop; j < len(window); j += hop {
+			sum += window[j] * window[j]
+		}
+		if sum > 0 {
+			p.Synth.Data[i] = w / sum
+		}
+	}
+}
+
 // Do processes input through phase vocoder
 func (p *Pvoc) Do(input *Fvec, fftgrain *Cvec) {
-	// Copy input to FFT buffer with windowing
-	for i := uint(0); i < p.WinSize; i++ {
-		if i < input.Length {
-			p.Fft.Data[i] = input.Data[i] * p.Window.Data[i]
-		} else {
-			p.Fft.Data[i] = 0
+	// Copy input to FFT buffer with windowing, zero-padding the rest
+	n := min(p.WinSize, input.Length)
+	frame, window := p.Fft.Data[:n], p.Window.Data[:n]
+	for i, v := range input.Data[:n] {
+		frame[i] = v * window[i]
+	}
+	clear(p.Fft.Data[n:])
+
+	if p.magnitudeOnly && Accelerated() {
+		if t, ok := p.transform.(MagnitudeFFT); ok {
+			copy(fftgrain.Norm, t.ForwardNorm(p.Fft.Data))
+			return
 		}
 	}
 
-	// Perform FFT
-	fftResult := fft.FFTReal(p.Fft.Data)
+	// Perform FFT in polar form (magnitude and phase)
+	norm, phas := p.transform.Forward(p.Fft.Data)
+	copy(fftgrain.Norm, norm)
+	copy(fftgrain.Phas, phas)
+}
 
-	// Convert to polar form (magnitude and phase)
-	for i := uint(0); i < fftgrain.Length; i++ {
-		real := real(fftResult[i])
-		imag := imag(fftResult[i])
-		fftgrain.Norm[i] = math.Sqrt(real*real + imag*imag)
-		fftgrain.Phas[i] = math.Atan2(imag, real)
+// Reset clears the frame buffers and the previous grain and phases
+func (p *Pvoc) Reset() {
+	p.Fft.Zeros()
+	p.In.Zeros()
+	if p.Out != nil {
+		p.Out.Zeros()
 	}
+	p.Grain.Zeros()
+	p.OldGrain.Zeros()
+	clear(p.PrevPhas)
+}
+
+// Clone returns a phase vocoder with the same sizes, window and FFT
+// implementation, see cloneFFT, and cleared buffers
+func (p *Pvoc) Clone() *Pvoc {
+	c := NewPvocPadded(p.WinSize, p.HopSize, p.FftSize)
+	c.Window.Copy(p.Window)
+	c.Synth.Copy(p.Synth)
+	c.transform = cloneFFT(p.transform)
+	c.magnitudeOnly = p.magnitudeOnly
+	return c
 }
 
-// RDo performs inverse phase vocoder operation (not needed for onset detection)
+// SetFFT replaces the FFT implementation. A nil FFT restores the built-in one.
+func (p *Pvoc) SetFFT(f FFT) {
+	if f == nil {
+		f = NewDefaultFFT()
+	}
+	p.transform = f
+}
+
+// GetFFT returns the FFT implementation in use
+func (p *Pvoc) GetFFT() FFT {
+	return p.transform
+}
+
+// RDo performs the inverse phase vocoder operation: the frame resynthesized
+// from fftgrain is windowed by Synth and overlap-added to the previous ones,
+// and the first HopSize samples of the sum, complete once the frames
+// overlapping them have been added, are written to output. Frames of WinSize
+// samples analyzed by Do every HopSize samples are thus reconstructed, the
+// output of every call being the first HopSize samples of its frame.
 func (p *Pvoc) RDo(fftgrain *Cvec, output *Fvec) {
-	// Not implemented as it's not needed for onset detection
+	inverse, ok := p.transform.(InverseFFT)
+	if !ok {
+		if p.inverse == nil {
+			p.inverse = NewDefaultFFT().(InverseFFT)
+		}
+		inverse = p.inverse
+	}
+	if len(p.frame) != int(p.FftSize) {
+		p.frame = make([]float64, p.FftSize)
+	}
+	inverse.Inverse(fftgrain.Norm, fftgrain.Phas, p.frame)
+
+	out := p.Out.Data
+	for i, w := range p.Synth.Data {
+		out[i] += p.frame[i] * w
+	}
+	hop := min(int(p.HopSize), len(out))
+	copy(output.Data, out[:hop])
+	copy(out, out[hop:])
+	clear(out[len(out)-hop:])
 }
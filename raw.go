@@ -0,0 +1,329 @@
+package onset
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Endianness is the byte order of raw PCM samples
+type Endianness int
+
+const (
+	// LittleEndian stores the least significant byte first
+	LittleEndian Endianness = iota
+	// BigEndian stores the most significant byte first
+	BigEndian
+)
+
+// RawFormat describes a headerless PCM stream
+type RawFormat struct {
+	// SampleRate is the number of frames per second
+	SampleRate uint
+	// Channels is the number of interleaved channels
+	Channels int
+	// BitDepth is the size of one sample in bits: 8, 16, 24 or 32 for integers,
+	// 32 or 64 for floats
+	BitDepth int
+	// Endianness is the byte order of multi-byte samples
+	Endianness Endianness
+	// Float reports whether samples are IEEE floating point values
+	Float bool
+	// Unsigned reports whether integer samples are unsigned (offset binary)
+	Unsigned bool
+}
+
+// AnalyzeRaw performs onset detection and slice analysis on a headerless PCM stream,
+// such as the output of an embedded capture device or a DSP pipeline.
+// The stream is read until EOF.
+func AnalyzeRaw(r io.Reader, format RawFormat, options SliceAnalyzerOptions) (*SliceAnalyzerResult, error) {
+	options, err := applyPreset(options)
+	if err != nil {
+		return nil, err
+	}
+	channels, err := decodeRaw(r, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raw audio: %w", err)
+	}
+
+	return analyzeChannels(channels, format.SampleRate, options, newProgress(options.Progress))
+}
+
+// Validate checks that the format describes a supported sample encoding
+func (f RawFormat) Validate() error {
+	if f.SampleRate == 0 {
+		return fmt.Errorf("invalid sample rate: %d", f.SampleRate)
+	}
+	if f.Channels <= 0 {
+		return fmt.Errorf("invalid channel count: %d", f.Channels)
+	}
+	_, err := f.sampleDecoder()
+	return err
+}
+
+// frameSize returns the size of one interleaved frame in bytes
+func (f RawFormat) frameSize() int {
+	return f.Channels * f.BitDepth / 8
+}
+
+// sampleDecoder returns a function converting one encoded sample to a float64 in [-1.0, 1.0]
+func (f RawFormat) sampleDecoder() (func([]byte) float64, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if f.Endianness == BigEndian {
+		order = binary.BigEndian
+	}
+
+	if f.Float {
+		switch f.BitDepth {
+		case 32:
+			return func(b []byte) float64 {
+				return float64(math.Float32frombits(order.Uint32(b)))
+			}, nil
+		case 64:
+			return func(b []byte) float64 {
+				return math.Float64frombits(order.Uint64(b))
+			}, nil
+		}
+		return nil, fmt.Errorf("unsupported float bit depth: %d", f.BitDepth)
+	}
+
+	var read func([]byte) int64
+	switch f.BitDepth {
+	case 8:
+		read = func(b []byte) int64 { return int64(b[0]) }
+	case 16:
+		read = func(b []byte) int64 { return int64(order.Uint16(b)) }
+	case 24:
+		if f.Endianness == BigEndian {
+			read = func(b []byte) int64 { return int64(b[0])<<16 | int64(b[1])<<8 | int64(b[2]) }
+		} else {
+			read = func(b []byte) int64 { return int64(b[2])<<16 | int64(b[1])<<8 | int64(b[0]) }
+		}
+	case 32:
+		read = func(b []byte) int64 { return int64(order.Uint32(b)) }
+	default:
+		return nil, fmt.Errorf("unsupported integer bit depth: %d", f.BitDepth)
+	}
+
+	bits := uint(f.BitDepth)
+	half := int64(1) << (bits - 1)
+	scale := 1.0 / float64(half)
+	if f.Unsigned {
+		return func(b []byte) float64 {
+			return float64(read(b)-half) * scale
+		}, nil
+	}
+	return func(b []byte) float64 {
+		// Sign-extend from the sample width
+		v := read(b)
+		if v >= half {
+			v -= half << 1
+		}
+		return float64(v) * scale
+	}, nil
+}
+
+// decodeRaw reads an interleaved PCM stream until EOF and returns per-channel samples.
+// A trailing partial frame is ignored.
+func decodeRaw(r io.Reader, format RawFormat) ([][]float64, error) {
+	s, err := newRawStream(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, _, err := readStream(s)
+	return channels, err
+}
+
+// rawStream decodes an interleaved PCM stream block by block
+type rawStream struct {
+	reader *bufio.Reader
+	format RawFormat
+	decode func([]byte) float64
+	frame  []byte
+}
+
+// newRawStream returns a stream decoding the PCM data in r
+func newRawStream(r io.Reader, format RawFormat) (*rawStream, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	decode, _ := format.sampleDecoder()
+
+	return &rawStream{
+		reader: bufio.NewReaderSize(r, 64*1024),
+		format: format,
+		decode: decode,
+		frame:  make([]byte, format.frameSize()),
+	}, nil
+}
+
+// SampleRate returns the sample rate in Hz
+func (s *rawStream) SampleRate() uint { return s.format.SampleRate }
+
+// NumChannels returns the number of channels
+func (s *rawStream) NumChannels() int { return s.format.Channels }
+
+// Close is a no-op; the underlying reader is owned by the caller
+func (s *rawStream) Close() error { return nil }
+
+// Read decodes the next block of frames. A trailing partial frame is ignored.
+func (s *rawStream) Read() ([][]float64, error) {
+	block := make([][]float64, s.format.Channels)
+	sampleSize := s.format.BitDepth / 8
+	for i := 0; i < streamBlockSize; i++ {
+		if _, err := io.ReadFull(s.reader, s.frame); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, err
+		}
+		for ch := range block {
+			block[ch] = append(block[ch], s.decode(s.frame[ch*sampleSize:]))
+		}
+	}
+
+	if len(block[0]) == 0 {
+		return nil, io.EOF
+	}
+	return block, nil
+}
+
+// ParseRawFormat parses a raw format specification of the form
+// "rate:channels:encoding", such as "44100:1:s16le" or "48000:2:f32be".
+// The encoding is "s" (signed), "u" (unsigned) or "f" (float), followed by the
+// bit depth and an optional "le" or "be" byte order, which defaults to little-endian.
+func ParseRawFormat(spec string) (RawFormat, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return RawFormat{}, fmt.Errorf("invalid raw format %q: expected rate:channels:encoding", spec)
+	}
+
+	rate, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return RawFormat{}, fmt.Errorf("invalid sample rate %q", parts[0])
+	}
+	channels, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return RawFormat{}, fmt.Errorf("invalid channel count %q", parts[1])
+	}
+
+	format := RawFormat{SampleRate: uint(rate), Channels: channels}
+	encoding := strings.ToLower(parts[2])
+	switch {
+	case strings.HasSuffix(encoding, "be"):
+		format.Endianness = BigEndian
+		encoding = strings.TrimSuffix(encoding, "be")
+	case strings.HasSuffix(encoding, "le"):
+		encoding = strings.TrimSuffix(encoding, "le")
+	}
+	if encoding == "" {
+		return RawFormat{}, fmt.Errorf("invalid sample encoding %q", parts[2])
+	}
+	switch encoding[0] {
+	case 's':
+	case 'u':
+		format.Unsigned = true
+	case 'f':
+		format.Float = true
+	default:
+		return RawFormat{}, fmt.Errorf("invalid sample encoding %q", parts[2])
+	}
+	if format.BitDepth, err = strconv.Atoi(encoding[1:]); err != nil {
+		return RawFormat{}, fmt.Errorf("invalid sample encoding %q", parts[2])
+	}
+
+	if err := format.Validate(); err != nil {
+		return RawFormat{}, err
+	}
+	return format, nil
+}
+
+// maxWavFormatSize is the largest WAV format chunk accepted by ReadWavHeader.
+// Format chunks are 16 to 40 bytes long; larger sizes come from corrupt or
+// crafted headers.
+const maxWavFormatSize = 64 << 10
+
+// ReadWavHeader reads a WAV header from r, which need not support seeking, and
+// returns the sample format along with a reader of the PCM data that follows,
+// so that WAV streams from pipes can be decoded like raw PCM. Streaming
+// writers often leave the data size unset; a size of 0 or 0xFFFFFFFF reads
+// the data until EOF.
+func ReadWavHeader(r io.Reader) (RawFormat, io.Reader, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return RawFormat{}, nil, fmt.Errorf("failed to read WAV header: %w", err)
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return RawFormat{}, nil, fmt.Errorf("invalid WAV file")
+	}
+
+	var format RawFormat
+	haveFormat := false
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return RawFormat{}, nil, fmt.Errorf("failed to read WAV chunk: %w", err)
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+		// Chunks are padded to an even size
+		padded := int64(size) + int64(size%2)
+
+		switch id {
+		case "fmt ":
+			if size < 16 || size > maxWavFormatSize {
+				return RawFormat{}, nil, fmt.Errorf("invalid WAV format chunk size: %d", size)
+			}
+			// Only the fields up to the sub-format tag of WAVE_FORMAT_EXTENSIBLE
+			// are used, the rest of the chunk is skipped
+			data := make([]byte, min(size, 26))
+			if _, err := io.ReadFull(r, data); err != nil {
+				return RawFormat{}, nil, fmt.Errorf("failed to read WAV format chunk: %w", err)
+			}
+			if _, err := io.CopyN(io.Discard, r, padded-int64(len(data))); err != nil {
+				return RawFormat{}, nil, fmt.Errorf("failed to read WAV format chunk: %w", err)
+			}
+			tag := binary.LittleEndian.Uint16(data[0:2])
+			if tag == 0xFFFE && len(data) >= 26 {
+				// WAVE_FORMAT_EXTENSIBLE stores the actual tag in the sub-format GUID
+				tag = binary.LittleEndian.Uint16(data[24:26])
+			}
+			format = RawFormat{
+				SampleRate: uint(binary.LittleEndian.Uint32(data[4:8])),
+				Channels:   int(binary.LittleEndian.Uint16(data[2:4])),
+				BitDepth:   int(binary.LittleEndian.Uint16(data[14:16])),
+			}
+			switch tag {
+			case 1:
+				format.Unsigned = format.BitDepth == 8
+			case 3:
+				format.Float = true
+			default:
+				return RawFormat{}, nil, fmt.Errorf("unsupported WAV encoding: %d", tag)
+			}
+			haveFormat = true
+
+		case "data":
+			if !haveFormat {
+				return RawFormat{}, nil, fmt.Errorf("WAV data chunk precedes format chunk")
+			}
+			if size != 0 && size != 0xFFFFFFFF {
+				r = io.LimitReader(r, int64(size))
+			}
+			if err := format.Validate(); err != nil {
+				return RawFormat{}, nil, err
+			}
+			return format, r, nil
+
+		default:
+			if _, err := io.CopyN(io.Discard, r, padded); err != nil {
+				return RawFormat{}, nil, fmt.Errorf("failed to skip WAV chunk: %w", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package onset
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PatternStep is one step of a rearrangement pattern
+type PatternStep struct {
+	// Slice is the zero-based index of the slice played, see SliceRanges
+	Slice int
+	// Reverse plays the slice backwards
+	Reverse bool
+}
+
+// ParsePattern parses a rearrangement pattern: slice numbers separated by
+// spaces or commas, starting at 1 as in the names of exported slices. A
+// number followed by r plays the slice reversed, and followed by xN plays it
+// N times, so that "1 2 1 3r 4x2" plays slices 1, 2, 1, 3 reversed, and 4
+// twice.
+func ParsePattern(pattern string) ([]PatternStep, error) {
+	var steps []PatternStep
+	for _, token := range strings.FieldsFunc(pattern, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t' || r == '\n'
+	}) {
+		text, repeat := strings.ToLower(token), 1
+		if i := strings.IndexByte(text, 'x'); i >= 0 {
+			n, err := strconv.Atoi(text[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid repeat count in pattern step %q", token)
+			}
+			text, repeat = text[:i], n
+		}
+		step := PatternStep{}
+		if strings.HasSuffix(text, "r") {
+			text, step.Reverse = strings.TrimSuffix(text, "r"), true
+		}
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid slice number in pattern step %q", token)
+		}
+		step.Slice = n - 1
+		for range repeat {
+			steps = append(steps, step)
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	return steps, nil
+}
+
+// Rearrange returns the slices of the analyzed samples concatenated in the
+// order of pattern, the classic re-chop of breakbeats. Slices can be repeated
+// and reversed. The result must hold its samples, so results of streaming
+// analysis cannot be rearranged.
+func Rearrange(result *SliceAnalyzerResult, pattern []PatternStep) ([]float64, error) {
+	if len(result.Samples) == 0 {
+		return nil, fmt.Errorf("result has no samples to rearrange")
+	}
+	ranges := result.SliceRanges()
+	length := 0
+	for _, step := range pattern {
+		if step.Slice < 0 || step.Slice >= len(ranges) {
+			return nil, fmt.Errorf("pattern plays slice %d of %d", step.Slice+1, len(ranges))
+		}
+		length += ranges[step.Slice].End - ranges[step.Slice].Start
+	}
+
+	out := make([]float64, 0, length)
+	for _, step := range pattern {
+		r := ranges[step.Slice]
+		start := len(out)
+		out = append(out, result.Samples[r.Start:r.End]...)
+		if step.Reverse {
+			slices.Reverse(out[start:])
+		}
+	}
+	return out, nil
+}
+
+// WriteRearranged writes the slices of the analyzed samples rearranged
+// according to pattern, see Rearrange, to a WAV file of the given bit depth
+// (16 if 0)
+func WriteRearranged(result *SliceAnalyzerResult, pattern []PatternStep, path string, bitDepth int) error {
+	samples, err := Rearrange(result, pattern)
+	if err != nil {
+		return err
+	}
+	if bitDepth == 0 {
+		bitDepth = 16
+	}
+	if err := WriteWav(path, samples, result.SampleRate, bitDepth); err != nil {
+		return fmt.Errorf("failed to write rearranged slices: %w", err)
+	}
+	return nil
+}
+
+// stutterFadeSec is the length in seconds of the fade out ending every gated
+// repeat of Stutter, which avoids clicks
+const stutterFadeSec = 0.002
+
+// StutterOptions configures Stutter
+type StutterOptions struct {
+	// Repeats is the number of times the slice is played. Default is 4 if 0.
+	Repeats int
+	// IntervalMs is the time between the starts of the repeats in
+	// milliseconds. Longer slices are cut and shorter ones followed by
+	// silence. Default is the length of the slice if 0.
+	IntervalMs float64
+	// Gate is the fraction of every interval played, from 0 to 1, the rest
+	// being silent. Default is 1 if 0.
+	Gate float64
+	// Decay is the gain of every repeat relative to the previous one, from 0
+	// to 1. Default is 1, no decay, if 0.
+	Decay float64
+}
+
+// Stutter returns a slice of the analyzed samples, by zero-based index,
+// played again and again as a beat repeat, gated and decaying as configured,
+// to turn detected slices into fills and stutters. The result must hold its
+// samples.
+func Stutter(result *SliceAnalyzerResult, slice int, options StutterOptions) ([]float64, error) {
+	if len(result.Samples) == 0 {
+		return nil, fmt.Errorf("result has no samples to repeat")
+	}
+	ranges := result.SliceRanges()
+	if slice < 0 || slice >= len(ranges) {
+		return nil, fmt.Errorf("invalid slice %d of %d", slice+1, len(ranges))
+	}
+	repeats := options.Repeats
+	if repeats == 0 {
+		repeats = 4
+	}
+	gate := options.Gate
+	if gate == 0 {
+		gate = 1
+	}
+	decay := options.Decay
+	if decay == 0 {
+		decay = 1
+	}
+	if repeats < 0 || options.IntervalMs < 0 || gate < 0 || gate > 1 || decay < 0 || decay > 1 {
+		return nil, fmt.Errorf("invalid stutter options %+v", options)
+	}
+
+	source := result.Samples[ranges[slice].Start:ranges[slice].End]
+	interval := len(source)
+	if options.IntervalMs > 0 {
+		interval = int(options.IntervalMs / 1000 * float64(result.SampleRate))
+	}
+	if interval == 0 {
+		return nil, fmt.Errorf("slice %d is empty", slice+1)
+	}
+	played := min(int(gate*float64(interval)), len(source))
+	fade := 0
+	if played < len(source) {
+		fade = min(int(stutterFadeSec*float64(result.SampleRate)), played)
+	}
+
+	out := make([]float64, repeats*interval)
+	gain := 1.0
+	for i := range repeats {
+		repeat := out[i*interval:]
+		for j, v := range source[:played] {
+			g := gain
+			if remaining := played - j; remaining <= fade {
+				g *= float64(remaining) / float64(fade+1)
+			}
+			repeat[j] = v * g
+		}
+		gain *= decay
+	}
+	return out, nil
+}
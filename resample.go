@@ -0,0 +1,103 @@
+package onset
+
+import "math"
+
+const (
+	// resampleZeroCrossings is the number of sinc zero crossings on each side of the kernel
+	resampleZeroCrossings = 16
+	// resampleTableDensity is the number of kernel table entries per zero crossing
+	resampleTableDensity = 512
+)
+
+// resampleKernel holds a Blackman-windowed sinc, sampled at resampleTableDensity
+// points per zero crossing, from 0 to resampleZeroCrossings
+var resampleKernel = func() []float64 {
+	n := resampleZeroCrossings*resampleTableDensity + 1
+	table := make([]float64, n+1)
+	for i := 0; i < n; i++ {
+		x := float64(i) / resampleTableDensity
+		sinc := 1.0
+		if x > 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		// Blackman window over [-resampleZeroCrossings, resampleZeroCrossings]
+		w := 0.42 + 0.5*math.Cos(math.Pi*x/resampleZeroCrossings) +
+			0.08*math.Cos(2*math.Pi*x/resampleZeroCrossings)
+		table[i] = sinc * w
+	}
+	return table
+}()
+
+// Resample converts samples from one sample rate to another using a
+// windowed-sinc interpolator. When downsampling, the kernel is widened so that
+// content above the new Nyquist frequency is filtered out before decimation.
+func Resample(samples []float64, fromRate, toRate uint) []float64 {
+	if fromRate == 0 || toRate == 0 || fromRate == toRate || len(samples) == 0 {
+		out := make([]float64, len(samples))
+		copy(out, samples)
+		return out
+	}
+
+	outLength := int(uint64(len(samples)) * uint64(toRate) / uint64(fromRate))
+	out := make([]float64, outLength)
+
+	// Cutoff relative to the input Nyquist frequency
+	cutoff := math.Min(1.0, float64(toRate)/float64(fromRate))
+	halfWidth := float64(resampleZeroCrossings) / cutoff
+
+	for n := range out {
+		// Exact input position of output sample n, as integer part and fraction
+		pos := uint64(n) * uint64(fromRate)
+		center := int(pos / uint64(toRate))
+		frac := float64(pos%uint64(toRate)) / float64(toRate)
+
+		first := int(math.Ceil(float64(center) + frac - halfWidth))
+		last := int(math.Floor(float64(center) + frac + halfWidth))
+		if first < 0 {
+			first = 0
+		}
+		if last >= len(samples) {
+			last = len(samples) - 1
+		}
+
+		sum := 0.0
+		for k := first; k <= last; k++ {
+			sum += samples[k] * resampleKernelAt((float64(k-center)-frac)*cutoff)
+		}
+		out[n] = sum * cutoff
+	}
+
+	return out
+}
+
+// resampleKernelAt interpolates the kernel table at x zero crossings from the center
+func resampleKernelAt(x float64) float64 {
+	x = math.Abs(x) * resampleTableDensity
+	i := int(x)
+	if i >= len(resampleKernel)-2 {
+		return 0
+	}
+	frac := x - float64(i)
+	return resampleKernel[i] + frac*(resampleKernel[i+1]-resampleKernel[i])
+}
+
+// resampleBy interpolates length samples every step input samples with the
+// windowed-sinc kernel of Resample, filtering content above the new Nyquist
+// frequency when step is above 1, for ratios that are not ratios of sample
+// rates
+func resampleBy(samples []float64, step float64, length int) []float64 {
+	out := make([]float64, length)
+	cutoff := math.Min(1.0, 1/step)
+	halfWidth := float64(resampleZeroCrossings) / cutoff
+	for n := range out {
+		pos := float64(n) * step
+		first := max(int(math.Ceil(pos-halfWidth)), 0)
+		last := min(int(math.Floor(pos+halfWidth)), len(samples)-1)
+		sum := 0.0
+		for k := first; k <= last; k++ {
+			sum += samples[k] * resampleKernelAt((float64(k)-pos)*cutoff)
+		}
+		out[n] = sum * cutoff
+	}
+	return out
+}
@@ -0,0 +1,142 @@
+package onset
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// Region is the time and sample range of one slice
+type Region struct {
+	// StartSec is the onset starting the slice in seconds
+	StartSec float64
+	// EndSec is the next onset in seconds, or the end of the samples for the
+	// last slice
+	EndSec float64
+	// StartSample and EndSample are the sample range of the slice, see
+	// SliceRanges
+	StartSample int
+	EndSample   int
+}
+
+// Regions returns the time and sample range of every slice, in the order of
+// Onsets. Times are clamped to the duration of the samples.
+func (r *SliceAnalyzerResult) Regions() []Region {
+	duration := r.duration()
+	ranges := r.SliceRanges()
+	regions := make([]Region, len(ranges))
+	for i, sr := range ranges {
+		end := duration
+		if i+1 < len(r.Onsets) {
+			end = r.Onsets[i+1]
+		}
+		start := min(max(r.Onsets[i], 0), duration)
+		regions[i] = Region{
+			StartSec:    start,
+			EndSec:      min(max(end, start), duration),
+			StartSample: sr.Start,
+			EndSample:   sr.End,
+		}
+	}
+	return regions
+}
+
+// Durations returns the duration in seconds of every slice, see Regions
+func (r *SliceAnalyzerResult) Durations() []float64 {
+	regions := r.Regions()
+	durations := make([]float64, len(regions))
+	for i, region := range regions {
+		durations[i] = region.EndSec - region.StartSec
+	}
+	return durations
+}
+
+// SliceIndexAt returns the index of the slice playing at timeSec seconds, the
+// last onset at or before it, or -1 before the first onset and past the end
+// of the samples
+func (r *SliceAnalyzerResult) SliceIndexAt(timeSec float64) int {
+	if timeSec >= r.duration() {
+		return -1
+	}
+	i, found := slices.BinarySearch(r.Onsets, timeSec)
+	if !found {
+		i--
+	}
+	return i
+}
+
+// duration returns the duration of the analyzed samples in seconds
+func (r *SliceAnalyzerResult) duration() float64 {
+	numSamples := r.NumSamples
+	if numSamples == 0 {
+		numSamples = len(r.Samples)
+	}
+	return float64(numSamples) / float64(r.SampleRate)
+}
+
+// WriteJSON writes the result to w as JSON with the field names of
+// SliceAnalyzerResult, so that it can be persisted and reloaded with
+// ReadResultJSON. Samples are left out, as they would dwarf the rest of the
+// result; decode the audio again if they are needed. NumSamples, and
+// Preview in streaming mode, still describe them.
+func (r *SliceAnalyzerResult) WriteJSON(w io.Writer) error {
+	entry := *r
+	entry.Samples = nil
+	if err := json.NewEncoder(w).Encode(&entry); err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+	return nil
+}
+
+// ReadResultJSON reads a result written by WriteJSON. Its Samples are empty.
+func ReadResultJSON(r io.Reader) (*SliceAnalyzerResult, error) {
+	var result SliceAnalyzerResult
+	if err := json.NewDecoder(r).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode result: %w", err)
+	}
+	if result.SampleRate == 0 {
+		return nil, fmt.Errorf("invalid result: sample rate is 0")
+	}
+	return &result, nil
+}
+
+// WriteCSV writes the slices of the result as CSV with one row per slice, see
+// Regions. The columns are the 1-based slice index, the start and end
+// times (seconds) and samples, and, when the loudness was measured, the
+// integrated loudness (LUFS) and true peak of the slice.
+func (r *SliceAnalyzerResult) WriteCSV(w io.Writer) error {
+	measured := r.SliceLoudness != nil
+	header := []string{"index", "start", "end", "start_sample", "end_sample"}
+	if measured {
+		header = append(header, "loudness", "true_peak")
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	for i, region := range r.Regions() {
+		record := []string{
+			strconv.Itoa(i + 1),
+			strconv.FormatFloat(region.StartSec, 'f', 6, 64),
+			strconv.FormatFloat(region.EndSec, 'f', 6, 64),
+			strconv.Itoa(region.StartSample),
+			strconv.Itoa(region.EndSample),
+		}
+		if measured {
+			record = append(record,
+				strconv.FormatFloat(r.SliceLoudness[i], 'f', 2, 64),
+				strconv.FormatFloat(r.SliceTruePeaks[i], 'g', -1, 64),
+			)
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV row: %w", err)
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,252 @@
+package onset
+
+import "sync"
+
+// SafeOnset wraps an Onset with a mutex so that one goroutine can feed audio
+// with Do while others change parameters or read the last onset, such as a UI
+// thread. Onset itself is not safe for concurrent use.
+//
+// Listeners subscribed with OnOnset are called with the lock held, so they must
+// not call methods of the SafeOnset.
+type SafeOnset struct {
+	mu sync.Mutex
+	o  *Onset
+}
+
+// NewSafeOnset creates a new onset detection object that is safe for
+// concurrent use
+func NewSafeOnset(onsetMode string, bufSize, hopSize, samplerate uint) *SafeOnset {
+	return WrapOnset(NewOnset(onsetMode, bufSize, hopSize, samplerate))
+}
+
+// WrapOnset returns a SafeOnset guarding o. The caller must not use o directly
+// afterwards.
+func WrapOnset(o *Onset) *SafeOnset {
+	return &SafeOnset{o: o}
+}
+
+// With calls fn with the underlying Onset while holding the lock, for
+// operations not covered by the SafeOnset methods
+func (s *SafeOnset) With(fn func(o *Onset)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.o)
+}
+
+// Do processes input and detects onsets
+func (s *SafeOnset) Do(input *Fvec, onset *Fvec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.o.Do(input, onset)
+}
+
+// DoEvent processes input and returns the detected onset, if any
+func (s *SafeOnset) DoEvent(input *Fvec) (*OnsetEvent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.DoEvent(input)
+}
+
+// OnOnset subscribes fn to the detected onsets and returns a function that
+// cancels the subscription
+func (s *SafeOnset) OnOnset(fn func(ev OnsetEvent)) (cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.o.OnOnset(fn)
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c()
+	}
+}
+
+// Params returns the current detection parameters
+func (s *SafeOnset) Params() Params {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.Params()
+}
+
+// ApplyParams sets all detection parameters at once, so that Do never sees a
+// partial update
+func (s *SafeOnset) ApplyParams(p Params) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.o.ApplyParams(p)
+}
+
+// Reset clears the whole detection state, as described for Onset.Reset
+func (s *SafeOnset) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.o.Reset()
+}
+
+// GetLast returns the time of the latest onset detected, in samples
+func (s *SafeOnset) GetLast() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetLast()
+}
+
+// GetLastS returns the time of the latest onset detected, in seconds
+func (s *SafeOnset) GetLastS() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetLastS()
+}
+
+// GetLastMs returns the time of the latest onset detected, in milliseconds
+func (s *SafeOnset) GetLastMs() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetLastMs()
+}
+
+// GetDescriptor returns the current value of the onset detection function
+func (s *SafeOnset) GetDescriptor() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetDescriptor()
+}
+
+// GetOnsetDescriptor returns the value of the onset detection function at the
+// peak of the last detected onset
+func (s *SafeOnset) GetOnsetDescriptor() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetOnsetDescriptor()
+}
+
+// GetThresholdedDescriptor returns the thresholded value of the onset detection function
+func (s *SafeOnset) GetThresholdedDescriptor() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetThresholdedDescriptor()
+}
+
+// SetAWhitening enables or disables adaptive whitening
+func (s *SafeOnset) SetAWhitening(enable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.o.SetAWhitening(enable)
+}
+
+// GetAWhitening returns whether adaptive whitening is enabled
+func (s *SafeOnset) GetAWhitening() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetAWhitening()
+}
+
+// SetCompression sets the compression lambda value
+func (s *SafeOnset) SetCompression(lambda float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.o.SetCompression(lambda)
+}
+
+// GetCompression returns the compression lambda value
+func (s *SafeOnset) GetCompression() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetCompression()
+}
+
+// SetSilence sets the silence threshold
+func (s *SafeOnset) SetSilence(silence float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.o.SetSilence(silence)
+}
+
+// GetSilence returns the silence threshold
+func (s *SafeOnset) GetSilence() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetSilence()
+}
+
+// SetDescriptorThreshold sets the magnitude threshold of the spectral bins
+func (s *SafeOnset) SetDescriptorThreshold(threshold float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.o.SetDescriptorThreshold(threshold)
+}
+
+// GetDescriptorThreshold returns the magnitude threshold of the spectral bins
+func (s *SafeOnset) GetDescriptorThreshold() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetDescriptorThreshold()
+}
+
+// SetFlatnessGate sets the minimum spectral flatness of onset frames
+func (s *SafeOnset) SetFlatnessGate(minFlatness float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.o.SetFlatnessGate(minFlatness)
+}
+
+// GetFlatnessGate returns the minimum spectral flatness of onset frames
+func (s *SafeOnset) GetFlatnessGate() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetFlatnessGate()
+}
+
+// SetCrestGate sets the maximum spectral crest factor of onset frames
+func (s *SafeOnset) SetCrestGate(maxCrest float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.o.SetCrestGate(maxCrest)
+}
+
+// GetCrestGate returns the maximum spectral crest factor of onset frames
+func (s *SafeOnset) GetCrestGate() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetCrestGate()
+}
+
+// SetThreshold sets the peak picking threshold
+func (s *SafeOnset) SetThreshold(threshold float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.o.SetThreshold(threshold)
+}
+
+// GetThreshold returns the peak picking threshold
+func (s *SafeOnset) GetThreshold() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetThreshold()
+}
+
+// SetMinioiMs sets the minimum inter-onset interval in milliseconds
+func (s *SafeOnset) SetMinioiMs(minioi float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.o.SetMinioiMs(minioi)
+}
+
+// GetMinioiMs returns the minimum inter-onset interval in milliseconds
+func (s *SafeOnset) GetMinioiMs() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetMinioiMs()
+}
+
+// SetDelayMs sets the constant delay in milliseconds
+func (s *SafeOnset) SetDelayMs(delay float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.o.SetDelayMs(delay)
+}
+
+// GetDelayMs returns the constant delay in milliseconds
+func (s *SafeOnset) GetDelayMs() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.o.GetDelayMs()
+}
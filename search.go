@@ -0,0 +1,176 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ParamGrid lists the parameter values swept by SearchParams. Every
+// combination of the values is analyzed.
+type ParamGrid struct {
+	// Methods are the detection methods. Default is "hfc" if empty. The
+	// "consensus" method is not supported.
+	Methods []string
+	// Thresholds are the peak picking thresholds, which must be positive.
+	// Default is the threshold of the analyzer (0.02) if empty.
+	Thresholds []float64
+	// MinioiMs are the minimum inter-onset intervals in milliseconds, which
+	// must be positive. Default is the interval of the analyzer (10 ms) if
+	// empty.
+	MinioiMs []float64
+	// AWhitening are the adaptive whitening settings. Default is the setting
+	// of each method if empty.
+	AWhitening []bool
+	// Options are the analyzer options the swept parameters are applied to.
+	// Method, Threshold, MinioiMs, Params, Preset and Tiers are replaced.
+	// Default is DefaultSliceAnalyzerOptions if nil.
+	Options *SliceAnalyzerOptions
+}
+
+// Objective is the score SearchParams ranks parameter combinations by: the
+// F-measure against reference annotations if Reference is set, and otherwise
+// the closeness to a target number of onsets
+type Objective struct {
+	// TargetCount is the number of onsets wanted. Combinations are scored
+	// 1/(1+d), d being the difference between their count and TargetCount.
+	TargetCount int
+	// Reference are the annotated onset times in seconds. Combinations are
+	// scored by the F-measure of their onsets, see MatchOnsets.
+	Reference []float64
+	// Tolerance is the distance in seconds within which a detected onset
+	// matches a reference onset. Default is 0.05 if 0.
+	Tolerance float64
+}
+
+// ParamScore is the score of a parameter combination evaluated by
+// SearchParams
+type ParamScore struct {
+	// Params are the parameters of the combination, which can be saved with
+	// SaveParams or applied with SliceAnalyzerOptions.Params
+	Params Params
+	// Count is the number of onsets detected
+	Count int
+	// Match compares the onsets with the reference of the objective. It is
+	// zero for a target count.
+	Match MatchResult
+	// Score is the value of the objective, from 0 to 1; higher is better
+	Score float64
+}
+
+// SearchParams analyzes an audio file with every combination of the grid and
+// returns the scores of the combinations from the best to the worst, ties
+// kept in grid order, so that detection parameters can be tuned on
+// representative material. The file is decoded once.
+func SearchParams(file string, grid ParamGrid, objective Objective) ([]ParamScore, error) {
+	if objective.Reference == nil && objective.TargetCount <= 0 {
+		return nil, fmt.Errorf("objective needs a positive target count or reference onsets")
+	}
+	base := DefaultSliceAnalyzerOptions()
+	if grid.Options != nil {
+		base = *grid.Options
+	}
+	base.Preset, base.Tiers = "", nil
+	combinations, err := gridParams(grid, base)
+	if err != nil {
+		return nil, err
+	}
+	if base.DecoderFallback != "" && base.DecoderFallback != DecoderFallbackFFmpeg {
+		return nil, fmt.Errorf("unknown decoder fallback %q", base.DecoderFallback)
+	}
+
+	s, err := openAudioFile(file, base.DecoderFallback)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	channels, sampleRate, err := readStream(s)
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+
+	tolerance := objective.Tolerance
+	if tolerance == 0 {
+		tolerance = 0.05
+	}
+	scores := make([]ParamScore, len(combinations))
+	for i, p := range combinations {
+		options := base
+		options.Method = p.Method
+		options.Threshold, options.MinioiMs = p.Threshold, p.MinioiMs
+		options.Params = &p
+		result, err := analyzeChannels(channels, sampleRate, options, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze %s at threshold %g: %w", p.Method, p.Threshold, err)
+		}
+
+		score := ParamScore{Params: p, Count: len(result.Onsets)}
+		if objective.Reference != nil {
+			score.Match = MatchOnsets(objective.Reference, result.Onsets, tolerance)
+			score.Score = score.Match.FMeasure
+		} else {
+			score.Score = 1 / (1 + math.Abs(float64(score.Count-objective.TargetCount)))
+		}
+		scores[i] = score
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+	return scores, nil
+}
+
+// gridParams returns the parameters of every combination of the grid, with
+// the defaults of base and of the methods for the values not swept
+func gridParams(grid ParamGrid, base SliceAnalyzerOptions) ([]Params, error) {
+	methods := grid.Methods
+	if len(methods) == 0 {
+		methods = []string{"hfc"}
+	}
+	base.Params = nil
+	defaultThreshold, defaultMinioi := detectionParams(base)
+	thresholds := grid.Thresholds
+	if len(thresholds) == 0 {
+		thresholds = []float64{defaultThreshold}
+	}
+	minioi := grid.MinioiMs
+	if len(minioi) == 0 {
+		minioi = []float64{defaultMinioi}
+	}
+	// The analyzer replaces values that are not positive with its defaults,
+	// which would be reported as the values tried
+	for _, threshold := range thresholds {
+		if !(threshold > 0) {
+			return nil, fmt.Errorf("invalid threshold %g in parameter grid: must be positive", threshold)
+		}
+	}
+	for _, interval := range minioi {
+		if !(interval > 0) {
+			return nil, fmt.Errorf("invalid minimum inter-onset interval %g ms in parameter grid: must be positive", interval)
+		}
+	}
+
+	var combinations []Params
+	for _, method := range methods {
+		if method == "consensus" || !isMethod(method) {
+			return nil, fmt.Errorf("unsupported method %q for parameter search", method)
+		}
+		spec, _ := lookupMethod(method)
+		whitening := grid.AWhitening
+		if len(whitening) == 0 {
+			whitening = []bool{spec.AWhitening}
+		}
+		for _, threshold := range thresholds {
+			for _, interval := range minioi {
+				for _, enable := range whitening {
+					p := methodParams(method, threshold, interval)
+					p.AWhitening = enable
+					combinations = append(combinations, p)
+				}
+			}
+		}
+	}
+	return combinations, nil
+}
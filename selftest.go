@@ -0,0 +1,148 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+)
+
+// selfTestToleranceSec is the distance in seconds within which SelfTest
+// expects every onset of the reference signal to be detected
+const selfTestToleranceSec = 0.02
+
+// selfTestOnsets are the onset times in seconds of the reference signal
+var selfTestOnsets = []float64{0.25, 0.75, 1.25, 1.75, 2.25, 2.75}
+
+// SelfTestResult is the outcome of SelfTest for one detection method
+type SelfTestResult struct {
+	// Method is the detection method
+	Method string
+	// Onsets are the onset times in seconds detected in the reference signal
+	Onsets []float64
+	// Match compares the detected onsets with those of the reference signal
+	Match MatchResult
+	// MaxErrorMs is the largest distance in milliseconds between an onset of
+	// the reference signal and the nearest detected onset
+	MaxErrorMs float64
+	// Passed reports whether every onset was detected within the tolerance,
+	// without spurious onsets
+	Passed bool
+}
+
+// SelfTestReport is the outcome of SelfTest
+type SelfTestReport struct {
+	// GoVersion, GOOS and GOARCH describe the environment of the test
+	GoVersion string
+	GOOS      string
+	GOARCH    string
+	// Accelerated reports whether the accelerated kernels were used
+	Accelerated bool
+	// SampleRate is the sample rate of the reference signal
+	SampleRate uint
+	// ToleranceMs is the tolerance of onset times in milliseconds
+	ToleranceMs float64
+	// Results holds the outcome of every built-in method
+	Results []SelfTestResult
+	// Passed reports whether every method passed
+	Passed bool
+}
+
+// SelfTest synthesizes a reference signal of noise bursts at known times,
+// runs every built-in detection method on it with its default parameters,
+// and checks that each detects exactly the bursts, within 20 ms. Downstream
+// projects can run it in CI to catch numeric regressions of the toolchain,
+// platform or accelerated kernels.
+func SelfTest() SelfTestReport {
+	const sampleRate, bufSize, hopSize = 44100, 512, 256
+	samples := selfTestSignal(sampleRate)
+	report := SelfTestReport{
+		GoVersion:   runtime.Version(),
+		GOOS:        runtime.GOOS,
+		GOARCH:      runtime.GOARCH,
+		Accelerated: Accelerated(),
+		SampleRate:  sampleRate,
+		ToleranceMs: selfTestToleranceSec * 1000,
+		Passed:      true,
+	}
+
+	for _, spec := range builtinMethods() {
+		o := NewOnset(spec.Name, bufSize, hopSize, sampleRate)
+		input, output := NewFvec(hopSize), NewFvec(1)
+		var onsets []float64
+		for start := 0; start+hopSize <= len(samples); start += hopSize {
+			copy(input.Data, samples[start:start+hopSize])
+			o.Do(input, output)
+			if output.Data[0] > 0 {
+				onsets = append(onsets, o.GetLastS())
+			}
+		}
+
+		result := SelfTestResult{
+			Method: spec.Name,
+			Onsets: onsets,
+			Match:  MatchOnsets(selfTestOnsets, onsets, selfTestToleranceSec),
+		}
+		for _, expected := range selfTestOnsets {
+			nearest := math.Inf(1)
+			for _, detected := range onsets {
+				nearest = math.Min(nearest, math.Abs(detected-expected))
+			}
+			result.MaxErrorMs = math.Max(result.MaxErrorMs, nearest*1000)
+		}
+		result.Passed = result.Match.Matched == len(selfTestOnsets) && result.Match.FalsePositives == 0
+		report.Passed = report.Passed && result.Passed
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// String formats the report as a table with one line per method
+func (r SelfTestReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s/%s, accelerated %t, tolerance %g ms\n", r.GoVersion, r.GOOS, r.GOARCH, r.Accelerated, r.ToleranceMs)
+	for _, result := range r.Results {
+		status := "ok"
+		if !result.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&b, "%-4s %-9s %d/%d onsets, %d spurious, max error %.1f ms\n",
+			status, result.Method, result.Match.Matched, len(selfTestOnsets), result.Match.FalsePositives, result.MaxErrorMs)
+	}
+	if r.Passed {
+		b.WriteString("PASS\n")
+	} else {
+		b.WriteString("FAIL\n")
+	}
+	return b.String()
+}
+
+// builtinMethods returns the built-in detection methods, without the legacy
+// and custom ones
+func builtinMethods() []MethodSpec {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var specs []MethodSpec
+	for _, spec := range methodRegistry {
+		if !spec.Legacy && !spec.Custom {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// selfTestSignal returns the reference signal of SelfTest: bursts of
+// exponentially decaying pseudo-random noise at selfTestOnsets over silence
+func selfTestSignal(sampleRate uint) []float64 {
+	samples := make([]float64, int(3.25*float64(sampleRate)))
+	seed := uint32(1)
+	for _, start := range selfTestOnsets {
+		offset := int(start * float64(sampleRate))
+		for i := 0; i < int(sampleRate)/10 && offset+i < len(samples); i++ {
+			seed = seed*1664525 + 1013904223
+			noise := float64(seed)/float64(1<<32)*2 - 1
+			samples[offset+i] = noise * math.Exp(-float64(i)/800.0)
+		}
+	}
+	return samples
+}
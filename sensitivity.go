@@ -0,0 +1,104 @@
+package onset
+
+import "fmt"
+
+// SensitivityOptions configures SensitivityCurve
+type SensitivityOptions struct {
+	// Method is the detection method. Default is "hfc" if empty; the
+	// "consensus" method is not supported.
+	Method string
+	// MinThreshold and MaxThreshold delimit the thresholds swept. Default is
+	// 0 to 2 if MaxThreshold is 0.
+	MinThreshold float64
+	MaxThreshold float64
+	// Steps is the number of thresholds evenly spaced from MinThreshold to
+	// MaxThreshold. Default is 50 if 0.
+	Steps int
+	// Reference are annotated onset times in seconds. If set, the onsets of
+	// every threshold are compared with them, see MatchOnsets.
+	Reference []float64
+	// Tolerance is the distance in seconds within which a detected onset
+	// matches a reference onset. Default is 0.05 if 0.
+	Tolerance float64
+}
+
+// SensitivityPoint is the detection at one threshold of a sensitivity curve
+type SensitivityPoint struct {
+	// Threshold is the peak picking threshold
+	Threshold float64
+	// Count is the number of onsets detected
+	Count int
+	// Match compares the onsets with the reference. It is zero without a
+	// reference.
+	Match MatchResult
+}
+
+// SensitivityCurve sweeps the peak picking threshold of a method over a
+// range and returns the number of onsets detected at every threshold, and
+// their match with a reference if given, with the settings of
+// DefaultSliceAnalyzerOptions before position optimization. The count falls
+// as the threshold rises; thresholds in a long plateau of constant count,
+// see Plateau, give a detection that is robust to the material. All
+// thresholds are evaluated in one pass over the audio.
+func SensitivityCurve(samples []float64, sampleRate uint, options SensitivityOptions) ([]SensitivityPoint, error) {
+	if sampleRate == 0 {
+		return nil, fmt.Errorf("invalid sample rate: %d", sampleRate)
+	}
+	method := options.Method
+	if method == "" {
+		method = "hfc"
+	}
+	if method == "consensus" || !isMethod(method) {
+		return nil, fmt.Errorf("unsupported method %q for the sensitivity curve", method)
+	}
+	maxThreshold := options.MaxThreshold
+	if maxThreshold == 0 {
+		maxThreshold = 2
+	}
+	if maxThreshold < options.MinThreshold {
+		return nil, fmt.Errorf("invalid threshold range %g to %g", options.MinThreshold, maxThreshold)
+	}
+	steps := options.Steps
+	if steps == 0 {
+		steps = 50
+	}
+	if steps < 2 {
+		return nil, fmt.Errorf("invalid number of steps %d: must be at least 2", steps)
+	}
+	tolerance := options.Tolerance
+	if tolerance == 0 {
+		tolerance = 0.05
+	}
+
+	thresholds := make([]float64, steps)
+	for i := range thresholds {
+		thresholds[i] = options.MinThreshold + (maxThreshold-options.MinThreshold)*float64(i)/float64(steps-1)
+	}
+	points := make([]SensitivityPoint, steps)
+	for i, onsets := range onsetsAt(samples, sampleRate, method, thresholds) {
+		points[i] = SensitivityPoint{Threshold: thresholds[i], Count: len(onsets)}
+		if options.Reference != nil {
+			points[i].Match = MatchOnsets(options.Reference, onsets, tolerance)
+		}
+	}
+	return points, nil
+}
+
+// Plateau returns the indices of the first and last points of the longest run
+// of consecutive points of a sensitivity curve with the same, nonzero, onset
+// count; the threshold in its middle is a stable choice. It returns -1, -1 if
+// no point has onsets.
+func Plateau(points []SensitivityPoint) (int, int) {
+	first, last := -1, -1
+	for start := 0; start < len(points); {
+		end := start
+		for end+1 < len(points) && points[end+1].Count == points[start].Count {
+			end++
+		}
+		if points[start].Count > 0 && (first < 0 || end-start > last-first) {
+			first, last = start, end
+		}
+		start = end + 1
+	}
+	return first, last
+}
@@ -0,0 +1,253 @@
+package onset
+
+import "math"
+
+// Spectra of the spectral shape measurements
+const (
+	shapeBufSize = 2048
+	shapeHopSize = 512
+	// shapeRolloff is the fraction of the energy below the rolloff frequency
+	shapeRolloff = 0.95
+	// flatnessFloor bounds the energies of the bins before their logarithm
+	flatnessFloor = 1e-10
+)
+
+// shapeDescriptors maps the names of the spectral shape modes of NewSpecdesc
+// to their descriptor
+var shapeDescriptors = map[string]SpecdescType{
+	"centroid": SpecCentroid,
+	"spread":   SpecSpread,
+	"skewness": SpecSkewness,
+	"kurtosis": SpecKurtosis,
+	"slope":    SpecSlope,
+	"decrease": SpecDecrease,
+	"rolloff":  SpecRolloff,
+	"flatness": SpecFlatness,
+	"crest":    SpecCrest,
+}
+
+// SpectralShape describes the shape of the magnitude spectrum of a frame,
+// or its mean over the frames of a slice, such as to sort slices from dull
+// to bright or to tell kicks from hats
+type SpectralShape struct {
+	// Centroid is the center of mass of the spectrum in Hz, its brightness
+	Centroid float64
+	// Spread is the standard deviation of the spectrum around its centroid
+	// in Hz, its bandwidth
+	Spread float64
+	// Skewness is the asymmetry of the spectrum around its centroid,
+	// positive when most of the energy is below it
+	Skewness float64
+	// Kurtosis is the peakedness of the spectrum around its centroid, 1.8
+	// for a flat spectrum
+	Kurtosis float64
+	// Slope is the slope of the linear regression of the magnitudes over
+	// the bins, normalized by their sum, negative when the magnitudes fall
+	// with frequency
+	Slope float64
+	// Decrease is the mean decrease of the magnitudes from the first bin,
+	// weighted towards low frequencies
+	Decrease float64
+	// Rolloff is the frequency in Hz below which 95% of the energy lies
+	Rolloff float64
+	// Flatness is the geometric mean of the energies of the bins divided by
+	// their arithmetic mean, near 0 for tones and about 0.56 for white noise
+	Flatness float64
+	// Crest is the largest magnitude divided by their mean, about 3 for
+	// white noise and larger for tones, the more so with longer spectra
+	Crest float64
+}
+
+// SpectralShapes returns the spectral shape of every hop of samples, spectra
+// of bufSize samples every hopSize samples, the last ones zero-padded.
+// Silent frames are all zeros.
+func SpectralShapes(samples []float64, sampleRate, bufSize, hopSize uint) []SpectralShape {
+	shapes, _ := spectralShapes(samples, sampleRate, bufSize, hopSize)
+	return shapes
+}
+
+// spectralShapes returns the spectral shape of every hop of samples and the
+// sum of the magnitudes of its spectrum
+func spectralShapes(samples []float64, sampleRate, bufSize, hopSize uint) ([]SpectralShape, []float64) {
+	if bufSize == 0 || hopSize == 0 {
+		return nil, nil
+	}
+	p := NewPvoc(bufSize, hopSize)
+	grain := NewCvec(bufSize)
+	frame := NewFvec(bufSize)
+	binHz := float64(sampleRate) / float64(bufSize)
+
+	var shapes []SpectralShape
+	var weights []float64
+	for pos := 0; pos < len(samples); pos += int(hopSize) {
+		frame.Zeros()
+		copy(frame.Data, samples[pos:min(pos+int(bufSize), len(samples))])
+		p.Do(frame, grain)
+		norm := grain.Norm[:grain.Length]
+		centroid, spread, skewness, kurtosis := spectralMoments(norm)
+		shapes = append(shapes, SpectralShape{
+			Centroid: centroid * binHz,
+			Spread:   math.Sqrt(spread) * binHz,
+			Skewness: skewness,
+			Kurtosis: kurtosis,
+			Slope:    spectralSlope(norm),
+			Decrease: spectralDecrease(norm),
+			Rolloff:  spectralRolloff(norm) * binHz,
+			Flatness: spectralFlatness(norm),
+			Crest:    spectralCrest(norm),
+		})
+		weights = append(weights, sum(norm))
+	}
+	return shapes, weights
+}
+
+// measureShapes sets the spectral shape of every slice of r, the mean of
+// the shapes of its frames weighted by their magnitudes, so that the decay
+// into silence does not dilute the attack. Slices shorter than a frame are
+// measured on one zero-padded frame.
+func (r *SliceAnalyzerResult) measureShapes() {
+	ranges := r.SliceRanges()
+	r.SliceShapes = make([]SpectralShape, len(ranges))
+	for i, sr := range ranges {
+		shapes, weights := spectralShapes(r.Samples[sr.Start:sr.End], r.SampleRate, shapeBufSize, shapeHopSize)
+		total := sum(weights)
+		if total == 0 {
+			continue
+		}
+		mean := &r.SliceShapes[i]
+		for j, s := range shapes {
+			w := weights[j] / total
+			mean.Centroid += w * s.Centroid
+			mean.Spread += w * s.Spread
+			mean.Skewness += w * s.Skewness
+			mean.Kurtosis += w * s.Kurtosis
+			mean.Slope += w * s.Slope
+			mean.Decrease += w * s.Decrease
+			mean.Rolloff += w * s.Rolloff
+			mean.Flatness += w * s.Flatness
+			mean.Crest += w * s.Crest
+		}
+	}
+}
+
+// sum returns the sum of values
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// spectralMoments returns the centroid of the magnitudes in bins and their
+// variance, skewness and kurtosis around it, all 0 for silence
+func spectralMoments(norm []float64) (centroid, spread, skewness, kurtosis float64) {
+	total := sum(norm)
+	if total == 0 {
+		return 0, 0, 0, 0
+	}
+	for j, v := range norm {
+		centroid += float64(j) * v
+	}
+	centroid /= total
+	var m3, m4 float64
+	for j, v := range norm {
+		d := float64(j) - centroid
+		spread += d * d * v
+		m3 += d * d * d * v
+		m4 += d * d * d * d * v
+	}
+	spread /= total
+	if spread == 0 {
+		return centroid, 0, 0, 0
+	}
+	return centroid, spread, m3 / total / math.Pow(spread, 1.5), m4 / total / (spread * spread)
+}
+
+// spectralSlope returns the slope of the linear regression of the
+// magnitudes over the bins, divided by their sum
+func spectralSlope(norm []float64) float64 {
+	total := sum(norm)
+	if total == 0 || len(norm) < 2 {
+		return 0
+	}
+	n := float64(len(norm))
+	var sumX, sumX2, sumXY float64
+	for j, v := range norm {
+		x := float64(j)
+		sumX += x
+		sumX2 += x * x
+		sumXY += x * v
+	}
+	return (n*sumXY - sumX*total) / (n*sumX2 - sumX*sumX) / total
+}
+
+// spectralDecrease returns the mean decrease of the magnitudes from the
+// first bin, each divided by its distance to it, divided by their sum
+func spectralDecrease(norm []float64) float64 {
+	if len(norm) < 2 {
+		return 0
+	}
+	total := sum(norm[1:])
+	if total == 0 {
+		return 0
+	}
+	decrease := 0.0
+	for j := 1; j < len(norm); j++ {
+		decrease += (norm[j] - norm[0]) / float64(j)
+	}
+	return decrease / total
+}
+
+// spectralRolloff returns the first bin below which shapeRolloff of the
+// energy lies
+func spectralRolloff(norm []float64) float64 {
+	energy := 0.0
+	for _, v := range norm {
+		energy += v * v
+	}
+	if energy == 0 {
+		return 0
+	}
+	cumulative := 0.0
+	for j, v := range norm {
+		cumulative += v * v
+		if cumulative >= shapeRolloff*energy {
+			return float64(j)
+		}
+	}
+	return float64(len(norm) - 1)
+}
+
+// spectralFlatness returns the geometric mean of the energies of the bins
+// divided by their arithmetic mean, in [0, 1], 0 for silence
+func spectralFlatness(norm []float64) float64 {
+	if len(norm) == 0 {
+		return 0
+	}
+	var logSum, energy float64
+	for _, v := range norm {
+		e := max(v*v, flatnessFloor)
+		logSum += math.Log(e)
+		energy += e
+	}
+	n := float64(len(norm))
+	if energy <= flatnessFloor*n {
+		return 0
+	}
+	return math.Exp(logSum/n) / (energy / n)
+}
+
+// spectralCrest returns the largest magnitude divided by their mean, 0 for
+// silence
+func spectralCrest(norm []float64) float64 {
+	total := sum(norm)
+	if total == 0 {
+		return 0
+	}
+	peak := 0.0
+	for _, v := range norm {
+		peak = max(peak, v)
+	}
+	return peak / (total / float64(len(norm)))
+}
@@ -0,0 +1,115 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// silenceFrameMs is the length in milliseconds of the frames whose level
+// SplitOnSilence compares to the threshold
+const silenceFrameMs = 10
+
+// SilenceOptions configures SplitOnSilence
+type SilenceOptions struct {
+	// ThresholdDB is the RMS level in dBFS below which a frame is silent.
+	// Default is -50 if 0.
+	ThresholdDB float64
+	// MinSilenceMs is the shortest silence in milliseconds that separates
+	// two segments. Default is 300 if 0.
+	MinSilenceMs float64
+	// MinSegmentMs is the shortest segment in milliseconds kept, shorter
+	// ones such as clicks being dropped. Default is 100 if 0.
+	MinSegmentMs float64
+}
+
+// SplitOnSilence returns the sample ranges of the sounding segments of
+// samples, separated by silences of at least MinSilenceMs, leading and
+// trailing silences excluded. Levels are measured over frames of
+// silenceFrameMs, so the boundaries are accurate to a frame. It complements
+// onset detection for spoken word and sparse recordings, where silence
+// rather than spectral change separates the parts.
+func SplitOnSilence(samples []float64, sampleRate uint, options SilenceOptions) ([]SliceRange, error) {
+	threshold, minSilenceMs, minSegmentMs := options.ThresholdDB, options.MinSilenceMs, options.MinSegmentMs
+	if threshold == 0 {
+		threshold = -50
+	}
+	if minSilenceMs == 0 {
+		minSilenceMs = 300
+	}
+	if minSegmentMs == 0 {
+		minSegmentMs = 100
+	}
+	if threshold > 0 || minSilenceMs < 0 || minSegmentMs < 0 || sampleRate == 0 {
+		return nil, fmt.Errorf("invalid silence options %+v at %d Hz", options, sampleRate)
+	}
+
+	frame := max(int(sampleRate)*silenceFrameMs/1000, 1)
+	minSilence := int(math.Ceil(minSilenceMs / 1000 * float64(sampleRate) / float64(frame)))
+	minSegment := int(minSegmentMs / 1000 * float64(sampleRate))
+	limit := math.Pow(10, threshold/20)
+
+	var segments []SliceRange
+	emit := func(start, end int) {
+		if end-start >= max(minSegment, 1) {
+			segments = append(segments, SliceRange{Start: start, End: end})
+		}
+	}
+	// start is the first sample of the current segment, and silence that of
+	// the silent frames ending it, if any, of which there are silent
+	start, silence, silent := -1, 0, 0
+	for i := 0; i < len(samples); i += frame {
+		if rootMeanSquare(samples[i:min(i+frame, len(samples))]) >= limit {
+			if start < 0 {
+				start = i
+			}
+			silent = 0
+			continue
+		}
+		if start < 0 {
+			continue
+		}
+		if silent == 0 {
+			silence = i
+		}
+		silent++
+		if silent >= minSilence {
+			emit(start, silence)
+			start, silent = -1, 0
+		}
+	}
+	if start >= 0 {
+		if silent == 0 {
+			silence = len(samples)
+		}
+		emit(start, silence)
+	}
+	return segments, nil
+}
+
+// TrimSilence returns samples without their leading and trailing silence,
+// the samples whose magnitude is below thresholdDB dBFS (-50 if 0), keeping
+// padMs milliseconds of it on each side, and the numbers of leading and
+// trailing samples removed, so that exports and analysis start at the
+// audio. The trimmed samples share the memory of samples, and are empty
+// with every sample counted as leading if all are silent.
+func TrimSilence(samples []float64, sampleRate uint, thresholdDB, padMs float64) (trimmed []float64, leading, trailing int) {
+	if thresholdDB == 0 {
+		thresholdDB = -50
+	}
+	limit := math.Pow(10, thresholdDB/20)
+	loud := func(v float64) bool { return math.Abs(v) >= limit }
+
+	first := slices.IndexFunc(samples, loud)
+	if first < 0 {
+		return samples[:0], len(samples), 0
+	}
+	last := len(samples) - 1
+	for !loud(samples[last]) {
+		last--
+	}
+
+	pad := max(int(padMs/1000*float64(sampleRate)), 0)
+	start, end := max(first-pad, 0), min(last+1+pad, len(samples))
+	return samples[start:end], start, len(samples) - end
+}
@@ -1,41 +1,232 @@
 package onset
 
 import (
+	"encoding/csv"
 	"fmt"
+	"io"
 	"math"
-	"os"
+	"slices"
 	"sort"
-
-	"github.com/go-audio/wav"
+	"strconv"
+	"strings"
 )
 
 // SliceAnalyzerResult contains the results of slice analysis
 type SliceAnalyzerResult struct {
 	// Onsets contains the detected onset times in seconds
 	Onsets []float64
-	// Samples contains the audio samples (left channel only for stereo files)
+	// Samples contains the analyzed audio samples (the selected channel for multichannel
+	// files, or the mix of all channels in "per-channel" mode)
 	Samples []float64
 	// SampleRate is the sample rate of the audio file
 	SampleRate uint
+	// NumSamples is the number of analyzed samples. It is set even when Samples
+	// is not retained in streaming mode.
+	NumSamples int
+	// Preview contains a decimated waveform of the analyzed samples in streaming
+	// mode, holding the peak of every PreviewDecimation samples
+	Preview []float64
+	// PreviewDecimation is the number of samples summarized by each Preview point
+	PreviewDecimation int
+	// Detection contains the per-hop onset detection curve of the analysis method.
+	// It is empty for the "consensus" method and the "per-channel" channel mode,
+	// which combine several curves.
+	Detection []DetectionFrame
+	// ChannelOnsets contains the onset times in seconds of each channel when
+	// the "per-channel" channel mode is used. Onsets then holds the union of
+	// all channels.
+	ChannelOnsets [][]float64
+	// Tiers contains the onsets of the sensitivity tiers requested with
+	// SliceAnalyzerOptions.Tiers, from the strictest to the loosest. Each tier
+	// holds only the onsets that stricter tiers missed; use OnsetsUpTo for
+	// the onsets shown at a given sensitivity.
+	Tiers []TierOnsets
+	// Loudness is the EBU R128 integrated loudness of the analyzed samples
+	// in LUFS, measured when SliceAnalyzerOptions.MeasureLoudness is set
+	Loudness float64
+	// SliceLoudness contains the integrated loudness in LUFS of every slice,
+	// see SliceRanges, measured when SliceAnalyzerOptions.MeasureLoudness is
+	// set
+	SliceLoudness []float64
+	// TruePeak is the true peak amplitude of the analyzed samples, see
+	// TruePeak, above 1 when they clip between samples, measured when
+	// SliceAnalyzerOptions.MeasureLoudness is set
+	TruePeak float64
+	// SliceTruePeaks contains the true peak amplitude of every slice,
+	// measured when SliceAnalyzerOptions.MeasureLoudness is set
+	SliceTruePeaks []float64
+	// SliceShapes contains the spectral shape of every slice, see
+	// SliceRanges, measured when SliceAnalyzerOptions.MeasureShape is set
+	SliceShapes []SpectralShape
+	// Tempo is the tempo estimated from Onsets, see EstimateTempo, and
+	// BeatGrid the bar and beat of each of its beats, set when
+	// SliceAnalyzerOptions.MeasureTempo is set, so that slices can be shown
+	// against musical time
+	Tempo    TempoEstimate
+	BeatGrid []GridBeat
+	// Slices contains the samples of every slice, see SliceRanges, when
+	// SliceAnalyzerOptions.KeepSlices is set, so that samplers can play them
+	// without cutting Samples again. They share the memory of Samples and
+	// are capped, so that appending to one copies it instead of overwriting
+	// the next. Not persisted by WriteJSON.
+	Slices [][]float64 `json:"-"`
+	// Votes contains, for the "consensus" method, the detections of the
+	// methods that agreed on every onset, in the order of Onsets, so that a
+	// unanimous hit can be told from a marginal agreement. Onsets added by
+	// MaxSliceMs have no votes. It is empty for other methods and the
+	// "per-channel" channel mode.
+	Votes [][]ConsensusVote
+	// Options are the options of the analysis, with the material preset
+	// applied, so that reports can show how the onsets were found
+	Options SliceAnalyzerOptions
+}
+
+// Ranking of candidate onsets for SliceAnalyzerOptions.Selection
+const (
+	// SelectionEnergy ranks onsets by the RMS energy of the audio around them
+	SelectionEnergy = "energy"
+	// SelectionStrength ranks onsets by the detection function value at
+	// their peak
+	SelectionStrength = "strength"
+)
+
+// ConsensusVote is the detection of a consensus onset by one method
+type ConsensusVote struct {
+	// Method is the detection method
+	Method string
+	// Time is the onset time in seconds detected by the method, before the
+	// consensus midpoint and any refinement
+	Time float64
+}
+
+// DetectionFrame holds the onset detection function values for a single hop
+type DetectionFrame struct {
+	// Time is the start of the hop in seconds
+	Time float64
+	// Descriptor is the raw onset detection function value
+	Descriptor float64
+	// Thresholded is the detection function value after peak picker thresholding
+	Thresholded float64
+	// Onset reports whether an onset was marked at this hop
+	Onset bool
 }
 
 // SliceAnalyzerOptions contains configuration options for slice analysis
 type SliceAnalyzerOptions struct {
 	// NumSlices specifies the number of slices to find.
 	// If 0 (default), all onsets are detected.
-	// If > 0, the best N onsets based on energy are selected.
+	// If > 0, the best N onsets ranked by Selection are selected. MaxSliceMs
+	// subdivides the slices afterwards, so the result can then have more.
 	NumSlices int
+	// Selection ranks the candidate onsets when NumSlices > 0:
+	// "energy" keeps the onsets with the loudest audio around them, and
+	// "strength" those with the highest detection function value, which
+	// better preserves the sharpest hits of sparse material and spares the
+	// energy pass over the audio in streaming mode. "strength" does not
+	// apply to the "consensus" method, which combines several detection
+	// functions. Default is "energy" if empty.
+	Selection string
 	// Optimize enables optimization of onset positions using variance analysis.
 	// Default is true.
 	Optimize bool
 	// OptimizeWindowMs specifies the window size in milliseconds for onset optimization.
 	// Default is 100.0 ms.
 	OptimizeWindowMs float64
+	// Backtrack moves every onset back to the preceding local minimum of the
+	// energy envelope, like librosa's onset backtracking, so that slices start
+	// before the full attack transient. Onsets move back by at most 200 ms and
+	// never past the previous onset. It applies after Optimize.
+	Backtrack bool
+	// Threshold is the peak picking threshold used to detect candidate onsets.
+	// Higher values keep only the more pronounced onsets.
+	// Default is 0.02 if 0, which detects all plausible candidates.
+	Threshold float64
+	// MinioiMs is the minimum inter-onset interval in milliseconds used when
+	// detecting candidate onsets. Default is 10.0 ms if 0.
+	MinioiMs float64
+	// Params applies a preset saved with SaveParams, such as one tuned on
+	// similar material, to the detectors. Its Method is used when Method is
+	// empty, and its threshold and minimum inter-onset interval when Threshold
+	// and MinioiMs are 0. With the "consensus" method, the preset applies to
+	// every method. Ignored if nil.
+	Params *Params
+	// Preset selects a built-in configuration for a type of material:
+	// "drums", "vocals", "guitar", "field-recording" or "full-mix" (see
+	// ListPresets and PresetInfo). It replaces Method and sets Threshold,
+	// MinioiMs, InputHighpassHz and RemoveDC where they are left at 0 or
+	// false, taking precedence over Params. Ignored if empty.
+	Preset string
+	// InputHighpassHz filters the audio with a highpass at this cutoff
+	// frequency before detection, so that HVAC rumble and handling noise do
+	// not dominate the "energy" and "hfc" methods on field recordings. 40 to
+	// 80 Hz suits most material. It must be below half the sample rate.
+	// Disabled if 0.
+	InputHighpassHz float64
+	// FFTSize zero-pads the 512-sample analysis frames to an FFT of this many
+	// samples, for a finer frequency resolution of the detection functions
+	// without more latency. It must be a power of two. Default is 512 if 0.
+	FFTSize uint
+	// RemoveDC removes the DC offset of the audio before detection with a
+	// one-pole DC blocker, for recordings whose offset skews the silence
+	// detection and the "energy" method.
+	RemoveDC bool
+	// NoiseGateDB gates the audio before detection, silencing it while its
+	// level stays below this threshold in dBFS, so that the constant hiss of
+	// a live microphone does not feed the detection function between hits.
+	// -60 to -40 suits most recordings. Disabled if 0.
+	NoiseGateDB float64
+	// NoiseGateAttackMs is the time in milliseconds over which the noise
+	// gate opens. Default is 1 if 0.
+	NoiseGateAttackMs float64
+	// NoiseGateReleaseMs is the time in milliseconds over which the noise
+	// gate closes. Default is 50 if 0.
+	NoiseGateReleaseMs float64
+	// DescriptorThreshold is the magnitude below which spectral bins are
+	// ignored by the "phase", "wphase" and "specdiff" methods. Raise it for
+	// noisy recordings. Default is 0.1 if 0.
+	DescriptorThreshold float64
+	// MinFlatness suppresses the onsets whose peak frame has a spectral
+	// flatness below it, so that vibrato and beating in sustained tones, such as
+	// pads and bowed strings, do not fire onsets; see Onset.SetFlatnessGate.
+	// 0.01 to 0.05 gates most tonal frames. It must be between 0 and 1.
+	// Disabled if 0.
+	MinFlatness float64
+	// MaxCrest suppresses the onsets whose peak frame has a spectral crest
+	// factor above it, see Onset.SetCrestGate. It must be at least 1. Disabled if 0.
+	MaxCrest float64
 	// Method specifies the onset detection method to use.
 	// Supported methods: "hfc", "energy", "complex", "phase", "wphase", "specdiff", "kl", "mkl", "specflux", "consensus"
+	// and methods registered with RegisterSpecdesc.
 	// Default is "hfc" if empty.
 	// The special "consensus" method uses all methods and generates consensus markers.
 	Method string
+	// PeakWinPre is the number of future frames of the detection function used
+	// by the peak picker. Each frame of lookahead adds one hop of latency.
+	// Default is 1 if 0; a negative value disables the lookahead.
+	PeakWinPre int
+	// PeakWinPost is the number of past frames of the detection function used
+	// by the peak picker. Default is 5 if 0.
+	PeakWinPost int
+	// DisablePeakSmoothing turns off the lowpass filter applied to the
+	// detection function before peak picking, which reacts faster to onsets
+	// at the cost of more spurious detections.
+	DisablePeakSmoothing bool
+	// AdaptiveWindowSec enables a long-window adaptive threshold: onsets must
+	// also exceed a percentile of the detection function over this many
+	// seconds, so that quiet passages and loud, busy ones are detected
+	// consistently. A window of a few seconds works well. Disabled if 0.
+	AdaptiveWindowSec float64
+	// AdaptivePercentile is the percentile (0 to 100) of the adaptive
+	// threshold. Default is 90 if 0.
+	AdaptivePercentile float64
+	// Tiers evaluates several peak picking thresholds in the same pass and
+	// reports the onsets grouped by sensitivity in the result's Tiers, so that
+	// a sensitivity slider can reveal more or fewer slices without
+	// re-analyzing. See DefaultSensitivityTiers. Tier onsets are refined like
+	// the main onsets, but not subject to NumSlices. Only applies
+	// to single methods analyzed in memory; ignored for "consensus", the
+	// "per-channel" channel mode and Streaming.
+	Tiers []SensitivityTier
 	// MinConsensusClusterSize specifies the minimum number of onset markers required
 	// for a cluster to be considered valid when using the "consensus" method.
 	// Default is 3. Only applies when Method is "consensus".
@@ -48,6 +239,88 @@ type SliceAnalyzerOptions struct {
 	// If multiple slices fall within this window, only the first is kept.
 	// Default is 80.0 ms. Only applies when UseMinimumSpacing is true.
 	MinimumSpacing float64
+	// MinSliceMs merges the slices shorter than this many milliseconds, from
+	// an onset to the next onset or the end of the audio, into the previous
+	// slice by removing their onset; a short first slice is merged into the
+	// next one. It applies after the minimum spacing. Disabled if 0.
+	MinSliceMs float64
+	// MaxSliceMs subdivides the slices longer than this many milliseconds
+	// into equal parts no longer than it, adding onsets, so that samplers
+	// get regions of usable length from long sustained passages. It applies
+	// after MinSliceMs and must be at least twice it, so that the parts are
+	// not too short. It also applies after the NumSlices selection, so that no
+	// slice is longer than it even when fewer onsets are kept, and can then
+	// yield more than NumSlices slices. Disabled if 0.
+	MaxSliceMs float64
+	// Channel selects which channel of a multichannel file is analyzed.
+	// Supported values: "left", "right", "mix" (average of all channels),
+	// "mid" ((L+R)/2), "side" ((L-R)/2, stereo files only),
+	// a zero-based channel index such as "2", and "per-channel", which analyzes
+	// every channel separately and reports the onsets in ChannelOnsets.
+	// Default is "left" if empty.
+	Channel string
+	// DecoderFallback selects an external decoder for formats the native decoders
+	// cannot handle. Supported values: "" (no fallback) and "ffmpeg", which requires
+	// the ffmpeg and ffprobe tools on the PATH.
+	DecoderFallback string
+	// AnalyzeRate resamples the audio to this sample rate before analysis.
+	// Downsampling high-rate files (96 kHz, 192 kHz) to 44.1 kHz speeds up the
+	// analysis and matches the tuned method defaults. The result's Samples and
+	// SampleRate are at the analysis rate. If 0 (default), the file rate is used.
+	AnalyzeRate uint
+	// Streaming decodes and analyzes the file block by block instead of loading it
+	// into memory, so that hour-long recordings can be analyzed with little RAM.
+	// The file is read once for detection and once more for each of energy ranking
+	// (NumSlices > 0 with the "energy" Selection) and position optimization
+	// (Optimize). Samples is left empty; set PreviewDecimation to keep a reduced
+	// waveform in Preview. Only applies to AnalyzeSlices; AnalyzeRate,
+	// MeasureLoudness, MeasureShape, KeepSlices and the "per-channel"
+	// channel mode are not supported.
+	Streaming bool
+	// Workers splits the detection of long audio into chunks analyzed on this
+	// many goroutines, so that hour-long recordings use all cores;
+	// runtime.NumCPU() is a good value. Every chunk is preceded by a few
+	// seconds of warm-up on the audio before it, so that the onsets match
+	// those of a sequential analysis, except that adaptive whitening, which
+	// remembers the spectrum for minutes, can differ slightly after chunk
+	// boundaries. Audio shorter than a minute is analyzed on one goroutine.
+	// Does not apply to Streaming. If 0 (default), detection runs on one
+	// goroutine.
+	Workers int
+	// PreviewDecimation is the number of samples summarized by each point of the
+	// Preview waveform in streaming mode. Each point holds the sample with the
+	// largest magnitude in its block. If 0 (default), no preview is kept.
+	PreviewDecimation int
+	// Progress, if set, is called periodically during decoding and analysis with
+	// the fraction of work done in [0, 1]. The last call reports 1. It is called
+	// from the goroutine running the analysis.
+	Progress func(frac float64) `json:"-"`
+	// MeasureLoudness measures the EBU R128 integrated loudness and the true
+	// peak of the analyzed samples and of every slice into Loudness,
+	// SliceLoudness, TruePeak and SliceTruePeaks. Not supported with
+	// Streaming.
+	MeasureLoudness bool
+	// MeasureShape measures the spectral shape of every slice into
+	// SliceShapes, the mean of the shapes of its frames weighted by their
+	// magnitudes. Not supported with Streaming.
+	MeasureShape bool
+	// MeasureTempo estimates the tempo of the onsets into the result's Tempo
+	// and numbers its beats in bars of BeatsPerBar beats into BeatGrid. The
+	// downbeats are the beats with the most energy; in streaming mode, whose
+	// results have no samples, the first beat is a downbeat.
+	MeasureTempo bool
+	// BeatsPerBar is the assumed meter of BeatGrid. Default is 4 if 0.
+	BeatsPerBar int
+	// KeepSlices fills the result's Slices with the samples of every slice,
+	// taken from the analyzed channel, or from the mix of all channels in the
+	// "per-channel" channel mode. Not supported with Streaming.
+	KeepSlices bool
+	// CacheDir, if set, enables an on-disk cache of AnalyzeSlices results in this
+	// directory. Entries are keyed by the file contents and the options, and
+	// for the consensus by the registered custom methods, so re-analyzing an
+	// unchanged file with identical options skips detection.
+	// Samples are not cached; they are decoded again on a cache hit.
+	CacheDir string `json:"-"`
 }
 
 // DefaultSliceAnalyzerOptions returns default options for slice analysis
@@ -60,122 +333,309 @@ func DefaultSliceAnalyzerOptions() SliceAnalyzerOptions {
 		MinConsensusClusterSize: 3,
 		UseMinimumSpacing:       true,
 		MinimumSpacing:          80.0,
+		Channel:                 ChannelLeft,
 	}
 }
 
-// AnalyzeSlices performs onset detection and slice analysis on a WAV file.
+// AnalyzeSlices performs onset detection and slice analysis on an audio file.
+// WAV, FLAC and MP3 files are supported; the format is detected from the file contents.
+// Other formats can be decoded with ffmpeg by setting options.DecoderFallback.
 // It returns the detected onset times along with audio samples and metadata.
 //
 // Parameters:
-//   - wavFile: Path to the WAV file to analyze
+//   - wavFile: Path to the audio file to analyze
 //   - options: Configuration options for the analysis
 //
 // Returns:
 //   - SliceAnalyzerResult containing onsets, samples, and sample rate
 //   - error if the file cannot be read or processed
 func AnalyzeSlices(wavFile string, options SliceAnalyzerOptions) (*SliceAnalyzerResult, error) {
-	// Read audio file (left channel only)
-	samples, sampleRate, err := readWavFileLeftChannel(wavFile)
+	options, err := applyPreset(options)
+	if err != nil {
+		return nil, err
+	}
+	if options.DecoderFallback != "" && options.DecoderFallback != DecoderFallbackFFmpeg {
+		return nil, fmt.Errorf("unknown decoder fallback %q", options.DecoderFallback)
+	}
+
+	if options.CacheDir != "" {
+		return analyzeFileCached(wavFile, options)
+	}
+	return analyzeFile(wavFile, options)
+}
+
+// analyzeFile decodes and analyzes an audio file
+func analyzeFile(wavFile string, options SliceAnalyzerOptions) (*SliceAnalyzerResult, error) {
+	p := newProgress(options.Progress)
+	if options.Streaming {
+		return analyzeFileStreaming(wavFile, options, p)
+	}
+
+	// Read audio file (all channels)
+	s, err := openAudioFile(wavFile, options.DecoderFallback)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	channels, sampleRate, err := readStreamWithProgress(s, p.sub(0, 0.2))
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read audio file: %w", err)
 	}
 
-	// Default to "hfc" if method is not specified
-	method := options.Method
-	if method == "" {
-		method = "hfc"
+	return analyzeChannels(channels, sampleRate, options, p.sub(0.2, 1))
+}
+
+// analyzeChannels analyzes decoded multichannel audio according to the channel mode
+func analyzeChannels(channels [][]float64, sampleRate uint, options SliceAnalyzerOptions, p *progress) (*SliceAnalyzerResult, error) {
+	if !strings.EqualFold(options.Channel, ChannelPerChannel) {
+		samples, err := selectChannel(channels, options.Channel)
+		if err != nil {
+			return nil, err
+		}
+		return analyzeSamples(samples, sampleRate, options, p)
+	}
+
+	// Resample every channel once up front so that the per-channel results and
+	// the mixed samples share the analysis rate
+	if options.AnalyzeRate > 0 && options.AnalyzeRate != sampleRate {
+		resampled := make([][]float64, len(channels))
+		for i, channel := range channels {
+			resampled[i] = Resample(channel, sampleRate, options.AnalyzeRate)
+		}
+		channels, sampleRate = resampled, options.AnalyzeRate
+	}
+
+	// Sensitivity tiers are not reported per channel
+	channelOptions := options
+	channelOptions.Tiers = nil
+
+	channelOnsets := make([][]float64, len(channels))
+	var union []float64
+	for i, channel := range channels {
+		channelProgress := p.sub(float64(i)/float64(len(channels)), float64(i+1)/float64(len(channels)))
+		result, err := analyzeSamples(channel, sampleRate, channelOptions, channelProgress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze channel %d: %w", i, err)
+		}
+		channelOnsets[i] = result.Onsets
+		union = append(union, result.Onsets...)
+	}
+
+	sort.Float64s(union)
+	union, _ = spaceOnsets(union, float64(len(channels[0]))/float64(sampleRate), options)
+
+	mixed := mixChannels(channels)
+	result := &SliceAnalyzerResult{
+		Onsets:        union,
+		Samples:       mixed,
+		SampleRate:    sampleRate,
+		NumSamples:    len(mixed),
+		ChannelOnsets: channelOnsets,
+		Options:       options,
+	}
+	if options.MeasureLoudness {
+		result.measureLoudness()
+	}
+	if options.MeasureShape {
+		result.measureShapes()
+	}
+	if options.MeasureTempo {
+		result.measureTempo(options.BeatsPerBar)
+	}
+	if options.KeepSlices {
+		result.Slices = result.sliceSamples()
+	}
+	p.report(1)
+	return result, nil
+}
+
+// AnalyzeSamples performs onset detection and slice analysis on in-memory audio.
+// The samples are mono and expected in the range [-1.0, 1.0]. This is useful when
+// the audio has already been decoded or synthesized, avoiding a round-trip through
+// a temporary WAV file.
+//
+// The returned result references the given samples slice; it is not copied
+// unless options.AnalyzeRate requires resampling.
+func AnalyzeSamples(samples []float64, sampleRate uint, options SliceAnalyzerOptions) (*SliceAnalyzerResult, error) {
+	options, err := applyPreset(options)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeSamples(samples, sampleRate, options, newProgress(options.Progress))
+}
+
+// analyzeSamples implements AnalyzeSamples, reporting progress to p
+func analyzeSamples(samples []float64, sampleRate uint, options SliceAnalyzerOptions, p *progress) (*SliceAnalyzerResult, error) {
+	if sampleRate == 0 {
+		return nil, fmt.Errorf("invalid sample rate: %d", sampleRate)
+	}
+
+	if options.AnalyzeRate > 0 && options.AnalyzeRate != sampleRate {
+		samples = Resample(samples, sampleRate, options.AnalyzeRate)
+		sampleRate = options.AnalyzeRate
+	}
+
+	method := analysisMethod(options)
+
+	// Detection and optimization share the progress range
+	detectProgress, optimizeProgress := p, (*progress)(nil)
+	if options.Optimize {
+		detectProgress, optimizeProgress = p.sub(0, 0.5), p.sub(0.5, 1)
+	}
+
+	if err := checkDetectionOptions(options, sampleRate); err != nil {
+		return nil, err
+	}
+	tiers, err := validateTiers(options.Tiers)
+	if err != nil {
+		return nil, err
 	}
 
 	var onsets []float64
+	var detection []DetectionFrame
+	var tierOnsets []TierOnsets
+	var markerVotes [][]ConsensusVote
 
 	if method == "consensus" {
 		// Use consensus method: run all methods and generate consensus
-		onsets = findConsensusOnsets(samples, sampleRate, options)
-	} else if options.NumSlices > 0 {
-		// Find the best N onsets based on energy
-		onsets = findBestOnsets(samples, sampleRate, options.NumSlices, method)
+		onsets, markerVotes = findConsensusOnsets(samples, sampleRate, options, detectProgress)
 	} else {
-		// Find all onsets
-		onsets = findAllOnsets(samples, sampleRate, method)
+		// Detect all candidate onsets, keeping the detection curve, and the
+		// onsets of the sensitivity tiers in the same pass
+		d := detectChunked(samples, sampleRate, method, tiers, options, true, detectProgress)
+		onsets, detection = d.onsets, d.curve
+		if len(tiers) > 0 {
+			tierOnsets = tierResults(samples, sampleRate, tiers, d.tierOnsets, options)
+		}
+
+		if options.NumSlices > 0 && options.Selection == SelectionStrength {
+			// Keep the best N onsets based on the detection function
+			onsets = pickBestOnsets(onsets, d.strengths, options.NumSlices)
+		} else if options.NumSlices > 0 {
+			// Keep the best N onsets based on energy
+			onsets = selectBestOnsets(samples, sampleRate, onsets, options.NumSlices)
+		}
 	}
 
 	// Optimize onset positions if requested
 	if options.Optimize && len(onsets) > 0 {
-		onsets = optimizeOnsetPositions(samples, sampleRate, onsets, options.OptimizeWindowMs)
+		onsets = optimizeOnsetPositions(samples, sampleRate, onsets, options.OptimizeWindowMs, optimizeProgress)
 	}
 
-	// Apply minimum spacing filter if requested
-	if options.UseMinimumSpacing && len(onsets) > 0 {
-		onsets = applyMinimumSpacing(onsets, options.MinimumSpacing)
+	// Move onsets back to the preceding energy minimum if requested
+	if options.Backtrack && len(onsets) > 0 {
+		onsets = backtrackOnsets(samples, sampleRate, onsets)
 	}
+	// Optimization and backtracking keep one onset per consensus marker, in
+	// order, so that the markers' votes follow their indices through the
+	// minimum spacing and the duration constraints
+	onsets, origins := spaceOnsets(onsets, float64(len(samples))/float64(sampleRate), options)
 
-	return &SliceAnalyzerResult{
+	result := &SliceAnalyzerResult{
 		Onsets:     onsets,
 		Samples:    samples,
 		SampleRate: sampleRate,
-	}, nil
-}
-
-// readWavFileLeftChannel reads a WAV file and returns only the left channel (or mono)
-func readWavFileLeftChannel(filename string) ([]float64, uint, error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to open file: %w", err)
+		NumSamples: len(samples),
+		Detection:  detection,
+		Tiers:      tierOnsets,
+		Options:    options,
 	}
-	defer f.Close()
-
-	decoder := wav.NewDecoder(f)
-	if !decoder.IsValidFile() {
-		return nil, 0, fmt.Errorf("invalid WAV file")
+	if markerVotes != nil {
+		result.Votes = attributeVotes(markerVotes, origins)
 	}
-
-	sampleRate := uint(decoder.SampleRate)
-
-	// Read all audio data
-	buf, err := decoder.FullPCMBuffer()
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to read PCM data: %w", err)
+	if options.MeasureLoudness {
+		result.measureLoudness()
+	}
+	if options.MeasureShape {
+		result.measureShapes()
 	}
+	if options.MeasureTempo {
+		result.measureTempo(options.BeatsPerBar)
+	}
+	if options.KeepSlices {
+		result.Slices = result.sliceSamples()
+	}
+	p.report(1)
+	return result, nil
+}
 
-	numChannels := buf.Format.NumChannels
-	numSamples := len(buf.Data) / numChannels
-	samples := make([]float64, numSamples)
+// WriteDetectionCSV writes the detection curve as CSV with one row per hop.
+// The columns are time (seconds), raw descriptor, thresholded descriptor and
+// an onset flag (1 when an onset was marked at that hop, 0 otherwise).
+func (r *SliceAnalyzerResult) WriteDetectionCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"time", "descriptor", "thresholded", "onset"}); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
 
-	// Extract left channel only (channel 0)
-	for i := 0; i < numSamples; i++ {
-		// Normalize int to float64 [-1.0, 1.0]
-		samples[i] = float64(buf.Data[i*numChannels]) / 32768.0
+	for _, frame := range r.Detection {
+		flag := "0"
+		if frame.Onset {
+			flag = "1"
+		}
+		record := []string{
+			strconv.FormatFloat(frame.Time, 'f', 6, 64),
+			strconv.FormatFloat(frame.Descriptor, 'g', -1, 64),
+			strconv.FormatFloat(frame.Thresholded, 'g', -1, 64),
+			flag,
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV row: %w", err)
+		}
 	}
 
-	return samples, sampleRate, nil
+	cw.Flush()
+	return cw.Error()
 }
 
-// onsetWithEnergy stores an onset time and its energy
+// onsetWithEnergy stores an onset time, its energy and its index among the
+// candidates
 type onsetWithEnergy struct {
 	time   float64
 	energy float64
+	index  int
 }
 
-// findBestOnsets uses onset detection to find the best N onsets in the audio.
+// selectBestOnsets keeps the best N onsets from the candidates.
 // The "best" onsets are those with the highest energy/loudness.
-func findBestOnsets(samples []float64, sampleRate uint, targetSlices int, method string) []float64 {
-	bufSize := uint(512)
-	hopSize := uint(256)
+func selectBestOnsets(samples []float64, sampleRate uint, candidates []float64, targetSlices int) []float64 {
+	best, _ := selectBestIndices(samples, sampleRate, candidates, targetSlices)
+	return best
+}
+
+// selectBestIndices is selectBestOnsets, also returning the index among the
+// candidates of every onset kept
+func selectBestIndices(samples []float64, sampleRate uint, candidates []float64, targetSlices int) ([]float64, []int) {
+	// Calculate energy at each onset
+	energies := make([]float64, len(candidates))
+	for i, onsetTime := range candidates {
+		energies[i] = calculateOnsetEnergy(samples, sampleRate, onsetTime)
+	}
 
-	// Detect all onsets with relaxed parameters to get more candidates
-	allOnsets := detectAllOnsets(samples, sampleRate, method, bufSize, hopSize)
+	return pickBestIndices(candidates, energies, targetSlices)
+}
+
+// pickBestOnsets keeps the N candidates with the highest energies, sorted by time
+func pickBestOnsets(candidates []float64, energies []float64, targetSlices int) []float64 {
+	best, _ := pickBestIndices(candidates, energies, targetSlices)
+	return best
+}
 
-	if len(allOnsets) == 0 {
-		return []float64{}
+// pickBestIndices is pickBestOnsets, also returning the index among the
+// candidates of every onset kept
+func pickBestIndices(candidates []float64, energies []float64, targetSlices int) ([]float64, []int) {
+	if len(candidates) == 0 {
+		return []float64{}, []int{}
 	}
 
-	// Calculate energy at each onset
-	onsetsWithEnergy := make([]onsetWithEnergy, len(allOnsets))
-	for i, onsetTime := range allOnsets {
-		energy := calculateOnsetEnergy(samples, sampleRate, onsetTime)
+	onsetsWithEnergy := make([]onsetWithEnergy, len(candidates))
+	for i, onsetTime := range candidates {
 		onsetsWithEnergy[i] = onsetWithEnergy{
 			time:   onsetTime,
-			energy: energy,
+			energy: energies[i],
+			index:  i,
 		}
 	}
 
@@ -196,113 +656,113 @@ func findBestOnsets(samples []float64, sampleRate uint, targetSlices int, method
 		return bestOnsets[i].time < bestOnsets[j].time
 	})
 
-	// Extract just the times
+	// Extract the times and indices
 	result := make([]float64, len(bestOnsets))
+	indices := make([]int, len(bestOnsets))
 	for i, onset := range bestOnsets {
-		result[i] = onset.time
+		result[i], indices[i] = onset.time, onset.index
 	}
 
-	return result
-}
-
-// findAllOnsets detects all onsets in the audio with default parameters
-func findAllOnsets(samples []float64, sampleRate uint, method string) []float64 {
-	bufSize := uint(512)
-	hopSize := uint(256)
-
-	return detectAllOnsets(samples, sampleRate, method, bufSize, hopSize)
+	return result, indices
 }
 
 // findConsensusOnsets runs all detection methods and generates consensus markers
-// by clustering nearby onsets and taking the midpoint of each cluster
-func findConsensusOnsets(samples []float64, sampleRate uint, options SliceAnalyzerOptions) []float64 {
-	bufSize := uint(512)
-	hopSize := uint(256)
+// by clustering nearby onsets and taking the midpoint of each cluster. It
+// returns the markers with the votes of each.
+func findConsensusOnsets(samples []float64, sampleRate uint, options SliceAnalyzerOptions, p *progress) ([]float64, [][]ConsensusVote) {
+	// Collect all onsets from all methods
+	var allVotes []ConsensusVote
+	methods := append(consensusMethods, customMethods()...)
+	for i, method := range methods {
+		methodProgress := p.sub(float64(i)/float64(len(methods)), float64(i+1)/float64(len(methods)))
+		for _, onsetTime := range detectAllOnsets(samples, sampleRate, method, options, methodProgress) {
+			allVotes = append(allVotes, ConsensusVote{Method: method, Time: onsetTime})
+		}
+	}
 
-	// All available methods
-	methods := []string{"energy", "hfc", "complex", "phase", "wphase", "specdiff", "kl", "mkl", "specflux"}
+	consensusOnsets, votes := clusterConsensusOnsets(allVotes, options.MinConsensusClusterSize)
 
-	// Collect all onsets from all methods
-	var allOnsets []float64
-	for _, method := range methods {
-		methodOnsets := detectAllOnsets(samples, sampleRate, method, bufSize, hopSize)
-		allOnsets = append(allOnsets, methodOnsets...)
+	// If targetSlices is specified, select the best N based on energy
+	if options.NumSlices > 0 && len(consensusOnsets) > options.NumSlices {
+		// For consensus, we could rank by cluster size (more methods agreeing)
+		// But for simplicity, we'll use energy like for single methods
+		best, kept := selectBestIndices(samples, sampleRate, consensusOnsets, options.NumSlices)
+		return best, attributeVotes(votes, kept)
 	}
 
-	if len(allOnsets) == 0 {
-		return []float64{}
+	return consensusOnsets, votes
+}
+
+// consensusMethods lists the built-in detection methods combined by the
+// "consensus" method, which also includes registered custom methods
+var consensusMethods = []string{"energy", "hfc", "complex", "phase", "wphase", "specdiff", "kl", "mkl", "specflux"}
+
+// clusterConsensusOnsets clusters the votes of all methods and returns the
+// midpoint of every cluster with at least minClusterSize votes, along with
+// the votes of each
+func clusterConsensusOnsets(allVotes []ConsensusVote, minClusterSize int) ([]float64, [][]ConsensusVote) {
+	if len(allVotes) == 0 {
+		return []float64{}, [][]ConsensusVote{}
 	}
 
-	// Sort all onsets by time
-	sort.Float64s(allOnsets)
+	// Sort all votes by time
+	sort.SliceStable(allVotes, func(i, j int) bool {
+		return allVotes[i].Time < allVotes[j].Time
+	})
 
 	// Cluster nearby onsets together
 	// Two onsets are in the same cluster if they're within clusterThreshold seconds
 	clusterThreshold := 0.05 // 50ms threshold for clustering
 
 	// Default minimum cluster size to 3 if not set
-	minClusterSize := options.MinConsensusClusterSize
 	if minClusterSize <= 0 {
 		minClusterSize = 3
 	}
 
 	var consensusOnsets []float64
-	currentCluster := []float64{allOnsets[0]}
-
-	for i := 1; i < len(allOnsets); i++ {
-		if allOnsets[i]-currentCluster[len(currentCluster)-1] <= clusterThreshold {
-			// Add to current cluster
-			currentCluster = append(currentCluster, allOnsets[i])
-		} else {
-			// Finalize current cluster if it meets minimum size requirement
-			if len(currentCluster) >= minClusterSize {
-				consensusOnsets = append(consensusOnsets, calculateClusterMidpoint(currentCluster))
-			}
-			currentCluster = []float64{allOnsets[i]}
+	var consensusVotes [][]ConsensusVote
+	start := 0
+
+	// Finalize the cluster of votes from start to end if it meets the
+	// minimum size requirement
+	finalize := func(end int) {
+		if end-start < minClusterSize {
+			return
 		}
+		cluster := make([]float64, end-start)
+		for i, vote := range allVotes[start:end] {
+			cluster[i] = vote.Time
+		}
+		consensusOnsets = append(consensusOnsets, calculateClusterMidpoint(cluster))
+		consensusVotes = append(consensusVotes, slices.Clone(allVotes[start:end]))
 	}
 
-	// Don't forget the last cluster if it meets minimum size requirement
-	if len(currentCluster) >= minClusterSize {
-		consensusOnsets = append(consensusOnsets, calculateClusterMidpoint(currentCluster))
-	}
-
-	// If targetSlices is specified, select the best N based on cluster size and energy
-	if options.NumSlices > 0 && len(consensusOnsets) > options.NumSlices {
-		// For consensus, we could rank by cluster size (more methods agreeing)
-		// But for simplicity, we'll use energy like in findBestOnsets
-		onsetsWithEnergy := make([]onsetWithEnergy, len(consensusOnsets))
-		for i, onsetTime := range consensusOnsets {
-			energy := calculateOnsetEnergy(samples, sampleRate, onsetTime)
-			onsetsWithEnergy[i] = onsetWithEnergy{
-				time:   onsetTime,
-				energy: energy,
-			}
+	for i := 1; i < len(allVotes); i++ {
+		if allVotes[i].Time-allVotes[i-1].Time > clusterThreshold {
+			finalize(i)
+			start = i
 		}
+	}
 
-		// Sort by energy (descending)
-		sort.Slice(onsetsWithEnergy, func(i, j int) bool {
-			return onsetsWithEnergy[i].energy > onsetsWithEnergy[j].energy
-		})
-
-		// Take top N onsets
-		bestOnsets := onsetsWithEnergy[:options.NumSlices]
+	// Don't forget the last cluster
+	finalize(len(allVotes))
 
-		// Sort back by time for output
-		sort.Slice(bestOnsets, func(i, j int) bool {
-			return bestOnsets[i].time < bestOnsets[j].time
-		})
+	return consensusOnsets, consensusVotes
+}
 
-		// Extract just the times
-		result := make([]float64, len(bestOnsets))
-		for i, onset := range bestOnsets {
-			result[i] = onset.time
+// attributeVotes returns the votes of every onset, whose origins are the
+// indices of their consensus markers in votes. Onsets added since the
+// markers were found, such as subdivisions, have an origin of -1 and no
+// votes. Carrying indices, rather than matching times, keeps the votes with
+// their onsets even when a later step moves an onset onto another's time.
+func attributeVotes(votes [][]ConsensusVote, origins []int) [][]ConsensusVote {
+	attributed := make([][]ConsensusVote, len(origins))
+	for i, origin := range origins {
+		if origin >= 0 {
+			attributed[i] = votes[origin]
 		}
-
-		return result
 	}
-
-	return consensusOnsets
+	return attributed
 }
 
 // calculateClusterMidpoint calculates the midpoint of a cluster of onset times
@@ -402,17 +862,175 @@ func calculatePercentile(sorted []float64, percentile float64) float64 {
 	return sorted[lowerIndex]*(1-weight) + sorted[upperIndex]*weight
 }
 
-// detectAllOnsets detects all onsets with relaxed parameters
-func detectAllOnsets(samples []float64, sampleRate uint, method string, bufSize, hopSize uint) []float64 {
+// analysisMethod returns the detection method of options, that of the preset
+// if Method is empty, or "hfc"
+func analysisMethod(options SliceAnalyzerOptions) string {
+	switch {
+	case options.Method != "":
+		return options.Method
+	case options.Params != nil && options.Params.Method != "":
+		return options.Params.Method
+	}
+	return "hfc"
+}
+
+// detectionParams returns the peak picker threshold and minimum inter-onset
+// interval in milliseconds used to detect candidate onsets
+func detectionParams(options SliceAnalyzerOptions) (float64, float64) {
 	// Use low threshold and short minioi to detect all possible onsets
 	threshold := 0.02
 	minioi := 10.0 // milliseconds
 
-	return detectOnsetsInternal(samples, sampleRate, method, bufSize, hopSize, threshold, minioi)
+	if preset := options.Params; preset != nil {
+		if preset.Threshold > 0 {
+			threshold = preset.Threshold
+		}
+		if preset.MinioiMs > 0 {
+			minioi = preset.MinioiMs
+		}
+	}
+	if options.Threshold > 0 {
+		threshold = options.Threshold
+	}
+	if options.MinioiMs > 0 {
+		minioi = options.MinioiMs
+	}
+	return threshold, minioi
+}
+
+// checkDetectionOptions returns an error for detection options out of range,
+// such as an input highpass cutoff not below half the sample rate
+func checkDetectionOptions(options SliceAnalyzerOptions, sampleRate uint) error {
+	if options.InputHighpassHz >= float64(sampleRate)/2 {
+		return fmt.Errorf("highpass cutoff %g Hz is not below half the sample rate %d", options.InputHighpassHz, sampleRate)
+	}
+	switch options.Selection {
+	case "", SelectionEnergy:
+	case SelectionStrength:
+		if analysisMethod(options) == "consensus" {
+			return fmt.Errorf("the %q selection does not apply to the consensus method", SelectionStrength)
+		}
+	default:
+		return fmt.Errorf("unknown selection %q: supported values are %q and %q", options.Selection, SelectionEnergy, SelectionStrength)
+	}
+	if options.BeatsPerBar < 0 {
+		return fmt.Errorf("invalid meter of %d beats per bar", options.BeatsPerBar)
+	}
+	if options.MinSliceMs < 0 || options.MaxSliceMs < 0 || (options.MaxSliceMs > 0 && options.MaxSliceMs < 2*options.MinSliceMs) {
+		return fmt.Errorf("invalid slice durations from %g to %g ms: the maximum must be at least twice the minimum", options.MinSliceMs, options.MaxSliceMs)
+	}
+	if options.NoiseGateDB > 0 || options.NoiseGateAttackMs < 0 || options.NoiseGateReleaseMs < 0 {
+		return fmt.Errorf("invalid noise gate of %g dB with %g ms attack and %g ms release", options.NoiseGateDB, options.NoiseGateAttackMs, options.NoiseGateReleaseMs)
+	}
+	if options.MinFlatness < 0 || options.MinFlatness > 1 || options.MaxCrest < 0 || (options.MaxCrest > 0 && options.MaxCrest < 1) {
+		return fmt.Errorf("invalid tonality gates: flatness %g must be between 0 and 1, crest %g must be 0 or at least 1", options.MinFlatness, options.MaxCrest)
+	}
+	if options.FFTSize > 0 && (options.FFTSize < 512 || options.FFTSize&(options.FFTSize-1) != 0) {
+		return fmt.Errorf("invalid FFT size %d: must be a power of two of at least 512", options.FFTSize)
+	}
+	return nil
+}
+
+// newAnalysisOnset creates a detector for the given method with the peak
+// picking threshold and the detection options
+func newAnalysisOnset(method string, bufSize, hopSize, sampleRate uint, threshold float64, options SliceAnalyzerOptions) *Onset {
+	_, minioi := detectionParams(options)
+	o := NewOnset(method, bufSize, hopSize, sampleRate)
+	if options.Params != nil {
+		o.ApplyParams(*options.Params)
+	}
+	o.SetThreshold(threshold)
+	o.SetMinioiMs(minioi)
+	if options.DescriptorThreshold > 0 {
+		o.SetDescriptorThreshold(options.DescriptorThreshold)
+	}
+	if options.InputHighpassHz > 0 {
+		o.SetInputHighpassHz(options.InputHighpassHz)
+	}
+	o.SetFlatnessGate(options.MinFlatness)
+	o.SetCrestGate(options.MaxCrest)
+	if pre := newAnalysisPreprocessor(options, sampleRate); pre != nil {
+		o.SetPreprocessor(pre)
+	}
+	if options.FFTSize > 0 {
+		o.SetFFTSize(options.FFTSize)
+	}
+	configurePeakPicker(o, options)
+	return o
+}
+
+// newAnalysisPreprocessor returns the DC blocker and the noise gate enabled
+// by the options, in that order, or nil if neither is
+func newAnalysisPreprocessor(options SliceAnalyzerOptions, sampleRate uint) Processor {
+	var stages []Processor
+	if options.RemoveDC {
+		stages = append(stages, NewDCBlocker(sampleRate))
+	}
+	if options.NoiseGateDB != 0 {
+		attack, release := options.NoiseGateAttackMs, options.NoiseGateReleaseMs
+		if attack == 0 {
+			attack = 1
+		}
+		if release == 0 {
+			release = 50
+		}
+		stages = append(stages, NewNoiseGate(options.NoiseGateDB, attack, release, sampleRate))
+	}
+	switch len(stages) {
+	case 0:
+		return nil
+	case 1:
+		return stages[0]
+	}
+	return NewPipeline(stages...)
+}
+
+// configurePeakPicker applies the peak picker options to o
+func configurePeakPicker(o *Onset, options SliceAnalyzerOptions) {
+	if options.PeakWinPre != 0 || options.PeakWinPost != 0 {
+		winPre, winPost := o.Pp.WinPre, o.Pp.WinPost
+		if options.PeakWinPre != 0 {
+			winPre = uint(max(options.PeakWinPre, 0))
+		}
+		if options.PeakWinPost > 0 {
+			winPost = uint(options.PeakWinPost)
+		}
+		o.SetPeakWindow(winPre, winPost)
+	}
+	if options.DisablePeakSmoothing {
+		o.Pp.SetSmoothingFilter(nil)
+	}
+	if options.AdaptiveWindowSec > 0 {
+		percentile := options.AdaptivePercentile
+		if percentile == 0 {
+			percentile = 90
+		}
+		o.SetAdaptiveThreshold(options.AdaptiveWindowSec, percentile)
+	}
+}
+
+// detectAllOnsets detects all onsets with relaxed parameters
+func detectAllOnsets(samples []float64, sampleRate uint, method string, options SliceAnalyzerOptions, p *progress) []float64 {
+	return detectChunked(samples, sampleRate, method, nil, options, false, p).onsets
 }
 
 // calculateOnsetEnergy calculates the RMS energy around an onset
 func calculateOnsetEnergy(samples []float64, sampleRate uint, onsetTime float64) float64 {
+	startSample, endSample := onsetEnergyRange(sampleRate, onsetTime)
+
+	// Clamp to valid range
+	if endSample > len(samples) {
+		endSample = len(samples)
+	}
+	if startSample >= endSample {
+		return 0.0
+	}
+
+	return rootMeanSquare(samples[startSample:endSample])
+}
+
+// onsetEnergyRange returns the sample range over which the energy of an onset is measured
+func onsetEnergyRange(sampleRate uint, onsetTime float64) (int, int) {
 	// Calculate energy in a window around the onset
 	windowMs := 50.0 // 50ms window
 	windowSamples := int(windowMs * float64(sampleRate) / 1000.0)
@@ -422,37 +1040,35 @@ func calculateOnsetEnergy(samples []float64, sampleRate uint, onsetTime float64)
 	// Window starts at onset and extends forward
 	startSample := onsetSample
 	endSample := onsetSample + windowSamples
-
-	// Clamp to valid range
 	if startSample < 0 {
 		startSample = 0
 	}
-	if endSample > len(samples) {
-		endSample = len(samples)
-	}
 
-	// Calculate RMS energy
-	sumSquares := 0.0
-	count := 0
-	for i := startSample; i < endSample; i++ {
-		sumSquares += samples[i] * samples[i]
-		count++
-	}
+	return startSample, endSample
+}
 
-	if count == 0 {
+// rootMeanSquare calculates the RMS energy of samples
+func rootMeanSquare(samples []float64) float64 {
+	if len(samples) == 0 {
 		return 0.0
 	}
 
-	return math.Sqrt(sumSquares / float64(count))
+	sumSquares := 0.0
+	for _, v := range samples {
+		sumSquares += v * v
+	}
+
+	return math.Sqrt(sumSquares / float64(len(samples)))
 }
 
 // optimizeOnsetPositions refines onset positions by finding the point of maximum variance difference
 // within a window around each detected onset
-func optimizeOnsetPositions(samples []float64, sampleRate uint, onsets []float64, windowMs float64) []float64 {
+func optimizeOnsetPositions(samples []float64, sampleRate uint, onsets []float64, windowMs float64, p *progress) []float64 {
 	optimized := make([]float64, len(onsets))
 
 	for i, onsetTime := range onsets {
 		optimized[i] = findOptimalOnsetPosition(samples, sampleRate, onsetTime, windowMs)
+		p.report(float64(i+1) / float64(len(onsets)))
 	}
 
 	return optimized
@@ -461,8 +1077,15 @@ func optimizeOnsetPositions(samples []float64, sampleRate uint, onsets []float64
 // applyMinimumSpacing filters onsets to ensure minimum spacing between them.
 // If multiple onsets fall within the minimum spacing window, only the first is kept.
 func applyMinimumSpacing(onsets []float64, minimumSpacingMs float64) []float64 {
+	filtered, _ := minimumSpacingIndices(onsets, minimumSpacingMs)
+	return filtered
+}
+
+// minimumSpacingIndices is applyMinimumSpacing, also returning the index in
+// onsets of every onset kept
+func minimumSpacingIndices(onsets []float64, minimumSpacingMs float64) ([]float64, []int) {
 	if len(onsets) == 0 {
-		return onsets
+		return onsets, []int{}
 	}
 
 	// Convert minimum spacing from milliseconds to seconds
@@ -470,6 +1093,7 @@ func applyMinimumSpacing(onsets []float64, minimumSpacingMs float64) []float64 {
 
 	// First onset is always kept
 	filtered := []float64{onsets[0]}
+	indices := []int{0}
 
 	// Check each subsequent onset
 	for i := 1; i < len(onsets); i++ {
@@ -479,16 +1103,113 @@ func applyMinimumSpacing(onsets []float64, minimumSpacingMs float64) []float64 {
 		// Only keep this onset if it's far enough from the previous one
 		if timeDiff >= minimumSpacingSec {
 			filtered = append(filtered, onsets[i])
+			indices = append(indices, i)
 		}
 		// Otherwise, skip this onset (it's too close to the previous one)
 	}
 
-	return filtered
+	return filtered, indices
+}
+
+// constrainSliceDurations applies the MinSliceMs and MaxSliceMs options to
+// sorted onsets of audio lasting duration seconds
+func constrainSliceDurations(onsets []float64, duration float64, options SliceAnalyzerOptions) []float64 {
+	constrained, _ := constrainSliceIndices(onsets, duration, options)
+	return constrained
+}
+
+// constrainSliceIndices is constrainSliceDurations, also returning the index
+// in onsets of every onset kept, -1 for the onsets added by subdivision
+func constrainSliceIndices(onsets []float64, duration float64, options SliceAnalyzerOptions) ([]float64, []int) {
+	indices := make([]int, len(onsets))
+	for i := range indices {
+		indices[i] = i
+	}
+	if len(onsets) == 0 || (options.MinSliceMs <= 0 && options.MaxSliceMs <= 0) {
+		return onsets, indices
+	}
+	onsets = slices.Clone(onsets)
+	end := func(i int) float64 {
+		if i+1 < len(onsets) {
+			return onsets[i+1]
+		}
+		return duration
+	}
+
+	if minSlice := options.MinSliceMs / 1000; minSlice > 0 {
+		for i := 0; i < len(onsets) && len(onsets) > 1; {
+			if end(i)-onsets[i] >= minSlice {
+				i++
+				continue
+			}
+			// Merge into the previous slice, or the first into the next,
+			// and check the merged slice again
+			if i == 0 {
+				onsets = slices.Delete(onsets, 1, 2)
+				indices = slices.Delete(indices, 1, 2)
+			} else {
+				onsets = slices.Delete(onsets, i, i+1)
+				indices = slices.Delete(indices, i, i+1)
+				i--
+			}
+		}
+	}
+
+	if maxSlice := options.MaxSliceMs / 1000; maxSlice > 0 {
+		subdivided := make([]float64, 0, len(onsets))
+		origins := make([]int, 0, len(onsets))
+		for i, start := range onsets {
+			length := end(i) - start
+			parts := max(int(math.Ceil(length/maxSlice)), 1)
+			for k := range parts {
+				subdivided = append(subdivided, start+float64(k)*length/float64(parts))
+				origins = append(origins, -1)
+			}
+			origins[len(origins)-parts] = indices[i]
+		}
+		onsets, indices = subdivided, origins
+	}
+	return onsets, indices
+}
+
+// spaceOnsets applies the minimum spacing, if enabled, and the slice
+// duration constraints to sorted onsets of audio lasting duration seconds. It
+// also returns the index in onsets of every onset kept, -1 for the onsets
+// added by subdivision.
+func spaceOnsets(onsets []float64, duration float64, options SliceAnalyzerOptions) ([]float64, []int) {
+	var spaced []int
+	if options.UseMinimumSpacing && len(onsets) > 0 {
+		onsets, spaced = minimumSpacingIndices(onsets, options.MinimumSpacing)
+	}
+	onsets, indices := constrainSliceIndices(onsets, duration, options)
+	if spaced != nil {
+		for i, index := range indices {
+			if index >= 0 {
+				indices[i] = spaced[index]
+			}
+		}
+	}
+	return onsets, indices
 }
 
 // findOptimalOnsetPosition finds the exact onset position by locating the midpoint
 // with the maximum variance difference between right and left sides within a window
 func findOptimalOnsetPosition(samples []float64, sampleRate uint, onsetTime float64, windowMs float64) float64 {
+	windowStart, windowEnd := optimizeWindowRange(sampleRate, onsetTime, windowMs)
+
+	// Clamp to valid range
+	if windowEnd > len(samples) {
+		windowEnd = len(samples)
+	}
+	if windowStart > windowEnd {
+		windowStart = windowEnd
+	}
+
+	return optimalOnsetInWindow(samples[windowStart:windowEnd], windowStart, sampleRate, onsetTime)
+}
+
+// optimizeWindowRange returns the sample range searched when optimizing an onset
+func optimizeWindowRange(sampleRate uint, onsetTime float64, windowMs float64) (int, int) {
 	// Convert onset time to sample index
 	onsetSample := int(onsetTime * float64(sampleRate))
 
@@ -499,33 +1220,35 @@ func findOptimalOnsetPosition(samples []float64, sampleRate uint, onsetTime floa
 	// Define search window boundaries
 	windowStart := onsetSample - halfWindow
 	windowEnd := onsetSample + halfWindow
-
-	// Clamp to valid range
 	if windowStart < 0 {
 		windowStart = 0
 	}
-	if windowEnd > len(samples) {
-		windowEnd = len(samples)
-	}
 
+	return windowStart, windowEnd
+}
+
+// optimalOnsetInWindow searches the window samples, which start at sample index
+// offset of the file, for the onset position. The window must already be clamped
+// to the file.
+func optimalOnsetInWindow(window []float64, offset int, sampleRate uint, onsetTime float64) float64 {
 	// If window is too small, return original onset
-	if windowEnd-windowStart < 10 {
+	if len(window) < 10 {
 		return onsetTime
 	}
 
 	// Search for the midpoint with maximum variance difference
 	maxDiff := -math.MaxFloat64
-	bestPosition := onsetSample
+	bestPosition := int(onsetTime * float64(sampleRate))
 
 	// Try each position in the window as a potential midpoint
 	// Leave some margin on both sides to calculate variance
 	minMargin := 5 // minimum samples on each side
-	for midpoint := windowStart + minMargin; midpoint < windowEnd-minMargin; midpoint++ {
+	for midpoint := minMargin; midpoint < len(window)-minMargin; midpoint++ {
 		// Calculate variance of left side (from window start to midpoint)
-		leftVariance := calculateVariance(samples, windowStart, midpoint)
+		leftVariance := calculateVariance(window, 0, midpoint)
 
 		// Calculate variance of right side (from midpoint to window end)
-		rightVariance := calculateVariance(samples, midpoint, windowEnd)
+		rightVariance := calculateVariance(window, midpoint, len(window))
 
 		// Calculate difference (right - left)
 		// Positive difference means signal variance increases at this point (onset characteristic)
@@ -534,7 +1257,7 @@ func findOptimalOnsetPosition(samples []float64, sampleRate uint, onsetTime floa
 		// Track maximum difference
 		if diff > maxDiff {
 			maxDiff = diff
-			bestPosition = midpoint
+			bestPosition = offset + midpoint
 		}
 	}
 
@@ -570,37 +1293,100 @@ func calculateVariance(samples []float64, start, end int) float64 {
 	return sumSquaredDiff / float64(count)
 }
 
-// detectOnsetsInternal processes audio samples and returns onset times in seconds
-func detectOnsetsInternal(samples []float64, sampleRate uint, method string, bufSize, hopSize uint, threshold float64, minioi float64) []float64 {
-	o := NewOnset(method, bufSize, hopSize, sampleRate)
-	o.SetThreshold(threshold)
-	o.SetMinioiMs(minioi)
+// feed runs detection on in-memory samples, reporting progress to p every 64
+// hops
+func (d *hopDetector) feed(samples []float64, p *progress) {
+	if d.recordCurve {
+		d.curve = make([]DetectionFrame, 0, uint(len(samples))/d.hopSize)
+	}
+
+	chunk := int(d.hopSize) * 64
+	for start := 0; start < len(samples); start += chunk {
+		end := min(start+chunk, len(samples))
+		d.write(samples[start:end])
+		p.report(float64(end) / float64(len(samples)))
+	}
+}
 
-	input := NewFvec(hopSize)
-	output := NewFvec(1)
+// hopDetector feeds audio of arbitrary block sizes to an onset detector hop by hop
+type hopDetector struct {
+	o           *Onset
+	sampleRate  uint
+	hopSize     uint
+	input       *Fvec
+	output      *Fvec
+	fill        uint
+	pos         uint64
+	recordCurve bool
+	onsets      []float64
+	strengths   []float64
+	curve       []DetectionFrame
+	// tiers pick peaks of the detection function at the thresholds of the
+	// sensitivity tiers, collecting their onsets in tierOnsets
+	tiers      []*Onset
+	tierOnsets [][]float64
+	// offset is the time in seconds of the first sample fed, and recordFrom
+	// the position of the first hop whose results are kept, for detectors
+	// warmed up on the audio preceding a chunk
+	offset     float64
+	recordFrom uint64
+}
 
-	var onsets []float64
+// newHopDetector creates a detector for the given method, configured by the
+// detection options
+func newHopDetector(method string, bufSize, hopSize, sampleRate uint, options SliceAnalyzerOptions, recordCurve bool) *hopDetector {
+	threshold, _ := detectionParams(options)
+	o := newAnalysisOnset(method, bufSize, hopSize, sampleRate, threshold, options)
+
+	return &hopDetector{
+		o:           o,
+		sampleRate:  sampleRate,
+		hopSize:     hopSize,
+		input:       NewFvec(hopSize),
+		output:      NewFvec(1),
+		recordCurve: recordCurve,
+	}
+}
 
-	// Process audio in chunks
-	for pos := uint(0); pos+hopSize < uint(len(samples)); pos += hopSize {
-		// Fill input buffer
-		for i := uint(0); i < hopSize; i++ {
-			if pos+i < uint(len(samples)) {
-				input.Data[i] = samples[pos+i]
-			} else {
-				input.Data[i] = 0
-			}
+// write buffers samples and runs detection on every complete hop. A hop is only
+// processed once the sample following it arrives, so the final hop of the
+// stream is skipped when it ends exactly at the last sample.
+func (d *hopDetector) write(samples []float64) {
+	for _, v := range samples {
+		if d.fill == d.hopSize {
+			d.process()
+			d.pos += uint64(d.hopSize)
+			d.fill = 0
 		}
+		d.input.Data[d.fill] = v
+		d.fill++
+	}
+}
 
-		// Process
-		o.Do(input, output)
+// process runs detection on the buffered hop
+func (d *hopDetector) process() {
+	d.o.Do(d.input, d.output)
 
-		// Check for onset
-		if output.Data[0] > 0 {
-			onsetTime := o.GetLastS()
-			onsets = append(onsets, onsetTime)
-		}
+	// Check for onset
+	isOnset := d.output.Data[0] > 0
+	if len(d.tiers) > 0 {
+		d.processTiers()
+	}
+	if d.pos < d.recordFrom {
+		// Warming up
+		return
+	}
+	if isOnset {
+		d.onsets = append(d.onsets, d.offset+d.o.GetLastS())
+		d.strengths = append(d.strengths, d.o.GetOnsetDescriptor())
 	}
 
-	return onsets
+	if d.recordCurve {
+		d.curve = append(d.curve, DetectionFrame{
+			Time:        float64(d.pos) / float64(d.sampleRate),
+			Descriptor:  d.o.GetDescriptor(),
+			Thresholded: d.o.GetThresholdedDescriptor(),
+			Onset:       isOnset,
+		})
+	}
 }
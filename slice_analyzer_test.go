@@ -1,6 +1,15 @@
 package onset
 
 import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -455,3 +464,2144 @@ func TestMinimumSpacing(t *testing.T) {
 		}
 	})
 }
+
+func TestWriteDetectionCSV(t *testing.T) {
+	options := SliceAnalyzerOptions{
+		Method:   "hfc",
+		Optimize: false,
+	}
+
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	if len(result.Detection) == 0 {
+		t.Fatal("Expected detection curve, got empty array")
+	}
+
+	var buf strings.Builder
+	if err := result.WriteDetectionCSV(&buf); err != nil {
+		t.Fatalf("WriteDetectionCSV failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if lines[0] != "time,descriptor,thresholded,onset" {
+		t.Errorf("Unexpected CSV header: %q", lines[0])
+	}
+	if len(lines) != len(result.Detection)+1 {
+		t.Errorf("Expected %d CSV lines, got %d", len(result.Detection)+1, len(lines))
+	}
+
+	// Every onset reported by the detection pass must be flagged in the curve
+	flagged := 0
+	for _, frame := range result.Detection {
+		if frame.Onset {
+			flagged++
+		}
+	}
+	if flagged != len(result.Onsets) {
+		t.Errorf("Expected %d flagged hops, got %d", len(result.Onsets), flagged)
+	}
+}
+
+func TestResultSerialization(t *testing.T) {
+	options := DefaultSliceAnalyzerOptions()
+	options.Method = "consensus"
+	options.MeasureLoudness = true
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := result.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	loaded, err := ReadResultJSON(&buf)
+	if err != nil {
+		t.Fatalf("ReadResultJSON failed: %v", err)
+	}
+	if len(loaded.Samples) != 0 {
+		t.Errorf("Expected no samples, got %d", len(loaded.Samples))
+	}
+	loaded.Samples = result.Samples
+	if !reflect.DeepEqual(loaded, result) {
+		t.Errorf("Expected the reloaded result to match, got %+v", loaded)
+	}
+	if _, err := ReadResultJSON(strings.NewReader(`{"Onsets": [0.1]}`)); err == nil {
+		t.Error("Expected error for a result without a sample rate, got nil")
+	}
+	if _, err := ReadResultJSON(strings.NewReader("onsets")); err == nil {
+		t.Error("Expected error for invalid JSON, got nil")
+	}
+
+	buf.Reset()
+	if err := result.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if lines[0] != "index,start,end,start_sample,end_sample,loudness,true_peak" {
+		t.Errorf("Unexpected CSV header: %q", lines[0])
+	}
+	if len(lines) != len(result.Onsets)+1 {
+		t.Fatalf("Expected %d CSV lines, got %d", len(result.Onsets)+1, len(lines))
+	}
+	last := strings.Split(lines[len(lines)-1], ",")
+	if last[0] != strconv.Itoa(len(result.Onsets)) || last[4] != strconv.Itoa(result.NumSamples) {
+		t.Errorf("Expected the last slice to end at sample %d, got %q", result.NumSamples, lines[len(lines)-1])
+	}
+}
+
+func TestRegions(t *testing.T) {
+	result := &SliceAnalyzerResult{
+		Onsets:     []float64{0.25, 0.5, 1.5},
+		SampleRate: 1000,
+		NumSamples: 2000,
+	}
+	want := []Region{
+		{StartSec: 0.25, EndSec: 0.5, StartSample: 250, EndSample: 500},
+		{StartSec: 0.5, EndSec: 1.5, StartSample: 500, EndSample: 1500},
+		{StartSec: 1.5, EndSec: 2, StartSample: 1500, EndSample: 2000},
+	}
+	if got := result.Regions(); !slices.Equal(got, want) {
+		t.Errorf("Expected regions %v, got %v", want, got)
+	}
+	if got := result.Durations(); !slices.Equal(got, []float64{0.25, 1, 0.5}) {
+		t.Errorf("Expected durations [0.25 1 0.5], got %v", got)
+	}
+
+	for _, tc := range []struct {
+		time  float64
+		index int
+	}{
+		{0, -1}, {0.25, 0}, {0.4, 0}, {0.5, 1}, {1.99, 2}, {2, -1},
+	} {
+		if got := result.SliceIndexAt(tc.time); got != tc.index {
+			t.Errorf("SliceIndexAt(%g): expected %d, got %d", tc.time, tc.index, got)
+		}
+	}
+}
+
+func TestMeasureShape(t *testing.T) {
+	options := DefaultSliceAnalyzerOptions()
+	options.MeasureShape = true
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if len(result.SliceShapes) != len(result.Onsets) {
+		t.Fatalf("Expected %d shapes, got %d", len(result.Onsets), len(result.SliceShapes))
+	}
+	for i, shape := range result.SliceShapes {
+		if shape.Centroid <= 0 || shape.Spread <= 0 || shape.Rolloff <= 0 {
+			t.Errorf("Slice %d: expected a measured shape, got %+v", i+1, shape)
+		}
+	}
+
+	// A low tone then a high tone fading into silence
+	sampleRate := uint(22050)
+	samples := make([]float64, 2*sampleRate)
+	for i := range samples {
+		tm := float64(i) / float64(sampleRate)
+		hz := 200.0
+		if tm >= 1 {
+			hz = 4000
+		}
+		samples[i] = math.Exp(-4*math.Mod(tm, 1)) * math.Sin(2*math.Pi*hz*tm)
+	}
+	r := &SliceAnalyzerResult{Onsets: []float64{0, 1}, Samples: samples, SampleRate: sampleRate}
+	r.measureShapes()
+	if math.Abs(r.SliceShapes[0].Centroid-200) > 50 || math.Abs(r.SliceShapes[1].Centroid-4000) > 50 {
+		t.Errorf("Expected centroids of 200 and 4000 Hz, got %+v", r.SliceShapes)
+	}
+}
+
+func TestTonalityGate(t *testing.T) {
+	// A tone with a 3 Hz tremolo, and a noise burst every second
+	sampleRate := uint(44100)
+	samples := make([]float64, 4*sampleRate)
+	seed := uint32(1)
+	for i := range samples {
+		tm := float64(i) / float64(sampleRate)
+		samples[i] = 0.4 * (0.5 + 0.5*math.Sin(2*math.Pi*3*tm)) * math.Sin(2*math.Pi*440*tm)
+		seed = seed*1664525 + 1013904223
+		if math.Mod(tm, 1) >= 0.5 && math.Mod(tm, 1) < 0.53 {
+			samples[i] += 0.8 * (float64(seed)/float64(1<<32)*2 - 1)
+		}
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+	ungated, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(ungated.Onsets) < 10 {
+		t.Fatalf("Expected the tremolo to fire onsets, got %v", ungated.Onsets)
+	}
+	for _, gate := range []struct {
+		name                  string
+		minFlatness, maxCrest float64
+	}{{"flatness", 0.05, 0}, {"crest", 0, 20}} {
+		options.MinFlatness, options.MaxCrest = gate.minFlatness, gate.maxCrest
+		result, err := AnalyzeSamples(samples, sampleRate, options)
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+		// The first onset is the start of the tone
+		if len(result.Onsets) != 5 {
+			t.Fatalf("%s gate: expected the start and 4 bursts, got %v", gate.name, result.Onsets)
+		}
+		for i, onsetTime := range result.Onsets[1:] {
+			if math.Abs(onsetTime-float64(i)-0.5) > 0.02 {
+				t.Errorf("%s gate: expected a burst at %g s, got %g", gate.name, float64(i)+0.5, onsetTime)
+			}
+		}
+	}
+
+	// Notes with a short noisy attack followed by a sustained tone fire at
+	// their attack, although the tone dominates the frame in which the peak
+	// picker reports them
+	notes := make([]float64, 5*sampleRate)
+	for n, hz := range []float64{220, 330, 440, 550} {
+		start := (n*2 + 1) * int(sampleRate) / 2
+		for i := range int(sampleRate) * 3 / 4 {
+			tm := float64(i) / float64(sampleRate)
+			notes[start+i] = 0.4 * math.Sin(2*math.Pi*hz*tm)
+			seed = seed*1664525 + 1013904223
+			if i < int(sampleRate)/200 {
+				notes[start+i] += 0.8 * (float64(seed)/float64(1<<32)*2 - 1)
+			}
+		}
+	}
+	for _, gate := range []struct {
+		name                  string
+		minFlatness, maxCrest float64
+	}{{"flatness", 0.05, 0}, {"crest", 0, 20}} {
+		options.MinFlatness, options.MaxCrest = gate.minFlatness, gate.maxCrest
+		result, err := AnalyzeSamples(notes, sampleRate, options)
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+		if len(result.Onsets) != 4 {
+			t.Fatalf("%s gate: expected 4 notes, got %v", gate.name, result.Onsets)
+		}
+		for i, onsetTime := range result.Onsets {
+			if want := float64(i) + 0.5; math.Abs(onsetTime-want) > 0.02 {
+				t.Errorf("%s gate: expected a note at %g s, got %g", gate.name, want, onsetTime)
+			}
+		}
+	}
+
+	options.MinFlatness, options.MaxCrest = 2, 0
+	if _, err := AnalyzeSamples(samples, sampleRate, options); err == nil {
+		t.Error("Expected error for a flatness gate above 1, got nil")
+	}
+	if _, err := NewOnsetWithOptions(WithCrestGate(0.5)); err == nil {
+		t.Error("Expected error for a crest gate below 1, got nil")
+	}
+}
+
+func TestKeepSlices(t *testing.T) {
+	options := DefaultSliceAnalyzerOptions()
+	options.KeepSlices = true
+	options.Channel = ChannelMix
+	options.CacheDir = t.TempDir()
+	for _, pass := range []string{"analysis", "cache hit"} {
+		result, err := AnalyzeSlices("amen.wav", options)
+		if err != nil {
+			t.Fatalf("AnalyzeSlices failed: %v", err)
+		}
+		ranges := result.SliceRanges()
+		if len(result.Slices) != len(ranges) {
+			t.Fatalf("%s: expected %d slices, got %d", pass, len(ranges), len(result.Slices))
+		}
+		for i, sr := range ranges {
+			if !slices.Equal(result.Slices[i], result.Samples[sr.Start:sr.End]) || cap(result.Slices[i]) != len(result.Slices[i]) {
+				t.Fatalf("%s: slice %d does not hold samples %d to %d", pass, i+1, sr.Start, sr.End)
+			}
+		}
+	}
+
+	options.CacheDir = ""
+	options.KeepSlices = false
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if result.Slices != nil {
+		t.Errorf("Expected no slices without KeepSlices, got %d", len(result.Slices))
+	}
+}
+
+func TestAnalyzeSamples(t *testing.T) {
+	sampleRate := uint(44100)
+
+	// Synthesize four decaying noise bursts, half a second apart
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75, 1.25, 1.75}, 2.25)
+
+	options := DefaultSliceAnalyzerOptions()
+	result, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	if len(result.Onsets) != 4 {
+		t.Fatalf("Expected 4 onsets, got %d: %v", len(result.Onsets), result.Onsets)
+	}
+
+	for i, onsetTime := range result.Onsets {
+		expected := 0.25 + float64(i)*0.5
+		if math.Abs(onsetTime-expected) > 0.02 {
+			t.Errorf("Onset %d at %.4fs, expected %.4fs", i, onsetTime, expected)
+		}
+	}
+
+	if _, err := AnalyzeSamples(samples, 0, options); err == nil {
+		t.Error("Expected error for zero sample rate, got nil")
+	}
+}
+
+func TestPeakPickerOptions(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75, 1.25, 1.75}, 2.25)
+
+	testCases := []SliceAnalyzerOptions{
+		{},
+		{PeakWinPre: -1, DisablePeakSmoothing: true},
+		{PeakWinPre: 3, PeakWinPost: 8},
+	}
+	for _, options := range testCases {
+		result, err := AnalyzeSamples(samples, sampleRate, options)
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+		if len(result.Onsets) != 4 {
+			t.Errorf("%+v: expected 4 onsets, got %v", options, result.Onsets)
+			continue
+		}
+		// The delay follows the lookahead, so onset times stay in place
+		for i, onsetTime := range result.Onsets {
+			expected := 0.25 + float64(i)*0.5
+			if math.Abs(onsetTime-expected) > 0.01 {
+				t.Errorf("%+v: onset %d at %.4fs, expected %.4fs", options, i, onsetTime, expected)
+			}
+		}
+	}
+}
+
+func TestAdaptiveThreshold(t *testing.T) {
+	sampleRate := uint(44100)
+
+	// Quiet hits over silence, then loud hits over a busy noise bed from 1s
+	expected := []float64{0.25, 0.75, 1.0, 1.25, 1.75, 2.25, 2.75, 3.25, 3.75}
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75}, 4.0)
+	for i := range samples[:int(sampleRate)] {
+		samples[i] *= 0.05
+	}
+	loud := synthBursts(sampleRate, expected[3:], 4.0)
+	seed := uint32(7)
+	for i := int(sampleRate); i < len(samples); i++ {
+		seed = seed*1664525 + 1013904223
+		samples[i] += loud[i] + 0.15*(float64(seed)/float64(1<<32)*2-1)
+	}
+
+	// spurious counts the onsets after the adaptive window has filled that
+	// are not near a hit, and fails if a hit was not detected
+	spurious := func(onsets []float64) int {
+		count := 0
+		for _, onsetTime := range onsets {
+			near := false
+			for _, hit := range expected {
+				near = near || math.Abs(onsetTime-hit) <= 0.02
+			}
+			if !near && onsetTime > 1.5 {
+				count++
+			}
+		}
+		for _, hit := range expected {
+			found := false
+			for _, onsetTime := range onsets {
+				found = found || math.Abs(onsetTime-hit) <= 0.02
+			}
+			if !found {
+				t.Errorf("Expected an onset near %.2fs, got %v", hit, onsets)
+			}
+		}
+		return count
+	}
+
+	options := SliceAnalyzerOptions{Method: "specflux"}
+	result, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if spurious(result.Onsets) == 0 {
+		t.Fatalf("Expected spurious onsets in the noise bed without the adaptive threshold, got %v", result.Onsets)
+	}
+
+	options.AdaptiveWindowSec = 2
+	options.AdaptivePercentile = 95
+	result, err = AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if n := spurious(result.Onsets); n != 0 {
+		t.Errorf("Expected no spurious onsets with the adaptive threshold, got %d: %v", n, result.Onsets)
+	}
+}
+
+// synthBursts synthesizes decaying noise bursts starting at the given times (in seconds)
+func synthBursts(sampleRate uint, times []float64, duration float64) []float64 {
+	samples := make([]float64, int(duration*float64(sampleRate)))
+	seed := uint32(1)
+	for _, start := range times {
+		offset := int(start * float64(sampleRate))
+		for i := 0; i < int(sampleRate)/10 && offset+i < len(samples); i++ {
+			seed = seed*1664525 + 1013904223
+			noise := float64(seed)/float64(1<<32)*2 - 1
+			samples[offset+i] = noise * math.Exp(-float64(i)/800.0)
+		}
+	}
+	return samples
+}
+
+func TestChannelSelection(t *testing.T) {
+	channels := [][]float64{{1, 1, 1}, {0, 0.5, 1}}
+
+	testCases := []struct {
+		mode     string
+		expected []float64
+	}{
+		{"", []float64{1, 1, 1}},
+		{"left", []float64{1, 1, 1}},
+		{"right", []float64{0, 0.5, 1}},
+		{"mix", []float64{0.5, 0.75, 1}},
+		{"mid", []float64{0.5, 0.75, 1}},
+		{"side", []float64{0.5, 0.25, 0}},
+		{"1", []float64{0, 0.5, 1}},
+	}
+
+	for _, tc := range testCases {
+		samples, err := selectChannel(channels, tc.mode)
+		if err != nil {
+			t.Fatalf("selectChannel(%q) failed: %v", tc.mode, err)
+		}
+		for i := range tc.expected {
+			if math.Abs(samples[i]-tc.expected[i]) > 1e-12 {
+				t.Errorf("selectChannel(%q)[%d] = %f, expected %f", tc.mode, i, samples[i], tc.expected[i])
+			}
+		}
+	}
+
+	for _, mode := range []string{"2", "-1", "center"} {
+		if _, err := selectChannel(channels, mode); err == nil {
+			t.Errorf("Expected error for channel mode %q, got nil", mode)
+		}
+	}
+
+	if _, err := selectChannel(channels[:1], "side"); err == nil {
+		t.Error("Expected error for side channel of a mono file, got nil")
+	}
+}
+
+func TestAnalyzeSlicesPerChannel(t *testing.T) {
+	options := SliceAnalyzerOptions{
+		Method:  "hfc",
+		Channel: ChannelPerChannel,
+	}
+
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	if len(result.ChannelOnsets) != 2 {
+		t.Fatalf("Expected onsets for 2 channels, got %d", len(result.ChannelOnsets))
+	}
+
+	for ch, onsets := range result.ChannelOnsets {
+		if len(onsets) == 0 {
+			t.Errorf("Expected onsets for channel %d, got empty array", ch)
+		}
+	}
+
+	if len(result.Onsets) < len(result.ChannelOnsets[0]) {
+		t.Errorf("Expected union of channels to have at least %d onsets, got %d",
+			len(result.ChannelOnsets[0]), len(result.Onsets))
+	}
+}
+
+func TestAnalyzeRate(t *testing.T) {
+	samples := synthBursts(96000, []float64{0.25, 0.75, 1.25, 1.75}, 2.25)
+
+	options := DefaultSliceAnalyzerOptions()
+	options.AnalyzeRate = 44100
+	result, err := AnalyzeSamples(samples, 96000, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	if result.SampleRate != 44100 {
+		t.Errorf("Expected analysis rate 44100, got %d", result.SampleRate)
+	}
+	if len(result.Onsets) != 4 {
+		t.Fatalf("Expected 4 onsets, got %d: %v", len(result.Onsets), result.Onsets)
+	}
+	for i, onsetTime := range result.Onsets {
+		expected := 0.25 + float64(i)*0.5
+		if math.Abs(onsetTime-expected) > 0.02 {
+			t.Errorf("Onset %d at %.4fs, expected %.4fs", i, onsetTime, expected)
+		}
+	}
+}
+
+func TestAnalyzeSlicesStreaming(t *testing.T) {
+	for _, method := range []string{"hfc", "consensus"} {
+		t.Run(method, func(t *testing.T) {
+			options := DefaultSliceAnalyzerOptions()
+			options.Method = method
+			options.NumSlices = 8
+
+			expected, err := AnalyzeSlices("amen.wav", options)
+			if err != nil {
+				t.Fatalf("AnalyzeSlices failed: %v", err)
+			}
+
+			options.Streaming = true
+			options.PreviewDecimation = 1000
+			result, err := AnalyzeSlices("amen.wav", options)
+			if err != nil {
+				t.Fatalf("Streaming AnalyzeSlices failed: %v", err)
+			}
+
+			if len(result.Onsets) != len(expected.Onsets) {
+				t.Fatalf("Expected %d onsets, got %d", len(expected.Onsets), len(result.Onsets))
+			}
+			for i := range expected.Onsets {
+				if result.Onsets[i] != expected.Onsets[i] {
+					t.Errorf("Onset %d: expected %.6fs, got %.6fs", i, expected.Onsets[i], result.Onsets[i])
+				}
+			}
+			if len(result.Detection) != len(expected.Detection) {
+				t.Errorf("Expected %d detection frames, got %d", len(expected.Detection), len(result.Detection))
+			}
+
+			if result.Samples != nil {
+				t.Error("Expected no retained samples in streaming mode")
+			}
+			if result.NumSamples != len(expected.Samples) {
+				t.Errorf("Expected %d samples, got %d", len(expected.Samples), result.NumSamples)
+			}
+			previewLen := (result.NumSamples + 999) / 1000
+			if len(result.Preview) != previewLen {
+				t.Errorf("Expected %d preview points, got %d", previewLen, len(result.Preview))
+			}
+		})
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Streaming = true
+	options.Channel = ChannelPerChannel
+	if _, err := AnalyzeSlices("amen.wav", options); err == nil {
+		t.Error("Expected an error for per-channel streaming analysis")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	for _, streaming := range []bool{false, true} {
+		var reports []float64
+		options := DefaultSliceAnalyzerOptions()
+		options.NumSlices = 8
+		options.Streaming = streaming
+		options.Progress = func(frac float64) {
+			reports = append(reports, frac)
+		}
+
+		if _, err := AnalyzeSlices("amen.wav", options); err != nil {
+			t.Fatalf("AnalyzeSlices failed: %v", err)
+		}
+
+		if len(reports) < 10 {
+			t.Fatalf("Expected periodic progress reports (streaming=%v), got %d", streaming, len(reports))
+		}
+		for i := 1; i < len(reports); i++ {
+			if reports[i] <= reports[i-1] {
+				t.Fatalf("Progress decreased from %f to %f (streaming=%v)", reports[i-1], reports[i], streaming)
+			}
+		}
+		if last := reports[len(reports)-1]; last != 1 {
+			t.Errorf("Expected final progress 1, got %f (streaming=%v)", last, streaming)
+		}
+	}
+}
+
+func TestAnalyzeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75}, 1.25)
+
+	valid := []string{
+		filepath.Join(dir, "a.wav"),
+		filepath.Join(dir, "b.WAV"),
+		filepath.Join(dir, "sub", "c.wav"),
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range valid {
+		if err := WriteWav(path, samples, sampleRate, 16); err != nil {
+			t.Fatalf("WriteWav failed: %v", err)
+		}
+	}
+	broken := filepath.Join(dir, "broken.wav")
+	if err := os.WriteFile(broken, []byte("not audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var reports []float64
+	options := DefaultSliceAnalyzerOptions()
+	options.Progress = func(frac float64) {
+		reports = append(reports, frac)
+	}
+	results, errs, err := AnalyzeDirectory(dir, options, 2)
+	if err != nil {
+		t.Fatalf("AnalyzeDirectory failed: %v", err)
+	}
+
+	if len(results) != len(valid) {
+		t.Fatalf("Expected %d results, got %d", len(valid), len(results))
+	}
+	for _, path := range valid {
+		result, ok := results[path]
+		if !ok {
+			t.Errorf("Missing result for %s", path)
+			continue
+		}
+		if len(result.Onsets) != 2 {
+			t.Errorf("Expected 2 onsets in %s, got %d", path, len(result.Onsets))
+		}
+	}
+	if len(errs) != 1 || errs[broken] == nil {
+		t.Errorf("Expected a single error for %s, got %v", broken, errs)
+	}
+	if len(reports) == 0 || reports[len(reports)-1] != 1 {
+		t.Errorf("Expected progress to finish at 1, got %v", reports)
+	}
+
+	if _, _, err := AnalyzeDirectory(filepath.Join(dir, "missing"), options, 0); err == nil {
+		t.Error("Expected error for missing directory, got nil")
+	}
+}
+
+func TestAnalyzeSlicesCache(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	path := filepath.Join(dir, "bursts.wav")
+	sampleRate := uint(44100)
+	if err := WriteWav(path, synthBursts(sampleRate, []float64{0.25, 0.75}, 1.25), sampleRate, 16); err != nil {
+		t.Fatalf("WriteWav failed: %v", err)
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.CacheDir = cacheDir
+	first, err := AnalyzeSlices(path, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+
+	entries, err := filepath.Glob(filepath.Join(cacheDir, "*.json"))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected one cache entry, got %v (%v)", entries, err)
+	}
+
+	// Tamper with the entry to prove that the second run reads it
+	cached, ok := readCachedResult(entries[0])
+	if !ok {
+		t.Fatal("Failed to read cache entry")
+	}
+	cached.Onsets = []float64{0.5}
+	if err := writeCachedResult(entries[0], cached); err != nil {
+		t.Fatalf("Failed to write cache entry: %v", err)
+	}
+
+	second, err := AnalyzeSlices(path, options)
+	if err != nil {
+		t.Fatalf("Cached AnalyzeSlices failed: %v", err)
+	}
+	if len(second.Onsets) != 1 || second.Onsets[0] != 0.5 {
+		t.Errorf("Expected cached onsets [0.5], got %v", second.Onsets)
+	}
+	if len(second.Samples) != len(first.Samples) || len(second.Detection) != len(first.Detection) {
+		t.Errorf("Cached result differs in samples or detection curve")
+	}
+
+	// Different options must not hit the entry
+	options.MinimumSpacing = 40
+	third, err := AnalyzeSlices(path, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if len(third.Onsets) != len(first.Onsets) {
+		t.Errorf("Expected %d onsets with new options, got %d", len(first.Onsets), len(third.Onsets))
+	}
+
+	// Registering a descriptor changes the methods of the consensus only
+	options.Method = "consensus"
+	consensusKey, err := cacheKey(path, options)
+	if err != nil {
+		t.Fatalf("cacheKey failed: %v", err)
+	}
+	options.Method = "hfc"
+	hfcKey, err := cacheKey(path, options)
+	if err != nil {
+		t.Fatalf("cacheKey failed: %v", err)
+	}
+	if err := RegisterSpecdesc("test-cache-rise", func(*Cvec, any, *Fvec) {}); err != nil {
+		t.Fatalf("RegisterSpecdesc failed: %v", err)
+	}
+	t.Cleanup(func() { unregisterSpecdesc("test-cache-rise") })
+	if key, _ := cacheKey(path, options); key != hfcKey {
+		t.Error("Expected registering a descriptor to keep the cache key of hfc")
+	}
+	options.Method = "consensus"
+	if key, _ := cacheKey(path, options); key == consensusKey {
+		t.Error("Expected registering a descriptor to change the cache key of the consensus")
+	}
+}
+
+func TestExportSlices(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75, 1.25}, 1.75)
+	result, err := AnalyzeSamples(samples, sampleRate, DefaultSliceAnalyzerOptions())
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) != 3 {
+		t.Fatalf("Expected 3 onsets, got %d", len(result.Onsets))
+	}
+
+	dir := filepath.Join(t.TempDir(), "slices")
+	options := DefaultExportOptions()
+	options.NameTemplate = "{name}-{index}-of-{count}.wav"
+	paths, err := ExportSlices(result, dir, "bursts", options)
+	if err != nil {
+		t.Fatalf("ExportSlices failed: %v", err)
+	}
+
+	ranges := result.SliceRanges()
+	if len(paths) != 3 || filepath.Base(paths[0]) != "bursts-01-of-3.wav" {
+		t.Fatalf("Unexpected slice paths: %v", paths)
+	}
+	if ranges[len(ranges)-1].End != len(samples) {
+		t.Errorf("Expected the last slice to end at %d, got %d", len(samples), ranges[len(ranges)-1].End)
+	}
+	for i, path := range paths {
+		channels, _, err := readAudioFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read slice: %v", err)
+		}
+		if want := ranges[i].End - ranges[i].Start; len(channels[0]) != want {
+			t.Errorf("Slice %d has %d samples, expected %d", i+1, len(channels[0]), want)
+		}
+	}
+
+	options.NameTemplate = "same.wav"
+	if _, err := ExportSlices(result, dir, "bursts", options); err == nil {
+		t.Error("Expected error for duplicate file names, got nil")
+	}
+}
+
+func TestExportMinLoudness(t *testing.T) {
+	// A loud burst and a burst 40 dB quieter
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25}, 1.5)
+	quiet := synthBursts(sampleRate, []float64{0.75}, 1.5)
+	for i := range samples {
+		samples[i] += 0.01 * quiet[i]
+	}
+	result := &SliceAnalyzerResult{Onsets: []float64{0.25, 0.75}, Samples: samples, SampleRate: sampleRate}
+
+	options := DefaultExportOptions()
+	options.MinLoudnessLUFS = -40
+	paths, err := ExportSlices(result, t.TempDir(), "bursts", options)
+	if err != nil {
+		t.Fatalf("ExportSlices failed: %v", err)
+	}
+	if len(paths) != 1 || filepath.Base(paths[0]) != "bursts_01.wav" {
+		t.Errorf("Expected only the loud slice, got %v", paths)
+	}
+}
+
+func TestMatchOnsets(t *testing.T) {
+	reference := []float64{0.5, 1.0, 1.5, 2.0}
+	detected := []float64{1.52, 0.49, 1.0, 1.01, 3.0}
+
+	m := MatchOnsets(reference, detected, 0.03)
+	if m.Matched != 3 || m.FalsePositives != 2 || m.FalseNegatives != 1 {
+		t.Errorf("Expected 3 matched, 2 false positives, 1 false negative, got %+v", m)
+	}
+	if math.Abs(m.Precision-0.6) > 1e-9 || math.Abs(m.Recall-0.75) > 1e-9 {
+		t.Errorf("Expected precision 0.6 and recall 0.75, got %f and %f", m.Precision, m.Recall)
+	}
+	if want := 2 * 0.6 * 0.75 / 1.35; math.Abs(m.FMeasure-want) > 1e-9 {
+		t.Errorf("Expected F-measure %f, got %f", want, m.FMeasure)
+	}
+
+	if empty := MatchOnsets(nil, nil, 0.05); empty.FMeasure != 0 || empty.Matched != 0 {
+		t.Errorf("Expected zero result for empty inputs, got %+v", empty)
+	}
+}
+
+func TestSensitivityTiers(t *testing.T) {
+	sampleRate := uint(44100)
+
+	// Loud and quiet hits over a noise bed
+	loudTimes := []float64{0.25, 0.75, 1.75, 2.25}
+	quietTimes := []float64{1.25, 2.75}
+	samples := synthBursts(sampleRate, loudTimes, 3.0)
+	quiet := synthBursts(sampleRate, quietTimes, 3.0)
+	seed := uint32(7)
+	for i := range samples {
+		seed = seed*1664525 + 1013904223
+		samples[i] += 0.02*quiet[i] + 0.02*(float64(seed)/float64(1<<32)*2-1)
+	}
+
+	contains := func(onsets []float64, hit float64) bool {
+		for _, onsetTime := range onsets {
+			if math.Abs(onsetTime-hit) <= 0.03 {
+				return true
+			}
+		}
+		return false
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+	options.Tiers = []SensitivityTier{{"weak", 0.02}, {"strong", 0.3}}
+	result, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Tiers) != 2 || result.Tiers[0].Name != "strong" || result.Tiers[1].Name != "weak" {
+		t.Fatalf("Expected the strong tier before the weak tier, got %+v", result.Tiers)
+	}
+
+	strong, weak := result.OnsetsUpTo("strong"), result.OnsetsUpTo("weak")
+	for _, hit := range loudTimes {
+		if !contains(strong, hit) {
+			t.Errorf("Expected a strong onset near %.2fs, got %v", hit, strong)
+		}
+	}
+	for _, hit := range quietTimes {
+		if contains(strong, hit) {
+			t.Errorf("Expected no strong onset near %.2fs, got %v", hit, strong)
+		}
+		if !contains(result.Tiers[1].Onsets, hit) {
+			t.Errorf("Expected a weak onset near %.2fs, got %v", hit, result.Tiers[1].Onsets)
+		}
+	}
+	if len(weak) != len(strong)+len(result.Tiers[1].Onsets) || !sort.Float64sAreSorted(weak) {
+		t.Errorf("Expected the weak tier to add its onsets to the strong ones, got %v", weak)
+	}
+
+	// The main onsets use the same threshold as the weak tier
+	if len(result.Onsets) != len(weak) {
+		t.Errorf("Expected %d onsets, got %v", len(weak), result.Onsets)
+	}
+	if result.OnsetsUpTo("unknown") != nil {
+		t.Error("Expected no onsets for an unknown tier")
+	}
+
+	options.Tiers = []SensitivityTier{{"weak", 0.02}, {"weak", 0.3}}
+	if _, err := AnalyzeSamples(samples, sampleRate, options); err == nil {
+		t.Error("Expected an error for duplicate tier names")
+	}
+}
+
+func TestBacktrack(t *testing.T) {
+	sampleRate := uint(44100)
+
+	// Noise bursts with a 30ms attack ramp, which is detected late
+	attacks := []float64{0.5, 1.2}
+	samples := make([]float64, 2*sampleRate)
+	seed := uint32(1)
+	for _, start := range attacks {
+		offset := int(start * float64(sampleRate))
+		for i := 0; i < int(sampleRate)/5; i++ {
+			seed = seed*1664525 + 1013904223
+			noise := float64(seed)/float64(1<<32)*2 - 1
+			samples[offset+i] = noise * math.Min(float64(i)/(0.03*float64(sampleRate)), 1)
+		}
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+	plain, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	options.Backtrack = true
+	backtracked, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	if len(plain.Onsets) != len(attacks) || len(backtracked.Onsets) != len(attacks) {
+		t.Fatalf("Expected %d onsets, got %v and %v", len(attacks), plain.Onsets, backtracked.Onsets)
+	}
+	for i, attack := range attacks {
+		if plain.Onsets[i] <= attack {
+			t.Errorf("Expected the onset near %.2fs to be detected after the attack starts, got %.4fs", attack, plain.Onsets[i])
+		}
+		if backtracked.Onsets[i] > attack || backtracked.Onsets[i] < attack-0.01 {
+			t.Errorf("Expected the backtracked onset just before %.2fs, got %.4fs", attack, backtracked.Onsets[i])
+		}
+	}
+
+	// Streaming backtracks the same way
+	options = DefaultSliceAnalyzerOptions()
+	options.Backtrack = true
+	expected, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	options.Streaming = true
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("Streaming AnalyzeSlices failed: %v", err)
+	}
+	if len(result.Onsets) != len(expected.Onsets) {
+		t.Fatalf("Expected %d onsets, got %d", len(expected.Onsets), len(result.Onsets))
+	}
+	for i := range expected.Onsets {
+		if result.Onsets[i] != expected.Onsets[i] {
+			t.Errorf("Onset %d: expected %.6fs, got %.6fs", i, expected.Onsets[i], result.Onsets[i])
+		}
+	}
+}
+
+func TestInputHighpass(t *testing.T) {
+	sampleRate := uint(44100)
+
+	// Quiet noise bursts and loud 25 Hz thumps, like handling noise
+	samples := synthBursts(sampleRate, []float64{0.5, 1.5}, 2.5)
+	for i := range samples {
+		samples[i] *= 0.1
+	}
+	for _, start := range []float64{1.0, 2.0} {
+		offset := int(start * float64(sampleRate))
+		for i := 0; i < int(sampleRate)/5; i++ {
+			samples[offset+i] += math.Sin(2*math.Pi*25*float64(i)/float64(sampleRate)) * math.Exp(-float64(i)/4000)
+		}
+	}
+
+	// peaks returns the peak of the detection function at the bursts and at
+	// the thumps
+	peaks := func(highpassHz float64) (float64, float64) {
+		options := DefaultSliceAnalyzerOptions()
+		options.Method = "energy"
+		options.InputHighpassHz = highpassHz
+		result, err := AnalyzeSamples(samples, sampleRate, options)
+		if err != nil {
+			t.Fatalf("AnalyzeSamples failed: %v", err)
+		}
+		burst, thump := 0.0, 0.0
+		for _, frame := range result.Detection {
+			if frame.Time >= 0.45 && frame.Time < 0.7 {
+				burst = math.Max(burst, frame.Descriptor)
+			}
+			if frame.Time >= 0.95 && frame.Time < 1.2 {
+				thump = math.Max(thump, frame.Descriptor)
+			}
+		}
+		return burst, thump
+	}
+
+	burst, thump := peaks(0)
+	filteredBurst, filteredThump := peaks(100)
+	if filteredThump > thump/50 {
+		t.Errorf("Expected the highpass to suppress the thumps, got %f without and %f with it", thump, filteredThump)
+	}
+	if math.Abs(filteredBurst-burst) > 0.1*burst {
+		t.Errorf("Expected the highpass to keep the bursts, got %f without and %f with it", burst, filteredBurst)
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.InputHighpassHz = 30000
+	if _, err := AnalyzeSamples(samples, sampleRate, options); err == nil {
+		t.Error("Expected an error for a cutoff above half the sample rate")
+	}
+
+	// A detector ignores cutoffs at or above half the sample rate, which
+	// would give NaN filter coefficients
+	o := NewOnset("energy", 512, 256, sampleRate)
+	o.SetInputHighpassHz(100)
+	for _, hz := range []float64{22050, 30000, math.NaN()} {
+		o.SetInputHighpassHz(hz)
+		if o.GetInputHighpassHz() != 100 {
+			t.Errorf("Expected a cutoff of %g Hz to be ignored, got %g Hz", hz, o.GetInputHighpassHz())
+		}
+	}
+	input, output := NewFvec(256), NewFvec(1)
+	for start := 0; start+256 <= len(samples); start += 256 {
+		copy(input.Data, samples[start:start+256])
+		o.Do(input, output)
+		if math.IsNaN(o.GetDescriptor()) {
+			t.Fatalf("Expected a finite detection function at %d", start)
+		}
+	}
+	o.SetInputHighpassHz(0)
+	if o.GetInputHighpassHz() != 0 {
+		t.Errorf("Expected 0 to disable the highpass, got %g Hz", o.GetInputHighpassHz())
+	}
+}
+
+func TestRemoveDC(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.5, 1.5}, 2.5)
+	for i := range samples {
+		samples[i] = 0.3 + 0.5*samples[i]
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Method = "energy"
+	options.RemoveDC = true
+	result, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) != 2 || math.Abs(result.Onsets[0]-0.5) > 0.02 || math.Abs(result.Onsets[1]-1.5) > 0.02 {
+		t.Errorf("Expected onsets near 0.5s and 1.5s, got %v", result.Onsets)
+	}
+}
+
+func TestNoiseGateOption(t *testing.T) {
+	// Bursts over loud hiss, which alone triggers the energy method
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.5, 1.5}, 2.5)
+	state := uint32(1)
+	for i := range samples {
+		state = state*1664525 + 1013904223
+		samples[i] += 0.01 * (float64(state)/math.MaxUint32 - 0.5)
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Method = "energy"
+	options.NoiseGateDB = -30
+	result, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) != 2 || math.Abs(result.Onsets[0]-0.5) > 0.02 || math.Abs(result.Onsets[1]-1.5) > 0.02 {
+		t.Errorf("Expected onsets near 0.5s and 1.5s, got %v", result.Onsets)
+	}
+
+	options.NoiseGateDB = 6
+	if _, err := AnalyzeSamples(samples, sampleRate, options); err == nil {
+		t.Error("Expected error for a positive gate threshold, got nil")
+	}
+}
+
+func TestParallelAnalysis(t *testing.T) {
+	var times []float64
+	for start := 0.05; start < 95; start += 0.37 {
+		times = append(times, start)
+	}
+	samples := synthBursts(44100, times, 95)
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+	options.Tiers = DefaultSensitivityTiers()
+	sequential, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+
+	options.Workers = 4
+	last := 0.0
+	options.Progress = func(frac float64) {
+		if frac < last {
+			t.Errorf("Progress went backwards from %f to %f", last, frac)
+		}
+		last = frac
+	}
+	parallel, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if last != 1 {
+		t.Errorf("Expected final progress 1, got %f", last)
+	}
+
+	if len(parallel.Onsets) != len(sequential.Onsets) {
+		t.Fatalf("Expected %d onsets, got %d", len(sequential.Onsets), len(parallel.Onsets))
+	}
+	for i, onsetTime := range sequential.Onsets {
+		if math.Abs(parallel.Onsets[i]-onsetTime) > 1e-9 {
+			t.Errorf("Onset %d: expected %f, got %f", i, onsetTime, parallel.Onsets[i])
+		}
+	}
+	if len(parallel.Detection) != len(sequential.Detection) {
+		t.Fatalf("Expected %d detection frames, got %d", len(sequential.Detection), len(parallel.Detection))
+	}
+	for i, frame := range sequential.Detection {
+		got := parallel.Detection[i]
+		if math.Abs(got.Time-frame.Time) > 1e-9 || math.Abs(got.Descriptor-frame.Descriptor) > 1e-9*max(frame.Descriptor, 1) || got.Onset != frame.Onset {
+			t.Errorf("Frame %d: expected %+v, got %+v", i, frame, got)
+			break
+		}
+	}
+	for i, tier := range sequential.Tiers {
+		if len(parallel.Tiers[i].Onsets) != len(tier.Onsets) {
+			t.Errorf("Tier %s: expected %d onsets, got %d", tier.Name, len(tier.Onsets), len(parallel.Tiers[i].Onsets))
+		}
+	}
+}
+
+func TestParamsOption(t *testing.T) {
+	sampleRate := uint(44100)
+	samples := synthBursts(sampleRate, []float64{0.25, 0.75, 1.25, 1.75}, 2.25)
+
+	preset := NewOnset("energy", 512, 256, sampleRate).Params()
+	preset.Threshold = 0.3
+	preset.MinioiMs = 50
+
+	explicit := DefaultSliceAnalyzerOptions()
+	explicit.Method = "energy"
+	explicit.Threshold = 0.3
+	explicit.MinioiMs = 50
+	want, err := AnalyzeSamples(samples, sampleRate, explicit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Method = ""
+	options.Params = &preset
+	got, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Onsets) != 4 || !slices.Equal(got.Onsets, want.Onsets) {
+		t.Errorf("Expected the preset to detect %v, got %v", want.Onsets, got.Onsets)
+	}
+}
+
+func TestMaterialPresets(t *testing.T) {
+	sampleRate := uint(44100)
+	times := []float64{0.25, 0.75, 1.25, 1.75}
+	samples := synthBursts(sampleRate, times, 2.25)
+
+	for _, name := range ListPresets() {
+		preset, ok := PresetInfo(name)
+		if !ok || preset.Description == "" || !isMethod(preset.Method) {
+			t.Fatalf("%s: invalid preset %+v", name, preset)
+		}
+
+		options := DefaultSliceAnalyzerOptions()
+		options.Preset = name
+		result, err := AnalyzeSamples(samples, sampleRate, options)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(result.Onsets) != len(times) {
+			t.Errorf("%s: expected %d onsets, got %v", name, len(times), result.Onsets)
+		}
+
+		if p := preset.Params(); p.Method != preset.Method || p.Threshold != preset.Threshold || p.Silence != -70 {
+			t.Errorf("%s: unexpected parameters %+v", name, p)
+		}
+	}
+
+	// Explicit settings take precedence over the preset
+	drums, _ := PresetInfo("drums")
+	options := drums.Apply(SliceAnalyzerOptions{Threshold: 0.5, InputHighpassHz: 100})
+	if options.Method != "hfc" || options.Threshold != 0.5 || options.MinioiMs != 30 || options.InputHighpassHz != 100 {
+		t.Errorf("Unexpected options %+v", options)
+	}
+
+	if _, err := AnalyzeSamples(samples, sampleRate, SliceAnalyzerOptions{Preset: "polka"}); err == nil {
+		t.Error("Expected error for an unknown preset, got nil")
+	}
+}
+
+func TestCalibrateThreshold(t *testing.T) {
+	decoded, err := AnalyzeSlices("amen.wav", SliceAnalyzerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, sampleRate := decoded.Samples, decoded.SampleRate
+
+	for _, target := range []int{4, 12} {
+		threshold, count, err := CalibrateThreshold(samples, sampleRate, "hfc", target, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count < target-1 || count > target+1 {
+			t.Errorf("Expected %d±1 onsets, got %d at threshold %g", target, count, threshold)
+		}
+
+		options := DefaultSliceAnalyzerOptions()
+		options.Threshold = threshold
+		options.Optimize = false
+		result, err := AnalyzeSamples(samples, sampleRate, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Onsets) != count {
+			t.Errorf("Expected the analyzer to detect %d onsets at threshold %g, got %d", count, threshold, len(result.Onsets))
+		}
+	}
+
+	// An unreachable target returns the closest count, at the lowest threshold
+	threshold, count, err := CalibrateThreshold(samples, sampleRate, "hfc", 1000, 0)
+	if err != nil || threshold != 0 || count < 12 {
+		t.Errorf("Expected the count at threshold 0, got %d at %g (%v)", count, threshold, err)
+	}
+
+	for _, tc := range []struct {
+		method            string
+		target, tolerance int
+	}{
+		{"consensus", 3, 0},
+		{"hfcc", 3, 0},
+		{"hfc", 0, 0},
+		{"hfc", 3, -1},
+	} {
+		if _, _, err := CalibrateThreshold(samples, sampleRate, tc.method, tc.target, tc.tolerance); err == nil {
+			t.Errorf("Expected error for %+v, got nil", tc)
+		}
+	}
+}
+
+func TestSearchParams(t *testing.T) {
+	base := DefaultSliceAnalyzerOptions()
+	base.Optimize = false
+	grid := ParamGrid{
+		Methods:    []string{"hfc", "specflux"},
+		Thresholds: []float64{0.05, 0.3, 1.5},
+		AWhitening: []bool{false, true},
+		Options:    &base,
+	}
+
+	// Annotations made with one of the combinations are matched perfectly by it
+	reference := base
+	reference.Params = &Params{Method: "specflux", Threshold: 0.3, MinioiMs: 10, Compression: 10, AWhitening: true}
+	reference.Method = "specflux"
+	annotated, err := AnalyzeSlices("amen.wav", reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores, err := SearchParams("amen.wav", grid, Objective{Reference: annotated.Onsets, Tolerance: 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 12 {
+		t.Fatalf("Expected 12 combinations, got %d", len(scores))
+	}
+	found := false
+	for i, score := range scores {
+		if i > 0 && score.Score > scores[i-1].Score {
+			t.Errorf("Scores are not ranked: %g after %g", score.Score, scores[i-1].Score)
+		}
+		p := score.Params
+		if p.Method == "specflux" && p.Threshold == 0.3 && p.AWhitening {
+			found = true
+			if score.Score != 1 || score.Match.Matched != len(annotated.Onsets) {
+				t.Errorf("Expected a perfect score for the reference parameters, got %+v", score)
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected the reference parameters among the results")
+	}
+
+	scores, err = SearchParams("amen.wav", grid, Objective{TargetCount: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, score := range scores[1:] {
+		if abs(score.Count-4) < abs(scores[0].Count-4) {
+			t.Errorf("Expected the count %d closest to the target first, got %d later", scores[0].Count, score.Count)
+		}
+	}
+
+	if _, err := SearchParams("amen.wav", grid, Objective{}); err == nil {
+		t.Error("Expected error without an objective, got nil")
+	}
+	if _, err := SearchParams("amen.wav", ParamGrid{Methods: []string{"consensus"}}, Objective{TargetCount: 4}); err == nil {
+		t.Error("Expected error for the consensus method, got nil")
+	}
+	for _, invalid := range []ParamGrid{{Thresholds: []float64{0.1, 0}}, {Thresholds: []float64{-0.1}}, {MinioiMs: []float64{0}}} {
+		if _, err := SearchParams("amen.wav", invalid, Objective{TargetCount: 4}); err == nil {
+			t.Errorf("Expected error for the grid %+v, got nil", invalid)
+		}
+	}
+}
+
+func TestSensitivityCurve(t *testing.T) {
+	decoded, err := AnalyzeSlices("amen.wav", SliceAnalyzerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, sampleRate := decoded.Samples, decoded.SampleRate
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Threshold = 1
+	options.Optimize = false
+	reference, err := AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	points, err := SensitivityCurve(samples, sampleRate, SensitivityOptions{MaxThreshold: 3, Steps: 31, Reference: reference.Onsets, Tolerance: 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 31 || points[0].Threshold != 0 || points[30].Threshold != 3 {
+		t.Fatalf("Expected 31 thresholds from 0 to 3, got %d", len(points))
+	}
+	if points[0].Count <= points[30].Count {
+		t.Errorf("Expected fewer onsets at higher thresholds, got %d and %d", points[0].Count, points[30].Count)
+	}
+	if p := points[10]; p.Count != len(reference.Onsets) || p.Match.FMeasure != 1 {
+		t.Errorf("Expected the analyzer's %d onsets at threshold 1, got %+v", len(reference.Onsets), p)
+	}
+
+	first, last := Plateau(points)
+	if first < 0 || last < first {
+		t.Fatalf("Expected a plateau, got %d to %d", first, last)
+	}
+	for _, p := range points[first : last+1] {
+		if p.Count != points[first].Count {
+			t.Errorf("Expected a constant count on the plateau, got %d and %d", p.Count, points[first].Count)
+		}
+	}
+
+	counts := []int{9, 5, 5, 3, 3, 3, 0, 0, 0, 0}
+	synthetic := make([]SensitivityPoint, len(counts))
+	for i, count := range counts {
+		synthetic[i].Count = count
+	}
+	if first, last := Plateau(synthetic); first != 3 || last != 5 {
+		t.Errorf("Expected the plateau of 3 onsets at 3 to 5, got %d to %d", first, last)
+	}
+	if first, last := Plateau(synthetic[6:]); first != -1 || last != -1 {
+		t.Errorf("Expected no plateau without onsets, got %d to %d", first, last)
+	}
+
+	if _, err := SensitivityCurve(samples, sampleRate, SensitivityOptions{Steps: 1}); err == nil {
+		t.Error("Expected error for a single step, got nil")
+	}
+}
+
+func TestWriteTerminal(t *testing.T) {
+	// A square wave over the second half, full scale
+	samples := make([]float64, 800)
+	for i := 400; i < len(samples); i++ {
+		samples[i] = 1
+		if i%2 == 1 {
+			samples[i] = -1
+		}
+	}
+	result := &SliceAnalyzerResult{
+		Onsets:     []float64{0.5},
+		Samples:    samples,
+		SampleRate: 800,
+		NumSamples: len(samples),
+	}
+
+	var b strings.Builder
+	if err := result.WriteTerminal(&b, 10); err != nil {
+		t.Fatalf("WriteTerminal failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != terminalLines+2 {
+		t.Fatalf("Expected %d lines, got %d:\n%s", terminalLines+2, len(lines), b.String())
+	}
+
+	// Silence sets the dots of the row of zero only; the square wave fills
+	// every dot
+	for i, want := range []string{"⠀⠀⠀⠀⠀⣿⣿⣿⣿⣿", "⠀⠀⠀⠀⠀⣿⣿⣿⣿⣿", "⠉⠉⠉⠉⠉⣿⣿⣿⣿⣿", "⠀⠀⠀⠀⠀⣿⣿⣿⣿⣿"} {
+		if lines[i] != want {
+			t.Errorf("Expected line %d %q, got %q", i, want, lines[i])
+		}
+	}
+	if lines[4] != "     ^" {
+		t.Errorf("Expected onset marker in column 5, got %q", lines[4])
+	}
+	if lines[5] != "0 s 1.00 s" {
+		t.Errorf("Unexpected time line %q", lines[5])
+	}
+
+	if err := (&SliceAnalyzerResult{SampleRate: 800}).WriteTerminal(&b, 10); err == nil {
+		t.Error("Expected error for a result without samples, got nil")
+	}
+}
+
+func TestRearrange(t *testing.T) {
+	pattern, err := ParsePattern("2, 1r 3x2")
+	if err != nil {
+		t.Fatalf("ParsePattern failed: %v", err)
+	}
+	expected := []PatternStep{{Slice: 1}, {Slice: 0, Reverse: true}, {Slice: 2}, {Slice: 2}}
+	if !slices.Equal(pattern, expected) {
+		t.Errorf("Expected %v, got %v", expected, pattern)
+	}
+	for _, invalid := range []string{"", "0", "a", "1x0", "2rr"} {
+		if _, err := ParsePattern(invalid); err == nil {
+			t.Errorf("Expected error for pattern %q, got nil", invalid)
+		}
+	}
+
+	result := &SliceAnalyzerResult{
+		Onsets:     []float64{0, 0.2, 0.5},
+		Samples:    []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		SampleRate: 10,
+	}
+	samples, err := Rearrange(result, pattern)
+	if err != nil {
+		t.Fatalf("Rearrange failed: %v", err)
+	}
+	if want := []float64{3, 4, 5, 2, 1, 6, 7, 8, 9, 10, 6, 7, 8, 9, 10}; !slices.Equal(samples, want) {
+		t.Errorf("Expected %v, got %v", want, samples)
+	}
+	if _, err := Rearrange(result, []PatternStep{{Slice: 3}}); err == nil {
+		t.Error("Expected error for a pattern past the last slice, got nil")
+	}
+}
+
+func TestStutter(t *testing.T) {
+	result := &SliceAnalyzerResult{
+		Onsets:     []float64{0, 0.004},
+		Samples:    []float64{1, 1, 1, 1, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5},
+		SampleRate: 1000,
+	}
+
+	samples, err := Stutter(result, 1, StutterOptions{Repeats: 3, Decay: 0.5})
+	if err != nil {
+		t.Fatalf("Stutter failed: %v", err)
+	}
+	if len(samples) != 18 || samples[0] != 0.5 || samples[6] != 0.25 || samples[17] != 0.125 {
+		t.Errorf("Expected three decaying repeats of slice 2, got %v", samples)
+	}
+
+	// Gated to half of 4 ms intervals: two samples played, faded out over
+	// 2 ms, then two silent
+	samples, err = Stutter(result, 0, StutterOptions{Repeats: 2, IntervalMs: 4, Gate: 0.5})
+	if err != nil {
+		t.Fatalf("Stutter failed: %v", err)
+	}
+	if want := []float64{2.0 / 3, 1.0 / 3, 0, 0, 2.0 / 3, 1.0 / 3, 0, 0}; !slices.Equal(samples, want) {
+		t.Errorf("Expected %v, got %v", want, samples)
+	}
+
+	if _, err := Stutter(result, 2, StutterOptions{}); err == nil {
+		t.Error("Expected error for an invalid slice, got nil")
+	}
+	if _, err := Stutter(result, 0, StutterOptions{Gate: 2}); err == nil {
+		t.Error("Expected error for a gate above 1, got nil")
+	}
+}
+
+func TestTimeStretch(t *testing.T) {
+	// A 440 Hz sine keeps its pitch and amplitude when stretched
+	sine := make([]float64, 22050)
+	for i := range sine {
+		sine[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/44100)
+	}
+	for _, factor := range []float64{0.5, 1, 1.5, 2} {
+		stretched, err := TimeStretch(sine, factor)
+		if err != nil {
+			t.Fatalf("TimeStretch failed: %v", err)
+		}
+		if want := int(math.Round(22050 * factor)); len(stretched) != want {
+			t.Fatalf("factor %g: expected %d samples, got %d", factor, want, len(stretched))
+		}
+		middle := stretched[len(stretched)/4 : 3*len(stretched)/4]
+		if freq := zeroCrossingFrequency(middle, 44100); math.Abs(freq-440) > 5 {
+			t.Errorf("factor %g: expected 440 Hz, got %.1f Hz", factor, freq)
+		}
+		if rms := rootMeanSquare(middle); math.Abs(rms-0.5/math.Sqrt2) > 0.02 {
+			t.Errorf("factor %g: expected an RMS of %.3f, got %.3f", factor, 0.5/math.Sqrt2, rms)
+		}
+	}
+	if _, err := TimeStretch(sine, 0); err == nil {
+		t.Error("Expected error for a zero factor, got nil")
+	}
+}
+
+// zeroCrossingFrequency estimates the frequency of a sine from its zero
+// crossings
+func zeroCrossingFrequency(samples []float64, sampleRate uint) float64 {
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			crossings++
+		}
+	}
+	return float64(crossings) / 2 / (float64(len(samples)) / float64(sampleRate))
+}
+
+func TestPitchShift(t *testing.T) {
+	sine := make([]float64, 22050)
+	for i := range sine {
+		sine[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/44100)
+	}
+	for _, c := range []struct {
+		semitones, freq float64
+	}{
+		{12, 880},
+		{-12, 220},
+		{7, 440 * math.Pow(2, 7.0/12)},
+		{0, 440},
+	} {
+		shifted, err := PitchShift(sine, c.semitones)
+		if err != nil {
+			t.Fatalf("PitchShift failed: %v", err)
+		}
+		if len(shifted) != len(sine) {
+			t.Fatalf("%g semitones: expected %d samples, got %d", c.semitones, len(sine), len(shifted))
+		}
+		middle := shifted[len(shifted)/4 : 3*len(shifted)/4]
+		if freq := zeroCrossingFrequency(middle, 44100); math.Abs(freq-c.freq) > c.freq/100 {
+			t.Errorf("%g semitones: expected %.1f Hz, got %.1f Hz", c.semitones, c.freq, freq)
+		}
+		if rms := rootMeanSquare(middle); math.Abs(rms-0.5/math.Sqrt2) > 0.02 {
+			t.Errorf("%g semitones: expected an RMS of %.3f, got %.3f", c.semitones, 0.5/math.Sqrt2, rms)
+		}
+	}
+	if _, err := PitchShift(sine, math.NaN()); err == nil {
+		t.Error("Expected error for a NaN shift, got nil")
+	}
+}
+
+func TestStretchSlices(t *testing.T) {
+	// Onsets every half second, 120 BPM, stretched to 100 BPM
+	result := &SliceAnalyzerResult{
+		Onsets:     []float64{0, 0.5, 1, 1.5, 2, 2.5},
+		Samples:    synthBursts(44100, []float64{0, 0.5, 1, 1.5, 2, 2.5}, 3),
+		SampleRate: 44100,
+	}
+	stretched, err := StretchSlices(result, 100)
+	if err != nil {
+		t.Fatalf("StretchSlices failed: %v", err)
+	}
+	factor := EstimateTempo(result.Onsets, TempoOptions{}).BPM / 100
+	ranges := result.SliceRanges()
+	if len(stretched) != len(ranges) {
+		t.Fatalf("Expected %d slices, got %d", len(ranges), len(stretched))
+	}
+	for i, r := range ranges {
+		if want := int(math.Round(float64(r.End-r.Start) * factor)); len(stretched[i]) != want {
+			t.Errorf("Slice %d: expected %d samples, got %d", i+1, want, len(stretched[i]))
+		}
+	}
+
+	whole, err := StretchFile(result, 100)
+	if err != nil {
+		t.Fatalf("StretchFile failed: %v", err)
+	}
+	if want := int(math.Round(float64(len(result.Samples)) * factor)); len(whole) != want {
+		t.Errorf("Expected %d samples, got %d", want, len(whole))
+	}
+
+	if _, err := StretchSlices(result, 0); err == nil {
+		t.Error("Expected error for a zero target tempo, got nil")
+	}
+	if _, err := StretchSlices(&SliceAnalyzerResult{Onsets: []float64{0}, Samples: []float64{1}, SampleRate: 44100}, 100); err == nil {
+		t.Error("Expected error without a tempo, got nil")
+	}
+}
+
+func TestSplitTransients(t *testing.T) {
+	// Onsets at 10 and 30 ms of constant samples at 1 kHz, 5 ms attacks
+	// with 2 ms fades
+	samples := make([]float64, 40)
+	for i := range samples {
+		samples[i] = 0.5
+	}
+	result := &SliceAnalyzerResult{Onsets: []float64{0.01, 0.03}, Samples: samples, SampleRate: 1000}
+	transients, sustain, err := SplitTransients(result, TransientOptions{AttackMs: 5, FadeMs: 2})
+	if err != nil {
+		t.Fatalf("SplitTransients failed: %v", err)
+	}
+	want := make([]float64, 40)
+	for _, start := range []int{10, 30} {
+		want[start-2], want[start-1] = 0, 0.25
+		for i := start; i < start+5; i++ {
+			want[i] = 0.5
+		}
+		want[start+5], want[start+6] = 0.5, 0.25
+	}
+	if !slices.Equal(transients, want) {
+		t.Errorf("Expected transients %v, got %v", want, transients)
+	}
+	for i := range samples {
+		if math.Abs(transients[i]+sustain[i]-samples[i]) > 1e-12 {
+			t.Fatalf("Sample %d: expected the parts to sum to %f, got %f", i, samples[i], transients[i]+sustain[i])
+		}
+	}
+
+	if _, _, err := SplitTransients(result, TransientOptions{AttackMs: -1}); err == nil {
+		t.Error("Expected error for a negative attack, got nil")
+	}
+}
+
+func TestSplitOnSilence(t *testing.T) {
+	// Tones at 0.1-0.5 s, 0.6-0.8 s and 1.5-1.52 s at 1 kHz, with a short
+	// gap, a long gap and a click too short to be a segment
+	samples := make([]float64, 2000)
+	for _, tone := range [][2]int{{100, 500}, {600, 800}, {1500, 1520}} {
+		for i := tone[0]; i < tone[1]; i++ {
+			samples[i] = 0.5 * math.Sin(float64(i))
+		}
+	}
+
+	segments, err := SplitOnSilence(samples, 1000, SilenceOptions{})
+	if err != nil {
+		t.Fatalf("SplitOnSilence failed: %v", err)
+	}
+	if want := []SliceRange{{100, 800}}; !slices.Equal(segments, want) {
+		t.Errorf("Expected %v, got %v", want, segments)
+	}
+
+	segments, err = SplitOnSilence(samples, 1000, SilenceOptions{MinSilenceMs: 50, MinSegmentMs: 10})
+	if err != nil {
+		t.Fatalf("SplitOnSilence failed: %v", err)
+	}
+	if want := []SliceRange{{100, 500}, {600, 800}, {1500, 1520}}; !slices.Equal(segments, want) {
+		t.Errorf("Expected %v, got %v", want, segments)
+	}
+
+	if _, err := SplitOnSilence(samples, 1000, SilenceOptions{ThresholdDB: 6}); err == nil {
+		t.Error("Expected error for a positive threshold, got nil")
+	}
+}
+
+func TestTrimSilence(t *testing.T) {
+	samples := []float64{0, 0.001, 0, 0.5, -0.2, 0, 0.3, 0.002, 0, 0}
+	trimmed, leading, trailing := TrimSilence(samples, 1000, 0, 0)
+	if want := []float64{0.5, -0.2, 0, 0.3}; !slices.Equal(trimmed, want) || leading != 3 || trailing != 3 {
+		t.Errorf("Expected %v with 3 and 3 samples removed, got %v with %d and %d", want, trimmed, leading, trailing)
+	}
+
+	// 2 ms of padding at 1 kHz, clipped at the start
+	trimmed, leading, trailing = TrimSilence(samples, 1000, -20, 2)
+	if want := samples[1:9]; !slices.Equal(trimmed, want) || leading != 1 || trailing != 1 {
+		t.Errorf("Expected %v with 1 and 1 samples removed, got %v with %d and %d", want, trimmed, leading, trailing)
+	}
+
+	trimmed, leading, trailing = TrimSilence([]float64{0, 0.001}, 1000, 0, 0)
+	if len(trimmed) != 0 || leading != 2 || trailing != 0 {
+		t.Errorf("Expected all samples removed as leading, got %v with %d and %d", trimmed, leading, trailing)
+	}
+}
+
+func TestLoudness(t *testing.T) {
+	// The K-weighting at 48 kHz matches the coefficients of BS.1770
+	want := [][]float64{
+		{1.53512485958697, -2.69169618940638, 1.19839281085285, -1.69065929318241, 0.73248077421585},
+		{1, -2, 1, -1.99004745483398, 0.99007225036621},
+	}
+	for i, stage := range newKWeighting(48000).Stages() {
+		f := stage.(*Filter)
+		got := []float64{f.B[0], f.B[1], f.B[2], f.A[1], f.A[2]}
+		for j := range got {
+			if math.Abs(got[j]-want[i][j]) > 1e-6 {
+				t.Errorf("Stage %d: expected coefficients %v, got %v", i+1, want[i], got)
+				break
+			}
+		}
+	}
+
+	// A full-scale 997 Hz sine measures -3.01 LUFS, and 20 dB lower -23.01
+	sine := make([]float64, 5*48000)
+	for i := range sine {
+		sine[i] = math.Sin(2 * math.Pi * 997 * float64(i) / 48000)
+	}
+	if got := IntegratedLoudness(sine, 48000); math.Abs(got+3.01) > 0.05 {
+		t.Errorf("Expected -3.01 LUFS, got %.2f", got)
+	}
+	for i := range sine {
+		sine[i] *= 0.1
+	}
+	if got := IntegratedLoudness(sine, 48000); math.Abs(got+23.01) > 0.05 {
+		t.Errorf("Expected -23.01 LUFS, got %.2f", got)
+	}
+	shortTerm := ShortTermLoudness(sine, 48000)
+	if len(shortTerm) != 21 || math.Abs(shortTerm[10]+23.01) > 0.05 {
+		t.Errorf("Expected 21 short-term values of -23.01 LUFS, got %v", shortTerm)
+	}
+
+	// The relative gate ignores the quiet half, which would lower the
+	// loudness by 3 LU; silence is at the floor
+	quiet := slices.Clone(sine)
+	for i := len(quiet) / 2; i < len(quiet); i++ {
+		quiet[i] *= 0.01
+	}
+	if got := IntegratedLoudness(quiet, 48000); math.Abs(got+23.01) > 0.5 {
+		t.Errorf("Expected the quiet half to be gated out, got %.2f LUFS", got)
+	}
+	if got := IntegratedLoudness(make([]float64, 48000), 48000); got != -70 {
+		t.Errorf("Expected -70 LUFS for silence, got %.2f", got)
+	}
+
+	// Results report the loudness of the file and of every slice
+	options := DefaultSliceAnalyzerOptions()
+	options.MeasureLoudness = true
+	result, err := AnalyzeSamples(synthBursts(44100, []float64{0.25, 0.75}, 1.25), 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.SliceLoudness) != len(result.Onsets) || result.Loudness <= -70 {
+		t.Errorf("Expected the loudness of the file and %d slices, got %.2f and %v", len(result.Onsets), result.Loudness, result.SliceLoudness)
+	}
+	if len(result.SliceTruePeaks) != len(result.Onsets) || result.TruePeak <= 0 {
+		t.Errorf("Expected the true peak of the file and %d slices, got %f and %v", len(result.Onsets), result.TruePeak, result.SliceTruePeaks)
+	}
+}
+
+func TestTruePeak(t *testing.T) {
+	// A quarter-rate sine sampled 45 degrees off its peaks: every sample is
+	// at 0.707, the waveform between them at 1, overshooting slightly where
+	// the sine starts and stops
+	samples := make([]float64, 4800)
+	for i := range samples {
+		samples[i] = math.Sin(math.Pi/2*float64(i) + math.Pi/4)
+	}
+	if got := TruePeak(samples, 48000); math.Abs(got-1) > 0.02 {
+		t.Errorf("Expected a true peak of 1, got %f", got)
+	}
+	if got := TruePeak(make([]float64, 100), 48000); got != 0 {
+		t.Errorf("Expected a true peak of 0 for silence, got %f", got)
+	}
+
+	// Normalized slices reach the target true peak
+	result := &SliceAnalyzerResult{Onsets: []float64{0}, Samples: samples, SampleRate: 48000}
+	options := DefaultExportOptions()
+	options.BitDepth = 32
+	options.NormalizeTruePeakDB = -1
+	paths, err := ExportSlices(result, t.TempDir(), "sine", options)
+	if err != nil {
+		t.Fatalf("ExportSlices failed: %v", err)
+	}
+	channels, _, err := readAudioFile(paths[0])
+	if err != nil {
+		t.Fatalf("Failed to read slice: %v", err)
+	}
+	if got := 20 * math.Log10(TruePeak(channels[0], 48000)); math.Abs(got+1) > 0.05 {
+		t.Errorf("Expected a true peak of -1 dBTP, got %.2f", got)
+	}
+
+	options.NormalizeTruePeakDB = 1
+	if _, err := ExportSlices(result, t.TempDir(), "sine", options); err == nil {
+		t.Error("Expected error for a positive normalization level, got nil")
+	}
+}
+
+func TestRenderSlice(t *testing.T) {
+	samples := []float64{0.25, -0.5, 0.25, 0.5, 0.25, 0.25}
+	rendered := renderSlice(samples, 1000, ExportOptions{NormalizePeakDB: -6.020599913279624, FadeInSamples: 2, FadeOutMs: 3})
+	want := []float64{0, -0.25, 0.25, 0.5 * 2 / 3, 0.25 / 3, 0}
+	for i := range want {
+		if math.Abs(rendered[i]-want[i]) > 1e-9 {
+			t.Fatalf("Expected %v, got %v", want, rendered)
+		}
+	}
+	if samples[0] != 0.25 {
+		t.Error("Expected the samples to be copied, not changed")
+	}
+
+	// Loudness normalization is capped by the peak level
+	sine := make([]float64, 48000)
+	for i := range sine {
+		sine[i] = 0.01 * math.Sin(2*math.Pi*997*float64(i)/48000)
+	}
+	if got := IntegratedLoudness(renderSlice(sine, 48000, ExportOptions{NormalizeLUFS: -20}), 48000); math.Abs(got+20) > 0.05 {
+		t.Errorf("Expected -20 LUFS, got %.2f", got)
+	}
+	capped := renderSlice(sine, 48000, ExportOptions{NormalizeLUFS: -1, NormalizePeakDB: -6})
+	if peak := slices.Max(capped); math.Abs(20*math.Log10(peak)+6) > 0.01 {
+		t.Errorf("Expected the peak capped at -6 dBFS, got %.2f", 20*math.Log10(peak))
+	}
+
+	if err := checkRenderOptions(ExportOptions{NormalizeLUFS: 3}); err == nil {
+		t.Error("Expected error for a positive loudness target, got nil")
+	}
+}
+
+func TestExportCrossfade(t *testing.T) {
+	sampleRate := uint(1000)
+	samples := make([]float64, 100)
+	for i := range samples {
+		samples[i] = 0.5 * math.Sin(float64(i)/3)
+	}
+	result := &SliceAnalyzerResult{Onsets: []float64{0, 0.03, 0.07}, Samples: samples, SampleRate: sampleRate}
+
+	options := DefaultExportOptions()
+	options.BitDepth = 32
+	options.CrossfadeMs = 4
+	paths, err := ExportSlices(result, t.TempDir(), "cf", options)
+	if err != nil {
+		t.Fatalf("ExportSlices failed: %v", err)
+	}
+
+	// The slices are extended by the crossfade, except the last, and sum
+	// back to the samples when overlapped by it, apart from the fade-in of
+	// the first and the fade-out of the last
+	mixed := make([]float64, len(samples))
+	ranges := result.SliceRanges()
+	for i, path := range paths {
+		channels, _, err := readAudioFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read slice: %v", err)
+		}
+		want := min(ranges[i].End+4, len(samples)) - ranges[i].Start
+		if len(channels[0]) != want {
+			t.Fatalf("Slice %d: expected %d samples, got %d", i+1, want, len(channels[0]))
+		}
+		for j, v := range channels[0] {
+			mixed[ranges[i].Start+j] += v
+		}
+	}
+	for i := 4; i < len(samples)-4; i++ {
+		if math.Abs(mixed[i]-samples[i]) > 1e-6 {
+			t.Fatalf("Sample %d: expected %f, got %f", i, samples[i], mixed[i])
+		}
+	}
+
+	options.CrossfadeMs = -1
+	if _, err := ExportSlices(result, t.TempDir(), "cf", options); err == nil {
+		t.Error("Expected error for a negative crossfade, got nil")
+	}
+}
+
+func TestSliceDurationConstraints(t *testing.T) {
+	onsets := []float64{0.1, 0.12, 0.5, 0.55, 1.0, 2.95}
+	options := SliceAnalyzerOptions{MinSliceMs: 100}
+	// 0.1-0.12 merges into the next slice, 0.5-0.55 into the previous, and
+	// the last slice, 2.95-3.0, into its previous
+	if got, want := constrainSliceDurations(onsets, 3, options), []float64{0.1, 0.55, 1.0}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// The 2 s last slice is split into three
+	options.MaxSliceMs = 800
+	want := []float64{0.1, 0.55, 1.0, 1.0 + 2.0/3, 1.0 + 4.0/3}
+	got := constrainSliceDurations(onsets, 3, options)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+
+	// Through the analysis, with a maximum below twice the minimum rejected
+	analysis := DefaultSliceAnalyzerOptions()
+	analysis.MaxSliceMs = 200
+	result, err := AnalyzeSamples(synthBursts(44100, []float64{0.25}, 1.25), 44100, analysis)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) != 5 {
+		t.Errorf("Expected the 1 s slice subdivided into 5 parts, got %v", result.Onsets)
+	}
+	// The subdivision applies after the NumSlices selection, and can give
+	// more slices than requested so that none is longer than the maximum
+	analysis.NumSlices = 1
+	result, err = AnalyzeSamples(synthBursts(44100, []float64{0.25, 0.75}, 1.25), 44100, analysis)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) <= analysis.NumSlices {
+		t.Errorf("Expected the kept slice subdivided, got %v", result.Onsets)
+	}
+	for i, r := range result.SliceRanges() {
+		if r.End-r.Start > 44100/5+1 {
+			t.Errorf("Slice %d: expected at most 200 ms, got %d samples", i, r.End-r.Start)
+		}
+	}
+
+	analysis.NumSlices = 0
+	analysis.MinSliceMs = 150
+	if _, err := AnalyzeSamples(synthBursts(44100, []float64{0.25}, 1.25), 44100, analysis); err == nil {
+		t.Error("Expected error for a maximum below twice the minimum, got nil")
+	}
+}
+
+func TestStrengthSelection(t *testing.T) {
+	// The kept onsets are the candidates with the highest detection
+	// function values
+	options := DefaultSliceAnalyzerOptions()
+	options.Optimize = false
+	options.UseMinimumSpacing = false
+	options.NumSlices = 6
+	options.Selection = SelectionStrength
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	d := detectChunked(result.Samples, result.SampleRate, "hfc", nil, options, false, nil)
+	strengths := slices.Clone(d.strengths)
+	slices.Sort(strengths)
+	weakest := strengths[len(strengths)-options.NumSlices]
+	if len(result.Onsets) != options.NumSlices {
+		t.Fatalf("Expected %d onsets, got %v", options.NumSlices, result.Onsets)
+	}
+	for _, onsetTime := range result.Onsets {
+		i := slices.Index(d.onsets, onsetTime)
+		if i < 0 || d.strengths[i] < weakest {
+			t.Errorf("Onset at %.3f s is not among the %d strongest candidates", onsetTime, options.NumSlices)
+		}
+	}
+
+	// Streaming ranks the same candidates without reading the file again
+	options.Streaming = true
+	streamed, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if !slices.Equal(streamed.Onsets, result.Onsets) {
+		t.Errorf("Expected streamed onsets %v, got %v", result.Onsets, streamed.Onsets)
+	}
+
+	options.Streaming = false
+	options.Method = "consensus"
+	if _, err := AnalyzeSamples(result.Samples, result.SampleRate, options); err == nil {
+		t.Error("Expected error for strength selection with the consensus method, got nil")
+	}
+	options.Method = "hfc"
+	options.Selection = "loudest"
+	if _, err := AnalyzeSamples(result.Samples, result.SampleRate, options); err == nil {
+		t.Error("Expected error for an unknown selection, got nil")
+	}
+}
+
+func TestStrengthAtPeak(t *testing.T) {
+	// A loud burst decaying within a few hops and a quiet sustained one. The
+	// loud burst is the stronger onset, although its detection function has
+	// decayed below the quiet one's by the time the peak picker reports it.
+	samples := make([]float64, 44100)
+	seed := uint32(1)
+	for _, b := range []struct{ start, amplitude, decay float64 }{{0.25, 1, 300}, {0.75, 0.3, 4000}} {
+		offset := int(b.start * 44100)
+		for i := range 11025 {
+			seed = seed*1664525 + 1013904223
+			noise := float64(seed)/float64(1<<32)*2 - 1
+			samples[offset+i] = b.amplitude * noise * math.Exp(-float64(i)/b.decay)
+		}
+	}
+
+	options := DefaultSliceAnalyzerOptions()
+	options.Method = "hfc"
+	options.Optimize = false
+	options.UseMinimumSpacing = false
+
+	// The strengths are the peaks of the detection function before the onsets
+	d := newHopDetector("hfc", 512, 256, 44100, options, true)
+	d.feed(samples, nil)
+	if len(d.onsets) != 2 {
+		t.Fatalf("Expected 2 onsets, got %v", d.onsets)
+	}
+	i := 0
+	for k, frame := range d.curve {
+		if !frame.Onset {
+			continue
+		}
+		peak := 0.0
+		for _, f := range d.curve[max(k-3, 0) : k+1] {
+			peak = max(peak, f.Descriptor)
+		}
+		if d.strengths[i] != peak {
+			t.Errorf("Onset at %.3f s: expected the strength of the peak %g, got %g", d.onsets[i], peak, d.strengths[i])
+		}
+		i++
+	}
+	if d.strengths[0] <= d.strengths[1] {
+		t.Errorf("Expected the loud burst to be stronger, got strengths %v", d.strengths)
+	}
+
+	// Strength selection keeps the loud burst
+	options.NumSlices = 1
+	options.Selection = SelectionStrength
+	result, err := AnalyzeSamples(samples, 44100, options)
+	if err != nil {
+		t.Fatalf("AnalyzeSamples failed: %v", err)
+	}
+	if len(result.Onsets) != 1 || math.Abs(result.Onsets[0]-0.25) > 0.02 {
+		t.Errorf("Expected the onset of the loud burst at 0.25 s, got %v", result.Onsets)
+	}
+}
+
+func TestConsensusVotes(t *testing.T) {
+	options := DefaultSliceAnalyzerOptions()
+	options.Method = "consensus"
+	options.NumSlices = 12
+	result, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if len(result.Votes) != len(result.Onsets) {
+		t.Fatalf("Expected votes for %d onsets, got %d", len(result.Onsets), len(result.Votes))
+	}
+	unanimous := 0
+	for i, votes := range result.Votes {
+		if len(votes) < options.MinConsensusClusterSize {
+			t.Errorf("Onset at %.3f s has %d votes, below the cluster size", result.Onsets[i], len(votes))
+		}
+		methods := map[string]bool{}
+		for _, vote := range votes {
+			if !slices.Contains(consensusMethods, vote.Method) {
+				t.Errorf("Unexpected method %q", vote.Method)
+			}
+			// Votes lie near the onset they were attributed to
+			if math.Abs(vote.Time-result.Onsets[i]) > 0.2 {
+				t.Errorf("Vote of %s at %.3f s is far from the onset at %.3f s", vote.Method, vote.Time, result.Onsets[i])
+			}
+			methods[vote.Method] = true
+		}
+		if len(methods) == len(consensusMethods) {
+			unanimous++
+		}
+	}
+	if unanimous == 0 {
+		t.Error("Expected unanimous onsets among the strongest hits")
+	}
+
+	// Streaming attributes the same votes
+	options.Streaming = true
+	streamed, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if !slices.Equal(streamed.Onsets, result.Onsets) || len(streamed.Votes) != len(result.Votes) {
+		t.Fatalf("Expected streamed onsets %v, got %v", result.Onsets, streamed.Onsets)
+	}
+	for i := range result.Votes {
+		if !slices.Equal(streamed.Votes[i], result.Votes[i]) {
+			t.Errorf("Expected streamed votes %v, got %v", result.Votes[i], streamed.Votes[i])
+		}
+	}
+
+	// Subdivisions have no votes, and single methods report none
+	options.Streaming = false
+	options.MaxSliceMs = 200
+	subdivided, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	withVotes := 0
+	for _, votes := range subdivided.Votes {
+		if len(votes) > 0 {
+			withVotes++
+		}
+	}
+	if len(subdivided.Onsets) <= len(result.Onsets) || withVotes != len(result.Onsets) {
+		t.Errorf("Expected %d of %d subdivided onsets with votes, got %d", len(result.Onsets), len(subdivided.Onsets), withVotes)
+	}
+	options.Method = "hfc"
+	single, err := AnalyzeSlices("amen.wav", options)
+	if err != nil {
+		t.Fatalf("AnalyzeSlices failed: %v", err)
+	}
+	if single.Votes != nil {
+		t.Errorf("Expected no votes for a single method, got %v", single.Votes)
+	}
+
+	// Votes follow the indices of the markers: the subdivision at 0.06 s
+	// lands on the time of the marker removed by the minimum spacing, but
+	// is not attributed its votes
+	markers := []float64{0, 0.06, 0.12}
+	markerVotes := [][]ConsensusVote{{{"hfc", 0}}, {{"hfc", 0.06}}, {{"hfc", 0.12}}}
+	spacing := SliceAnalyzerOptions{UseMinimumSpacing: true, MinimumSpacing: 80, MaxSliceMs: 100}
+	spaced, origins := spaceOnsets(markers, 0.2, spacing)
+	if !slices.Equal(spaced, markers) || !slices.Equal(origins, []int{0, -1, 2}) {
+		t.Fatalf("Expected onsets %v from markers 0, none and 2, got %v from %v", markers, spaced, origins)
+	}
+	attributed := attributeVotes(markerVotes, origins)
+	if len(attributed[1]) != 0 || attributed[2][0].Time != 0.12 {
+		t.Errorf("Expected no votes for the subdivision and the votes of marker 2 next, got %v", attributed)
+	}
+}
+
+func TestAnalyzeStructure(t *testing.T) {
+	// Sections of 20 s: a low chord, bright noise, then the chord again
+	sampleRate := uint(22050)
+	samples := make([]float64, 60*int(sampleRate))
+	seed := uint32(1)
+	for i := range samples {
+		tm := float64(i) / float64(sampleRate)
+		seed = seed*1664525 + 1013904223
+		noise := float64(seed)/float64(1<<32)*2 - 1
+		if tm >= 20 && tm < 40 {
+			samples[i] = 0.3*noise + 0.2*math.Sin(2*math.Pi*3520*tm)
+		} else {
+			samples[i] = 0.3*math.Sin(2*math.Pi*220*tm) + 0.2*math.Sin(2*math.Pi*330*tm) + 0.01*noise
+		}
+	}
+
+	s, err := AnalyzeStructure(samples, sampleRate, StructureOptions{KeepMatrix: true})
+	if err != nil {
+		t.Fatalf("AnalyzeStructure failed: %v", err)
+	}
+	if len(s.Boundaries) != 3 || s.Boundaries[0] != 0 || math.Abs(s.Boundaries[1]-20) > s.FrameSec || math.Abs(s.Boundaries[2]-40) > s.FrameSec {
+		t.Errorf("Expected boundaries at 0, 20 and 40 s, got %v", s.Boundaries)
+	}
+	n := len(s.Novelty)
+	if len(s.Matrix) != n || n < 110 {
+		t.Fatalf("Expected a matrix of %d frames, got %d", n, len(s.Matrix))
+	}
+	// The repeated sections are alike, and unlike the middle one
+	early, middle, late := int(10/s.FrameSec), int(30/s.FrameSec), int(50/s.FrameSec)
+	if s.Matrix[early][late] < 0.9 || s.Matrix[early][middle] > 0 {
+		t.Errorf("Expected similar outer sections, got similarities %.2f and %.2f", s.Matrix[early][late], s.Matrix[early][middle])
+	}
+
+	if _, err := AnalyzeStructure(samples, sampleRate, StructureOptions{Threshold: 2}); err == nil {
+		t.Error("Expected error for a threshold above 1, got nil")
+	}
+	if _, err := AnalyzeStructure(samples, sampleRate, StructureOptions{Features: "tempogram"}); err == nil {
+		t.Error("Expected error for unknown features, got nil")
+	}
+
+	for _, features := range []string{StructureFeaturesMFCC, StructureFeaturesChroma} {
+		s, err := AnalyzeStructure(samples, sampleRate, StructureOptions{Features: features})
+		if err != nil {
+			t.Fatalf("AnalyzeStructure with %s features failed: %v", features, err)
+		}
+		if len(s.Boundaries) != 3 || math.Abs(s.Boundaries[1]-20) > s.FrameSec || math.Abs(s.Boundaries[2]-40) > s.FrameSec {
+			t.Errorf("Expected boundaries at 0, 20 and 40 s with %s features, got %v", features, s.Boundaries)
+		}
+	}
+
+	// Sections of a file match those of its samples
+	path := filepath.Join(t.TempDir(), "sections.wav")
+	if err := WriteWav(path, samples, sampleRate, 16); err != nil {
+		t.Fatalf("WriteWav failed: %v", err)
+	}
+	fractions := 0
+	sections, err := DetectSections(path, SectionOptions{
+		StructureOptions: StructureOptions{Features: StructureFeaturesMFCC},
+		Progress:         func(float64) { fractions++ },
+	})
+	if err != nil {
+		t.Fatalf("DetectSections failed: %v", err)
+	}
+	if len(sections.Boundaries) != 3 || math.Abs(sections.Boundaries[1]-20) > sections.FrameSec || math.Abs(sections.Boundaries[2]-40) > sections.FrameSec {
+		t.Errorf("Expected sections at 0, 20 and 40 s, got %v", sections.Boundaries)
+	}
+	if len(sections.Novelty) != n || fractions == 0 {
+		t.Errorf("Expected %d frames with progress, got %d frames and %d reports", n, len(sections.Novelty), fractions)
+	}
+	if _, err := DetectSections(path, SectionOptions{Channel: ChannelPerChannel}); err == nil {
+		t.Error("Expected error for the per-channel mode, got nil")
+	}
+}
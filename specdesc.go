@@ -18,6 +18,22 @@ const (
 	OnsetKL
 	OnsetMKL
 	OnsetSpecflux
+	// OnsetCustom is a detection function registered with RegisterSpecdesc
+	OnsetCustom
+	// Statistical descriptors of the shape of the magnitude spectrum, as in
+	// aubio, for describing frames rather than detecting onsets; see
+	// SpectralShape. Frequencies are in bins.
+	SpecCentroid
+	SpecSpread
+	SpecSkewness
+	SpecKurtosis
+	SpecSlope
+	SpecDecrease
+	SpecRolloff
+	// Descriptors of the tonality of the spectrum: the spectral flatness and
+	// crest factor, see SpectralShape
+	SpecFlatness
+	SpecCrest
 )
 
 // Specdesc represents a spectral descriptor for onset detection
@@ -28,9 +44,20 @@ type Specdesc struct {
 	Dev1      *Fvec
 	Theta1    *Fvec
 	Theta2    *Fvec
+
+	// custom is the function of a registered custom descriptor
+	custom SpecdescFunc
+	// state is the per-detector state of the custom descriptor, created by
+	// newState for the FFT size
+	state    any
+	newState func(bufSize uint) any
+	size     uint
 }
 
-// NewSpecdesc creates a new spectral descriptor
+// NewSpecdesc creates a new spectral descriptor computing the detection
+// function of an onset method, or one of the spectral shape modes
+// "centroid", "spread", "skewness", "kurtosis", "slope", "decrease",
+// "rolloff", "flatness" and "crest"
 func NewSpecdesc(onsetMode string, size uint) *Specdesc {
 	rsize := size/2 + 1
 	s := &Specdesc{
@@ -39,36 +66,49 @@ func NewSpecdesc(onsetMode string, size uint) *Specdesc {
 		Dev1:      NewFvec(rsize),
 		Theta1:    NewFvec(rsize),
 		Theta2:    NewFvec(rsize),
+		size:      size,
 	}
 
 	// Determine onset type from mode string
-	mode := strings.ToLower(onsetMode)
-	switch mode {
-	case "energy":
-		s.OnsetType = OnsetEnergy
-	case "specdiff":
-		s.OnsetType = OnsetSpecdiff
-	case "hfc", "default":
-		s.OnsetType = OnsetHFC
-	case "complexdomain", "complex":
-		s.OnsetType = OnsetComplex
-	case "phase":
-		s.OnsetType = OnsetPhase
-	case "wphase":
-		s.OnsetType = OnsetWPhase
-	case "kl":
-		s.OnsetType = OnsetKL
-	case "mkl":
-		s.OnsetType = OnsetMKL
-	case "specflux":
-		s.OnsetType = OnsetSpecflux
-	default:
-		s.OnsetType = OnsetHFC
+	s.OnsetType = OnsetHFC
+	if t, ok := shapeDescriptors[strings.ToLower(onsetMode)]; ok {
+		s.OnsetType = t
+	} else if spec, ok := lookupMethod(onsetMode); ok {
+		s.OnsetType = spec.descriptor
+		if spec.custom != nil {
+			s.custom = spec.custom.fn
+			s.newState = spec.custom.newState
+			if s.newState != nil {
+				s.state = s.newState(size)
+			}
+		}
 	}
 
 	return s
 }
 
+// Reset clears the magnitudes and phases of the previous frames, and recreates
+// the state of a custom descriptor
+func (s *Specdesc) Reset() {
+	s.OldMag.Zeros()
+	s.Dev1.Zeros()
+	s.Theta1.Zeros()
+	s.Theta2.Zeros()
+	if s.newState != nil {
+		s.state = s.newState(s.size)
+	}
+}
+
+// usesPhase reports whether the descriptor reads the phases of the spectrum.
+// Custom descriptors are assumed to.
+func (s *Specdesc) usesPhase() bool {
+	switch s.OnsetType {
+	case OnsetComplex, OnsetPhase, OnsetWPhase, OnsetCustom:
+		return true
+	}
+	return false
+}
+
 // Do computes the spectral descriptor
 func (s *Specdesc) Do(fftgrain *Cvec, onset *Fvec) {
 	switch s.OnsetType {
@@ -90,6 +130,26 @@ func (s *Specdesc) Do(fftgrain *Cvec, onset *Fvec) {
 		s.mkl(fftgrain, onset)
 	case OnsetSpecflux:
 		s.specflux(fftgrain, onset)
+	case OnsetCustom:
+		s.custom(fftgrain, s.state, onset)
+	case SpecCentroid:
+		onset.Data[0], _, _, _ = spectralMoments(fftgrain.Norm[:fftgrain.Length])
+	case SpecSpread:
+		_, onset.Data[0], _, _ = spectralMoments(fftgrain.Norm[:fftgrain.Length])
+	case SpecSkewness:
+		_, _, onset.Data[0], _ = spectralMoments(fftgrain.Norm[:fftgrain.Length])
+	case SpecKurtosis:
+		_, _, _, onset.Data[0] = spectralMoments(fftgrain.Norm[:fftgrain.Length])
+	case SpecSlope:
+		onset.Data[0] = spectralSlope(fftgrain.Norm[:fftgrain.Length])
+	case SpecDecrease:
+		onset.Data[0] = spectralDecrease(fftgrain.Norm[:fftgrain.Length])
+	case SpecRolloff:
+		onset.Data[0] = spectralRolloff(fftgrain.Norm[:fftgrain.Length])
+	case SpecFlatness:
+		onset.Data[0] = spectralFlatness(fftgrain.Norm[:fftgrain.Length])
+	case SpecCrest:
+		onset.Data[0] = spectralCrest(fftgrain.Norm[:fftgrain.Length])
 	default:
 		s.hfc(fftgrain, onset)
 	}
@@ -97,6 +157,10 @@ func (s *Specdesc) Do(fftgrain *Cvec, onset *Fvec) {
 
 // energy computes energy-based onset detection
 func (s *Specdesc) energy(fftgrain *Cvec, onset *Fvec) {
+	if Accelerated() {
+		onset.Data[0] = sumSquares(fftgrain.Norm[:fftgrain.Length])
+		return
+	}
 	onset.Data[0] = 0.0
 	for j := uint(0); j < fftgrain.Length; j++ {
 		onset.Data[0] += fftgrain.Norm[j] * fftgrain.Norm[j]
@@ -105,6 +169,10 @@ func (s *Specdesc) energy(fftgrain *Cvec, onset *Fvec) {
 
 // hfc computes High Frequency Content onset detection
 func (s *Specdesc) hfc(fftgrain *Cvec, onset *Fvec) {
+	if Accelerated() {
+		onset.Data[0] = weightedSum(fftgrain.Norm[:fftgrain.Length])
+		return
+	}
 	onset.Data[0] = 0.0
 	for j := uint(0); j < fftgrain.Length; j++ {
 		onset.Data[0] += float64(j+1) * fftgrain.Norm[j]
@@ -204,6 +272,10 @@ func (s *Specdesc) mkl(fftgrain *Cvec, onset *Fvec) {
 
 // specflux computes Spectral Flux onset detection
 func (s *Specdesc) specflux(fftgrain *Cvec, onset *Fvec) {
+	if Accelerated() {
+		onset.Data[0] = positiveFlux(fftgrain.Norm[:fftgrain.Length], s.OldMag.Data)
+		return
+	}
 	onset.Data[0] = 0.0
 	for j := uint(0); j < fftgrain.Length; j++ {
 		if fftgrain.Norm[j] > s.OldMag.Data[j] {
@@ -0,0 +1,318 @@
+package onset
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// analyzeFileStreaming analyzes an audio file without loading it into memory.
+// Detection runs on the decoded blocks as they arrive; energy ranking and
+// position optimization read the file again and only keep the samples of the
+// windows around each onset. Progress is split evenly between the passes.
+func analyzeFileStreaming(filename string, options SliceAnalyzerOptions, p *progress) (*SliceAnalyzerResult, error) {
+	if options.AnalyzeRate > 0 {
+		return nil, fmt.Errorf("streaming analysis does not support AnalyzeRate")
+	}
+	if strings.EqualFold(options.Channel, ChannelPerChannel) {
+		return nil, fmt.Errorf("streaming analysis does not support the %q channel mode", ChannelPerChannel)
+	}
+
+	method := analysisMethod(options)
+	methods := []string{method}
+	if method == "consensus" {
+		methods = append(consensusMethods, customMethods()...)
+	}
+
+	// Ranking by strength uses the detection results, without a pass
+	rankEnergy := options.NumSlices > 0 && options.Selection != SelectionStrength
+	passes := 1
+	if rankEnergy {
+		passes++
+	}
+	if options.Optimize {
+		passes++
+	}
+	if options.Backtrack {
+		passes++
+	}
+	pass := 0
+	nextPass := func() *progress {
+		pass++
+		return p.sub(float64(pass-1)/float64(passes), float64(pass)/float64(passes))
+	}
+
+	s, err := openAudioFile(filename, options.DecoderFallback)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	sampleRate := s.SampleRate()
+	if sampleRate == 0 {
+		s.Close()
+		return nil, fmt.Errorf("invalid sample rate: %d", sampleRate)
+	}
+	if err := checkDetectionOptions(options, sampleRate); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	// Detect candidate onsets with every method in a single pass
+	detectors := make([]*hopDetector, len(methods))
+	for i, m := range methods {
+		detectors[i] = newHopDetector(m, 512, 256, sampleRate, options, method != "consensus")
+	}
+	preview := previewBuilder{decimation: options.PreviewDecimation}
+	numSamples := 0
+
+	err = streamChannel(s, options.Channel, nextPass(), func(block []float64) {
+		for _, d := range detectors {
+			d.write(block)
+		}
+		preview.write(block)
+		numSamples += len(block)
+	})
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+
+	var onsets []float64
+	var detection []DetectionFrame
+	var markerVotes [][]ConsensusVote
+	if method == "consensus" {
+		var allVotes []ConsensusVote
+		for i, d := range detectors {
+			for _, onsetTime := range d.onsets {
+				allVotes = append(allVotes, ConsensusVote{Method: methods[i], Time: onsetTime})
+			}
+		}
+		onsets, markerVotes = clusterConsensusOnsets(allVotes, options.MinConsensusClusterSize)
+	} else {
+		onsets, detection = detectors[0].onsets, detectors[0].curve
+		if options.NumSlices > 0 && !rankEnergy {
+			onsets = pickBestOnsets(onsets, detectors[0].strengths, options.NumSlices)
+		}
+	}
+
+	// Keep the best N onsets based on energy. Consensus markers are only ranked
+	// when there are more of them than requested.
+	energyProgress := (*progress)(nil)
+	if rankEnergy {
+		energyProgress = nextPass()
+	}
+	if rankEnergy && (method != "consensus" || len(onsets) > options.NumSlices) {
+		ranges := make([]sampleRange, len(onsets))
+		for i, onsetTime := range onsets {
+			ranges[i].start, ranges[i].end = onsetEnergyRange(sampleRate, onsetTime)
+		}
+		energies := make([]float64, len(onsets))
+		err := collectRanges(filename, options, ranges, energyProgress, func(i int, window []float64) {
+			energies[i] = rootMeanSquare(window)
+		})
+		if err != nil {
+			return nil, err
+		}
+		best, kept := pickBestIndices(onsets, energies, options.NumSlices)
+		if markerVotes != nil {
+			markerVotes = attributeVotes(markerVotes, kept)
+		}
+		onsets = best
+	}
+
+	// Optimize onset positions if requested
+	if options.Optimize && len(onsets) > 0 {
+		ranges := make([]sampleRange, len(onsets))
+		for i, onsetTime := range onsets {
+			ranges[i].start, ranges[i].end = optimizeWindowRange(sampleRate, onsetTime, options.OptimizeWindowMs)
+		}
+		optimized := make([]float64, len(onsets))
+		err := collectRanges(filename, options, ranges, nextPass(), func(i int, window []float64) {
+			optimized[i] = optimalOnsetInWindow(window, ranges[i].start, sampleRate, onsets[i])
+		})
+		if err != nil {
+			return nil, err
+		}
+		onsets = optimized
+	}
+
+	// Move onsets back to the preceding energy minimum if requested
+	if options.Backtrack && len(onsets) > 0 {
+		ranges := make([]sampleRange, len(onsets))
+		for i := range onsets {
+			ranges[i].start, ranges[i].end = backtrackWindowRange(sampleRate, onsets, i)
+		}
+		backtracked := make([]float64, len(onsets))
+		err := collectRanges(filename, options, ranges, nextPass(), func(i int, window []float64) {
+			backtracked[i] = backtrackInWindow(window, ranges[i].start, sampleRate, onsets[i])
+		})
+		if err != nil {
+			return nil, err
+		}
+		onsets = backtracked
+	}
+	// Optimization and backtracking keep one onset per consensus marker, in
+	// order, so that the markers' votes follow their indices through the
+	// minimum spacing and the duration constraints
+	onsets, origins := spaceOnsets(onsets, float64(numSamples)/float64(sampleRate), options)
+
+	var votes [][]ConsensusVote
+	if markerVotes != nil {
+		votes = attributeVotes(markerVotes, origins)
+	}
+
+	result := &SliceAnalyzerResult{
+		Onsets:            onsets,
+		SampleRate:        sampleRate,
+		NumSamples:        numSamples,
+		Preview:           preview.finish(),
+		PreviewDecimation: options.PreviewDecimation,
+		Detection:         detection,
+		Votes:             votes,
+		Options:           options,
+	}
+	if options.MeasureTempo {
+		result.measureTempo(options.BeatsPerBar)
+	}
+	p.report(1)
+	return result, nil
+}
+
+// streamChannel reads the stream until EOF and calls fn with every block of
+// the channel selected by mode, reporting the fraction of the input read to p
+func streamChannel(s audioStream, mode string, p *progress, fn func([]float64)) error {
+	// Validate the channel mode before decoding anything
+	if _, err := selectChannel(make([][]float64, s.NumChannels()), mode); err != nil {
+		return err
+	}
+
+	for {
+		block, err := s.Read()
+		if err == io.EOF {
+			p.report(1)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		samples, err := selectChannel(block, mode)
+		if err != nil {
+			return err
+		}
+		fn(samples)
+		if frac, ok := streamPosition(s); ok {
+			p.report(frac)
+		}
+	}
+}
+
+// sampleRange is a half-open range of sample indices
+type sampleRange struct {
+	start, end int
+}
+
+// collectRanges reads the selected channel of the file and calls fn with the
+// samples of every range, clamped to the end of the file. Only the samples of
+// the ranges overlapping the current read position are held in memory.
+func collectRanges(filename string, options SliceAnalyzerOptions, ranges []sampleRange, p *progress, fn func(i int, window []float64)) error {
+	// Visit the ranges in order of their start
+	order := make([]int, len(ranges))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return ranges[order[a]].start < ranges[order[b]].start
+	})
+
+	type pendingRange struct {
+		index  int
+		window []float64
+	}
+	var active []pendingRange
+	next := 0
+	pos := 0
+
+	s, err := openAudioFile(filename, options.DecoderFallback)
+	if err != nil {
+		return fmt.Errorf("failed to read audio file: %w", err)
+	}
+	err = streamChannel(s, options.Channel, p, func(block []float64) {
+		blockEnd := pos + len(block)
+
+		// Start collecting the ranges beginning in this block
+		for next < len(order) && ranges[order[next]].start < blockEnd {
+			r := ranges[order[next]]
+			active = append(active, pendingRange{index: order[next], window: make([]float64, 0, max(r.end-r.start, 0))})
+			next++
+		}
+
+		kept := active[:0]
+		for _, a := range active {
+			r := ranges[a.index]
+			lo, hi := max(r.start, pos), min(r.end, blockEnd)
+			if lo < hi {
+				a.window = append(a.window, block[lo-pos:hi-pos]...)
+			}
+			if r.end <= blockEnd {
+				fn(a.index, a.window)
+			} else {
+				kept = append(kept, a)
+			}
+		}
+		active = kept
+		pos = blockEnd
+	})
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read audio file: %w", err)
+	}
+
+	// Ranges reaching past the end of the file are clamped to it
+	for _, a := range active {
+		fn(a.index, a.window)
+	}
+	for ; next < len(order); next++ {
+		fn(order[next], nil)
+	}
+
+	return nil
+}
+
+// previewBuilder decimates a signal to one peak per block of samples
+type previewBuilder struct {
+	decimation int
+	peak       float64
+	count      int
+	points     []float64
+}
+
+// write adds samples to the preview
+func (p *previewBuilder) write(samples []float64) {
+	if p.decimation <= 0 {
+		return
+	}
+	for _, v := range samples {
+		if math.Abs(v) > math.Abs(p.peak) {
+			p.peak = v
+		}
+		p.count++
+		if p.count == p.decimation {
+			p.points = append(p.points, p.peak)
+			p.peak, p.count = 0, 0
+		}
+	}
+}
+
+// finish flushes the last partial block and returns the preview points
+func (p *previewBuilder) finish() []float64 {
+	if p.count > 0 {
+		p.points = append(p.points, p.peak)
+		p.peak, p.count = 0, 0
+	}
+	return p.points
+}
@@ -0,0 +1,180 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// stretchWinSize and stretchHopSize are the window and synthesis hop sizes
+// of the phase vocoder of TimeStretch
+const (
+	stretchWinSize = 2048
+	stretchHopSize = 512
+)
+
+// TimeStretch returns samples played factor times longer without changing
+// the pitch, factor 2 halving the tempo. A phase vocoder analyzes frames
+// every stretchHopSize/factor samples and resynthesizes them every
+// stretchHopSize samples, advancing the phases by the measured frequencies
+// of the spectral peaks, see lockPhases. Transients are softened, as with
+// any phase vocoder.
+func TimeStretch(samples []float64, factor float64) ([]float64, error) {
+	if !(factor > 0) || math.IsInf(factor, 0) {
+		return nil, fmt.Errorf("invalid stretch factor %g", factor)
+	}
+	length := int(math.Round(float64(len(samples)) * factor))
+	if len(samples) == 0 || length == 0 {
+		return []float64{}, nil
+	}
+
+	// Pad a window of silence on both sides, so that the edges are covered
+	// by full overlaps, and skip the stretched padding in the output
+	padded := make([]float64, len(samples)+2*stretchWinSize)
+	copy(padded[stretchWinSize:], samples)
+	skip := int(math.Round(stretchWinSize * factor))
+
+	p := NewPvoc(stretchWinSize, stretchHopSize)
+	bins := stretchWinSize/2 + 1
+	grain := NewCvec(stretchWinSize)
+	frame, hop := NewFvec(stretchWinSize), NewFvec(stretchHopSize)
+	prevNorm, prevPhas := make([]float64, bins), make([]float64, bins)
+	synthPhas := make([]float64, bins)
+	var peaks []int
+	out := make([]float64, 0, skip+length+stretchHopSize)
+	prevPos := 0
+	for k := 0; len(out) < skip+length; k++ {
+		pos := int(math.Round(float64(k*stretchHopSize) / factor))
+		if pos+stretchWinSize <= len(padded) {
+			copy(frame.Data, padded[pos:pos+stretchWinSize])
+		} else {
+			frame.Zeros()
+			if pos < len(padded) {
+				copy(frame.Data, padded[pos:])
+			}
+		}
+		p.Do(frame, grain)
+
+		lockPhases(grain, prevNorm, prevPhas, synthPhas, pos-prevPos, &peaks)
+		prevPos = pos
+
+		p.RDo(grain, hop)
+		out = append(out, hop.Data...)
+	}
+	return out[skip : skip+length], nil
+}
+
+// PitchShift returns samples transposed by semitones, which can be
+// fractional, without changing their length: they are time-stretched by the
+// frequency ratio, see TimeStretch, and resampled back to their length. It
+// corrects or transposes slices for sampler instruments built from chops.
+func PitchShift(samples []float64, semitones float64) ([]float64, error) {
+	if math.IsNaN(semitones) || math.IsInf(semitones, 0) {
+		return nil, fmt.Errorf("invalid pitch shift %g semitones", semitones)
+	}
+	if semitones == 0 || len(samples) == 0 {
+		return slices.Clone(samples), nil
+	}
+	ratio := math.Pow(2, semitones/12)
+	stretched, err := TimeStretch(samples, ratio)
+	if err != nil {
+		return nil, err
+	}
+	return resampleBy(stretched, float64(len(stretched))/float64(len(samples)), len(samples)), nil
+}
+
+// lockPhases replaces the analysis phases of grain with synthesis phases
+// advanced by stretchHopSize samples from synthPhas, and updates prevNorm,
+// prevPhas and synthPhas. The phase of every spectral peak advances by the
+// frequency measured from its phase advance over the analysis hop, and the
+// bins around it keep their phases relative to the peak, so that the bins
+// of a partial stay coherent (identity phase locking).
+func lockPhases(grain *Cvec, prevNorm, prevPhas, synthPhas []float64, analysisHop int, peaks *[]int) {
+	norm, phas := grain.Norm, grain.Phas
+	size := 2 * (len(phas) - 1)
+	*peaks = (*peaks)[:0]
+	for b := range norm {
+		if (b == 0 || norm[b] > norm[b-1]) && (b == len(norm)-1 || norm[b] >= norm[b+1]) {
+			*peaks = append(*peaks, b)
+		}
+	}
+
+	for _, b := range *peaks {
+		if prevNorm[b] == 0 || analysisHop <= 0 {
+			// Peaks starting to sound take their analysis phase
+			synthPhas[b] = phas[b]
+			continue
+		}
+		// Deviation of the phase advance from that of the bin frequency
+		// gives the frequency of the partial
+		omega := 2 * math.Pi * float64(b) / float64(size)
+		deviation := math.Remainder(phas[b]-prevPhas[b]-omega*float64(analysisHop), 2*math.Pi)
+		freq := omega + deviation/float64(analysisHop)
+		synthPhas[b] = math.Remainder(synthPhas[b]+freq*stretchHopSize, 2*math.Pi)
+	}
+
+	// Every bin follows its nearest peak; the largest bin is always a peak
+	j := 0
+	for b := range phas {
+		for j+1 < len(*peaks) && (*peaks)[j+1]-b < b-(*peaks)[j] {
+			j++
+		}
+		if peak := (*peaks)[j]; peak != b {
+			synthPhas[b] = synthPhas[peak] + phas[b] - phas[peak]
+		}
+		prevNorm[b], prevPhas[b] = norm[b], phas[b]
+	}
+	copy(phas, synthPhas)
+}
+
+// StretchSlices time-stretches every slice of the analyzed samples from the
+// tempo estimated from the onsets, see EstimateTempo, to targetBPM without
+// changing the pitch, see TimeStretch, returning tempo-matched chops for
+// sampling. The stretched slices, in order, concatenate to the whole file
+// at the target tempo. The result must hold its samples.
+func StretchSlices(result *SliceAnalyzerResult, targetBPM float64) ([][]float64, error) {
+	if len(result.Samples) == 0 {
+		return nil, fmt.Errorf("result has no samples to stretch")
+	}
+	factor, err := stretchFactor(result, targetBPM)
+	if err != nil {
+		return nil, err
+	}
+	ranges := result.SliceRanges()
+	stretched := make([][]float64, len(ranges))
+	for i, r := range ranges {
+		stretched[i], err = TimeStretch(result.Samples[r.Start:r.End], factor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stretched, nil
+}
+
+// StretchFile time-stretches the whole analyzed samples from the tempo
+// estimated from the onsets to targetBPM without changing the pitch, which
+// keeps the phases continuous across the slice boundaries. The result must
+// hold its samples.
+func StretchFile(result *SliceAnalyzerResult, targetBPM float64) ([]float64, error) {
+	if len(result.Samples) == 0 {
+		return nil, fmt.Errorf("result has no samples to stretch")
+	}
+	factor, err := stretchFactor(result, targetBPM)
+	if err != nil {
+		return nil, err
+	}
+	return TimeStretch(result.Samples, factor)
+}
+
+// stretchFactor returns the factor stretching the analyzed samples from
+// their estimated tempo to targetBPM
+func stretchFactor(result *SliceAnalyzerResult, targetBPM float64) (float64, error) {
+	if !(targetBPM > 0) || math.IsInf(targetBPM, 0) {
+		return 0, fmt.Errorf("invalid target tempo %g BPM", targetBPM)
+	}
+	tempo := EstimateTempo(result.Onsets, TempoOptions{})
+	if tempo.BPM == 0 {
+		return 0, fmt.Errorf("no tempo found in %d onsets", len(result.Onsets))
+	}
+	return tempo.BPM / targetBPM, nil
+}
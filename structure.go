@@ -0,0 +1,413 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Spectral frames of the structure analysis
+const (
+	structureWinSize = 2048
+	structureHopSize = 1024
+	// structureBands is the number of log-spaced bands of the "bands"
+	// features, from structureMinHz up to structureMaxHz or half the sample
+	// rate
+	structureBands = 24
+	structureMinHz = 60.0
+	structureMaxHz = 12000.0
+	// structureMFCCs coefficients of Slaney's 40 mel bands are computed by
+	// the "mfcc" features, the first one, the loudness, excluded
+	structureMelBands = 40
+	structureMFCCs    = 13
+)
+
+// Features compared by the structure analysis, for StructureOptions.Features
+const (
+	// StructureFeaturesBands compares log energies in log-spaced bands,
+	// following the overall timbre
+	StructureFeaturesBands = "bands"
+	// StructureFeaturesMFCC compares mel-frequency cepstral coefficients,
+	// following the timbre with less weight on loudness, such as speakers
+	// and instrumentation
+	StructureFeaturesMFCC = "mfcc"
+	// StructureFeaturesChroma compares the energy of the 12 pitch classes,
+	// following the harmony, such as the chords of a verse and a chorus or
+	// the keys of the tracks of a DJ mix
+	StructureFeaturesChroma = "chroma"
+)
+
+// StructureOptions contains configuration options for AnalyzeStructure
+type StructureOptions struct {
+	// FrameSec is the length in seconds of the feature frames compared by the
+	// self-similarity matrix, and the resolution of the boundaries.
+	// Default is 0.5 if 0.
+	FrameSec float64
+	// KernelSec is the length in seconds of the audio compared on each side
+	// of a candidate boundary. Longer kernels find longer sections and
+	// ignore shorter changes. Default is 8 if 0.
+	KernelSec float64
+	// MinSectionSec is the shortest section in seconds. Default is 8 if 0.
+	MinSectionSec float64
+	// Threshold is the novelty, relative to its largest value, below which
+	// peaks are not boundaries. Default is 0.2 if 0.
+	Threshold float64
+	// Features selects the features of the frames: "bands", "mfcc" or
+	// "chroma", see StructureFeaturesBands. Default is "bands" if empty.
+	Features string
+	// KeepMatrix keeps the self-similarity matrix of the frames in the
+	// result's Matrix, such as for plotting. It holds the square of the
+	// number of frames, so long recordings need a longer FrameSec.
+	KeepMatrix bool
+}
+
+// Structure holds the sections of a recording found by AnalyzeStructure
+type Structure struct {
+	// FrameSec is the length in seconds of the feature frames, FrameSec of
+	// the options rounded to a whole number of spectral frames
+	FrameSec float64
+	// Novelty contains the novelty of every frame, how much the audio
+	// before its start differs from the audio after it
+	Novelty []float64
+	// Boundaries contains the start times in seconds of the sections, the
+	// first one at 0. They can be used as the Onsets of a
+	// SliceAnalyzerResult to export the sections.
+	Boundaries []float64
+	// Matrix contains the cosine similarity of every pair of frames, with
+	// KeepMatrix
+	Matrix [][]float64
+}
+
+// SectionOptions contains configuration options for DetectSections
+type SectionOptions struct {
+	StructureOptions
+	// Channel selects the channel of multichannel files, as
+	// SliceAnalyzerOptions.Channel, except "per-channel". Default is "left"
+	// if empty.
+	Channel string
+	// DecoderFallback selects an external decoder for formats the native
+	// decoders cannot handle, as SliceAnalyzerOptions.DecoderFallback
+	DecoderFallback string
+	// Progress, if set, is called periodically with the fraction of the
+	// file decoded in [0, 1]
+	Progress func(frac float64)
+}
+
+// AnalyzeStructure finds the phrase-level sections of mono samples, such as
+// intro, verse and chorus, complementing onset-level slicing. The samples
+// are cut into frames of FrameSec whose features, measured on phase vocoder
+// spectra, are compared pairwise into a self-similarity matrix. A
+// checkerboard kernel slid along its diagonal measures the novelty of every
+// frame, as in Foote's method, whose peaks are the boundaries.
+func AnalyzeStructure(samples []float64, sampleRate uint, options StructureOptions) (*Structure, error) {
+	e, err := newFeatureExtractor(sampleRate, options)
+	if err != nil {
+		return nil, err
+	}
+	e.write(samples)
+	return segmentFeatures(e.finish(), e.frameSec, options), nil
+}
+
+// DetectSections finds the sections of an audio file like AnalyzeStructure,
+// decoding it block by block so that only the features are kept in memory,
+// for the coarse segmentation of hour-long podcasts and DJ mixes. Longer
+// KernelSec and MinSectionSec, such as 30 and 60, suit such recordings.
+func DetectSections(file string, options SectionOptions) (*Structure, error) {
+	if strings.EqualFold(options.Channel, ChannelPerChannel) {
+		return nil, fmt.Errorf("section detection does not support the %q channel mode", ChannelPerChannel)
+	}
+	s, err := openAudioFile(file, options.DecoderFallback)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	defer s.Close()
+
+	e, err := newFeatureExtractor(s.SampleRate(), options.StructureOptions)
+	if err != nil {
+		return nil, err
+	}
+	p := newProgress(options.Progress)
+	if err := streamChannel(s, options.Channel, p, e.write); err != nil {
+		return nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	p.report(1)
+	return segmentFeatures(e.finish(), e.frameSec, options.StructureOptions), nil
+}
+
+// segmentFeatures returns the structure of the features of frames of
+// frameSec seconds
+func segmentFeatures(features [][]float64, frameSec float64, options StructureOptions) *Structure {
+	kernelSec, minSectionSec, threshold := options.KernelSec, options.MinSectionSec, options.Threshold
+	if kernelSec == 0 {
+		kernelSec = 8
+	}
+	if minSectionSec == 0 {
+		minSectionSec = 8
+	}
+	if threshold == 0 {
+		threshold = 0.2
+	}
+	similarity := func(i, j int) float64 {
+		dot := 0.0
+		for k, v := range features[i] {
+			dot += v * features[j][k]
+		}
+		return dot
+	}
+
+	s := &Structure{FrameSec: frameSec, Boundaries: []float64{0}}
+	if options.KeepMatrix {
+		s.Matrix = make([][]float64, len(features))
+		for i := range features {
+			s.Matrix[i] = make([]float64, len(features))
+			for j := range features {
+				s.Matrix[i][j] = similarity(i, j)
+			}
+		}
+	}
+
+	// Gaussian-tapered checkerboard kernel: frames on the same side of the
+	// boundary count positively, across it negatively
+	half := max(int(math.Round(kernelSec/frameSec)), 1)
+	taper := make([]float64, 2*half)
+	for k := range taper {
+		d := (float64(k-half) + 0.5) / (0.5 * float64(half))
+		taper[k] = math.Exp(-0.5 * d * d)
+	}
+	s.Novelty = make([]float64, len(features))
+	for i := range features {
+		sum := 0.0
+		for a := max(-half, -i); a < half && i+a < len(features); a++ {
+			for b := max(-half, -i); b < half && i+b < len(features); b++ {
+				sign := 1.0
+				if (a < 0) != (b < 0) {
+					sign = -1
+				}
+				sum += sign * taper[a+half] * taper[b+half] * similarity(i+a, i+b)
+			}
+		}
+		s.Novelty[i] = max(sum, 0)
+	}
+
+	// Boundaries are the strongest peaks above the threshold, at least a
+	// section apart from each other and from the ends
+	largest := 0.0
+	for _, v := range s.Novelty {
+		largest = max(largest, v)
+	}
+	minSection := max(int(math.Round(minSectionSec/frameSec)), 1)
+	var candidates []int
+	for i := minSection; i <= len(s.Novelty)-minSection; i++ {
+		v := s.Novelty[i]
+		if v > 0 && v >= threshold*largest && v >= s.Novelty[i-1] && (i+1 == len(s.Novelty) || v > s.Novelty[i+1]) {
+			candidates = append(candidates, i)
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return s.Novelty[candidates[a]] > s.Novelty[candidates[b]]
+	})
+	var kept []int
+	for _, i := range candidates {
+		far := true
+		for _, k := range kept {
+			far = far && (i-k >= minSection || k-i >= minSection)
+		}
+		if far {
+			kept = append(kept, i)
+		}
+	}
+	sort.Ints(kept)
+	for _, i := range kept {
+		s.Boundaries = append(s.Boundaries, float64(i)*frameSec)
+	}
+	return s
+}
+
+// featureExtractor computes the features of the frames of audio written to
+// it block by block. Spectra are taken every structureHopSize samples and
+// their features averaged over the frames of hopsPerFrame hops.
+type featureExtractor struct {
+	hopsPerFrame int
+	// frameSec is the length of the frames in seconds
+	frameSec float64
+	pvoc     *Pvoc
+	grain    *Cvec
+	frame    *Fvec
+	// weights maps the spectrum to the "bands" features, one row of bin
+	// weights per band
+	weights [][]float64
+	// mfcc and chroma compute the "mfcc" and "chroma" features
+	mfcc   *Mfcc
+	chroma *Chroma
+	// pending holds the samples of the next spectra
+	pending []float64
+	// current sums the features of the hops of the frame being filled
+	current []float64
+	hops    int
+	frames  [][]float64
+}
+
+// newFeatureExtractor returns an extractor of the features of options for
+// audio at sampleRate
+func newFeatureExtractor(sampleRate uint, options StructureOptions) (*featureExtractor, error) {
+	frameSec := options.FrameSec
+	if frameSec == 0 {
+		frameSec = 0.5
+	}
+	features := options.Features
+	if features == "" {
+		features = StructureFeaturesBands
+	}
+	if sampleRate == 0 || frameSec < 0 || options.KernelSec < 0 || options.MinSectionSec < 0 || options.Threshold < 0 || options.Threshold > 1 {
+		return nil, fmt.Errorf("invalid structure options %+v at %d Hz", options, sampleRate)
+	}
+
+	var weights [][]float64
+	var mfcc *Mfcc
+	var chroma *Chroma
+	switch features {
+	case StructureFeaturesBands:
+		binHz := float64(sampleRate) / structureWinSize
+		bins := structureWinSize/2 + 1
+		maxHz := min(structureMaxHz, float64(sampleRate)/2)
+		edges := make([]int, structureBands+1)
+		for k := range edges {
+			hz := structureMinHz * math.Pow(maxHz/structureMinHz, float64(k)/structureBands)
+			edges[k] = min(int(hz/binHz), bins-1)
+		}
+		for k := range structureBands {
+			w := make([]float64, bins)
+			for bin := edges[k]; bin <= max(edges[k+1]-1, edges[k]); bin++ {
+				w[bin] = 1
+			}
+			weights = append(weights, w)
+		}
+	case StructureFeaturesMFCC:
+		var err error
+		if mfcc, err = NewMfcc(structureWinSize, structureMelBands, structureMFCCs, sampleRate); err != nil {
+			return nil, err
+		}
+	case StructureFeaturesChroma:
+		chroma = NewChroma(structureWinSize, sampleRate)
+	default:
+		return nil, fmt.Errorf("unknown structure features %q: supported features are %q, %q and %q", features, StructureFeaturesBands, StructureFeaturesMFCC, StructureFeaturesChroma)
+	}
+
+	// Frames are whole numbers of hops
+	hopsPerFrame := max(int(math.Round(frameSec*float64(sampleRate)/structureHopSize)), 1)
+	return &featureExtractor{
+		hopsPerFrame: hopsPerFrame,
+		frameSec:     float64(hopsPerFrame*structureHopSize) / float64(sampleRate),
+		pvoc:         NewPvoc(structureWinSize, structureHopSize),
+		grain:        NewCvec(structureWinSize),
+		frame:        NewFvec(structureWinSize),
+		weights:      weights,
+		mfcc:         mfcc,
+		chroma:       chroma,
+	}, nil
+}
+
+// write analyzes every spectrum of samples whose window is complete
+func (e *featureExtractor) write(samples []float64) {
+	e.pending = append(e.pending, samples...)
+	consumed := 0
+	for len(e.pending)-consumed >= structureWinSize {
+		e.hop(e.pending[consumed : consumed+structureWinSize])
+		consumed += structureHopSize
+	}
+	e.pending = append(e.pending[:0], e.pending[consumed:]...)
+}
+
+// finish analyzes the spectra starting in the remaining samples, padded with
+// silence, and returns the features of every frame, minus their mean over
+// all frames so that the spectral balance shared by the whole recording
+// does not dominate, scaled to unit length
+func (e *featureExtractor) finish() [][]float64 {
+	for consumed := 0; consumed < len(e.pending); consumed += structureHopSize {
+		e.hop(e.pending[consumed:min(consumed+structureWinSize, len(e.pending))])
+	}
+	e.pending = e.pending[:0]
+	if e.hops > 0 {
+		e.endFrame()
+	}
+
+	if len(e.frames) == 0 {
+		return nil
+	}
+	mean := make([]float64, len(e.frames[0]))
+	for _, feature := range e.frames {
+		for k, v := range feature {
+			mean[k] += v / float64(len(e.frames))
+		}
+	}
+	for _, feature := range e.frames {
+		norm := 0.0
+		for k := range feature {
+			feature[k] -= mean[k]
+			norm += feature[k] * feature[k]
+		}
+		if norm > 0 {
+			norm = math.Sqrt(norm)
+			for k := range feature {
+				feature[k] /= norm
+			}
+		}
+	}
+	return e.frames
+}
+
+// hop adds the features of the spectrum of window, zero-padded to
+// structureWinSize, to the current frame
+func (e *featureExtractor) hop(window []float64) {
+	e.frame.Zeros()
+	copy(e.frame.Data, window)
+	e.pvoc.Do(e.frame, e.grain)
+
+	var feature []float64
+	switch {
+	case e.chroma != nil:
+		// Relative to the strongest pitch class, so that loudness does not
+		// matter
+		out := NewFvec(ChromaClasses)
+		e.chroma.Do(e.grain, out)
+		feature = out.Data
+		normalizeChroma(feature)
+	case e.mfcc != nil:
+		// Without the first coefficient, the loudness
+		out := NewFvec(structureMFCCs)
+		e.mfcc.Do(e.grain, out)
+		feature = out.Data[1:]
+	default:
+		feature = make([]float64, len(e.weights))
+		for k, w := range e.weights {
+			energy := 0.0
+			for bin, weight := range w {
+				if weight != 0 {
+					energy += weight * e.grain.Norm[bin] * e.grain.Norm[bin]
+				}
+			}
+			feature[k] = math.Log(1e-10 + energy)
+		}
+	}
+
+	if e.current == nil {
+		e.current = make([]float64, len(feature))
+	}
+	for k, v := range feature {
+		e.current[k] += v
+	}
+	e.hops++
+	if e.hops == e.hopsPerFrame {
+		e.endFrame()
+	}
+}
+
+// endFrame appends the mean features of the hops of the current frame
+func (e *featureExtractor) endFrame() {
+	for k := range e.current {
+		e.current[k] /= float64(e.hops)
+	}
+	e.frames = append(e.frames, e.current)
+	e.current, e.hops = nil, 0
+}
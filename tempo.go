@@ -0,0 +1,375 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+// tempoResolution is the sampling rate in Hz of the onset envelope used for tempo estimation
+const tempoResolution = 100.0
+
+// TempoOptions contains configuration options for tempo estimation
+type TempoOptions struct {
+	// MinBPM is the lowest tempo considered. Default is 60 if 0.
+	MinBPM float64
+	// MaxBPM is the highest tempo considered. Default is 200 if 0.
+	MaxBPM float64
+}
+
+// TempoEstimate holds the estimated tempo and beat positions of a recording
+type TempoEstimate struct {
+	// BPM is the estimated tempo in beats per minute, or 0 if none was found
+	BPM float64
+	// Confidence rates how periodic the onsets are at the estimated tempo,
+	// from 0 (no periodicity) to 1 (perfectly regular)
+	Confidence float64
+	// Beats contains the beat times in seconds, from the first to the last onset
+	Beats []float64
+}
+
+// EstimateTempo estimates a global tempo from onset times in seconds.
+// The onsets are rendered into a smoothed pulse train whose autocorrelation is
+// searched for the strongest period in the tempo range, combining each period
+// with its multiples and weighting towards 120 BPM to settle octave
+// ambiguities. Beats are laid on a grid with the phase that best aligns with
+// the onsets.
+func EstimateTempo(onsets []float64, options TempoOptions) TempoEstimate {
+	minBPM, maxBPM := options.MinBPM, options.MaxBPM
+	if minBPM <= 0 {
+		minBPM = 60
+	}
+	if maxBPM <= 0 {
+		maxBPM = 200
+	}
+	if len(onsets) < 2 || maxBPM <= minBPM {
+		return TempoEstimate{}
+	}
+
+	onsets = sortedCopy(onsets)
+	first, last := onsets[0], onsets[len(onsets)-1]
+	envelope := onsetEnvelope(onsets, first, last)
+
+	minLag := int(math.Floor(60 / maxBPM * tempoResolution))
+	maxLag := int(math.Ceil(60 / minBPM * tempoResolution))
+	if minLag < 1 {
+		minLag = 1
+	}
+	if maxLag >= len(envelope) {
+		maxLag = len(envelope) - 1
+	}
+	if minLag > maxLag {
+		return TempoEstimate{}
+	}
+
+	// Autocorrelation up to the harmonics of the longest period
+	numHarmonics := 4
+	acf := make([]float64, min(numHarmonics*maxLag+2, len(envelope)))
+	for lag := range acf {
+		for i := 0; i+lag < len(envelope); i++ {
+			acf[lag] += envelope[i] * envelope[i+lag]
+		}
+	}
+	if acf[0] == 0 {
+		return TempoEstimate{}
+	}
+
+	// Score every period by its mean unbiased autocorrelation over its first
+	// harmonics, so that the period of the meter wins over syncopated
+	// sub-periods, and weight it with a log-normal prior centered on
+	// 120 BPM (0.5 s)
+	n := float64(len(envelope))
+	bestLag, bestScore := 0, 0.0
+	for lag := minLag; lag <= maxLag; lag++ {
+		sum, count := 0.0, 0
+		for k := 1; k <= numHarmonics && k*lag < len(acf) && (k == 1 || n-float64(k*lag) >= tempoResolution/2); k++ {
+			sum += acf[k*lag] * n / (n - float64(k*lag))
+			count++
+		}
+		octaves := math.Log2(float64(lag) / tempoResolution / 0.5)
+		score := sum / float64(count) * math.Exp(-0.5*octaves*octaves)
+		if score > bestScore {
+			bestLag, bestScore = lag, score
+		}
+	}
+	if bestLag == 0 {
+		return TempoEstimate{}
+	}
+
+	// Refine the period with a parabola through the neighboring lags
+	period := float64(bestLag)
+	if bestLag+1 < len(acf) {
+		a, b, c := acf[bestLag-1], acf[bestLag], acf[bestLag+1]
+		if d := a - 2*b + c; d < 0 {
+			period += 0.5 * (a - c) / d
+		}
+	}
+	period /= tempoResolution
+
+	// Choose the grid phase that hits the most onset energy
+	bestPhase, bestHits := 0.0, -1.0
+	steps := int(period * tempoResolution)
+	for s := 0; s < steps; s++ {
+		phase := float64(s) / tempoResolution
+		hits := 0.0
+		for t := first + phase; t <= last; t += period {
+			if i := int(math.Round((t - first) * tempoResolution)); i < len(envelope) {
+				hits += envelope[i]
+			}
+		}
+		if hits > bestHits {
+			bestPhase, bestHits = phase, hits
+		}
+	}
+
+	var beats []float64
+	for t := first + bestPhase; t <= last+1e-9; t += period {
+		beats = append(beats, t)
+	}
+
+	return TempoEstimate{
+		BPM:        60 / period,
+		Confidence: math.Min(acf[bestLag]/acf[0], 1),
+		Beats:      beats,
+	}
+}
+
+// IntervalHistogram is the histogram of the intervals between consecutive
+// onsets
+type IntervalHistogram struct {
+	// BinMs is the width of the bins in milliseconds. Bin i counts the
+	// intervals from i*BinMs up to (i+1)*BinMs.
+	BinMs float64
+	// Counts contains the number of intervals in every bin, up to the bin of
+	// the longest interval
+	Counts []int
+	// Dominant contains the dominant intervals in milliseconds, the mean
+	// interval of every bin holding more intervals than its neighbors and at
+	// least half as many as the fullest bin, most frequent first
+	Dominant []float64
+}
+
+// IOIHistogram returns the histogram of the inter-onset intervals of onsets
+// in seconds, in bins of binMs milliseconds. Its dominant intervals give the
+// beat and its subdivisions of rhythmic material, and a populated first bin
+// reveals double triggers, such as those of a too sensitive threshold in a
+// parameter sweep. The histogram is empty with fewer than two onsets.
+func IOIHistogram(onsets []float64, binMs float64) (IntervalHistogram, error) {
+	if binMs <= 0 {
+		return IntervalHistogram{}, fmt.Errorf("invalid bin width of %g ms", binMs)
+	}
+	histogram := IntervalHistogram{BinMs: binMs}
+	if len(onsets) < 2 {
+		return histogram, nil
+	}
+
+	sorted := sortedCopy(onsets)
+	intervals := make([]float64, len(sorted)-1)
+	for i := range intervals {
+		intervals[i] = (sorted[i+1] - sorted[i]) * 1000
+	}
+	bin := func(interval float64) int { return int(interval / binMs) }
+	longest := slices.Max(intervals)
+	histogram.Counts = make([]int, bin(longest)+1)
+	sums := make([]float64, len(histogram.Counts))
+	for _, interval := range intervals {
+		histogram.Counts[bin(interval)]++
+		sums[bin(interval)] += interval
+	}
+
+	// Peaks of the histogram: a plateau counts once, at its first bin
+	fullest := slices.Max(histogram.Counts)
+	var peaks []int
+	for i, count := range histogram.Counts {
+		left, right := 0, 0
+		if i > 0 {
+			left = histogram.Counts[i-1]
+		}
+		if i+1 < len(histogram.Counts) {
+			right = histogram.Counts[i+1]
+		}
+		if count > left && count >= right && 2*count >= fullest {
+			peaks = append(peaks, i)
+		}
+	}
+	sort.SliceStable(peaks, func(a, b int) bool {
+		return histogram.Counts[peaks[a]] > histogram.Counts[peaks[b]]
+	})
+	for _, i := range peaks {
+		histogram.Dominant = append(histogram.Dominant, sums[i]/float64(histogram.Counts[i]))
+	}
+	return histogram, nil
+}
+
+// onsetEnvelope renders onsets as Gaussian pulses sampled at tempoResolution,
+// starting at first
+func onsetEnvelope(onsets []float64, first, last float64) []float64 {
+	const width = 2.0 // pulse standard deviation in envelope samples
+	envelope := make([]float64, int((last-first)*tempoResolution)+int(4*width)+1)
+	for _, t := range onsets {
+		center := (t - first) * tempoResolution
+		lo := max(int(center-4*width), 0)
+		hi := min(int(center+4*width)+1, len(envelope))
+		for i := lo; i < hi; i++ {
+			d := (float64(i) - center) / width
+			envelope[i] += math.Exp(-0.5 * d * d)
+		}
+	}
+	return envelope
+}
+
+// TempoCurveOptions contains configuration options for EstimateTempoCurve
+type TempoCurveOptions struct {
+	// TempoOptions bounds the global tempo, around which the local tempos
+	// are searched within half an octave so that they do not jump octaves
+	TempoOptions
+	// WindowSec is the length in seconds of the windows of onsets whose
+	// tempo is estimated. Longer windows are steadier, shorter ones follow
+	// faster changes. Default is 8 if 0.
+	WindowSec float64
+	// HopSec is the step in seconds between windows. Default is 1 if 0.
+	HopSec float64
+}
+
+// TempoPoint is the local tempo of a window of onsets
+type TempoPoint struct {
+	// Time is the center of the window in seconds
+	Time float64
+	// BPM is the tempo of the window in beats per minute
+	BPM float64
+	// Confidence rates how periodic the onsets of the window are, see
+	// TempoEstimate
+	Confidence float64
+}
+
+// TempoCurve holds the tempo over time of a recording and its beats
+type TempoCurve struct {
+	// Points contains the local tempos, in time order. Windows with too few
+	// onsets to estimate a tempo have no point.
+	Points []TempoPoint
+	// Beats contains the beat times in seconds from the first to the last
+	// onset, each one beat of the local tempo after the previous one, moved
+	// to the onset within an eighth of a beat of it if there is one
+	Beats []float64
+}
+
+// BPMAt returns the tempo at timeSec, interpolated linearly between the
+// points of the curve and constant past its ends, or 0 if the curve is empty
+func (c TempoCurve) BPMAt(timeSec float64) float64 {
+	if len(c.Points) == 0 {
+		return 0
+	}
+	i := sort.Search(len(c.Points), func(i int) bool { return c.Points[i].Time > timeSec })
+	switch i {
+	case 0:
+		return c.Points[0].BPM
+	case len(c.Points):
+		return c.Points[i-1].BPM
+	}
+	a, b := c.Points[i-1], c.Points[i]
+	return a.BPM + (b.BPM-a.BPM)*(timeSec-a.Time)/(b.Time-a.Time)
+}
+
+// EstimateTempoCurve estimates the tempo over time of onsets in seconds, with
+// EstimateTempo on sliding windows, so that rubato or live-drummed material
+// can be sliced and quantized against a varying grid instead of a single
+// global tempo. The curve is empty if no global tempo is found.
+func EstimateTempoCurve(onsets []float64, options TempoCurveOptions) TempoCurve {
+	window, hop := options.WindowSec, options.HopSec
+	if window <= 0 {
+		window = 8
+	}
+	if hop <= 0 {
+		hop = 1
+	}
+	global := EstimateTempo(onsets, options.TempoOptions)
+	if global.BPM == 0 {
+		return TempoCurve{}
+	}
+
+	onsets = sortedCopy(onsets)
+	first, last := onsets[0], onsets[len(onsets)-1]
+	local := TempoOptions{MinBPM: global.BPM / math.Sqrt2, MaxBPM: global.BPM * math.Sqrt2}
+	var curve TempoCurve
+	for center := first; center <= last+1e-9; center += hop {
+		lo := sort.SearchFloat64s(onsets, center-window/2)
+		hi := sort.SearchFloat64s(onsets, center+window/2)
+		tempo := EstimateTempo(onsets[lo:hi], local)
+		if tempo.BPM > 0 {
+			curve.Points = append(curve.Points, TempoPoint{Time: center, BPM: tempo.BPM, Confidence: tempo.Confidence})
+		}
+	}
+	if len(curve.Points) == 0 {
+		curve.Points = []TempoPoint{{Time: (first + last) / 2, BPM: global.BPM, Confidence: global.Confidence}}
+	}
+
+	// Step from beat to beat at the local tempo, snapping to nearby onsets
+	for beat := first; beat <= last+1e-9; {
+		curve.Beats = append(curve.Beats, beat)
+		period := 60 / curve.BPMAt(beat)
+		next := beat + period
+		if i := nearestOnset(onsets, next); math.Abs(onsets[i]-next) <= period/8 {
+			next = onsets[i]
+		}
+		beat = next
+	}
+	return curve
+}
+
+// nearestOnset returns the index of the onset of sorted onsets closest to t
+func nearestOnset(onsets []float64, t float64) int {
+	i := sort.SearchFloat64s(onsets, t)
+	if i == len(onsets) || (i > 0 && t-onsets[i-1] < onsets[i]-t) {
+		i--
+	}
+	return i
+}
+
+// GridBeat is a beat of the bar and beat grid of a recording
+type GridBeat struct {
+	// Time is the beat time in seconds
+	Time float64
+	// Bar is the 1-based bar number, 0 for the beats before the first
+	// downbeat
+	Bar int
+	// Beat is the 1-based position of the beat in its bar
+	Beat int
+}
+
+// beatGrid numbers beats in bars of beatsPerBar beats. The downbeats are the
+// beats, among every choice of phase, on which samples hold the most energy,
+// the first beat if samples are empty.
+func beatGrid(beats []float64, beatsPerBar int, samples []float64, sampleRate uint) []GridBeat {
+	if len(beats) == 0 {
+		return nil
+	}
+	bestPhase, bestEnergy := 0, 0.0
+	for phase := range min(beatsPerBar, len(beats)) {
+		energy := 0.0
+		for i := phase; i < len(beats); i += beatsPerBar {
+			energy += calculateOnsetEnergy(samples, sampleRate, beats[i])
+		}
+		if energy > bestEnergy {
+			bestPhase, bestEnergy = phase, energy
+		}
+	}
+
+	grid := make([]GridBeat, len(beats))
+	for i, t := range beats {
+		// Shifted so that the beats before the first downbeat fall in bar 0
+		n := i - bestPhase + beatsPerBar
+		grid[i] = GridBeat{Time: t, Bar: n / beatsPerBar, Beat: n%beatsPerBar + 1}
+	}
+	return grid
+}
+
+// measureTempo sets the tempo estimated from the onsets of r and its beat
+// grid in bars of beatsPerBar beats
+func (r *SliceAnalyzerResult) measureTempo(beatsPerBar int) {
+	if beatsPerBar == 0 {
+		beatsPerBar = 4
+	}
+	r.Tempo = EstimateTempo(r.Onsets, TempoOptions{})
+	r.BeatGrid = beatGrid(r.Tempo.Beats, beatsPerBar, r.Samples, r.SampleRate)
+}
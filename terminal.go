@@ -0,0 +1,110 @@
+package onset
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// terminalLines is the height of terminal waveforms in lines of text. Every
+// braille character holds 2 columns and 4 rows of dots.
+const terminalLines = 4
+
+// brailleDots are the bits of the braille dots by row and column, added to
+// U+2800 to form a character
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// PrintTerminal prints the waveform of the result to standard output in
+// braille characters, width characters wide (80 if 0), with a line marking
+// the onsets below, so that detections can be checked in a terminal, such as
+// over SSH, without opening an image. See WriteTerminal.
+func (r *SliceAnalyzerResult) PrintTerminal(width int) error {
+	return r.WriteTerminal(os.Stdout, width)
+}
+
+// WriteTerminal writes the waveform of the result to w in braille characters,
+// width characters wide (80 if 0), scaled to its peak. The line below marks
+// with ^ every character holding an onset and the last line gives the time
+// range. Results of streaming analysis are drawn from their Preview.
+func (r *SliceAnalyzerResult) WriteTerminal(w io.Writer, width int) error {
+	if width == 0 {
+		width = 80
+	}
+	if width < 0 {
+		return fmt.Errorf("invalid width %d", width)
+	}
+	samples, decimation := r.Samples, 1
+	numSamples := len(samples)
+	if numSamples == 0 && len(r.Preview) > 0 {
+		samples, decimation = r.Preview, r.PreviewDecimation
+		numSamples = r.NumSamples
+	}
+	if numSamples == 0 || r.SampleRate == 0 {
+		return fmt.Errorf("result has no samples to draw")
+	}
+
+	peak := 0.0
+	for _, v := range samples {
+		peak = math.Max(peak, math.Abs(v))
+	}
+	if peak == 0 {
+		peak = 1
+	}
+
+	// Set the dots between the extremes of the samples of every dot column
+	dotColumns, dotRows := 2*width, 4*terminalLines
+	grid := make([][]rune, terminalLines)
+	for line := range grid {
+		grid[line] = make([]rune, width)
+	}
+	row := func(v float64) int {
+		return int(math.Round((1 - v/peak) / 2 * float64(dotRows-1)))
+	}
+	for x := range dotColumns {
+		start := x * numSamples / dotColumns / decimation
+		end := (x + 1) * numSamples / dotColumns / decimation
+		end = min(max(end, start+1), len(samples))
+		if start >= end {
+			continue
+		}
+		lo, hi := samples[start], samples[start]
+		for _, v := range samples[start:end] {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+		for y := row(hi); y <= row(lo); y++ {
+			grid[y/4][x/2] |= brailleDots[y%4][x%2]
+		}
+	}
+
+	duration := float64(numSamples) / float64(r.SampleRate)
+	markers := []byte(strings.Repeat(" ", width))
+	for _, t := range r.Onsets {
+		if c := int(t / duration * float64(width)); c >= 0 && c < width {
+			markers[c] = '^'
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, line := range grid {
+		for _, dots := range line {
+			bw.WriteRune(0x2800 + dots)
+		}
+		bw.WriteByte('\n')
+	}
+	fmt.Fprintln(bw, strings.TrimRight(string(markers), " "))
+	end := fmt.Sprintf("%.2f s", duration)
+	start := "0 s"
+	fmt.Fprintf(bw, "%s%s%s\n", start, strings.Repeat(" ", max(width-len(start)-len(end), 1)), end)
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write waveform: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package onset
+
+import (
+	"fmt"
+	"sort"
+)
+
+// tierMatchSec is the distance in seconds within which onsets of different
+// tiers are considered the same onset
+const tierMatchSec = 0.02
+
+// SensitivityTier is a named peak picking threshold evaluated alongside the
+// main detection, such as "strong" or "weak"
+type SensitivityTier struct {
+	// Name identifies the tier
+	Name string
+	// Threshold is the peak picking threshold of the tier. Higher values keep
+	// only the more pronounced onsets.
+	Threshold float64
+}
+
+// DefaultSensitivityTiers returns three tiers, "strong", "medium" and "weak",
+// spanning the useful range of thresholds of the default "hfc" method
+func DefaultSensitivityTiers() []SensitivityTier {
+	return []SensitivityTier{
+		{Name: "strong", Threshold: 0.3},
+		{Name: "medium", Threshold: 0.1},
+		{Name: "weak", Threshold: 0.02},
+	}
+}
+
+// TierOnsets holds the onsets of a sensitivity tier
+type TierOnsets struct {
+	// Name is the name of the tier
+	Name string
+	// Threshold is the peak picking threshold of the tier
+	Threshold float64
+	// Onsets are the onset times in seconds detected at this threshold but not
+	// by any stricter tier
+	Onsets []float64
+}
+
+// OnsetsUpTo returns the sorted onset times in seconds of the named tier and
+// of all stricter tiers, which are the slices shown when a sensitivity slider
+// is set to that tier. It returns nil for an unknown tier.
+func (r *SliceAnalyzerResult) OnsetsUpTo(name string) []float64 {
+	var onsets []float64
+	for _, tier := range r.Tiers {
+		onsets = append(onsets, tier.Onsets...)
+		if tier.Name == name {
+			sort.Float64s(onsets)
+			return onsets
+		}
+	}
+	return nil
+}
+
+// validateTiers checks the sensitivity tiers and returns them sorted from the
+// strictest to the loosest
+func validateTiers(tiers []SensitivityTier) ([]SensitivityTier, error) {
+	names := make(map[string]bool, len(tiers))
+	for _, tier := range tiers {
+		if tier.Name == "" {
+			return nil, fmt.Errorf("sensitivity tier with threshold %g has no name", tier.Threshold)
+		}
+		if names[tier.Name] {
+			return nil, fmt.Errorf("duplicate sensitivity tier %q", tier.Name)
+		}
+		if tier.Threshold < 0 {
+			return nil, fmt.Errorf("invalid threshold %g of sensitivity tier %q: must not be negative", tier.Threshold, tier.Name)
+		}
+		names[tier.Name] = true
+	}
+	sorted := append([]SensitivityTier(nil), tiers...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Threshold > sorted[j].Threshold
+	})
+	return sorted, nil
+}
+
+// addTiers makes d also pick peaks at the threshold of every tier, sharing
+// the detection function of its main detector
+func (d *hopDetector) addTiers(method string, bufSize uint, tiers []SensitivityTier, options SliceAnalyzerOptions) {
+	for _, tier := range tiers {
+		o := newAnalysisOnset(method, bufSize, d.hopSize, d.sampleRate, tier.Threshold, options)
+		d.tiers = append(d.tiers, o)
+	}
+	d.tierOnsets = make([][]float64, len(tiers))
+}
+
+// processTiers runs peak picking of every tier on the hop just processed by
+// the main detector
+func (d *hopDetector) processTiers() {
+	for i, o := range d.tiers {
+		o.follow(d.o, d.input, d.output)
+		if d.output.Data[0] > 0 && d.pos >= d.recordFrom {
+			d.tierOnsets[i] = append(d.tierOnsets[i], d.offset+o.GetLastS())
+		}
+	}
+}
+
+// groupTiers returns, for every tier from the strictest to the loosest, the
+// onsets that are not within spacingSec of an onset of a stricter tier or of
+// an earlier onset of the same tier. A stricter onset thus never disappears
+// when a looser tier is added.
+func groupTiers(tierOnsets [][]float64, spacingSec float64) [][]float64 {
+	groups := make([][]float64, len(tierOnsets))
+	var kept []float64
+	for i, onsets := range tierOnsets {
+		sorted := append([]float64(nil), onsets...)
+		sort.Float64s(sorted)
+		for _, t := range sorted {
+			j := sort.SearchFloat64s(kept, t)
+			if j > 0 && t-kept[j-1] < spacingSec {
+				continue
+			}
+			if j < len(kept) && kept[j]-t < spacingSec {
+				continue
+			}
+			kept = append(kept, 0)
+			copy(kept[j+1:], kept[j:])
+			kept[j] = t
+			groups[i] = append(groups[i], t)
+		}
+	}
+	return groups
+}
+
+// tierResults groups the onsets detected by the tiers and refines them like
+// the main onsets, with optimization, backtracking and minimum spacing
+func tierResults(samples []float64, sampleRate uint, tiers []SensitivityTier, tierOnsets [][]float64, options SliceAnalyzerOptions) []TierOnsets {
+	groups := groupTiers(tierOnsets, tierMatchSec)
+	if options.Optimize {
+		for i, group := range groups {
+			if len(group) > 0 {
+				groups[i] = optimizeOnsetPositions(samples, sampleRate, group, options.OptimizeWindowMs, nil)
+			}
+		}
+	}
+	if options.Backtrack {
+		for i, group := range groups {
+			if len(group) > 0 {
+				groups[i] = backtrackOnsets(samples, sampleRate, group)
+			}
+		}
+	}
+	spacing := tierMatchSec
+	if options.UseMinimumSpacing {
+		spacing = max(spacing, options.MinimumSpacing/1000)
+	}
+	groups = groupTiers(groups, spacing)
+
+	results := make([]TierOnsets, len(tiers))
+	for i, tier := range tiers {
+		results[i] = TierOnsets{Name: tier.Name, Threshold: tier.Threshold, Onsets: groups[i]}
+	}
+	return results
+}
@@ -0,0 +1,79 @@
+package onset
+
+import "fmt"
+
+// TransientOptions configures SplitTransients
+type TransientOptions struct {
+	// AttackMs is the length in milliseconds of the transient following
+	// every onset. Default is 30 if 0.
+	AttackMs float64
+	// FadeMs is the length in milliseconds of the crossfades between the
+	// transients and the sustain, the transients fading in before their
+	// onsets and out after their attacks. Default is 5 if 0.
+	FadeMs float64
+}
+
+// SplitTransients splits the analyzed samples into transients, the attack
+// following every onset, and sustain, everything else, for layering and
+// processing them separately. The two parts crossfade, so that they sum
+// back to the original samples. The result must hold its samples.
+func SplitTransients(result *SliceAnalyzerResult, options TransientOptions) (transients, sustain []float64, err error) {
+	if len(result.Samples) == 0 {
+		return nil, nil, fmt.Errorf("result has no samples to split")
+	}
+	attackMs, fadeMs := options.AttackMs, options.FadeMs
+	if attackMs == 0 {
+		attackMs = 30
+	}
+	if fadeMs == 0 {
+		fadeMs = 5
+	}
+	if attackMs < 0 || fadeMs < 0 {
+		return nil, nil, fmt.Errorf("invalid transient options %+v", options)
+	}
+
+	// The gain of the transients rises over the fade before every onset,
+	// holds over the attack and falls over the fade after it
+	rate := float64(result.SampleRate)
+	attack, fade := int(attackMs/1000*rate), max(int(fadeMs/1000*rate), 1)
+	gain := make([]float64, len(result.Samples))
+	for _, r := range result.SliceRanges() {
+		for i := max(r.Start-fade, 0); i < min(r.Start+attack+fade, len(gain)); i++ {
+			g := 1.0
+			if i < r.Start {
+				g = float64(i-r.Start+fade) / float64(fade)
+			} else if i >= r.Start+attack {
+				g = float64(r.Start+attack+fade-i) / float64(fade)
+			}
+			gain[i] = max(gain[i], g)
+		}
+	}
+
+	transients = make([]float64, len(result.Samples))
+	sustain = make([]float64, len(result.Samples))
+	for i, v := range result.Samples {
+		transients[i] = v * gain[i]
+		sustain[i] = v - transients[i]
+	}
+	return transients, sustain, nil
+}
+
+// WriteTransientSplit splits the analyzed samples into transients and
+// sustain, see SplitTransients, and writes them to two WAV files of the
+// given bit depth (16 if 0)
+func WriteTransientSplit(result *SliceAnalyzerResult, options TransientOptions, transientPath, sustainPath string, bitDepth int) error {
+	transients, sustain, err := SplitTransients(result, options)
+	if err != nil {
+		return err
+	}
+	if bitDepth == 0 {
+		bitDepth = 16
+	}
+	if err := WriteWav(transientPath, transients, result.SampleRate, bitDepth); err != nil {
+		return fmt.Errorf("failed to write transients: %w", err)
+	}
+	if err := WriteWav(sustainPath, sustain, result.SampleRate, bitDepth); err != nil {
+		return fmt.Errorf("failed to write sustain: %w", err)
+	}
+	return nil
+}
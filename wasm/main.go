@@ -0,0 +1,204 @@
+//go:build js && wasm
+
+// Command wasm exposes the onset detector and the slice analyzer to
+// JavaScript, so that analysis runs client-side on WebAudio buffers.
+//
+// Build it with:
+//
+//	GOOS=js GOARCH=wasm go build -o onsets.wasm ./wasm
+//
+// and load it with wasm_exec.js from the Go distribution. It defines the
+// global functions:
+//
+//	analyze(samples: Float32Array, sampleRate: number, optionsJSON?: string): object
+//	newOnsetDetector(method: string, sampleRate: number, optionsJSON?: string): object
+//
+// analyze runs the slice analyzer on mono samples. optionsJSON holds
+// SliceAnalyzerOptions fields, such as {"Method": "consensus", "NumSlices": 8},
+// which override the defaults. It returns {onsets, sampleRate, numSamples} or
+// {error}.
+//
+// newOnsetDetector returns a streaming detector with the methods
+// process(samples: Float32Array), which returns the onsets detected in the
+// block as [{time, strength}], reset(), and release(), which frees the
+// detector once it is no longer used. Only the Threshold and MinioiMs
+// options apply; unset ones keep the tuned live defaults of the method.
+// It returns {error} for invalid arguments.
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"syscall/js"
+
+	"github.com/schollz/onsets"
+)
+
+func main() {
+	js.Global().Set("analyze", js.FuncOf(analyze))
+	js.Global().Set("newOnsetDetector", js.FuncOf(newOnsetDetector))
+
+	// Keep the exported functions alive
+	select {}
+}
+
+// analyze implements the analyze function
+func analyze(this js.Value, args []js.Value) any {
+	if len(args) < 2 {
+		return jsError(fmt.Errorf("expected samples and sample rate"))
+	}
+	samples, err := float32Samples(args[0])
+	if err != nil {
+		return jsError(err)
+	}
+	sampleRate, err := sampleRateArg(args[1])
+	if err != nil {
+		return jsError(err)
+	}
+	options, err := optionsArg(args, 2)
+	if err != nil {
+		return jsError(err)
+	}
+
+	result, err := onset.AnalyzeSamples(samples, sampleRate, options)
+	if err != nil {
+		return jsError(err)
+	}
+
+	onsets := make([]any, len(result.Onsets))
+	for i, t := range result.Onsets {
+		onsets[i] = t
+	}
+	return map[string]any{
+		"onsets":     onsets,
+		"sampleRate": int(result.SampleRate),
+		"numSamples": len(result.Samples),
+	}
+}
+
+// newOnsetDetector implements the newOnsetDetector function
+func newOnsetDetector(this js.Value, args []js.Value) any {
+	if len(args) < 2 {
+		return jsError(fmt.Errorf("expected method and sample rate"))
+	}
+	method := args[0].String()
+	if method == "consensus" {
+		return jsError(fmt.Errorf("streaming detection does not support the consensus method"))
+	}
+	sampleRate, err := sampleRateArg(args[1])
+	if err != nil {
+		return jsError(err)
+	}
+	options, err := optionsArg(args, 2)
+	if err != nil {
+		return jsError(err)
+	}
+
+	o, err := onset.NewOnsetErr(method, 512, 256, sampleRate)
+	if err != nil {
+		return jsError(err)
+	}
+	if options.Threshold > 0 {
+		o.SetThreshold(options.Threshold)
+	}
+	if options.MinioiMs > 0 {
+		o.SetMinioiMs(options.MinioiMs)
+	}
+	hop := onset.NewFvec(o.HopSize)
+	out := onset.NewFvec(1)
+	fill := uint(0)
+
+	process := js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) < 1 {
+			return jsError(fmt.Errorf("expected samples"))
+		}
+		samples, err := float32Samples(args[0])
+		if err != nil {
+			return jsError(err)
+		}
+
+		events := []any{}
+		for _, v := range samples {
+			hop.Data[fill] = v
+			fill++
+			if fill < o.HopSize {
+				continue
+			}
+			fill = 0
+
+			o.Do(hop, out)
+			if out.Data[0] > 0 {
+				events = append(events, map[string]any{
+					"time":     o.GetLastS(),
+					"strength": o.GetOnsetDescriptor(),
+				})
+			}
+		}
+		return events
+	})
+	reset := js.FuncOf(func(this js.Value, args []js.Value) any {
+		o.Reset()
+		fill = 0
+		return nil
+	})
+
+	var release js.Func
+	release = js.FuncOf(func(this js.Value, args []js.Value) any {
+		process.Release()
+		reset.Release()
+		release.Release()
+		return nil
+	})
+
+	return map[string]any{
+		"process": process,
+		"reset":   reset,
+		"release": release,
+	}
+}
+
+// float32Samples copies the samples of a Float32Array
+func float32Samples(v js.Value) ([]float64, error) {
+	if !v.InstanceOf(js.Global().Get("Float32Array")) {
+		return nil, fmt.Errorf("samples must be a Float32Array")
+	}
+
+	// Copy the bytes in one call instead of reading every element through JS
+	n := v.Get("length").Int()
+	data := make([]byte, n*4)
+	bytes := js.Global().Get("Uint8Array").New(v.Get("buffer"), v.Get("byteOffset"), n*4)
+	js.CopyBytesToGo(data, bytes)
+
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:])))
+	}
+	return samples, nil
+}
+
+// sampleRateArg reads a positive sample rate
+func sampleRateArg(v js.Value) (uint, error) {
+	if v.Type() != js.TypeNumber || v.Int() <= 0 {
+		return 0, fmt.Errorf("invalid sample rate")
+	}
+	return uint(v.Int()), nil
+}
+
+// optionsArg decodes the optional options JSON at args[i] over the defaults
+func optionsArg(args []js.Value, i int) (onset.SliceAnalyzerOptions, error) {
+	options := onset.DefaultSliceAnalyzerOptions()
+	if i >= len(args) || args[i].IsUndefined() || args[i].IsNull() {
+		return options, nil
+	}
+	if err := json.Unmarshal([]byte(args[i].String()), &options); err != nil {
+		return options, fmt.Errorf("invalid options: %w", err)
+	}
+	return options, nil
+}
+
+// jsError returns an object carrying the error message
+func jsError(err error) any {
+	return map[string]any{"error": err.Error()}
+}
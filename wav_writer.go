@@ -0,0 +1,82 @@
+package onset
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+)
+
+// WriteWav writes mono samples in the range [-1.0, 1.0] to a PCM WAV file.
+// Supported bit depths are 8, 16, 24 and 32; samples outside the range are clipped.
+func WriteWav(path string, samples []float64, sampleRate uint, bitDepth int) error {
+	return WriteWavChannels(path, [][]float64{samples}, sampleRate, bitDepth)
+}
+
+// WriteWavChannels writes one or more channels of samples in the range [-1.0, 1.0]
+// to an interleaved PCM WAV file. All channels must have the same length.
+func WriteWavChannels(path string, channels [][]float64, sampleRate uint, bitDepth int) error {
+	if len(channels) == 0 {
+		return fmt.Errorf("no channels to write")
+	}
+	for ch, channel := range channels {
+		if len(channel) != len(channels[0]) {
+			return fmt.Errorf("channel %d has %d samples, expected %d", ch, len(channel), len(channels[0]))
+		}
+	}
+	switch bitDepth {
+	case 8, 16, 24, 32:
+	default:
+		return fmt.Errorf("unsupported bit depth: %d", bitDepth)
+	}
+	if sampleRate == 0 {
+		return fmt.Errorf("invalid sample rate: %d", sampleRate)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	numChannels := len(channels)
+	encoder := wav.NewEncoder(f, int(sampleRate), bitDepth, numChannels, 1)
+
+	// 8-bit WAV samples are unsigned, all other depths are signed
+	maxValue := float64(int64(1)<<(bitDepth-1)) - 1
+	offset := 0
+	if bitDepth == 8 {
+		offset = 128
+	}
+
+	// Encode in chunks to bound the size of the interleaved buffer
+	const chunkFrames = 4096
+	buf := &audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: numChannels, SampleRate: int(sampleRate)},
+		SourceBitDepth: bitDepth,
+	}
+	for start := 0; start < len(channels[0]); start += chunkFrames {
+		end := start + chunkFrames
+		if end > len(channels[0]) {
+			end = len(channels[0])
+		}
+		buf.Data = buf.Data[:0]
+		for i := start; i < end; i++ {
+			for _, channel := range channels {
+				v := math.Max(-1, math.Min(1, channel[i]))
+				buf.Data = append(buf.Data, int(math.Round(v*maxValue))+offset)
+			}
+		}
+		if err := encoder.Write(buf); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write samples: %w", err)
+		}
+	}
+
+	if err := encoder.Close(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to finalize WAV file: %w", err)
+	}
+	return f.Close()
+}